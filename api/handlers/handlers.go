@@ -4,26 +4,43 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"sema/models/delta"
-	"sema/models/joinMessage"
-	"sema/models/syncMessage"
-	"sema/models/updateRepoMessage"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sema/models/audit"
+	"sema/models/comment"
+	"sema/models/invitation"
+	"sema/models/protocol"
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
+	"sema/models/reportTemplates"
+	"sema/models/review"
 	"sema/repository"
-	"time"
 
 	"sema/services/authentication"
+	"sema/services/exportJobs"
 	"sema/services/reportGeneration"
+	"sema/services/sectionAccess"
+	"sema/services/templateMigration"
+	"sema/services/versionDiff"
 	"sema/services/websockets"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 var websocketmanager = websockets.SpawnWebSocketManager()
 
+// Finished exports are kept for an hour
+var exportmanager = exportJobs.SpawnManager(filepath.Join(os.TempDir(), "sema-exports"), time.Hour)
+
 type ReportRequest struct { 
 	Type string `json:"type" binding:"required"`
 	Name string `json:"name" binding:"required"`
@@ -84,11 +101,12 @@ func HomeHandler(repo repository.ReportRepository) gin.HandlerFunc {
 
 func AddUserToReport(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
 		// Get the email from the request body
 		var requestData struct {
 			Email   string `json:"email"`
-			ReportID string `json:"reportId"`
-			Privilege bool `json:"privilege"`
+			Role    reportRoles.Role `json:"role"`
+			Privilege bool `json:"privilege"` // Older clients, admin when role is left out
 
 		}
 
@@ -105,27 +123,127 @@ func AddUserToReport(authService authentication.AuthServiceInterface, repo repos
 			return
 		}
 
-		// Get the UID from the email using the AuthService
+		role := requestData.Role
+		if role == reportRoles.None {
+			role = reportRoles.FromFlags(requestData.Privilege, false)
+		}
+		if !role.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be viewer, commenter, editor, admin or owner"})
+			return
+		}
+
+		caller, ok := callerRole(c, repo)
+		if !ok {
+			return
+		}
+		if !caller.Manages(role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Only owners can add an %s", role)})
+			return
+		}
+
+		// Get the UID from the email using the AuthService, people without
+		// an account are invited instead
 		uid, err := authService.GetUIDFromEmail(requestData.Email)
+		if errors.Is(err, authentication.ErrUserNotFound) {
+			inviteUser(c, repo, requestData.Email, role)
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get UID from email, is user registered?"})
 			return
 		}
 
-		// Add the user to the report (Assuming repo has a method to add a user by UID and ReportID)
-		err = repo.LinkReportWithUser(uid, requestData.ReportID, requestData.Privilege, false) //
+		err = repo.LinkReportWithUser(uid, reportID, role)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user to report"})
+			log.Println("Error adding user to report:", err)
+			c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to add user to report"})
 			return
 		}
 
+		repo.BufferLog(auditEvent(c, audit.MemberAdded, fmt.Sprintf("added %s as %s", requestData.Email, role), map[string]interface{}{"email": requestData.Email, "role": string(role)}))
+
 		// Return success response
 		fmt.Println("Added User")
 		c.JSON(http.StatusOK, gin.H{"message": "User successfully added to report"})
 	}
 }
 
-func VerifyTokenHandler(authService authentication.AuthServiceInterface) gin.HandlerFunc {
+// inviteUser stores an invitation for someone without an account. The
+// response carries the token for the invitation link, /register?invite=token.
+func inviteUser(c *gin.Context, repo repository.ReportRepository, email string, role reportRoles.Role) {
+	reportID := c.Param("reportID")
+	invite := invitation.New(reportID, email, role, c.GetString("email"), time.Now())
+
+	invitationID, err := repo.CreateInvitation(invite)
+	if err != nil {
+		log.Println("Error creating invitation:", err)
+		c.JSON(invitationErrorStatus(err), gin.H{"error": "Failed to invite user"})
+		return
+	}
+	invite.ID = invitationID
+
+	repo.BufferLog(auditEvent(c, audit.InvitationCreated, fmt.Sprintf("invited %s as %s", invite.Email, role), map[string]interface{}{"invitationID": invite.ID, "email": invite.Email, "role": string(role)}))
+	c.JSON(http.StatusAccepted, gin.H{"message": "User is not registered yet and was invited", "invitation": invite})
+}
+
+// invitationErrorStatus maps repository invitation errors to HTTP statuses
+func invitationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrInvitationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrInvitationExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// InvitationsHandler lists the pending and expired invitations of a report
+func InvitationsHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitations, err := repo.ListInvitations(c.Param("reportID"))
+		if err != nil {
+			log.Println("Error listing invitations:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invitations"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+	}
+}
+
+// ResendInvitationHandler gives an invitation a new token and a full
+// lifetime. The link sent before stops working.
+func ResendInvitationHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		invite, err := repo.RenewInvitation(reportID, c.Param("invitationID"), invitation.NewToken(), time.Now().Add(invitation.Lifetime))
+		if err != nil {
+			log.Println("Error renewing invitation:", err)
+			c.JSON(invitationErrorStatus(err), gin.H{"error": "Failed to resend invitation"})
+			return
+		}
+
+		repo.BufferLog(auditEvent(c, audit.InvitationResent, fmt.Sprintf("resent the invitation of %s", invite.Email), map[string]interface{}{"invitationID": invite.ID, "email": invite.Email}))
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation renewed", "invitation": invite})
+	}
+}
+
+// RevokeInvitationHandler deletes an invitation before it is accepted
+func RevokeInvitationHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		invite, err := repo.RevokeInvitation(reportID, c.Param("invitationID"))
+		if err != nil {
+			log.Println("Error revoking invitation:", err)
+			c.JSON(invitationErrorStatus(err), gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+
+		repo.BufferLog(auditEvent(c, audit.InvitationRevoked, fmt.Sprintf("revoked the invitation of %s", invite.Email), map[string]interface{}{"invitationID": invite.ID, "email": invite.Email}))
+		c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+	}
+}
+
+func VerifyTokenHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Extract token from Authorization header
 		authHeader := c.GetHeader("Authorization")
@@ -144,15 +262,58 @@ func VerifyTokenHandler(authService authentication.AuthServiceInterface) gin.Han
 			return
 		}
 
+		// Signing in accepts pending invitations, the token of an invitation
+		// link stands in for a verified email
+		var body struct {
+			Invite string `json:"invite"`
+		}
+		c.ShouldBindJSON(&body)
+		email, _ := decodedToken.Claims["email"].(string)
+		verified, _ := decodedToken.Claims["email_verified"].(bool)
+		joined := acceptInvitations(c, repo, decodedToken.UID, email, verified, body.Invite)
+
 		// Token is valid, return user info (optional)
 		c.JSON(http.StatusOK, gin.H{
 			"uid":    decodedToken.UID,
 			"status": "token is valid",
+			"joinedReports": joined,
 		})
 	}
 }
 
 
+// acceptInvitations adds a user who signed in to the reports they were
+// invited to and returns the IDs of those reports. Failures are only logged,
+// the invitations stay for the next sign in.
+func acceptInvitations(c *gin.Context, repo repository.ReportRepository, uid, email string, verified bool, token string) []string {
+	joined := []string{}
+	if email == "" {
+		return joined
+	}
+
+	invitations, err := repo.FindInvitations(email)
+	if err != nil {
+		log.Println("Error fetching invitations:", err)
+		return joined
+	}
+	now := time.Now()
+	for _, invite := range invitations {
+		if !invite.AcceptableBy(email, verified, token, now) {
+			continue
+		}
+		if err := repo.AcceptInvitation(uid, invite); err != nil {
+			log.Println("Error accepting invitation:", err)
+			continue
+		}
+		event := auditEvent(c, audit.InvitationAccepted, fmt.Sprintf("accepted the invitation as %s", invite.Role), map[string]interface{}{"invitationID": invite.ID, "role": string(invite.Role)})
+		event.ReportID, event.Actor = invite.ReportID, email
+		repo.BufferLog(event)
+		joined = append(joined, invite.ReportID)
+	}
+	return joined
+}
+
+
 func CreateReportHandler(repo repository.ReportRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ReportRequest
@@ -196,7 +357,7 @@ func CreateReportHandler(repo repository.ReportRepository) gin.HandlerFunc {
 
 
 		repo.CreateReport(req.Name, reportID, req.Type, userEmailStr) 
-		err := repo.LinkReportWithUser(uidStr, reportID, true, true) // Always give admin to creator 
+		err := repo.LinkReportWithUser(uidStr, reportID, reportRoles.Owner) // Creators own their reports
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to link report with user"})
 			return
@@ -214,13 +375,16 @@ func RegisterHandler(c *gin.Context) {
 
 func ReportHandler(repo repository.ReportRepository) gin.HandlerFunc {
 
-	/* Get Sections + Subsections from report or Get template report is based off of */
+	/* Get Sections + Subsections from report */
 	return func(c *gin.Context) {	
 		fmt.Println("3")
 		reportID := c.Param("reportID")
-		templateID, _ := repo.GetReportFieldTemplateID(reportID)
-		template, _ := repo.GetTemplate(templateID)
-		templateJSON, _ := json.Marshal(template.Sections)
+		sections, _ := repo.FetchReportStructure(reportID)
+		access, err := memberAccess(c, repo)
+		if err != nil {
+			log.Println("Error fetching section access:", err)
+		}
+		templateJSON, _ := json.Marshal(access.Filter(sections))
 		fmt.Println(string(templateJSON)) // Log to ensure it's correct
 		c.HTML(http.StatusOK, "report.html", gin.H {
 			"template" : string(templateJSON),
@@ -240,50 +404,147 @@ func GenerateReportHandler(repo repository.ReportRepository) gin.HandlerFunc {
     log.Println("Generating Report:", reportID)
 
     // Fetch report content
-    reportName, reportContent, err := repo.FetchReportContent(reportID)
+    reportName, reportContent, style, err := fetchExportContent(repo, reportID)
     if err != nil {
       c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": "Failed to fetch report content"})
       return
     }
 
-    // Generate PDF
-    pdfFileName := reportName + ".pdf"
-    err = reportGeneration.GeneratePDF(reportName, reportContent)
+    format := c.DefaultQuery("format", "pdf")
+    exporter, err := reportGeneration.GetExporter(format)
+    if err != nil {
+      c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": fmt.Sprintf("Unsupported format %q, expected one of %v", format, reportGeneration.Formats())})
+      return
+    }
+
+    data, err := exporter.Export(reportName, reportContent, style)
     if err != nil {
-      c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": fmt.Sprintf("Failed to generate PDF: %v", err)})
+      c.JSON(http.StatusInternalServerError, gin.H{"success": false, "error": fmt.Sprintf("Failed to generate report: %v", err)})
       return
     }
 
     // Set response headers
-    c.Header("Content-Disposition", "attachment; filename="+pdfFileName)
-    c.Header("Content-Type", "application/pdf")
-
-    // Serve the file
-    c.File(pdfFileName)
-
-    // Clean up the file after serving
-    go func(fileName string) {
-      time.Sleep(1 * time.Second)
-      err := os.Remove(fileName)
-      if err != nil {
-        log.Printf("Failed to delete PDF file: %v", err)
+    fileName := reportName + "." + exporter.Extension()
+    c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+    c.Data(http.StatusOK, exporter.ContentType(), data)
+  }
+}
+
+// fetchExportContent fetches a report's content and the style of its
+// template. Reports whose template can't be read get the default style.
+func fetchExportContent(repo repository.ReportRepository, reportID string) (string, []map[string]interface{}, reportTemplates.Style, error) {
+  reportName, reportContent, err := repo.FetchReportContent(reportID)
+  if err != nil {
+    return "", nil, reportTemplates.Style{}, err
+  }
+
+  var style reportTemplates.Style
+  templateID, err := repo.GetReportFieldTemplateID(reportID)
+  if err == nil {
+    var template *reportTemplates.ReportTemplate
+    template, err = repo.GetTemplate(templateID)
+    if err == nil && template != nil {
+      style = template.Style
+    }
+  }
+  if err != nil {
+    log.Println("Exporting report", reportID, "with the default style:", err)
+  }
+
+  return reportName, reportContent, style, nil
+}
+
+type ExportJobRequest struct {
+  Format string `json:"format"`
+}
+
+// StartExportHandler queues an export of the report and returns its job
+// without waiting for it to render
+func StartExportHandler(repo repository.ReportRepository) gin.HandlerFunc {
+  return func(c *gin.Context) {
+    reportID := c.Param("reportID")
+
+    var req ExportJobRequest
+    if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+      c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+      return
+    }
+    if req.Format == "" {
+      req.Format = "pdf"
+    }
+
+    job, err := exportmanager.Start(reportID, req.Format, c.GetString("email"), func() (string, []map[string]interface{}, reportTemplates.Style, error) {
+      return fetchExportContent(repo, reportID)
+    })
+    if errors.Is(err, reportGeneration.ErrUnsupportedFormat) {
+      c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported format %q, expected one of %v", req.Format, reportGeneration.Formats())})
+      return
+    }
+    if err != nil {
+      log.Println("Error starting export:", err)
+      c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start export"})
+      return
+    }
+
+    log.Println("Started export job", job.ID, "for report", reportID)
+    c.JSON(http.StatusAccepted, exportJobResponse(job))
+  }
+}
+
+// ExportStatusHandler reports the status of an export job
+func ExportStatusHandler() gin.HandlerFunc {
+  return func(c *gin.Context) {
+    job, ok := exportmanager.Get(c.Param("reportID"), c.Param("jobID"))
+    if !ok {
+      c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
+      return
+    }
+    c.JSON(http.StatusOK, exportJobResponse(job))
+  }
+}
+
+// ExportDownloadHandler serves the file of a finished export job
+func ExportDownloadHandler() gin.HandlerFunc {
+  return func(c *gin.Context) {
+    job, path, contentType, err := exportmanager.Artifact(c.Param("reportID"), c.Param("jobID"))
+    if err != nil {
+      if job.ID == "" {
+        c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
       } else {
-        log.Printf("Deleted PDF file: %s", fileName)
+        c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Export is %s", job.Status)})
       }
-    }(pdfFileName)
+      return
+    }
+
+    c.Header("Content-Type", contentType)
+    c.FileAttachment(path, job.FileName)
+  }
+}
+
+// exportJobResponse adds the download link once a job is done
+func exportJobResponse(job exportJobs.Job) gin.H {
+  response := gin.H{"job": job}
+  if job.Status == exportJobs.StatusDone {
+    response["downloadURL"] = fmt.Sprintf("/report/%s/api/exports/%s/download", job.ReportID, job.ID)
   }
+  return response
 }
 
 func ReportLogsHandler(repo repository.ReportRepository) gin.HandlerFunc {
   return func(c *gin.Context) {
     reportID := c.Param("reportID")
 
-    logs, err := repo.FetchLogsForReport(reportID)
+    events, err := repo.FetchLogsForReport(reportID, audit.Query{})
     if err != nil {
       c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
       return
     }
 
+    logs := make([]string, len(events))
+    for i, event := range events {
+      logs[i] = event.String()
+    }
+
     c.HTML(http.StatusOK, "logs.html", gin.H{
       "reportID": reportID,
       "logs":     logs,
@@ -292,6 +553,133 @@ func ReportLogsHandler(repo repository.ReportRepository) gin.HandlerFunc {
 }
 
 
+// Pages of the audit log API hold 100 events unless asked otherwise
+const (
+	defaultAuditPage = 100
+	maxAuditPage     = 1000
+)
+
+// AuditLogHandler lists a report's audit events, oldest first. They can be
+// filtered by user email, action and a from/to range in RFC 3339, and come in
+// pages of limit events, the response's next being the after of the next
+// page. With format csv or jsonl every matching event is downloaded instead.
+func AuditLogHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+
+		query, err := auditQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" && format != "jsonl" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json, csv or jsonl"})
+			return
+		}
+
+		// One more event than the page tells whether there is a next one
+		limit := query.Limit
+		if format == "json" {
+			query.Limit = limit + 1
+		} else {
+			query.Limit = 0
+		}
+
+		events, err := repo.FetchLogsForReport(reportID, query)
+		if errors.Is(err, repository.ErrEventNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown page cursor"})
+			return
+		}
+		if err != nil {
+			log.Println("Error fetching audit events:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
+			return
+		}
+
+		if format == "json" {
+			next := ""
+			if len(events) > limit {
+				events = events[:limit]
+				next = events[limit-1].ID
+			}
+			c.JSON(http.StatusOK, gin.H{"events": events, "next": next})
+			return
+		}
+
+		write, contentType := audit.WriteCSV, "text/csv; charset=utf-8"
+		if format == "jsonl" {
+			write, contentType = audit.WriteJSONLines, "application/x-ndjson"
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-audit.%s"`, reportID, format))
+		c.Status(http.StatusOK)
+		if err := write(c.Writer, events); err != nil {
+			log.Println("Error exporting audit events:", err)
+		}
+	}
+}
+
+// VerifyLogsHandler checks a report's hash-chained log and reports the first
+// broken link, if any
+func VerifyLogsHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		events, head, err := repo.FetchLogChain(c.Param("reportID"))
+		if err != nil {
+			log.Println("Error fetching log chain:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
+			return
+		}
+		c.JSON(http.StatusOK, audit.Verify(events, head))
+	}
+}
+
+// auditQuery reads the filters and page of the audit log API
+func auditQuery(c *gin.Context) (audit.Query, error) {
+	query := audit.Query{
+		Actor:  c.Query("user"),
+		Action: audit.Action(c.Query("action")),
+		After:  c.Query("after"),
+		Limit:  defaultAuditPage,
+	}
+
+	var err error
+	if from := c.Query("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return query, errors.New("from must be an RFC 3339 time")
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return query, errors.New("to must be an RFC 3339 time")
+		}
+	}
+	if limit := c.Query("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > maxAuditPage {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxAuditPage)
+		}
+	}
+	return query, nil
+}
+
+// auditEvent is an event of the signed in user acting on the route's report,
+// section and subsection
+func auditEvent(c *gin.Context, action audit.Action, message string, payload map[string]interface{}) audit.Event {
+	return audit.Event{
+		ReportID:     c.Param("reportID"),
+		Actor:        c.GetString("email"),
+		Action:       action,
+		SectionID:    c.Param("sectionID"),
+		SubsectionID: c.Param("subsectionID"),
+		IP:           c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Message:      message,
+		Payload:      payload,
+	}
+}
+
 func RemoveUserFromReport(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
 
 	return func(c *gin.Context) {
@@ -321,14 +709,19 @@ func RemoveUserFromReport(authService authentication.AuthServiceInterface, repo
 			return
 		}
 
-		// Add the user to the report (Assuming repo has a method to add a user by UID and ReportID)
+		if !mayManageMember(c, repo, uid) {
+			return
+		}
 
 		err = repo.RemoveUserFromReport(uid, reportID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove user from report"})
+			log.Println("Error removing user from report:", err)
+			c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to remove user from report"})
 			return
 		}
 
+		repo.BufferLog(auditEvent(c, audit.MemberRemoved, fmt.Sprintf("removed %s from the report", requestData.Email), map[string]interface{}{"email": requestData.Email}))
+
 		// Return success response
 		fmt.Println("Removed User")
 		c.JSON(http.StatusOK, gin.H{"message": "User successfully removed from report"})
@@ -460,14 +853,26 @@ func WebSocketHandler(repo repository.ReportRepository) gin.HandlerFunc {
 		}
 		defer conn.Close()
 
+		// Everything sent to the connection goes through its own writer,
+		// which finishes what is queued before the connection closes
+		websocketmanager.Connect(conn)
+		defer websocketmanager.Disconnect(conn)
+
 		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
 
-		id := c.Request.URL.String()
+		id := sectionRoomID(reportID, sectionID)
 		fmt.Println(id)
 
-		websocketmanager.OpenConnection(id, conn)
+		// The connection is only in the section's room once it joined
 		defer websocketmanager.CloseConnection(id, conn)
 
+		// Set by AuthSectionAccess, routes without it are open to everyone
+		level := sectionAccess.Edit
+		if value, ok := c.Get("sectionAccess"); ok {
+			level, _ = value.(sectionAccess.Level)
+		}
+
 		userEmailVal, ok := c.Get("email")
 		if !ok {
 			log.Println("Email not found in context")
@@ -479,116 +884,1726 @@ func WebSocketHandler(repo repository.ReportRepository) gin.HandlerFunc {
 			return
 		}
 
+		// Reads fail once the client stops answering pings or goes idle
+		heartbeat := websockets.StartHeartbeat(conn)
+		defer heartbeat.Stop()
+
 		for {
 			_, msg, err := conn.ReadMessage()
 			if err != nil {
 				log.Println("Error reading message: ", err)
+				if heartbeat.Idle() {
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle"), time.Now().Add(time.Second))
+				}
 				websocketmanager.CloseConnection(id, conn)
 				break
 			}
+			heartbeat.Received()
 
-			var message map[string]interface{}
-			if err := json.Unmarshal(msg, &message); err != nil {
-				log.Println("Error unmarshalling message:", err)
+			message, rejected := protocol.Decode(msg)
+			if rejected != nil {
+				log.Println("Rejecting message:", rejected)
 				log.Println("Raw JSON:", string(msg))
+				sendFrame(conn, rejected)
 				continue
 			}
 
-			switch message["type"] {
-			case "join":
-				log.Println("A client joined: ", id)
-				repo.BufferLog(reportID, "joined a report section", userEmail)
-
-				if websocketmanager.GetNumofConns(id) == 1 {
-					var joinMsg joinMessage.JoinMessage
-					if err := json.Unmarshal(msg, &joinMsg); err != nil {
-						log.Println("Error unmarshalling sync message:", err)
-						log.Println("Raw JSON:", string(msg))
-						continue
-					}
-
-					contents, err := repo.FetchReportSectionContents(joinMsg.ReportID, joinMsg.Section)
-					if err != nil {
-						log.Println("Error fetching contents from database: ", err)
+			switch message := message.(type) {
+			case *protocol.Join:
+				// Access may have changed since the connection was opened
+				if allowed, err := socketAccess(c, repo, level, sectionAccess.Read); !allowed {
+					log.Println("Rejecting join from user without read access:", userEmail, err)
+					sendFrame(conn, protocol.NewError(protocol.CodeForbidden, message.ID, "read access is needed to join %s", sectionID))
+					return
+				}
+				websocketmanager.OpenConnection(id, conn)
+
+				who := protocol.Presence{UID: c.GetString("uid"), Email: userEmail, ReportID: reportID, SectionID: sectionID}
+
+				// A client that lost its connection gets what it missed, if it can be caught up
+				resumed := false
+				if message.Resume != "" {
+					sessionID, err := websocketmanager.ResumeSection(id, conn, message.Resume, message.Revisions, who.UID, userEmail)
+					if err == nil {
+						log.Println("A client resumed: ", id)
+						resumed = true
+						who.SessionID = sessionID
+					} else if errors.Is(err, websockets.ErrCannotResume) {
+						log.Println("Joining afresh: ", err)
+					} else {
+						log.Println("Error resuming section: ", err)
+						websocketmanager.CloseConnection(id, conn)
+						sendFrame(conn, socketError(err, message.ID, ""))
 						return
 					}
-
-					for _, content := range contents {
-						if content == "" {
-							continue
-						}
-						bContent := []byte(content)
-						var delta delta.Delta
-						if err := json.Unmarshal(bContent, &delta); err != nil {
-							log.Println("Error unmarshalling delta:", err)
-							log.Println("Raw JSON:", string(bContent))
-							continue
-						}
-						conn.WriteJSON(delta)
-					}
-				} else {
-					websocketmanager.RequestSectionContents(id, conn)
 				}
 
-			case "sync":
-				log.Println("Received JSON sync message:", string(msg))
-				repo.BufferLog(reportID, "synced content", userEmail)
+				if !resumed {
+					log.Println("A client joined: ", id)
+					repo.BufferLog(auditEvent(c, audit.SectionJoined, "joined a report section", nil))
 
-				var syncMsg syncMessage.SyncMessage
-				if err := json.Unmarshal(msg, &syncMsg); err != nil {
-					log.Println("Error unmarshalling sync message:", err)
-					log.Println("Raw JSON:", string(msg))
-					continue
+					// The server holds the section contents, clients only ever get a snapshot from it
+					if err := websocketmanager.JoinSection(id, reportID, sectionID, repo, conn); err != nil {
+						log.Println("Error joining section: ", err)
+						websocketmanager.CloseConnection(id, conn)
+						sendFrame(conn, socketError(err, message.ID, ""))
+						return
+					}
 				}
 
-				for editorID, content := range syncMsg.Contents {
-					websocketmanager.SendToIDExpectConn(id, content, conn)
-					deltaJSON, err := json.Marshal(content)
-					if err != nil {
-						log.Fatal("Error marshalling Delta:", err)
-						log.Println("Raw JSON:", string(msg))
-					}
-					repo.UpdateReportSectionContents(syncMsg.ReportID, syncMsg.Section, editorID, string(deltaJSON))
+				// Tell the section who joined, and the new client who is already there
+				announced, err := websocketmanager.Announce(id, conn, who)
+				if err != nil {
+					log.Println("Error announcing presence: ", err)
+					sendFrame(conn, socketError(err, message.ID, ""))
+					return
+				}
+				token, err := websocketmanager.StartSession(id, conn, announced)
+				if err != nil {
+					log.Println("Error starting session: ", err)
+					sendFrame(conn, socketError(err, message.ID, ""))
+					return
 				}
 
-			case "updateRepo":
-				log.Println("Received JSON updateRepo message:", string(msg))
-				repo.BufferLog(reportID, "triggered repository update", userEmail)
+				sendFrame(conn, protocol.Welcome{Type: protocol.TypeWelcome, ReplyTo: message.ID, Version: protocol.Version, SessionID: announced.SessionID, ResumeToken: token, Resumed: resumed})
 
-				var updateRepoMsg updateRepoMessage.UpdateRepoMessage
-				if err := json.Unmarshal(msg, &updateRepoMsg); err != nil {
-					log.Println("Error unmarshalling updateRepo message:", err)
-					log.Println("Raw JSON:", string(msg))
+			case *protocol.DeltaMessage:
+				editorID := message.Delta.EditorId
+				if allowed, err := socketAccess(c, repo, level, sectionAccess.Edit); !allowed {
+					log.Println("Rejecting delta from user without edit access:", userEmail, err)
+					forbidden := protocol.NewError(protocol.CodeForbidden, message.ID, "edit access is needed to change %s", editorID)
+					forbidden.EditorId = editorID
+					rejectDelta(id, conn, forbidden)
 					continue
 				}
-
-				for editorID, content := range updateRepoMsg.Contents {
-					deltaJSON, err := json.Marshal(content)
-					if err != nil {
-						log.Fatal("Error marshalling Delta:", err)
-						log.Println("Raw JSON:", string(msg))
-					}
-					repo.UpdateReportSectionContents(updateRepoMsg.ReportID, updateRepoMsg.Section, editorID, string(deltaJSON))
-				}
-
-			case "delta":
 				log.Println("Received JSON delta message:", string(msg))
-				repo.BufferLog(reportID, "sent a delta update: " + string(msg), userEmail)
 
-				var delta delta.Delta
-				if err := json.Unmarshal(msg, &delta); err != nil {
-					log.Println("Error unmarshalling delta:", err)
-					log.Println("Raw JSON:", string(msg))
+				// Transform against concurrent edits, broadcast and acknowledge
+				applied, err := websocketmanager.ApplyDelta(id, *message, userEmail, conn)
+				if err != nil {
+					log.Println("Error applying delta:", err)
+					rejectDelta(id, conn, socketError(err, message.ID, editorID))
+					continue
+				}
+				event := auditEvent(c, audit.ContentEdited, fmt.Sprintf("edited subsection %s", applied.Delta.EditorId), map[string]interface{}{"revision": applied.Delta.Revision, "ops": applied.Delta.Delta.Ops})
+				event.SubsectionID = applied.Delta.EditorId
+				repo.BufferLog(event)
+
+			case *protocol.Cursor:
+				if _, err := websocketmanager.MoveCursor(id, conn, message.EditorId, message.Ranges, message.Revision); err != nil {
+					log.Println("Error moving cursor:", err)
+					sendFrame(conn, socketError(err, message.ID, message.EditorId))
 					continue
 				}
-				websocketmanager.SendToIDExpectConn(id, delta, conn)
+				if message.ID != "" {
+					sendFrame(conn, protocol.Ack{Type: protocol.TypeAck, ReplyTo: message.ID})
+				}
 
-			case "close":
+			case *protocol.Close:
+				// Leaving on purpose, there is nothing to resume
+				websocketmanager.EndSession(conn)
 				websocketmanager.CloseConnection(id, conn)
-				repo.BufferLog(reportID, "User closed a WebSocket connection", userEmail)
+				repo.BufferLog(auditEvent(c, audit.SectionLeft, "closed a WebSocket connection", nil))
+			}
+		}
+	}
+}
+
+// sendFrame writes a protocol message to a client, logging failures. The
+// read loop notices a broken connection.
+func sendFrame(conn *websocket.Conn, frame interface{}) {
+	if err := websocketmanager.Send(conn, frame); err != nil {
+		log.Println("Error sending message:", err)
+	}
+}
+
+// socketError turns an error from the WebSocket manager into the frame that
+// tells the client why its message was rejected
+func socketError(err error, replyTo, editorID string) *protocol.Error {
+	code := protocol.CodeInternal
+	switch {
+	case errors.Is(err, websockets.ErrNotJoined):
+		code = protocol.CodeNotJoined
+	case errors.Is(err, websockets.ErrRestructuring):
+		code = protocol.CodeUnavailable
+	case errors.Is(err, websockets.ErrUnknownEditor):
+		code = protocol.CodeUnknownEditor
+	case errors.Is(err, websockets.ErrApproved):
+		code = protocol.CodeApproved
+	case errors.Is(err, websockets.ErrOutOfStep):
+		code = protocol.CodeOutOfStep
+	}
+
+	frame := protocol.NewError(code, replyTo, "%v", err)
+	if code == protocol.CodeInternal {
+		frame.Message = "the server could not handle the message"
+	}
+	frame.EditorId = editorID
+	return frame
+}
+
+// rejectDelta tells a client its delta was dropped. Unless sending it again
+// could work, the client is also sent the editor's contents to carry on from.
+func rejectDelta(id string, conn *websocket.Conn, frame *protocol.Error) {
+	sendFrame(conn, frame)
+	switch frame.Code {
+	case protocol.CodeForbidden, protocol.CodeApproved, protocol.CodeOutOfStep:
+		if err := websocketmanager.SendSnapshot(id, frame.EditorId, conn); err != nil {
+			log.Println("Error sending snapshot:", err)
+		}
+	}
+}
+
+// EditorsHandler lists who has a section of the report open, with the
+// subsection they are focused on and their cursor there. Members only see
+// the sections they can read.
+func EditorsHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		access, err := memberAccess(c, repo)
+		if err != nil {
+			log.Println("Error fetching section access:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check section access"})
+			return
+		}
+
+		editors := []protocol.Presence{}
+		for _, who := range websocketmanager.ReportPresence(c.Param("reportID")) {
+			if access.Section(who.SectionID).Allows(sectionAccess.Read) {
+				editors = append(editors, who)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"editors": editors})
+	}
+}
+
+// memberAccess looks up what the signed in user may do in the sections of the
+// route's report. Without a user, as on the load test routes, every section
+// is open.
+func memberAccess(c *gin.Context, repo repository.ReportRepository) (sectionAccess.Access, error) {
+	uid := c.GetString("uid")
+	if uid == "" {
+		return sectionAccess.All, nil
+	}
+	return sectionAccess.Lookup(repo, uid, c.Param("reportID"))
+}
+
+// socketAccess checks a WebSocket user's access to its section again, so
+// grants revoked and members removed while the socket is open take effect.
+// Routes without AuthSectionAccess, or without a user, keep the level the
+// socket was opened with.
+func socketAccess(c *gin.Context, repo repository.ReportRepository, opened, required sectionAccess.Level) (bool, error) {
+	if _, checked := c.Get("sectionAccess"); !checked || c.GetString("uid") == "" {
+		return opened.Allows(required), nil
+	}
+	access, err := memberAccess(c, repo)
+	if err != nil {
+		return false, err
+	}
+	return access.Section(c.Param("sectionID")).Allows(required), nil
+}
+
+// sectionRoomID is the id the WebSocket manager knows a report section by
+func sectionRoomID(reportID, sectionID string) string {
+	return reportID + "/" + sectionID
+}
+
+// Pages of the version history API hold 50 versions unless asked otherwise
+const (
+	defaultVersionPage = 50
+	maxVersionPage     = 500
+)
+
+// SubsectionVersionsHandler lists a page of a subsection's versions, newest
+// first. The next page starts after the version named by "next".
+func SubsectionVersionsHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+
+		limit := defaultVersionPage
+		if raw := c.Query("limit"); raw != "" {
+			var err error
+			if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxVersionPage {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxVersionPage)})
+				return
 			}
 		}
+
+		// One more version than the page tells whether there is a next one
+		versions, err := repo.ListSubsectionVersions(reportID, sectionID, subsectionID, repository.VersionPage{After: c.Query("after"), Limit: limit + 1})
+		if errors.Is(err, repository.ErrVersionNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown page cursor"})
+			return
+		}
+		if err != nil {
+			log.Println("Error fetching versions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+			return
+		}
+
+		next := ""
+		if len(versions) > limit {
+			versions = versions[:limit]
+			next = versions[limit-1].VersionID
+		}
+		c.JSON(http.StatusOK, gin.H{"versions": versions, "next": next})
 	}
 }
 
+func SubsectionVersionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+		versionID := c.Param("versionID")
+
+		version, err := repo.GetSubsectionVersion(reportID, sectionID, subsectionID, versionID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, version)
+	}
+}
+
+// RestoreSubsectionVersion puts an old version back as the subsection's content.
+// If the section is open the restore goes through the WebSocket manager so
+// connected editors get it as a normal delta, otherwise it is saved directly.
+func RestoreSubsectionVersion(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+		versionID := c.Param("versionID")
 
+		userEmail, ok := c.Get("email")
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email not found"})
+			return
+		}
+		userEmailStr, ok := userEmail.(string)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is not a string"})
+			return
+		}
+
+		version, err := repo.GetSubsectionVersion(reportID, sectionID, subsectionID, versionID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+
+		// Approved content stays as it is until the subsection is reopened
+		current, err := repo.GetSubsectionReview(reportID, sectionID, subsectionID)
+		if err != nil {
+			c.JSON(reviewErrorStatus(err), gin.H{"error": "Failed to fetch subsection review"})
+			return
+		}
+		if current.Status == review.Approved {
+			c.JSON(http.StatusConflict, gin.H{"error": "Subsection is approved, reopen it first"})
+			return
+		}
+
+		replaced, err := websocketmanager.ReplaceContent(sectionRoomID(reportID, sectionID), subsectionID, version.Content, userEmailStr)
+		if errors.Is(err, websockets.ErrApproved) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Subsection is approved, reopen it first"})
+			return
+		} else if err != nil {
+			log.Println("Error restoring version:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+			return
+		}
+		if !replaced {
+			err = repo.UpdateReportSectionContents(reportID, sectionID, subsectionID, version.Content, userEmailStr)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore version"})
+				return
+			}
+		}
+
+		repo.BufferLog(auditEvent(c, audit.VersionRestored, fmt.Sprintf("restored subsection %s of section %s to version %s", subsectionID, sectionID, versionID), map[string]interface{}{"versionID": versionID}))
+		c.JSON(http.StatusOK, gin.H{"message": "Version restored"})
+	}
+}
+
+// SubsectionDiffHandler compares two versions of a subsection. Without a "to"
+// version the current content is compared against, with the edits of an open
+// section that are not saved yet.
+func SubsectionDiffHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+		fromID := c.Query("from")
+		toID := c.Query("to")
+
+		if fromID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from version is required"})
+			return
+		}
+
+		from, err := repo.GetSubsectionVersion(reportID, sectionID, subsectionID, fromID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+
+		var toContent string
+		if toID != "" {
+			to, err := repo.GetSubsectionVersion(reportID, sectionID, subsectionID, toID)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+				return
+			}
+			toContent = to.Content
+		} else {
+			// An open section has edits the repository only gets on the next flush
+			current, open, err := websocketmanager.Content(sectionRoomID(reportID, sectionID), subsectionID)
+			if errors.Is(err, websockets.ErrUnknownEditor) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Subsection not found"})
+				return
+			} else if err != nil {
+				log.Println("Error reading open subsection:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subsection content"})
+				return
+			}
+			toContent = current
+			if !open {
+				contents, err := repo.FetchReportSectionContents(reportID, sectionID)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subsection content"})
+					return
+				}
+				toContent = contents[subsectionID]
+			}
+		}
+
+		result, err := versionDiff.Compare(from.Content, toContent)
+		if err != nil {
+			log.Println("Error comparing versions:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare versions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// CommentRequest is the body for starting a thread or replying to one. Index
+// and Length are the commented range, picked at Revision of the subsection.
+type CommentRequest struct {
+	Body     string `json:"body"`
+	Index    int    `json:"index"`
+	Length   int    `json:"length"`
+	Revision int    `json:"revision"`
+}
+
+// commentErrorStatus maps repository comment errors to HTTP statuses
+func commentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrCommentNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sectionHasSubsection reports whether a subsection belongs to a section of
+// the report
+func sectionHasSubsection(repo repository.ReportRepository, reportID, sectionID, subsectionID string) (bool, error) {
+	sections, err := repo.FetchReportStructure(reportID)
+	if err != nil {
+		return false, err
+	}
+	for _, section := range sections {
+		if section.ID != sectionID {
+			continue
+		}
+		for _, subsection := range section.Subsections {
+			if subsection.ID == subsectionID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// memberMentions keeps the mentioned emails of people in the report
+func memberMentions(authService authentication.AuthServiceInterface, repo repository.ReportRepository, reportID string, mentions []string) []string {
+	members := []string{}
+	for _, email := range mentions {
+		uid, err := authService.GetUIDFromEmail(email)
+		if err != nil {
+			continue
+		}
+		if inReport, err := repo.IsUserInReport(uid, reportID); err == nil && inReport {
+			members = append(members, email)
+		}
+	}
+	return members
+}
+
+// sectionThread fetches a thread of the route's report and checks it is on a
+// subsection of the route's section, responding with an error if not
+func sectionThread(c *gin.Context, repo repository.ReportRepository) (*comment.Thread, bool) {
+	reportID := c.Param("reportID")
+	thread, err := repo.GetCommentThread(reportID, c.Param("threadID"))
+	if err != nil {
+		c.JSON(commentErrorStatus(err), gin.H{"error": "Comment not found"})
+		return nil, false
+	}
+	inSection, err := sectionHasSubsection(repo, reportID, c.Param("sectionID"), thread.SubsectionID)
+	if err != nil {
+		log.Println("Error fetching report structure:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report structure"})
+		return nil, false
+	} else if !inSection {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return nil, false
+	}
+	return thread, true
+}
+
+// CommentThreadsHandler lists the comment threads of a section. While the
+// section is open its anchors are the live ones.
+func CommentThreadsHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+
+		threads, open := websocketmanager.CommentThreads(sectionRoomID(reportID, sectionID))
+		if !open {
+			var err error
+			threads, err = repo.FetchCommentThreads(reportID, sectionID)
+			if err != nil {
+				log.Println("Error fetching comments:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"threads": threads})
+	}
+}
+
+// AddCommentHandler starts a thread on a range of a subsection and sends it to
+// everyone in the section
+func AddCommentHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+
+		var req CommentRequest
+		if err := c.ShouldBindJSON(&req); err != nil || !comment.ValidBody(req.Body) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment"})
+			return
+		}
+		if req.Index < 0 || req.Length < 0 || req.Revision < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment range"})
+			return
+		}
+
+		inSection, err := sectionHasSubsection(repo, reportID, sectionID, subsectionID)
+		if err != nil {
+			log.Println("Error fetching report structure:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report structure"})
+			return
+		} else if !inSection {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subsection not found"})
+			return
+		}
+
+		// The range is moved to the current content before it is stored, and
+		// again over any edit made while storing it
+		room := sectionRoomID(reportID, sectionID)
+		anchor, revision, err := websocketmanager.RebaseAnchor(room, subsectionID, comment.Anchor{Index: req.Index, Length: req.Length}, req.Revision)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "The content changed too much, select the text again"})
+			return
+		}
+
+		thread := comment.NewThread(reportID, subsectionID, anchor, c.GetString("email"), req.Body, time.Now())
+		thread.Mentions = memberMentions(authService, repo, reportID, thread.Mentions)
+		thread.ID, err = repo.CreateCommentThread(thread)
+		if err != nil {
+			log.Println("Error creating comment:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+			return
+		}
+		if tracked, err := websocketmanager.AddComment(room, thread, revision); err != nil {
+			log.Println("Error tracking comment:", err)
+		} else {
+			thread = tracked
+		}
+
+		repo.BufferLog(auditEvent(c, audit.CommentCreated, fmt.Sprintf("commented on subsection %s of section %s", subsectionID, sectionID), map[string]interface{}{"threadID": thread.ID, "mentions": thread.Mentions}))
+		c.JSON(http.StatusCreated, thread)
+	}
+}
+
+// ReplyCommentHandler adds a reply to a thread and sends the thread to
+// everyone in the section
+func ReplyCommentHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+
+		var req CommentRequest
+		if err := c.ShouldBindJSON(&req); err != nil || !comment.ValidBody(req.Body) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reply"})
+			return
+		}
+		thread, ok := sectionThread(c, repo)
+		if !ok {
+			return
+		}
+
+		reply := comment.NewReply(c.GetString("email"), req.Body, time.Now())
+		reply.Mentions = memberMentions(authService, repo, reportID, reply.Mentions)
+		thread, err := repo.AddCommentReply(reportID, thread.ID, reply)
+		if err != nil {
+			log.Println("Error replying to comment:", err)
+			c.JSON(commentErrorStatus(err), gin.H{"error": "Failed to reply"})
+			return
+		}
+		updated := websocketmanager.UpdateComment(sectionRoomID(reportID, sectionID), *thread)
+
+		event := auditEvent(c, audit.CommentReplied, fmt.Sprintf("replied to a comment on subsection %s of section %s", thread.SubsectionID, sectionID), map[string]interface{}{"threadID": thread.ID, "replyID": reply.ID, "mentions": reply.Mentions})
+		event.SubsectionID = thread.SubsectionID
+		repo.BufferLog(event)
+		c.JSON(http.StatusCreated, updated)
+	}
+}
+
+// ResolveCommentHandler resolves a thread, or reopens it, and sends it to
+// everyone in the section
+func ResolveCommentHandler(repo repository.ReportRepository, resolved bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+
+		thread, ok := sectionThread(c, repo)
+		if !ok {
+			return
+		}
+
+		thread, err := repo.SetCommentResolved(reportID, thread.ID, resolved, c.GetString("email"), time.Now())
+		if err != nil {
+			log.Println("Error resolving comment:", err)
+			c.JSON(commentErrorStatus(err), gin.H{"error": "Failed to change comment"})
+			return
+		}
+		updated := websocketmanager.UpdateComment(sectionRoomID(reportID, sectionID), *thread)
+
+		action, verb := audit.CommentResolved, "resolved"
+		if !resolved {
+			action, verb = audit.CommentReopened, "reopened"
+		}
+		event := auditEvent(c, action, fmt.Sprintf("%s a comment on subsection %s of section %s", verb, thread.SubsectionID, sectionID), map[string]interface{}{"threadID": thread.ID})
+		event.SubsectionID = thread.SubsectionID
+		repo.BufferLog(event)
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// ReviewRequest is the body for changing the review status of a subsection,
+// or of every subsection of a section
+type ReviewRequest struct {
+	Status review.Status `json:"status"`
+	Note   string        `json:"note"`
+}
+
+// ReviewersRequest is the body for assigning reviewers, by email
+type ReviewersRequest struct {
+	Reviewers []string `json:"reviewers"`
+}
+
+// reviewErrorStatus maps repository review errors to HTTP statuses
+func reviewErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrSectionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrStatusChanged):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// reviewTargets returns the subsections of the route's section a review
+// change applies to: the route's subsection, or all of them. It responds with
+// an error when the section or subsection does not exist.
+func reviewTargets(c *gin.Context, repo repository.ReportRepository) ([]string, bool) {
+	sections, err := repo.FetchReportStructure(c.Param("reportID"))
+	if err != nil {
+		log.Println("Error fetching report structure:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report structure"})
+		return nil, false
+	}
+	for _, section := range sections {
+		if section.ID != c.Param("sectionID") {
+			continue
+		}
+		var targets []string
+		for _, subsection := range section.Subsections {
+			if subsectionID := c.Param("subsectionID"); subsectionID == "" || subsectionID == subsection.ID {
+				targets = append(targets, subsection.ID)
+			}
+		}
+		if len(targets) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Subsection not found"})
+			return nil, false
+		}
+		return targets, true
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Section not found"})
+	return nil, false
+}
+
+// sectionReviewResponse is a section's status with the review of each of
+// its subsections
+func sectionReviewResponse(reviews map[string]review.Review) gin.H {
+	statuses := make([]review.Status, 0, len(reviews))
+	for _, r := range reviews {
+		statuses = append(statuses, r.Status)
+	}
+	return gin.H{"status": review.SectionStatus(statuses), "subsections": reviews}
+}
+
+// SectionReviewHandler returns the review status of a section and its
+// subsections, with their reviewers and approvals
+func SectionReviewHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := reviewTargets(c, repo); !ok {
+			return
+		}
+		reviews, err := repo.FetchSectionReviews(c.Param("reportID"), c.Param("sectionID"))
+		if err != nil {
+			log.Println("Error fetching reviews:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+			return
+		}
+		c.JSON(http.StatusOK, sectionReviewResponse(reviews))
+	}
+}
+
+// ReviewStatusHandler moves a subsection, or every subsection of a section,
+// to another review status. Every subsection changed has to be at the same
+// status and the user has to be allowed to move each of them. Approved
+// subsections take no edits until they are reopened.
+func ReviewStatusHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		email := invitation.NormalizeEmail(c.GetString("email"))
+
+		var req ReviewRequest
+		if err := c.ShouldBindJSON(&req); err != nil || !req.Status.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+		targets, ok := reviewTargets(c, repo)
+		if !ok {
+			return
+		}
+		reviews, err := repo.FetchSectionReviews(reportID, sectionID)
+		if err != nil {
+			log.Println("Error fetching reviews:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+			return
+		}
+		access, err := memberAccess(c, repo)
+		if err != nil {
+			log.Println("Error fetching section access:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check section access"})
+			return
+		}
+
+		// Check every subsection before changing any
+		from := reviews[targets[0]].Status
+		for _, subsectionID := range targets {
+			current := reviews[subsectionID]
+			actor := review.Actor{
+				Admin:    access.Role.IsAdmin(),
+				Editor:   access.Section(sectionID).Allows(sectionAccess.Edit),
+				Reviewer: current.IsReviewer(email),
+			}
+			switch {
+			case current.Status != from:
+				c.JSON(http.StatusConflict, gin.H{"error": "The subsections are at different statuses, change them one at a time"})
+				return
+			case !review.Allowed(from, req.Status):
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot go from %s to %s", from, req.Status)})
+				return
+			case !review.MayTransition(from, req.Status, actor):
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You may not move %s to %s", from, req.Status)})
+				return
+			}
+		}
+
+		room := sectionRoomID(reportID, sectionID)
+		change := review.Transition{From: from, To: req.Status, By: email, At: time.Now(), Note: req.Note}
+		for _, subsectionID := range targets {
+			changed, err := repo.ChangeSubsectionStatus(reportID, sectionID, subsectionID, change)
+			if err != nil {
+				log.Println("Error changing review status:", err)
+				c.JSON(reviewErrorStatus(err), gin.H{"error": "Failed to change status"})
+				return
+			}
+			reviews[subsectionID] = *changed
+			websocketmanager.UpdateReview(room, subsectionID, *changed)
+
+			event := auditEvent(c, audit.ReviewStatusChanged, fmt.Sprintf("moved subsection %s of section %s from %s to %s", subsectionID, sectionID, from, req.Status), map[string]interface{}{"from": string(from), "to": string(req.Status), "note": req.Note})
+			event.SubsectionID = subsectionID
+			repo.BufferLog(event)
+		}
+
+		c.JSON(http.StatusOK, sectionReviewResponse(reviews))
+	}
+}
+
+// ReviewersHandler assigns the reviewers of a subsection, or of every
+// subsection of a section. Reviewers have to be in the report.
+func ReviewersHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+
+		var req ReviewersRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reviewers"})
+			return
+		}
+		reviewers := []string{}
+		seen := map[string]bool{}
+		for _, email := range req.Reviewers {
+			email = invitation.NormalizeEmail(email)
+			if seen[email] {
+				continue
+			}
+			seen[email] = true
+			uid, err := authService.GetUIDFromEmail(email)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s has no account", email)})
+				return
+			}
+			if inReport, err := repo.IsUserInReport(uid, reportID); err != nil || !inReport {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is not in the report", email)})
+				return
+			}
+			reviewers = append(reviewers, email)
+		}
+
+		targets, ok := reviewTargets(c, repo)
+		if !ok {
+			return
+		}
+		room := sectionRoomID(reportID, sectionID)
+		for _, subsectionID := range targets {
+			changed, err := repo.SetSubsectionReviewers(reportID, sectionID, subsectionID, reviewers)
+			if err != nil {
+				log.Println("Error assigning reviewers:", err)
+				c.JSON(reviewErrorStatus(err), gin.H{"error": "Failed to assign reviewers"})
+				return
+			}
+			websocketmanager.UpdateReview(room, subsectionID, *changed)
+
+			event := auditEvent(c, audit.ReviewersAssigned, fmt.Sprintf("assigned reviewers %s to subsection %s of section %s", strings.Join(reviewers, ", "), subsectionID, sectionID), map[string]interface{}{"reviewers": reviewers})
+			event.SubsectionID = subsectionID
+			repo.BufferLog(event)
+		}
+
+		reviews, err := repo.FetchSectionReviews(reportID, sectionID)
+		if err != nil {
+			log.Println("Error fetching reviews:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch reviews"})
+			return
+		}
+		c.JSON(http.StatusOK, sectionReviewResponse(reviews))
+	}
+}
+
+// SectionRequest is the body for adding or changing a section or subsection.
+// Position is 0-based and without one it goes at the end. SectionID only
+// applies to subsections, moving them to another section.
+type SectionRequest struct {
+	Title     string `json:"title"`
+	SectionID string `json:"sectionID"`
+	Position  *int   `json:"position"`
+}
+
+func (req SectionRequest) position() int {
+	if req.Position == nil {
+		return -1
+	}
+	return *req.Position
+}
+
+// validSectionTitle rejects blank titles. Anything else is fine, since
+// sections and subsections are stored under generated IDs.
+func validSectionTitle(title string) bool {
+	return strings.TrimSpace(title) != ""
+}
+
+// sectionErrorStatus maps repository section errors to HTTP statuses
+func sectionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrSectionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrSectionExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// restructure applies a change to a report's sections through the WebSocket
+// manager, so open sections are saved first and editors are told to reload,
+// and responds with the new structure. A change that adds a section or
+// subsection returns its ID, which is included in the response and the
+// logged event's payload.
+func restructure(c *gin.Context, repo repository.ReportRepository, event audit.Event, change func() (string, error)) {
+	reportID := c.Param("reportID")
+
+	var id string
+	var sections []reportStructure.Section
+	err := websocketmanager.RestructureReport(reportID, func() ([]reportStructure.Section, error) {
+		var err error
+		if id, err = change(); err != nil {
+			return nil, err
+		}
+		sections, err = repo.FetchReportStructure(reportID)
+		return sections, err
+	})
+	if err != nil {
+		log.Println("Error restructuring report:", err)
+		status := sectionErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			c.JSON(status, gin.H{"error": "Failed to change report sections"})
+		} else {
+			c.JSON(status, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if id != "" {
+		if event.Payload == nil {
+			event.Payload = map[string]interface{}{}
+		}
+		event.Payload["id"] = id
+	}
+	repo.BufferLog(event)
+	if id != "" {
+		c.JSON(http.StatusOK, gin.H{"id": id, "sections": sections})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"sections": sections})
+}
+
+// sectionChange is the payload of an event moving or renaming a section or
+// subsection
+func sectionChange(req SectionRequest) map[string]interface{} {
+	payload := map[string]interface{}{}
+	if req.Title != "" {
+		payload["title"] = req.Title
+	}
+	if req.SectionID != "" {
+		payload["sectionID"] = req.SectionID
+	}
+	if req.Position != nil {
+		payload["position"] = *req.Position
+	}
+	return payload
+}
+
+// ReportStructureHandler lists the sections the member may read, with what
+// they may do in each
+func ReportStructureHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sections, err := repo.FetchReportStructure(c.Param("reportID"))
+		if err != nil {
+			log.Println("Error fetching report structure:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report structure"})
+			return
+		}
+		access, err := memberAccess(c, repo)
+		if err != nil {
+			log.Println("Error fetching section access:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check section access"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"sections": access.Filter(sections)})
+	}
+}
+
+func AddSectionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req SectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || !validSectionTitle(req.Title) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A section title is required"})
+			return
+		}
+
+		restructure(c, repo, auditEvent(c, audit.SectionAdded, fmt.Sprintf("added section %s", req.Title), map[string]interface{}{"title": req.Title}), func() (string, error) {
+			return repo.AddSection(c.Param("reportID"), req.Title, req.position())
+		})
+	}
+}
+
+// UpdateSectionHandler renames a section, moves it, or both. Its subsections
+// and their history are kept.
+func UpdateSectionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+
+		var req SectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Title == "" && req.Position == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A new title or position is required"})
+			return
+		}
+		if req.Title != "" && !validSectionTitle(req.Title) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid section title"})
+			return
+		}
+
+		var changes []string
+		if req.Title != "" {
+			changes = append(changes, "renamed to "+req.Title)
+		}
+		if req.Position != nil {
+			changes = append(changes, fmt.Sprintf("moved to position %d", *req.Position))
+		}
+
+		message := fmt.Sprintf("section %s %s", sectionID, strings.Join(changes, " and "))
+		restructure(c, repo, auditEvent(c, audit.SectionUpdated, message, sectionChange(req)), func() (string, error) {
+			if req.Title != "" {
+				if err := repo.RenameSection(reportID, sectionID, req.Title); err != nil {
+					return "", err
+				}
+			}
+			if req.Position != nil {
+				return "", repo.MoveSection(reportID, sectionID, *req.Position)
+			}
+			return "", nil
+		})
+	}
+}
+
+func DeleteSectionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sectionID := c.Param("sectionID")
+
+		restructure(c, repo, auditEvent(c, audit.SectionDeleted, fmt.Sprintf("deleted section %s", sectionID), nil), func() (string, error) {
+			return "", repo.DeleteSection(c.Param("reportID"), sectionID)
+		})
+	}
+}
+
+func AddSubsectionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sectionID := c.Param("sectionID")
+
+		var req SectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || !validSectionTitle(req.Title) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A subsection title is required"})
+			return
+		}
+
+		message := fmt.Sprintf("added subsection %s to section %s", req.Title, sectionID)
+		restructure(c, repo, auditEvent(c, audit.SubsectionAdded, message, map[string]interface{}{"title": req.Title}), func() (string, error) {
+			return repo.AddSubsection(c.Param("reportID"), sectionID, req.Title, req.position())
+		})
+	}
+}
+
+// UpdateSubsectionHandler renames a subsection, moves it within its section
+// or to another one, or both. Its content and versions are kept.
+func UpdateSubsectionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+
+		var req SectionRequest
+		if err := c.ShouldBindJSON(&req); err != nil || (req.Title == "" && req.SectionID == "" && req.Position == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A new title, section or position is required"})
+			return
+		}
+		if req.Title != "" && !validSectionTitle(req.Title) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subsection title"})
+			return
+		}
+
+		toSection := sectionID
+		if req.SectionID != "" {
+			toSection = req.SectionID
+		}
+		moved := req.SectionID != "" || req.Position != nil
+
+		var changes []string
+		if req.Title != "" {
+			changes = append(changes, "renamed to "+req.Title)
+		}
+		if moved {
+			changes = append(changes, fmt.Sprintf("moved to section %s position %d", toSection, req.position()))
+		}
+
+		message := fmt.Sprintf("subsection %s of section %s %s", subsectionID, sectionID, strings.Join(changes, " and "))
+		restructure(c, repo, auditEvent(c, audit.SubsectionUpdated, message, sectionChange(req)), func() (string, error) {
+			if moved {
+				if err := repo.MoveSubsection(reportID, sectionID, subsectionID, toSection, req.position()); err != nil {
+					return "", err
+				}
+			}
+			if req.Title != "" {
+				return "", repo.RenameSubsection(reportID, toSection, subsectionID, req.Title)
+			}
+			return "", nil
+		})
+	}
+}
+
+func DeleteSubsectionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sectionID := c.Param("sectionID")
+		subsectionID := c.Param("subsectionID")
+
+		message := fmt.Sprintf("deleted subsection %s of section %s", subsectionID, sectionID)
+		restructure(c, repo, auditEvent(c, audit.SubsectionDeleted, message, nil), func() (string, error) {
+			return "", repo.DeleteSubsection(c.Param("reportID"), sectionID, subsectionID)
+		})
+	}
+}
+
+// GrantRequest is the body for granting or revoking a member's access to a
+// section. Access is "read", "comment" or "edit".
+type GrantRequest struct {
+	Email  string              `json:"email"`
+	Access sectionAccess.Level `json:"access"`
+}
+
+// SectionGrantsHandler shows the sections a member has been granted, by
+// section ID, and their role. Report admins can edit every section without
+// grants, other roles limit what a grant allows.
+func SectionGrantsHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := c.Query("email")
+		if email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+			return
+		}
+		uid, err := authService.GetUIDFromEmail(email)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Failed to get UID from email, is user registered?"})
+			return
+		}
+
+		access, err := sectionAccess.Lookup(repo, uid, c.Param("reportID"))
+		if err != nil {
+			log.Println("Error fetching section grants:", err)
+			c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to fetch section grants"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"email": email, "role": access.Role, "admin": access.Role.IsAdmin(), "grants": access.Grants})
+	}
+}
+
+// GrantSectionHandler gives a member read, comment or edit access to a
+// section, replacing what they had
+func GrantSectionHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req GrantRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" || !req.Access.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An email and an access of read, comment or edit are required"})
+			return
+		}
+
+		logMessage := func(section string) string {
+			return fmt.Sprintf("granted %s %s access to section %s", req.Email, req.Access, section)
+		}
+		changeGrant(c, authService, repo, req, audit.SectionGrantChanged, logMessage, func(uid, reportID, sectionID string) error {
+			return repo.SetSectionGrant(uid, reportID, sectionID, req.Access)
+		})
+	}
+}
+
+// RevokeSectionHandler takes away a member's access to a section
+func RevokeSectionHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req GrantRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+			return
+		}
+
+		logMessage := func(section string) string {
+			return fmt.Sprintf("revoked access of %s to section %s", req.Email, section)
+		}
+		changeGrant(c, authService, repo, req, audit.SectionGrantRevoked, logMessage, func(uid, reportID, sectionID string) error {
+			return repo.RevokeSectionGrant(uid, reportID, sectionID)
+		})
+	}
+}
+
+// changeGrant checks the member and the section of the route exist, then
+// changes the member's grant and logs it with the section's title
+func changeGrant(c *gin.Context, authService authentication.AuthServiceInterface, repo repository.ReportRepository, req GrantRequest, action audit.Action, logMessage func(section string) string, change func(uid, reportID, sectionID string) error) {
+	reportID := c.Param("reportID")
+	sectionID := c.Param("sectionID")
+
+	uid, err := authService.GetUIDFromEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to get UID from email, is user registered?"})
+		return
+	}
+
+	sections, err := repo.FetchReportStructure(reportID)
+	if err != nil {
+		log.Println("Error fetching report structure:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report structure"})
+		return
+	}
+	title := ""
+	for _, section := range sections {
+		if section.ID == sectionID {
+			title = section.Title
+		}
+	}
+	if title == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Section not found"})
+		return
+	}
+
+	if err := change(uid, reportID, sectionID); err != nil {
+		log.Println("Error changing section grant:", err)
+		c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to change section access"})
+		return
+	}
+
+	payload := map[string]interface{}{"email": req.Email}
+	if action == audit.SectionGrantChanged {
+		payload["access"] = string(req.Access)
+	}
+	repo.BufferLog(auditEvent(c, action, logMessage(title), payload))
+	c.JSON(http.StatusOK, gin.H{"message": "Section access updated"})
+}
+
+// memberErrorStatus maps repository membership and grant errors to HTTP
+// statuses
+func memberErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrUserNotInReport), errors.Is(err, repository.ErrReportNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrAlreadyInReport), errors.Is(err, repository.ErrLastOwner):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrNotOwner):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+type MemberRoleRequest struct {
+	Email string           `json:"email"`
+	Role  reportRoles.Role `json:"role"`
+}
+
+// callerRole looks up the role of the signed in user in the route's report,
+// writing the error response when it fails. Without a user, as on the load
+// test routes, the caller may do anything.
+func callerRole(c *gin.Context, repo repository.ReportRepository) (reportRoles.Role, bool) {
+	uid := c.GetString("uid")
+	if uid == "" {
+		return reportRoles.Owner, true
+	}
+	role, err := repo.GetMemberRole(uid, c.Param("reportID"))
+	if err != nil {
+		log.Println("Error fetching member role:", err)
+		c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to fetch your role"})
+		return reportRoles.None, false
+	}
+	return role, true
+}
+
+// mayManageMember checks the signed in user may change or remove a member of
+// the route's report, writing the error response when not
+func mayManageMember(c *gin.Context, repo repository.ReportRepository, uid string) bool {
+	caller, ok := callerRole(c, repo)
+	if !ok {
+		return false
+	}
+	role, err := repo.GetMemberRole(uid, c.Param("reportID"))
+	if err != nil {
+		log.Println("Error fetching member role:", err)
+		c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to fetch the member's role"})
+		return false
+	}
+	if !caller.Manages(role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can change owners"})
+		return false
+	}
+	return true
+}
+
+// MembersHandler lists who is in a report, with their role, email and when
+// they joined
+func MembersHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		members, err := repo.ListMembers(c.Param("reportID"))
+		if err != nil {
+			log.Println("Error listing members:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list members"})
+			return
+		}
+
+		for i, member := range members {
+			user, err := authService.GetUserByUID(member.UID)
+			if err != nil || user == nil || user.UserInfo == nil {
+				log.Println("Error fetching member email:", member.UID, err)
+				continue
+			}
+			members[i].Email = user.Email
+		}
+		c.JSON(http.StatusOK, gin.H{"members": members})
+	}
+}
+
+// MemberRoleHandler changes the role of a member. Admins manage everyone but
+// owners, only owners make or change owners, and the last owner of a report
+// keeps their role.
+func MemberRoleHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		var req MemberRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" || !req.Role.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An email and a role of viewer, commenter, editor, admin or owner are required"})
+			return
+		}
+
+		uid, err := authService.GetUIDFromEmail(req.Email)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Failed to get UID from email, is user registered?"})
+			return
+		}
+
+		previous, err := repo.GetMemberRole(uid, reportID)
+		if err != nil {
+			log.Println("Error fetching member role:", err)
+			c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to fetch the member's role"})
+			return
+		}
+		caller, ok := callerRole(c, repo)
+		if !ok {
+			return
+		}
+		if !caller.Manages(previous) || !caller.Manages(req.Role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only owners can change owners"})
+			return
+		}
+
+		if err := repo.SetMemberRole(uid, reportID, req.Role); err != nil {
+			log.Println("Error changing member role:", err)
+			c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to change role, a report must keep an owner"})
+			return
+		}
+
+		message := fmt.Sprintf("changed the role of %s from %s to %s", req.Email, previous, req.Role)
+		repo.BufferLog(auditEvent(c, audit.MemberRoleChanged, message, map[string]interface{}{"email": req.Email, "from": string(previous), "to": string(req.Role)}))
+		c.JSON(http.StatusOK, gin.H{"message": "Role updated", "role": req.Role})
+	}
+}
+
+// TransferOwnershipHandler hands the signed in owner's ownership to another
+// member. The previous owner stays on as an admin.
+func TransferOwnershipHandler(authService authentication.AuthServiceInterface, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+		var req MemberRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email is required"})
+			return
+		}
+
+		uid, err := authService.GetUIDFromEmail(req.Email)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Failed to get UID from email, is user registered?"})
+			return
+		}
+
+		if err := repo.TransferOwnership(reportID, c.GetString("uid"), uid); err != nil {
+			log.Println("Error transferring ownership:", err)
+			c.JSON(memberErrorStatus(err), gin.H{"error": "Failed to transfer ownership"})
+			return
+		}
+
+		repo.BufferLog(auditEvent(c, audit.OwnershipTransferred, fmt.Sprintf("transferred ownership to %s", req.Email), map[string]interface{}{"email": req.Email}))
+		c.JSON(http.StatusOK, gin.H{"message": "Ownership transferred"})
+	}
+}
+
+// MigrationRequest picks the template version to migrate a report to, the
+// latest when left out. Migrations that would delete written content are
+// refused unless DiscardContent is set.
+type MigrationRequest struct {
+	Version        int  `json:"version"`
+	DryRun         bool `json:"dryRun"`
+	DiscardContent bool `json:"discardContent"`
+}
+
+// planMigration works out the steps to take a report to a version of its
+// template
+func planMigration(repo repository.ReportRepository, reportID string, version int) (*templateMigration.Migration, error) {
+	templateID, err := repo.GetReportFieldTemplateID(reportID)
+	if err != nil {
+		return nil, err
+	}
+	fromVersion, err := repo.GetReportTemplateVersion(reportID)
+	if err != nil {
+		return nil, err
+	}
+
+	var template *reportTemplates.ReportTemplate
+	if version == 0 {
+		template, err = repo.GetTemplate(templateID)
+	} else {
+		template, err = repo.GetTemplateVersion(templateID, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := repo.FetchReportStructure(reportID)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := templateMigration.Plan(current, template.Sections)
+	if err := templateMigration.MarkDiscardedContent(repo, reportID, steps); err != nil {
+		return nil, err
+	}
+	return &templateMigration.Migration{
+		TemplateID:  templateID,
+		FromVersion: fromVersion,
+		ToVersion:   template.Version,
+		Steps:       steps,
+	}, nil
+}
+
+// MigrationPlanHandler shows the changes migrating a report to a version of
+// its template would make, without making them
+func MigrationPlanHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		version := 0
+		if param := c.Query("version"); param != "" {
+			var err error
+			if version, err = strconv.Atoi(param); err != nil || version < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+				return
+			}
+		}
+
+		migration, err := planMigration(repo, c.Param("reportID"), version)
+		if err != nil {
+			log.Println("Error planning migration:", err)
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to plan migration"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"migration": migration, "dryRun": true})
+	}
+}
+
+// MigrateReportHandler brings a report's sections and subsections in line with
+// a version of its template. Content of the ones kept is untouched.
+func MigrateReportHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportID := c.Param("reportID")
+
+		var req MigrationRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.Version < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+
+		if req.DryRun {
+			migration, err := planMigration(repo, reportID, req.Version)
+			if err != nil {
+				log.Println("Error planning migration:", err)
+				c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to plan migration"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"migration": migration, "dryRun": true})
+			return
+		}
+
+		// Planned again once open sections are saved, so it matches what is applied
+		var migration *templateMigration.Migration
+		var sections []reportStructure.Section
+		errDiscards := errors.New("migration would delete content")
+		err := websocketmanager.RestructureReport(reportID, func() ([]reportStructure.Section, error) {
+			var err error
+			migration, err = planMigration(repo, reportID, req.Version)
+			if err != nil {
+				return nil, err
+			}
+			if migration.DiscardsContent() && !req.DiscardContent {
+				return nil, errDiscards
+			}
+
+			if err := templateMigration.Apply(repo, reportID, migration.Steps); err != nil {
+				return nil, err
+			}
+			if err := repo.SetReportTemplateVersion(reportID, migration.ToVersion); err != nil {
+				return nil, err
+			}
+			sections, err = repo.FetchReportStructure(reportID)
+			return sections, err
+		})
+
+		switch {
+		case errors.Is(err, errDiscards):
+			c.JSON(http.StatusConflict, gin.H{"error": "The migration deletes subsections with content, set discardContent to go ahead", "migration": migration})
+			return
+		case errors.Is(err, repository.ErrTemplateNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template version not found"})
+			return
+		case err != nil:
+			log.Println("Error migrating report:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to migrate report"})
+			return
+		}
+
+		repo.BufferLog(auditEvent(c, audit.ReportMigrated, migration.LogMessage(), map[string]interface{}{"fromVersion": migration.FromVersion, "toVersion": migration.ToVersion}))
+		log.Println("Migrated report", reportID, "to template version", migration.ToVersion)
+		c.JSON(http.StatusOK, gin.H{"migration": migration, "dryRun": false, "sections": sections})
+	}
+}
+
+// TemplateRequest is the body for creating or updating a template. Sections
+// are in order, each with its subsections in order.
+type TemplateRequest struct {
+	TemplateID string                    `json:"templateID"` // Only read on create, generated when empty
+	Name       string                    `json:"name"`
+	Sections   []reportTemplates.Section `json:"sections"`
+	Style      reportTemplates.Style     `json:"style"`
+}
+
+func (req TemplateRequest) template() reportTemplates.ReportTemplate {
+	return reportTemplates.ReportTemplate{Name: req.Name, Sections: req.Sections, Style: req.Style}
+}
+
+// CloneTemplateRequest names the copy. Without a version the current
+// template is cloned.
+type CloneTemplateRequest struct {
+	TemplateID string `json:"templateID"`
+	Name       string `json:"name"`
+	Version    int    `json:"version"`
+}
+
+// templateErrorStatus maps repository template errors to HTTP statuses
+func templateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrTemplateNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrTemplateExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// maxTemplateIDBytes is the longest document ID Firestore accepts
+const maxTemplateIDBytes = 1500
+
+// validTemplateID rejects IDs Firestore can't use as a document ID: empty,
+// with a slash, "." or "..", reserved like "__name__", or too long
+func validTemplateID(templateID string) bool {
+	reserved := len(templateID) >= 4 && strings.HasPrefix(templateID, "__") && strings.HasSuffix(templateID, "__")
+	return strings.TrimSpace(templateID) != "" && !strings.Contains(templateID, "/") && templateID != "." && templateID != ".." &&
+		!reserved && len(templateID) <= maxTemplateIDBytes
+}
+
+func ListTemplatesHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templates, err := repo.ListTemplates()
+		if err != nil {
+			log.Println("Error listing templates:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch templates"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"templates": templates})
+	}
+}
+
+func GetTemplateHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templateID := c.Param("templateID")
+
+		template, err := repo.GetTemplate(templateID)
+		if err != nil {
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to fetch template"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"templateID": templateID, "template": template})
+	}
+}
+
+func CreateTemplateHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		templateID := req.TemplateID
+		if templateID == "" {
+			templateID = generateID()
+		}
+		if !validTemplateID(templateID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+			return
+		}
+
+		template := req.template()
+		if err := template.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		saved, err := repo.CreateTemplate(templateID, template, c.GetString("email"))
+		if err != nil {
+			log.Println("Error creating template:", err)
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to create template"})
+			return
+		}
+
+		log.Println("Created template", templateID)
+		c.JSON(http.StatusCreated, gin.H{"templateID": templateID, "template": saved})
+	}
+}
+
+// UpdateTemplateHandler saves a new version of a template. Reports already
+// created keep the version they were made from.
+func UpdateTemplateHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templateID := c.Param("templateID")
+
+		var req TemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		template := req.template()
+		if err := template.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		saved, err := repo.UpdateTemplate(templateID, template, c.GetString("email"))
+		if err != nil {
+			log.Println("Error updating template:", err)
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to update template"})
+			return
+		}
+
+		log.Println("Updated template", templateID, "to version", saved.Version)
+		c.JSON(http.StatusOK, gin.H{"templateID": templateID, "template": saved})
+	}
+}
+
+// CloneTemplateHandler copies a template, or one of its versions, into a new
+// template starting at version 1
+func CloneTemplateHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sourceID := c.Param("templateID")
+
+		var req CloneTemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+
+		var source *reportTemplates.ReportTemplate
+		var err error
+		if req.Version > 0 {
+			source, err = repo.GetTemplateVersion(sourceID, req.Version)
+		} else {
+			source, err = repo.GetTemplate(sourceID)
+		}
+		if err != nil {
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to fetch template"})
+			return
+		}
+
+		templateID := req.TemplateID
+		if templateID == "" {
+			templateID = generateID()
+		}
+		if !validTemplateID(templateID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+			return
+		}
+
+		clone := source.Copy()
+		clone.Name = req.Name
+		if clone.Name == "" {
+			clone.Name = source.Name + " (copy)"
+		}
+		clone.ClonedFrom = fmt.Sprintf("%s@%d", sourceID, source.Version)
+
+		saved, err := repo.CreateTemplate(templateID, clone, c.GetString("email"))
+		if err != nil {
+			log.Println("Error cloning template:", err)
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to clone template"})
+			return
+		}
+
+		log.Println("Cloned template", sourceID, "to", templateID)
+		c.JSON(http.StatusCreated, gin.H{"templateID": templateID, "template": saved})
+	}
+}
+
+func TemplateVersionsHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		versions, err := repo.ListTemplateVersions(c.Param("templateID"))
+		if err != nil {
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Failed to fetch template versions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"versions": versions})
+	}
+}
+
+func TemplateVersionHandler(repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templateID := c.Param("templateID")
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil || version < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+
+		template, err := repo.GetTemplateVersion(templateID, version)
+		if err != nil {
+			c.JSON(templateErrorStatus(err), gin.H{"error": "Version not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"templateID": templateID, "template": template})
+	}
+}
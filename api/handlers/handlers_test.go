@@ -1,20 +1,35 @@
 package handlers_test
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"strings"
 	"os"
 	"time"
 
 	"sema/repository"
+	"sema/models/audit"
+	"sema/models/comment"
+	"sema/models/invitation"
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
 	"sema/models/reportTemplates"
+	"sema/models/review"
+	"sema/services/authentication"
+	"sema/services/sectionAccess"
+	"sema/services/templateMigration"
+	"sema/models/protocol"
 
 	"github.com/gorilla/websocket"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"sema/api/handlers"
+	"sema/api/middleware"
 	auth "firebase.google.com/go/auth"
 
 
@@ -26,6 +41,7 @@ import (
 type mockAuthService struct {
 	verifyTokenFunc func(token string) (*auth.Token, error)
 	 GetUIDFromEmailFunc func(email string) (string, error)
+	GetUserByUIDFunc func(uid string) (*auth.UserRecord, error)
 
 }
 
@@ -39,6 +55,9 @@ func (m *mockAuthService) GetUIDFromEmail(email string) (string, error) {
 
 
 func (m *mockAuthService) GetUserByUID(uid string) (*auth.UserRecord, error) {
+	if m.GetUserByUIDFunc != nil {
+		return m.GetUserByUIDFunc(uid)
+	}
 	return nil, nil
 }
 
@@ -59,21 +78,30 @@ func (m *mockAuthService) VerifyToken(token string) (*auth.Token, error) {
 
 
 type mockRepo struct{
-	BufferLogFunc func(reportID, message, user string)
+	BufferLogFunc func(event audit.Event)
 	getReportFieldTemplateIDFunc func(reportID string) (string, error)
 	getTemplateFunc func(templateID string) (*reportTemplates.ReportTemplate, error)
 	FetchReportContentFunc func(reportID string) (string, []map[string]interface{}, error)
-	FetchLogsForReportFunc func(reportID string) ([]string, error)
+	FetchLogsForReportFunc func(reportID string, query audit.Query) ([]audit.Event, error)
 	RemoveUserFromReportFunc func(uid, reportID string) error
 	RenameReportFunc func(reportID, newName string) error
 	DeleteReportFunc func(reportID string) error
 	IsAdminInReportFunc func(uid, reportID string) (bool, error)
 	FetchReportSectionContentsFunc    func(reportID, section string) (map[string]string, error)
-	UpdateReportSectionContentsFunc   func(reportID, section, subsection, content string) error
+	UpdateReportSectionContentsFunc   func(reportID, section, subsection, content, author string) error
+	FetchReportStructureFunc          func(reportID string) ([]reportStructure.Section, error)
+}
+
+func (m *mockRepo) UpdateReportSectionContents(reportID, section, subsection, content, author string) error {
+	return m.UpdateReportSectionContentsFunc(reportID, section, subsection, content, author)
+}
+
+func (m *mockRepo) ListSubsectionVersions(reportID, section, subsection string, page repository.VersionPage) ([]repository.SubsectionVersion, error) {
+	return nil, nil
 }
 
-func (m *mockRepo) UpdateReportSectionContents(reportID, section, subsection, content string) error {
-	return m.UpdateReportSectionContentsFunc(reportID, section, subsection, content)
+func (m *mockRepo) GetSubsectionVersion(reportID, section, subsection, versionID string) (*repository.SubsectionVersion, error) {
+	return nil, nil
 }
 
 func (m *mockRepo) FetchReportSectionContents(reportID, section string) (map[string]string, error) {
@@ -121,11 +149,11 @@ func (m *mockRepo) FetchReportContent(reportID string) (string, []map[string]int
 
 
 
-func (m *mockRepo) FetchLogsForReport(reportID string) ([]string, error) {
+func (m *mockRepo) FetchLogsForReport(reportID string, query audit.Query) ([]audit.Event, error) {
 	if m.FetchLogsForReportFunc != nil {
-		return m.FetchLogsForReportFunc(reportID)
+		return m.FetchLogsForReportFunc(reportID, query)
 	}
-	return []string{}, nil
+	return []audit.Event{}, nil
 }
 
 
@@ -140,7 +168,47 @@ func (m *mockRepo) IsUserInReport(uid, reportID string) (bool, error) {
 
 
 
-func (m *mockRepo) LinkReportWithUser(uID, reportID string, privilege bool, ownership bool) error {
+func (m *mockRepo) LinkReportWithUser(uID, reportID string, role reportRoles.Role) error {
+	return nil
+}
+
+func (m *mockRepo) CreateInvitation(invite invitation.Invitation) (string, error) {
+	return "invite1", nil
+}
+
+func (m *mockRepo) ListInvitations(reportID string) ([]invitation.Invitation, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) RenewInvitation(reportID, invitationID, token string, expiresAt time.Time) (*invitation.Invitation, error) {
+	return &invitation.Invitation{ID: invitationID, ReportID: reportID, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+func (m *mockRepo) RevokeInvitation(reportID, invitationID string) (*invitation.Invitation, error) {
+	return &invitation.Invitation{ID: invitationID, ReportID: reportID}, nil
+}
+
+func (m *mockRepo) FindInvitations(email string) ([]invitation.Invitation, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) AcceptInvitation(uID string, invite invitation.Invitation) error {
+	return nil
+}
+
+func (m *mockRepo) GetMemberRole(uID, reportID string) (reportRoles.Role, error) {
+	return reportRoles.Editor, nil
+}
+
+func (m *mockRepo) ListMembers(reportID string) ([]repository.Member, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) SetMemberRole(uID, reportID string, role reportRoles.Role) error {
+	return nil
+}
+
+func (m *mockRepo) TransferOwnership(reportID, fromUID, toUID string) error {
 	return nil
 }
 
@@ -158,14 +226,184 @@ func (m *mockRepo) DestroyUser(uID string) error {
 
 
 func (m *mockRepo) GetReportFieldTemplateID(reportID string) (string, error) {
-	return m.getReportFieldTemplateIDFunc(reportID)
+	if m.getReportFieldTemplateIDFunc != nil {
+		return m.getReportFieldTemplateIDFunc(reportID)
+	}
+	return "", nil
 }
 func (m *mockRepo) GetTemplate(templateID string) (*reportTemplates.ReportTemplate, error) {
-	return m.getTemplateFunc(templateID)
+	if m.getTemplateFunc != nil {
+		return m.getTemplateFunc(templateID)
+	}
+	return &reportTemplates.ReportTemplate{}, nil
+}
+
+
+func (m *mockRepo) IsTemplateAdmin(uid string) (bool, error) {
+	return false, nil
+}
+
+func (m *mockRepo) ListTemplates() ([]repository.TemplateSummary, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) CreateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error) {
+	return &template, nil
+}
+
+func (m *mockRepo) UpdateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error) {
+	return &template, nil
+}
+
+func (m *mockRepo) ListTemplateVersions(templateID string) ([]repository.TemplateSummary, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) GetTemplateVersion(templateID string, version int) (*reportTemplates.ReportTemplate, error) {
+	return nil, nil
+}
+
+func (m *mockRepo) FetchReportStructure(reportID string) ([]reportStructure.Section, error) {
+	if m.FetchReportStructureFunc != nil {
+		return m.FetchReportStructureFunc(reportID)
+	}
+	return []reportStructure.Section{}, nil
+}
+
+func (m *mockRepo) GetReportTemplateVersion(reportID string) (int, error) {
+	return 0, nil
+}
+
+func (m *mockRepo) SetReportTemplateVersion(reportID string, version int) error {
+	return nil
+}
+
+func (m *mockRepo) AddSection(reportID, title string, position int) (string, error) {
+	return "", nil
 }
 
+func (m *mockRepo) AddSubsection(reportID, sectionID, title string, position int) (string, error) {
+	return "", nil
+}
+
+func (m *mockRepo) DeleteSection(reportID, sectionID string) error {
+	return nil
+}
+
+func (m *mockRepo) DeleteSubsection(reportID, sectionID, subsectionID string) error {
+	return nil
+}
+
+func (m *mockRepo) MoveSection(reportID, sectionID string, position int) error {
+	return nil
+}
+
+func (m *mockRepo) MoveSubsection(reportID, sectionID, subsectionID, toSectionID string, position int) error {
+	return nil
+}
+
+func (m *mockRepo) RenameSection(reportID, sectionID, title string) error {
+	return nil
+}
+
+func (m *mockRepo) RenameSubsection(reportID, sectionID, subsectionID, title string) error {
+	return nil
+}
+
+func (m *mockRepo) GetSectionGrants(uID, reportID string) (map[string]sectionAccess.Level, error) {
+	return map[string]sectionAccess.Level{}, nil
+}
+
+func (m *mockRepo) SetSectionGrant(uID, reportID, sectionID string, level sectionAccess.Level) error {
+	return nil
+}
+
+func (m *mockRepo) RevokeSectionGrant(uID, reportID, sectionID string) error {
+	return nil
+}
+
+func (m *mockRepo) FetchLogChain(reportID string) ([]audit.Event, audit.Head, error) {
+	return nil, audit.Head{}, nil
+}
+
+func (m *mockRepo) CreateCommentThread(thread comment.Thread) (string, error) {
+	return "", nil
+}
+
+func (m *mockRepo) FetchCommentThreads(reportID, sectionID string) ([]comment.Thread, error) {
+	return []comment.Thread{}, nil
+}
+
+func (m *mockRepo) GetCommentThread(reportID, threadID string) (*comment.Thread, error) {
+	return nil, repository.ErrCommentNotFound
+}
+
+func (m *mockRepo) AddCommentReply(reportID, threadID string, reply comment.Reply) (*comment.Thread, error) {
+	return nil, repository.ErrCommentNotFound
+}
+
+func (m *mockRepo) SetCommentResolved(reportID, threadID string, resolved bool, by string, at time.Time) (*comment.Thread, error) {
+	return nil, repository.ErrCommentNotFound
+}
+
+func (m *mockRepo) UpdateCommentAnchors(reportID string, anchors map[string]comment.Anchor) error {
+	return nil
+}
+
+func (m *mockRepo) GetSubsectionReview(reportID, sectionID, subsectionID string) (*review.Review, error) {
+	r := review.New()
+	return &r, nil
+}
+
+func (m *mockRepo) FetchSectionReviews(reportID, sectionID string) (map[string]review.Review, error) {
+	return map[string]review.Review{}, nil
+}
 
-func (m *mockRepo) BufferLog(reportID, message, user string) {}
+func (m *mockRepo) ChangeSubsectionStatus(reportID, sectionID, subsectionID string, change review.Transition) (*review.Review, error) {
+	return nil, repository.ErrSectionNotFound
+}
+
+func (m *mockRepo) SetSubsectionReviewers(reportID, sectionID, subsectionID string, reviewers []string) (*review.Review, error) {
+	return nil, repository.ErrSectionNotFound
+}
+
+func (m *mockRepo) BufferLog(event audit.Event) {
+	if m.BufferLogFunc != nil {
+		m.BufferLogFunc(event)
+	}
+}
+
+// structureIDs looks up the IDs of a section and one of its subsections by title
+func structureIDs(t *testing.T, repo repository.ReportRepository, reportID, section, subsection string) (string, string) {
+	t.Helper()
+	sections, err := repo.FetchReportStructure(reportID)
+	assert.NoError(t, err)
+	for _, s := range sections {
+		if s.Title != section {
+			continue
+		}
+		for _, sub := range s.Subsections {
+			if sub.Title == subsection {
+				return s.ID, sub.ID
+			}
+		}
+		return s.ID, ""
+	}
+	t.Fatalf("section %s not found in report %s", section, reportID)
+	return "", ""
+}
+
+// titles drops the IDs from a report's structure
+func titles(sections []reportStructure.Section) []reportTemplates.Section {
+	titled := make([]reportTemplates.Section, len(sections))
+	for i, section := range sections {
+		titled[i] = reportTemplates.Section{Title: section.Title, Subsections: []string{}}
+		for _, subsection := range section.Subsections {
+			titled[i].Subsections = append(titled[i].Subsections, subsection.Title)
+		}
+	}
+	return titled
+}
 
 func TestHomeHandler(t *testing.T) {
 	// Set Gin to Test Mode
@@ -230,7 +468,7 @@ func TestVerifyTokenHandler(t *testing.T) {
 		router := gin.Default()
 
 		mockAuth := &mockAuthService{} // doesn't need to verify anything here
-		router.GET("/verify", handlers.VerifyTokenHandler(mockAuth))
+		router.GET("/verify", handlers.VerifyTokenHandler(mockAuth, &mockRepo{}))
 
 		req, _ := http.NewRequest("GET", "/verify", nil)
 		w := httptest.NewRecorder()
@@ -248,7 +486,7 @@ func TestVerifyTokenHandler(t *testing.T) {
 				return &auth.Token{UID: "mockUID123"}, nil
 			},
 		}
-		router.GET("/verify", handlers.VerifyTokenHandler(mockAuth))
+		router.GET("/verify", handlers.VerifyTokenHandler(mockAuth, &mockRepo{}))
 
 		req, _ := http.NewRequest("GET", "/verify", nil)
 		req.Header.Set("Authorization", "Bearer mock.token.here")
@@ -316,17 +554,13 @@ func TestReportHandler(t *testing.T) {
 
 	// Mock repo
 	mockRepo := &mockRepo{
-		// simulate a template with one section and two subsections
-		getReportFieldTemplateIDFunc: func(reportID string) (string, error) {
-			return "template123", nil
-		},
-		getTemplateFunc: func(templateID string) (*reportTemplates.ReportTemplate, error) {
-			return &reportTemplates.ReportTemplate{
-				Sections: []reportTemplates.Section{
-					{
-						Title:       "Introduction",
-						Subsections: []string{"Overview", "Scope"},
-					},
+		// simulate a report with one section and two subsections
+		FetchReportStructureFunc: func(reportID string) ([]reportStructure.Section, error) {
+			return []reportStructure.Section{
+				{
+					ID:          "s1",
+					Title:       "Introduction",
+					Subsections: []reportStructure.Subsection{{ID: "u1", Title: "Overview"}, {ID: "u2", Title: "Scope"}},
 				},
 			}, nil
 		},
@@ -387,13 +621,114 @@ mockRepo := &mockRepo{}
 }
 
 
+func TestGenerateReportHandlerFormats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := &mockRepo{}
+	mockRepo.FetchReportContentFunc = func(rID string) (string, []map[string]interface{}, error) {
+		return "test-report", []map[string]interface{}{
+			{"sectionTitle": "Introduction", "subsections": []map[string]interface{}{
+				{"title": "Overview", "content": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Hello\n"}]}}}`},
+			}},
+		}, nil
+	}
+
+	mockRepo.getReportFieldTemplateIDFunc = func(reportID string) (string, error) {
+		return "template1", nil
+	}
+	mockRepo.getTemplateFunc = func(templateID string) (*reportTemplates.ReportTemplate, error) {
+		return &reportTemplates.ReportTemplate{Style: reportTemplates.Style{Organization: "Example Lab", Classification: "Confidential"}}, nil
+	}
+
+	router := gin.Default()
+	router.GET("/api/generateReport", handlers.GenerateReportHandler(mockRepo))
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/generateReport?reportID=test123&format=md", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/markdown; charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "test-report.md")
+	assert.Contains(t, resp.Body.String(), "Hello")
+	assert.Contains(t, resp.Body.String(), "Example Lab")
+	assert.Contains(t, resp.Body.String(), "**Confidential**")
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/generateReport?reportID=test123&format=docx", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "test-report.docx")
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/generateReport?reportID=test123&format=rtf", nil))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+func TestExportJobHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockRepo := &mockRepo{}
+	mockRepo.FetchReportContentFunc = func(rID string) (string, []map[string]interface{}, error) {
+		return "test-report", []map[string]interface{}{
+			{"sectionTitle": "Introduction", "subsections": []map[string]interface{}{
+				{"title": "Overview", "content": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Hello\n"}]}}}`},
+			}},
+		}, nil
+	}
+
+	router := gin.Default()
+	router.POST("/report/:reportID/api/exports", handlers.StartExportHandler(mockRepo))
+	router.GET("/report/:reportID/api/exports/:jobID", handlers.ExportStatusHandler())
+	router.GET("/report/:reportID/api/exports/:jobID/download", handlers.ExportDownloadHandler())
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/report/test123/api/exports", strings.NewReader(`{"format":"md"}`)))
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+
+	var started struct {
+		Job struct {
+			ID string `json:"jobID"`
+		} `json:"job"`
+	}
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &started))
+	assert.NotEmpty(t, started.Job.ID)
+
+	// Poll until the job is done
+	var status struct {
+		Job struct {
+			Status string `json:"status"`
+		} `json:"job"`
+		DownloadURL string `json:"downloadURL"`
+	}
+	for i := 0; i < 100 && status.Job.Status != "done"; i++ {
+		resp = httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/report/test123/api/exports/"+started.Job.ID, nil))
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &status))
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "done", status.Job.Status)
+	assert.Equal(t, "/report/test123/api/exports/"+started.Job.ID+"/download", status.DownloadURL)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, status.DownloadURL, nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Disposition"), "test-report.md")
+	assert.Contains(t, resp.Body.String(), "Hello")
+
+	// Jobs can't be read through another report
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/report/other/api/exports/"+started.Job.ID, nil))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/report/test123/api/exports", strings.NewReader(`{"format":"rtf"}`)))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
 func TestReportLogsHandler(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
 	mockRepo := &mockRepo{
-		FetchLogsForReportFunc: func(reportID string) ([]string, error) {
+		FetchLogsForReportFunc: func(reportID string, query audit.Query) ([]audit.Event, error) {
 			assert.Equal(t, "mockReportID", reportID)
-			return []string{"log1", "log2"}, nil
+			return []audit.Event{{Actor: "a@example.com", Message: "log1"}, {Message: "log2"}}, nil
 		},
 	}
 
@@ -406,7 +741,7 @@ func TestReportLogsHandler(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), "log1")
+	assert.Contains(t, w.Body.String(), "a@example.com log1")
 	assert.Contains(t, w.Body.String(), "log2")
 }
 
@@ -533,10 +868,10 @@ func TestWebSocketHandlerJoinMessage(t *testing.T) {
 		FetchReportSectionContentsFunc: func(reportID, section string) (map[string]string, error) {
 			return map[string]string{"Overview": `{"ops":[{"insert":"Hello world"}]}`}, nil
 		},
-		BufferLogFunc: func(reportID, message, user string) {
+		BufferLogFunc: func(event audit.Event) {
 			// You can assert on this if needed
 		},
-		UpdateReportSectionContentsFunc: func(reportID, section, subsection, content string) error {
+		UpdateReportSectionContentsFunc: func(reportID, section, subsection, content, author string) error {
 			return nil
 		},
 	}
@@ -566,11 +901,11 @@ func TestWebSocketHandlerJoinMessage(t *testing.T) {
 	err = ws.WriteJSON(joinMessage)
 	assert.NoError(t, err)
 
-	// Read response (delta)
+	// Read response (snapshot of the subsection held by the server)
 	var received map[string]interface{}
 	err = ws.ReadJSON(&received)
 	assert.NoError(t, err)
-	assert.NotNil(t, received)
+	assert.Equal(t, "snapshot", received["type"])
 }
 
 func TestWebSocketHandler_Join(t *testing.T) {
@@ -596,7 +931,7 @@ func TestWebSocketHandler_Join(t *testing.T) {
   assert.NoError(t, err)
 }
 
-func TestWebSocketHandler_Sync(t *testing.T) {
+func TestWebSocketHandler_Delta(t *testing.T) {
   gin.SetMode(gin.TestMode)
   mockRepo := &mockRepo{}
   router := gin.Default()
@@ -614,12 +949,12 @@ func TestWebSocketHandler_Sync(t *testing.T) {
   }
   defer conn.Close()
 
-  msg := `{"type":"sync", "reportID":"test-report", "section":"Section 1", "contents":{"editor1":{"ops":[{"insert":"Hello"}]}}}`
+  msg := `{"type":"delta", "ops":[{"insert":"Delta content"}]}`
   err = conn.WriteMessage(websocket.TextMessage, []byte(msg))
   assert.NoError(t, err)
 }
 
-func TestWebSocketHandler_Delta(t *testing.T) {
+func TestWebSocketHandler_Close(t *testing.T) {
   gin.SetMode(gin.TestMode)
   mockRepo := &mockRepo{}
   router := gin.Default()
@@ -637,54 +972,1587 @@ func TestWebSocketHandler_Delta(t *testing.T) {
   }
   defer conn.Close()
 
-  msg := `{"type":"delta", "ops":[{"insert":"Delta content"}]}`
+  msg := `{"type":"close"}`
   err = conn.WriteMessage(websocket.TextMessage, []byte(msg))
   assert.NoError(t, err)
 }
 
-func TestWebSocketHandler_UpdateRepo(t *testing.T) {
-  gin.SetMode(gin.TestMode)
-  mockRepo := &mockRepo{}
-  router := gin.Default()
-  router.GET("/ws/:reportID", handlers.WebSocketHandler(mockRepo))
 
-  server := httptest.NewServer(router)
-  defer server.Close()
+func TestCreateReportHandlerWithMemoryRepository(t *testing.T) {
+	gin.SetMode(gin.TestMode)
 
-  wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/test-report"
-  header := http.Header{"Origin": []string{"http://localhost"}}
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
 
-  conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
-  if err != nil {
-    t.Fatalf("Failed to connect to WebSocket: %v", err)
-  }
-  defer conn.Close()
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "mockUID123")
+		c.Set("email", "user@example.com")
+	})
+	router.POST("/create", handlers.CreateReportHandler(repo))
 
-  msg := `{"type":"updateRepo", "reportID":"test-report", "section":"Section 1", "contents":{"editor1":{"ops":[{"insert":"Updated"}]}}}`
-  err = conn.WriteMessage(websocket.TextMessage, []byte(msg))
-  assert.NoError(t, err)
+	body := `{"name": "New Report", "type": "standard"}`
+	req, _ := http.NewRequest(http.MethodPost, "/create", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	reports, err := repo.GetUserReportLinks("mockUID123")
+	assert.NoError(t, err)
+	assert.Len(t, reports, 1)
+	assert.Equal(t, "New Report", reports[0].ReportTitle)
+
+	isAdmin, _ := repo.IsAdminInReport("mockUID123", reports[0].ReportID)
+	assert.True(t, isAdmin)
 }
 
-func TestWebSocketHandler_Close(t *testing.T) {
-  gin.SetMode(gin.TestMode)
-  mockRepo := &mockRepo{}
-  router := gin.Default()
-  router.GET("/ws/:reportID", handlers.WebSocketHandler(mockRepo))
+func TestWebSocketHandlerJoinGetsServerState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
 
-  server := httptest.NewServer(router)
-  defer server.Close()
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "stateReport", "standard", "test@example.com"))
+	sectionID, subsectionID := structureIDs(t, repo, "stateReport", "Introduction", "Overview")
 
-  wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/test-report"
-  header := http.Header{"Origin": []string{"http://localhost"}}
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("email", "test@example.com")
+	})
+	router.GET("/report/:reportID/section/:sectionID", handlers.WebSocketHandler(repo))
 
-  conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
-  if err != nil {
-    t.Fatalf("Failed to connect to WebSocket: %v", err)
-  }
-  defer conn.Close()
+	server := httptest.NewServer(router)
+	defer server.Close()
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/report/stateReport/section/" + sectionID
 
-  msg := `{"type":"close"}`
-  err = conn.WriteMessage(websocket.TextMessage, []byte(msg))
-  assert.NoError(t, err)
+	first, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	defer first.Close()
+
+	var received map[string]interface{}
+	assert.NoError(t, first.WriteJSON(map[string]string{"type": "join"}))
+	assert.NoError(t, first.ReadJSON(&received))
+	assert.Equal(t, "snapshot", received["type"])
+	assert.NoError(t, first.ReadJSON(&received))
+	assert.Equal(t, "reviews", received["type"])
+	assert.NoError(t, first.ReadJSON(&received))
+	assert.Equal(t, "comments", received["type"])
+	assert.NoError(t, first.ReadJSON(&received))
+	assert.Equal(t, "presence", received["type"])
+	assert.NoError(t, first.ReadJSON(&received))
+	assert.Equal(t, "welcome", received["type"])
+	assert.Equal(t, float64(protocol.Version), received["version"])
+
+	edit := `{"type":"delta","delta":{"editorId":"` + subsectionID + `","revision":0,"delta":{"ops":[{"insert":"Typed"}]}}}`
+	assert.NoError(t, first.WriteMessage(websocket.TextMessage, []byte(edit)))
+	assert.NoError(t, first.ReadJSON(&received))
+	assert.Equal(t, "ack", received["type"])
+	assert.Equal(t, float64(1), received["revision"])
+
+	// A second client gets the edit from the server, not from the first client
+	second, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	defer second.Close()
+
+	var snapshot struct {
+		Type  string `json:"type"`
+		Delta struct {
+			Revision int `json:"revision"`
+			Delta    struct {
+				Ops []map[string]interface{} `json:"ops"`
+			} `json:"delta"`
+		} `json:"delta"`
+	}
+	assert.NoError(t, second.WriteJSON(map[string]string{"type": "join"}))
+	assert.NoError(t, second.ReadJSON(&snapshot))
+	assert.Equal(t, "snapshot", snapshot.Type)
+	assert.Equal(t, 1, snapshot.Delta.Revision)
+	assert.Equal(t, "Typed\n", snapshot.Delta.Delta.Ops[0]["insert"])
 }
 
+func TestWebSocketPresence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "presenceReport", "standard", "alice@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("aliceUID", "presenceReport", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("bobUID", "presenceReport", reportRoles.Commenter))
+	sectionID, subsectionID := structureIDs(t, repo, "presenceReport", "Introduction", "Overview")
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("email", c.Query("email"))
+		c.Set("uid", strings.TrimSuffix(c.Query("email"), "@example.com")+"UID")
+	})
+	router.GET("/report/:reportID/section/:sectionID", handlers.WebSocketHandler(repo))
+	router.GET("/report/:reportID/api/editors", handlers.EditorsHandler(repo))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/report/presenceReport/section/" + sectionID
+
+	type presenceMessage struct {
+		Type     string                `json:"type"`
+		Editors  []protocol.Presence `json:"editors"`
+		Presence protocol.Presence   `json:"presence"`
+	}
+	// join reads the section's state up to the welcome and returns the
+	// others already in it
+	join := func(email string) (*websocket.Conn, presenceMessage) {
+		conn, _, err := websocket.DefaultDialer.Dial(u+"?email="+email, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "join", "version": protocol.Version}))
+		var present, message presenceMessage
+		for message.Type != "welcome" {
+			message = presenceMessage{}
+			assert.NoError(t, conn.ReadJSON(&message))
+			if message.Type == "presence" {
+				present = message
+			}
+		}
+		return conn, present
+	}
+
+	alice, present := join("alice@example.com")
+	defer alice.Close()
+	assert.Empty(t, present.Editors)
+
+	bob, present := join("bob@example.com")
+	defer bob.Close()
+	assert.Len(t, present.Editors, 1)
+	assert.Equal(t, "alice@example.com", present.Editors[0].Email)
+	assert.Equal(t, "aliceUID", present.Editors[0].UID)
+
+	var change presenceMessage
+	assert.NoError(t, alice.ReadJSON(&change))
+	assert.Equal(t, "joined", change.Type)
+	assert.Equal(t, "bob@example.com", change.Presence.Email)
+
+	cursor := `{"type":"cursor","editorId":"` + subsectionID + `","revision":0,"ranges":[{"index":0,"length":0}]}`
+	assert.NoError(t, alice.WriteMessage(websocket.TextMessage, []byte(cursor)))
+	assert.NoError(t, bob.ReadJSON(&change))
+	assert.Equal(t, "cursor", change.Type)
+	assert.Equal(t, "alice@example.com", change.Presence.Email)
+	assert.Equal(t, subsectionID, change.Presence.EditorId)
+	assert.Equal(t, []protocol.Range{{Index: 0, Length: 0}}, change.Presence.Ranges)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/report/presenceReport/api/editors?email=alice@example.com", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Editors []protocol.Presence `json:"editors"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed.Editors, 2)
+	assert.Equal(t, "alice@example.com", listed.Editors[0].Email)
+	assert.Equal(t, sectionID, listed.Editors[0].SectionID)
+
+	// Members only see the sections they can read, bob has no grants
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/report/presenceReport/api/editors?email=bob@example.com", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"editors":[]}`, w.Body.String())
+
+	// Leaving is sent to the others
+	assert.NoError(t, bob.WriteJSON(map[string]string{"type": "close"}))
+	assert.NoError(t, alice.ReadJSON(&change))
+	assert.Equal(t, "left", change.Type)
+	assert.Equal(t, "bob@example.com", change.Presence.Email)
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "protocolReport", "standard", "test@example.com"))
+	sectionID, subsectionID := structureIDs(t, repo, "protocolReport", "Introduction", "Overview")
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("email", "test@example.com")
+		if c.Query("access") != "" {
+			c.Set("sectionAccess", sectionAccess.Level(c.Query("access")))
+		}
+	})
+	router.GET("/report/:reportID/section/:sectionID", handlers.WebSocketHandler(repo))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/report/protocolReport/section/" + sectionID
+
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// send writes a raw message and reads the answer
+	send := func(conn *websocket.Conn, raw string) map[string]interface{} {
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(raw)))
+		var answer map[string]interface{}
+		assert.NoError(t, conn.ReadJSON(&answer))
+		return answer
+	}
+	delta := func(id string, revision int) string {
+		return fmt.Sprintf(`{"type":"delta","id":"%s","delta":{"editorId":"%s","revision":%d,"delta":{"ops":[{"insert":"Hi"}]}}}`, id, subsectionID, revision)
+	}
+
+	answer := send(conn, `not json`)
+	assert.Equal(t, "error", answer["type"])
+	assert.Equal(t, "invalid_message", answer["code"])
+
+	answer = send(conn, `{"type":"sync","id":"m1"}`)
+	assert.Equal(t, "unknown_type", answer["code"])
+	assert.Equal(t, "m1", answer["replyTo"])
+
+	answer = send(conn, `{"type":"join","id":"j1","version":99}`)
+	assert.Equal(t, "unsupported_version", answer["code"])
+	assert.Equal(t, false, answer["retryable"])
+
+	answer = send(conn, delta("d1", 0))
+	assert.Equal(t, "not_joined", answer["code"])
+	assert.Equal(t, "d1", answer["replyTo"])
+
+	// The welcome answers the join once everything else is sent
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "join", "id": "j2", "version": protocol.Version}))
+	for answer["type"] != "welcome" {
+		answer = map[string]interface{}{}
+		assert.NoError(t, conn.ReadJSON(&answer))
+	}
+	assert.Equal(t, "j2", answer["replyTo"])
+	assert.NotEmpty(t, answer["sessionId"])
+
+	answer = send(conn, delta("d2", 0))
+	assert.Equal(t, "ack", answer["type"])
+	assert.Equal(t, "d2", answer["replyTo"])
+	assert.Equal(t, float64(1), answer["revision"])
+
+	// A delta that does not fit is dropped and the client put back in step
+	answer = send(conn, delta("d3", 5))
+	assert.Equal(t, "out_of_step", answer["code"])
+	assert.Equal(t, subsectionID, answer["editorId"])
+	assert.NoError(t, conn.ReadJSON(&answer))
+	assert.Equal(t, "snapshot", answer["type"])
+
+	// So is one that reaches past the end of the subsection
+	answer = send(conn, `{"type":"delta","id":"d4","delta":{"editorId":"`+subsectionID+`","revision":1,"delta":{"ops":[{"delete":10}]}}}`)
+	assert.Equal(t, "out_of_step", answer["code"])
+	assert.Equal(t, "d4", answer["replyTo"])
+	assert.NoError(t, conn.ReadJSON(&answer))
+	assert.Equal(t, "snapshot", answer["type"])
+
+	answer = send(conn, `{"type":"cursor","id":"c1","editorId":"missing","ranges":[]}`)
+	assert.Equal(t, "unknown_editor", answer["code"])
+	answer = send(conn, `{"type":"cursor","id":"c2","editorId":"`+subsectionID+`","ranges":[{"index":0,"length":1}],"revision":1}`)
+	assert.Equal(t, "ack", answer["type"])
+	assert.Equal(t, "c2", answer["replyTo"])
+
+	// Readers are told their edits are not allowed
+	reader, _, err := websocket.DefaultDialer.Dial(u+"?access=read", nil)
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.NoError(t, reader.WriteJSON(map[string]interface{}{"type": "join", "version": protocol.Version}))
+	for answer["type"] != "welcome" {
+		answer = map[string]interface{}{}
+		assert.NoError(t, reader.ReadJSON(&answer))
+	}
+	answer = send(reader, delta("r1", 1))
+	assert.Equal(t, "forbidden", answer["code"])
+	assert.Equal(t, "r1", answer["replyTo"])
+	assert.NoError(t, reader.ReadJSON(&answer))
+	assert.Equal(t, "snapshot", answer["type"])
+}
+
+func TestWebSocketResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "resumeReport", "standard", "test@example.com"))
+	sectionID, subsectionID := structureIDs(t, repo, "resumeReport", "Introduction", "Overview")
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("email", "test@example.com")
+	})
+	router.GET("/report/:reportID/section/:sectionID", handlers.WebSocketHandler(repo))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/report/resumeReport/section/" + sectionID
+
+	// join sends a join and returns everything up to the welcome by type,
+	// leaving out saves and others joining and leaving, which may come at any
+	// time
+	join := func(conn *websocket.Conn, message map[string]interface{}) (map[string]interface{}, []string) {
+		assert.NoError(t, conn.WriteJSON(message))
+		var types []string
+		for {
+			var answer map[string]interface{}
+			assert.NoError(t, conn.ReadJSON(&answer))
+			switch answer["type"] {
+			case "welcome":
+				return answer, types
+			case "saved", "joined", "left":
+			default:
+				types = append(types, answer["type"].(string))
+			}
+		}
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	welcome, _ := join(conn, map[string]interface{}{"type": "join", "version": protocol.Version})
+	token, _ := welcome["resumeToken"].(string)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, false, welcome["resumed"])
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"delta","id":"d1","delta":{"editorId":"`+subsectionID+`","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`)))
+	var ack map[string]interface{}
+	assert.NoError(t, conn.ReadJSON(&ack))
+	assert.Equal(t, "ack", ack["type"])
+	conn.Close()
+
+	// Resuming from before the delta acks it again instead of sending snapshots
+	resumed, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	defer resumed.Close()
+	welcome, types := join(resumed, map[string]interface{}{
+		"type": "join", "id": "j1", "version": protocol.Version,
+		"resume": token, "revisions": map[string]int{subsectionID: 0},
+	})
+	assert.Equal(t, true, welcome["resumed"])
+	assert.Equal(t, "j1", welcome["replyTo"])
+	assert.Equal(t, []string{"ack", "reviews", "comments", "presence"}, types)
+
+	// A token that can't be resumed falls back to joining afresh
+	fresh, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	defer fresh.Close()
+	welcome, types = join(fresh, map[string]interface{}{
+		"type": "join", "version": protocol.Version,
+		"resume": token, "revisions": map[string]int{subsectionID: 5},
+	})
+	assert.Equal(t, false, welcome["resumed"])
+	assert.Contains(t, types, "snapshot")
+	assert.NotEqual(t, token, welcome["resumeToken"])
+
+	// and leaves the connection with the session alone
+	assert.NoError(t, resumed.WriteMessage(websocket.TextMessage, []byte(`{"type":"cursor","id":"c1","editorId":"","ranges":[]}`)))
+	for ack["replyTo"] != "c1" {
+		ack = map[string]interface{}{}
+		assert.NoError(t, resumed.ReadJSON(&ack))
+	}
+	assert.Equal(t, "ack", ack["type"])
+}
+
+func TestWebSocketAccessIsRechecked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "recheckReport", "standard", "alice@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("aliceUID", "recheckReport", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("bobUID", "recheckReport", reportRoles.Editor))
+	sectionID, subsectionID := structureIDs(t, repo, "recheckReport", "Introduction", "Overview")
+	assert.NoError(t, repo.SetSectionGrant("bobUID", "recheckReport", sectionID, sectionAccess.Edit))
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("email", c.Query("email"))
+		c.Set("uid", strings.TrimSuffix(c.Query("email"), "@example.com")+"UID")
+	})
+	router.GET("/report/:reportID/section/:sectionID", middleware.AuthSectionAccess(nil, repo, sectionAccess.Read), handlers.WebSocketHandler(repo))
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + "/report/recheckReport/section/" + sectionID
+
+	// next reads the next answer, leaving out saves and others joining and
+	// leaving, which may come at any time
+	next := func(conn *websocket.Conn) map[string]interface{} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			var answer map[string]interface{}
+			if !assert.NoError(t, conn.ReadJSON(&answer)) {
+				t.FailNow()
+			}
+			switch answer["type"] {
+			case "saved", "joined", "left", "presence":
+			default:
+				return answer
+			}
+		}
+	}
+	join := func(conn *websocket.Conn) {
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "join", "version": protocol.Version}))
+		for answer := next(conn); answer["type"] != "welcome"; answer = next(conn) {
+		}
+	}
+	delta := func(id string, revision int) []byte {
+		return []byte(fmt.Sprintf(`{"type":"delta","id":"%s","delta":{"editorId":"%s","revision":%d,"delta":{"ops":[{"insert":"Hi"}]}}}`, id, subsectionID, revision))
+	}
+
+	alice, _, err := websocket.DefaultDialer.Dial(u+"?email=alice@example.com", nil)
+	assert.NoError(t, err)
+	defer alice.Close()
+	bob, _, err := websocket.DefaultDialer.Dial(u+"?email=bob@example.com", nil)
+	assert.NoError(t, err)
+	defer bob.Close()
+	join(alice)
+
+	// Connections that have not joined get nothing from the section and may
+	// not edit it
+	assert.NoError(t, alice.WriteMessage(websocket.TextMessage, delta("a1", 0)))
+	answer := next(alice)
+	assert.Equal(t, "ack", answer["type"])
+	assert.NoError(t, bob.WriteMessage(websocket.TextMessage, delta("b1", 1)))
+	answer = next(bob)
+	assert.Equal(t, "not_joined", answer["code"])
+	assert.Equal(t, "b1", answer["replyTo"])
+
+	// A grant revoked while the socket is open stops its edits
+	join(bob)
+	assert.NoError(t, repo.SetSectionGrant("bobUID", "recheckReport", sectionID, sectionAccess.Read))
+	assert.NoError(t, bob.WriteMessage(websocket.TextMessage, delta("b2", 1)))
+	answer = next(bob)
+	assert.Equal(t, "forbidden", answer["code"])
+	assert.Equal(t, "b2", answer["replyTo"])
+	assert.Equal(t, "snapshot", next(bob)["type"])
+
+	// and someone removed from the report may not join again
+	assert.NoError(t, repo.RemoveUserFromReport("bobUID", "recheckReport"))
+	assert.NoError(t, bob.WriteJSON(map[string]interface{}{"type": "join", "id": "j1", "version": protocol.Version}))
+	answer = next(bob)
+	assert.Equal(t, "forbidden", answer["code"])
+	assert.Equal(t, "j1", answer["replyTo"])
+}
+
+func TestSubsectionVersionsAndRestore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "versionReport", "standard", "test@example.com"))
+	sectionID, subsectionID := structureIDs(t, repo, "versionReport", "Introduction", "Overview")
+	first := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"First\n"}]}}}`
+	second := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Second\n"}]}}}`
+	assert.NoError(t, repo.UpdateReportSectionContents("versionReport", sectionID, subsectionID, first, "alice@example.com"))
+	assert.NoError(t, repo.UpdateReportSectionContents("versionReport", sectionID, subsectionID, second, "bob@example.com"))
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("email", "test@example.com")
+	})
+	base := "/report/:reportID/api/sections/:sectionID/subsections/:subsectionID/versions"
+	router.GET(base, handlers.SubsectionVersionsHandler(repo))
+	router.GET(base+"/:versionID", handlers.SubsectionVersionHandler(repo))
+	router.POST(base+"/:versionID/restore", handlers.RestoreSubsectionVersion(repo))
+
+	url := "/report/versionReport/api/sections/" + sectionID + "/subsections/" + subsectionID + "/versions"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var listed struct {
+		Versions []repository.SubsectionVersion `json:"versions"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed.Versions, 2)
+	assert.Equal(t, "bob@example.com", listed.Versions[0].Author)
+	oldest := listed.Versions[1].VersionID
+
+	// Versions come a page at a time
+	var paged struct {
+		Versions []repository.SubsectionVersion `json:"versions"`
+		Next     string                         `json:"next"`
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"?limit=1", nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &paged))
+	assert.Len(t, paged.Versions, 1)
+	assert.Equal(t, paged.Versions[0].VersionID, paged.Next)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"?limit=1&after="+paged.Next, nil))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &paged))
+	assert.Equal(t, oldest, paged.Versions[0].VersionID)
+	assert.Empty(t, paged.Next)
+	for _, query := range []string{"?limit=0", "?limit=x", "?after=missing"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"/"+oldest, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "First")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Nobody has the section open, so the restore is saved straight away
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url+"/"+oldest+"/restore", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	contents, _ := repo.FetchReportSectionContents("versionReport", sectionID)
+	assert.Equal(t, first, contents[subsectionID])
+
+	versions, _ := repo.ListSubsectionVersions("versionReport", sectionID, subsectionID, repository.VersionPage{})
+	assert.Len(t, versions, 3)
+	assert.Equal(t, "test@example.com", versions[0].Author)
+
+	assert.Contains(t, logLines(t, repo, "versionReport"), "restored subsection "+subsectionID+" of section "+sectionID)
+}
+
+func TestSubsectionDiffHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("standard", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "diffReport", "standard", "test@example.com"))
+	sectionID, subsectionID := structureIDs(t, repo, "diffReport", "Introduction", "Overview")
+	first := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Round one\n"}]}}}`
+	second := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Round two\n"}]}}}`
+	assert.NoError(t, repo.UpdateReportSectionContents("diffReport", sectionID, subsectionID, first, "test@example.com"))
+	assert.NoError(t, repo.UpdateReportSectionContents("diffReport", sectionID, subsectionID, second, "alice@example.com"))
+	versions, _ := repo.ListSubsectionVersions("diffReport", sectionID, subsectionID, repository.VersionPage{})
+
+	router := gin.Default()
+	router.GET("/report/:reportID/api/sections/:sectionID/subsections/:subsectionID/diff", handlers.SubsectionDiffHandler(repo))
+	url := "/report/diffReport/api/sections/" + sectionID + "/subsections/" + subsectionID + "/diff"
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"?from="+versions[1].VersionID+"&to="+versions[0].VersionID, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result struct {
+		Runs []map[string]interface{} `json:"runs"`
+		HTML string                   `json:"html"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Contains(t, result.HTML, `<ins class="diff-insert">tw</ins>`)
+
+	// Without "to" the current content is used
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"?from="+versions[0].VersionID, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Len(t, result.Runs, 1)
+	assert.Equal(t, "equal", result.Runs[0]["type"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Edits in an open section are compared against before they are saved
+	socketRouter := gin.Default()
+	socketRouter.Use(func(c *gin.Context) {
+		c.Set("email", "test@example.com")
+	})
+	socketRouter.GET("/report/:reportID/section/:sectionID", handlers.WebSocketHandler(repo))
+	server := httptest.NewServer(socketRouter)
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/report/diffReport/section/"+sectionID, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "join", "version": protocol.Version}))
+	var answer map[string]interface{}
+	for answer["type"] != "welcome" {
+		answer = map[string]interface{}{}
+		assert.NoError(t, conn.ReadJSON(&answer))
+	}
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"delta","id":"d1","delta":{"editorId":"`+subsectionID+`","revision":0,"delta":{"ops":[{"insert":"Unsaved "}]}}}`)))
+	for answer["type"] != "ack" {
+		answer = map[string]interface{}{}
+		assert.NoError(t, conn.ReadJSON(&answer))
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url+"?from="+versions[0].VersionID, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Contains(t, result.HTML, `<ins class="diff-insert">Unsaved </ins>`)
+}
+
+func TestTemplateHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "mockUID123")
+		c.Set("email", "admin@example.com")
+	})
+	router.GET("/templates", handlers.ListTemplatesHandler(repo))
+	router.POST("/templates", handlers.CreateTemplateHandler(repo))
+	router.GET("/templates/:templateID", handlers.GetTemplateHandler(repo))
+	router.PUT("/templates/:templateID", handlers.UpdateTemplateHandler(repo))
+	router.POST("/templates/:templateID/clone", handlers.CloneTemplateHandler(repo))
+	router.GET("/templates/:templateID/versions", handlers.TemplateVersionsHandler(repo))
+	router.GET("/templates/:templateID/versions/:version", handlers.TemplateVersionHandler(repo))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodPost, "/templates", `{"templateID": "st", "name": "Security Target", "sections": [{"title": "Introduction", "subsections": ["Overview", "Scope"]}]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"Version":1`)
+
+	// IDs are unique and templates must be well formed
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "/templates", `{"templateID": "st", "name": "Other", "sections": [{"title": "A"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/templates", `{"name": "No sections"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/templates", `{"name": "Twice", "sections": [{"title": "A"}, {"title": "A"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/templates", `{"templateID": "a/b", "name": "Slash", "sections": [{"title": "A"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/templates", `{"templateID": "__reserved__", "name": "Reserved", "sections": [{"title": "A"}]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/templates", `{"templateID": "`+strings.Repeat("x", 1501)+`", "name": "Long", "sections": [{"title": "A"}]}`).Code)
+
+	// Reports made before an update keep the version they were made from
+	assert.NoError(t, repo.CreateReport("Old Report", "report1", "st", "user@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("mockUID123", "report1", reportRoles.Owner))
+
+	w = send(http.MethodPut, "/templates/st", `{"name": "Security Target", "sections": [{"title": "Introduction", "subsections": ["Overview", "Scope", "References"]}]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"Version":2`)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPut, "/templates/missing", `{"name": "Missing", "sections": [{"title": "A"}]}`).Code)
+
+	reports, _ := repo.GetUserReportLinks("mockUID123")
+	assert.Equal(t, "st", reports[0].TemplateID)
+	assert.Equal(t, 1, reports[0].TemplateVersion)
+
+	w = send(http.MethodGet, "/templates/st/versions", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var versions struct {
+		Versions []repository.TemplateSummary `json:"versions"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &versions))
+	assert.Len(t, versions.Versions, 2)
+	assert.Equal(t, 2, versions.Versions[0].Version)
+	assert.Equal(t, "admin@example.com", versions.Versions[0].UpdatedBy)
+
+	w = send(http.MethodGet, "/templates/st/versions/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "References")
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/templates/st/versions/3", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodGet, "/templates/st/versions/latest", "").Code)
+
+	// Clones start over at version 1 and remember their source
+	w = send(http.MethodPost, "/templates/st/clone", `{"templateID": "st-old", "version": 1}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	clone, err := repo.GetTemplate("st-old")
+	assert.NoError(t, err)
+	assert.Equal(t, "Security Target (copy)", clone.Name)
+	assert.Equal(t, 1, clone.Version)
+	assert.Equal(t, "st@1", clone.ClonedFrom)
+	assert.Equal(t, []string{"Overview", "Scope"}, clone.Sections[0].Subsections)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPost, "/templates/missing/clone", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/templates/st/clone", `{"templateID": "__st__"}`).Code)
+
+	w = send(http.MethodGet, "/templates", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"templateID":"st-old"`)
+	assert.Contains(t, w.Body.String(), `"clonedFrom":"st@1"`)
+}
+
+func TestReportStructureHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Name: "Security Target",
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview", "Scope"}},
+			{Title: "Design", Subsections: []string{"Components"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "admin@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("mockUID123", "report1", reportRoles.Owner))
+	introduction, overview := structureIDs(t, repo, "report1", "Introduction", "Overview")
+	_, scope := structureIDs(t, repo, "report1", "Introduction", "Scope")
+	design, _ := structureIDs(t, repo, "report1", "Design", "")
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "content", "admin@example.com"))
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "mockUID123")
+		c.Set("email", "admin@example.com")
+	})
+	router.GET("/report/:reportID/api/structure", handlers.ReportStructureHandler(repo))
+	router.POST("/report/:reportID/api/sections", handlers.AddSectionHandler(repo))
+	router.PUT("/report/:reportID/api/sections/:sectionID", handlers.UpdateSectionHandler(repo))
+	router.DELETE("/report/:reportID/api/sections/:sectionID", handlers.DeleteSectionHandler(repo))
+	router.POST("/report/:reportID/api/sections/:sectionID/subsections", handlers.AddSubsectionHandler(repo))
+	router.PUT("/report/:reportID/api/sections/:sectionID/subsections/:subsectionID", handlers.UpdateSubsectionHandler(repo))
+	router.DELETE("/report/:reportID/api/sections/:sectionID/subsections/:subsectionID", handlers.DeleteSubsectionHandler(repo))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var added struct {
+		ID string `json:"id"`
+	}
+	w := send(http.MethodPost, "/report/report1/api/sections", `{"title": "Evaluation", "position": 0}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+	evaluation := added.ID
+	assert.NotEmpty(t, evaluation)
+
+	sections := "/report/report1/api/sections/"
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, sections+evaluation+"/subsections", `{"title": "Results"}`).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, sections+introduction+"/subsections/"+overview, `{"title": "Summary", "sectionID": "`+evaluation+`", "position": 0}`).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, sections+design, `{"title": "Architecture", "position": 0}`).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, sections+introduction+"/subsections/"+scope, "").Code)
+
+	// Titles that only differ by characters a document ID couldn't hold are fine
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, sections+introduction+"/subsections", `{"title": "A/B"}`).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, sections+introduction+"/subsections", `{"title": "A_B"}`).Code)
+
+	// Titles are required and unique, and what is changed must exist
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/report/report1/api/sections", `{"title": " "}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, sections+introduction, `{}`).Code)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "/report/report1/api/sections", `{"title": " introduction"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, sections+"Missing", "").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPut, sections+introduction+"/subsections/Missing", `{"title": "Other"}`).Code)
+
+	w = send(http.MethodGet, "/report/report1/api/structure", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var structure struct {
+		Sections []reportStructure.Section `json:"sections"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &structure))
+	assert.Equal(t, []reportTemplates.Section{
+		{Title: "Architecture", Subsections: []string{"Components"}},
+		{Title: "Evaluation", Subsections: []string{"Summary", "Results"}},
+		{Title: "Introduction", Subsections: []string{"A/B", "A_B"}},
+	}, titles(structure.Sections))
+
+	// IDs don't change when sections and subsections are renamed or moved
+	assert.Equal(t, design, structure.Sections[0].ID)
+	assert.Equal(t, overview, structure.Sections[1].Subsections[0].ID)
+	contents, err := repo.FetchReportSectionContents("report1", evaluation)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", contents[overview])
+
+	logs := logLines(t, repo, "report1")
+	assert.Contains(t, logs, "added section Evaluation")
+}
+
+func TestMigrateReportHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	_, err := repo.CreateTemplate("st", reportTemplates.ReportTemplate{
+		Name: "Security Target",
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview", "Notes"}},
+		},
+	}, "admin@example.com")
+	assert.NoError(t, err)
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "admin@example.com"))
+	introduction, overview := structureIDs(t, repo, "report1", "Introduction", "Overview")
+	_, notes := structureIDs(t, repo, "report1", "Introduction", "Notes")
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, `{"type":"delta","delta":{"editorId":"`+overview+`","delta":{"ops":[{"insert":"Kept\n"}]}}}`, "admin@example.com"))
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, notes, `{"type":"delta","delta":{"editorId":"`+notes+`","delta":{"ops":[{"insert":"Dropped\n"}]}}}`, "admin@example.com"))
+
+	_, err = repo.UpdateTemplate("st", reportTemplates.ReportTemplate{
+		Name: "Security Target",
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+			{Title: "References", Subsections: []string{"Standards"}},
+		},
+	}, "admin@example.com")
+	assert.NoError(t, err)
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "mockUID123")
+		c.Set("email", "admin@example.com")
+	})
+	router.GET("/report/:reportID/api/migration", handlers.MigrationPlanHandler(repo))
+	router.POST("/report/:reportID/api/migration", handlers.MigrateReportHandler(repo))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var result struct {
+		Migration templateMigration.Migration `json:"migration"`
+		DryRun    bool                        `json:"dryRun"`
+		Sections  []reportStructure.Section   `json:"sections"`
+	}
+
+	// A dry run changes nothing
+	w := send(http.MethodGet, "/report/report1/api/migration", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 1, result.Migration.FromVersion)
+	assert.Equal(t, 2, result.Migration.ToVersion)
+	assert.Len(t, result.Migration.Steps, 3)
+	assert.True(t, result.Migration.DiscardsContent())
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/report/report1/api/migration", `{"dryRun": true}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodGet, "/report/report1/api/migration?version=x", "").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodGet, "/report/report1/api/migration?version=9", "").Code)
+
+	// Deleting written content has to be confirmed
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "/report/report1/api/migration", `{}`).Code)
+	version, _ := repo.GetReportTemplateVersion("report1")
+	assert.Equal(t, 1, version)
+
+	w = send(http.MethodPost, "/report/report1/api/migration", `{"discardContent": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.False(t, result.DryRun)
+	assert.Equal(t, []reportTemplates.Section{
+		{Title: "Introduction", Subsections: []string{"Overview"}},
+		{Title: "References", Subsections: []string{"Standards"}},
+	}, titles(result.Sections))
+	assert.NotEmpty(t, result.Sections[1].Subsections[0].ID)
+
+	contents, err := repo.FetchReportSectionContents("report1", introduction)
+	assert.NoError(t, err)
+	assert.Contains(t, contents[overview], "Kept")
+	version, _ = repo.GetReportTemplateVersion("report1")
+	assert.Equal(t, 2, version)
+
+	logs := logLines(t, repo, "report1")
+	assert.Contains(t, logs, "migrated report from template st version 1 to version 2")
+
+	// Migrating back to the old version is planned the same way
+	w = send(http.MethodGet, "/report/report1/api/migration?version=1", "")
+	result.Migration = templateMigration.Migration{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Migration.ToVersion)
+	assert.False(t, result.Migration.DiscardsContent())
+}
+
+func TestSectionGrantHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+			{Title: "Design", Subsections: []string{"Components"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "admin@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("adminUID", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("memberUID", "report1", reportRoles.Editor))
+	introduction, _ := structureIDs(t, repo, "report1", "Introduction", "")
+
+	mockAuth := &mockAuthService{
+		GetUIDFromEmailFunc: func(email string) (string, error) {
+			return strings.TrimSuffix(email, "@example.com") + "UID", nil
+		},
+	}
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", c.GetHeader("X-UID"))
+		c.Set("email", "admin@example.com")
+	})
+	router.GET("/report/:reportID/api/structure", handlers.ReportStructureHandler(repo))
+	router.GET("/report/:reportID/api/grants", handlers.SectionGrantsHandler(mockAuth, repo))
+	router.PUT("/report/:reportID/api/sections/:sectionID/grants", handlers.GrantSectionHandler(mockAuth, repo))
+	router.DELETE("/report/:reportID/api/sections/:sectionID/grants", handlers.RevokeSectionHandler(mockAuth, repo))
+
+	send := func(method, path, uid, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-UID", uid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	structure := func(uid string) []reportStructure.Section {
+		var result struct {
+			Sections []reportStructure.Section `json:"sections"`
+		}
+		w := send(http.MethodGet, "/report/report1/api/structure", uid, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result.Sections
+	}
+
+	// Members only see the sections they are granted, admins see everything
+	assert.Empty(t, structure("memberUID"))
+	assert.Len(t, structure("adminUID"), 2)
+	assert.Equal(t, "edit", structure("adminUID")[0].Access)
+
+	grants := "/report/report1/api/sections/" + introduction + "/grants"
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, grants, "adminUID", `{"email": "member@example.com", "access": "comment"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, grants, "adminUID", `{"email": "member@example.com", "access": "owner"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPut, grants, "adminUID", `{"email": "stranger@example.com", "access": "read"}`).Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPut, "/report/report1/api/sections/missing/grants", "adminUID", `{"email": "member@example.com", "access": "read"}`).Code)
+
+	sections := structure("memberUID")
+	assert.Len(t, sections, 1)
+	assert.Equal(t, introduction, sections[0].ID)
+	assert.Equal(t, "comment", sections[0].Access)
+
+	w := send(http.MethodGet, "/report/report1/api/grants?email=member@example.com", "adminUID", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"`+introduction+`":"comment"`)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, grants, "adminUID", `{"email": "member@example.com"}`).Code)
+	assert.Empty(t, structure("memberUID"))
+
+	logs := logLines(t, repo, "report1")
+	assert.Contains(t, logs, "granted member@example.com comment access to section Introduction")
+	assert.Contains(t, logs, "revoked access of member@example.com to section Introduction")
+}
+
+func TestMemberRoleHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "owner@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("ownerUID", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("adminUID", "report1", reportRoles.Admin))
+
+	mockAuth := &mockAuthService{
+		GetUIDFromEmailFunc: func(email string) (string, error) {
+			return strings.TrimSuffix(email, "@example.com") + "UID", nil
+		},
+	}
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", c.GetHeader("X-UID"))
+		c.Set("email", strings.TrimSuffix(c.GetHeader("X-UID"), "UID")+"@example.com")
+	})
+	router.POST("/report/:reportID/api/addusertoreport", handlers.AddUserToReport(mockAuth, repo))
+	router.DELETE("/report/:reportID/api/removeuser", handlers.RemoveUserFromReport(mockAuth, repo))
+	router.PUT("/report/:reportID/api/members/role", handlers.MemberRoleHandler(mockAuth, repo))
+	router.POST("/report/:reportID/api/transferownership", handlers.TransferOwnershipHandler(mockAuth, repo))
+
+	send := func(method, path, uid, body string) int {
+		req, _ := http.NewRequest(method, "/report/report1/api/"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-UID", uid)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	role := func(uid string) reportRoles.Role {
+		role, err := repo.GetMemberRole(uid, "report1")
+		assert.NoError(t, err)
+		return role
+	}
+
+	// Members are added with a role, older clients still send privilege
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "addusertoreport", "adminUID", `{"email": "member@example.com", "role": "viewer"}`))
+	assert.Equal(t, reportRoles.Viewer, role("memberUID"))
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "addusertoreport", "adminUID", `{"email": "legacy@example.com", "privilege": true}`))
+	assert.Equal(t, reportRoles.Admin, role("legacyUID"))
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "addusertoreport", "adminUID", `{"email": "member@example.com", "role": "editor"}`))
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "addusertoreport", "adminUID", `{"email": "other@example.com", "role": "superuser"}`))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "addusertoreport", "adminUID", `{"email": "other@example.com", "role": "owner"}`))
+
+	// Admins manage everyone but owners
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "members/role", "adminUID", `{"email": "member@example.com", "role": "commenter"}`))
+	assert.Equal(t, reportRoles.Commenter, role("memberUID"))
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "members/role", "adminUID", `{"email": "legacy@example.com", "role": "editor"}`))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "members/role", "adminUID", `{"email": "member@example.com", "role": "owner"}`))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "members/role", "adminUID", `{"email": "owner@example.com", "role": "viewer"}`))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodDelete, "removeuser", "adminUID", `{"email": "owner@example.com"}`))
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPut, "members/role", "adminUID", `{"email": "stranger@example.com", "role": "viewer"}`))
+
+	// The last owner keeps their role
+	assert.Equal(t, http.StatusConflict, send(http.MethodPut, "members/role", "ownerUID", `{"email": "owner@example.com", "role": "admin"}`))
+	assert.Equal(t, http.StatusConflict, send(http.MethodDelete, "removeuser", "ownerUID", `{"email": "owner@example.com"}`))
+
+	// Only owners transfer ownership, and stay on as admins
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "transferownership", "adminUID", `{"email": "member@example.com"}`))
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "transferownership", "ownerUID", `{"email": "member@example.com"}`))
+	assert.Equal(t, reportRoles.Owner, role("memberUID"))
+	assert.Equal(t, reportRoles.Admin, role("ownerUID"))
+
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "removeuser", "memberUID", `{"email": "legacy@example.com"}`))
+	inReport, _ := repo.IsUserInReport("legacyUID", "report1")
+	assert.False(t, inReport)
+
+	allLogs := logLines(t, repo, "report1")
+	assert.Contains(t, allLogs, "added member@example.com as viewer")
+	assert.Contains(t, allLogs, "changed the role of member@example.com from viewer to commenter")
+	assert.Contains(t, allLogs, "transferred ownership to member@example.com")
+	assert.Contains(t, allLogs, "removed legacy@example.com from the report")
+}
+
+func TestInvitationHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "admin@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("adminUID", "report1", reportRoles.Owner))
+
+	verified := false
+	mockAuth := &mockAuthService{
+		GetUIDFromEmailFunc: func(email string) (string, error) {
+			return "", fmt.Errorf("%w: %s", authentication.ErrUserNotFound, email)
+		},
+		verifyTokenFunc: func(token string) (*auth.Token, error) {
+			return &auth.Token{UID: "evaluatorUID", Claims: map[string]interface{}{"email": "Evaluator@example.com", "email_verified": verified}}, nil
+		},
+	}
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "adminUID")
+		c.Set("email", "admin@example.com")
+	})
+	router.POST("/api/auth/verify", handlers.VerifyTokenHandler(mockAuth, repo))
+	router.POST("/report/:reportID/api/addusertoreport", handlers.AddUserToReport(mockAuth, repo))
+	router.GET("/report/:reportID/api/invitations", handlers.InvitationsHandler(repo))
+	router.POST("/report/:reportID/api/invitations/:invitationID/resend", handlers.ResendInvitationHandler(repo))
+	router.DELETE("/report/:reportID/api/invitations/:invitationID", handlers.RevokeInvitationHandler(repo))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer mock.token.here")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	list := func() []invitation.Invitation {
+		var result struct {
+			Invitations []invitation.Invitation `json:"invitations"`
+		}
+		w := send(http.MethodGet, "/report/report1/api/invitations", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result.Invitations
+	}
+
+	// Adding someone without an account invites them
+	w := send(http.MethodPost, "/report/report1/api/addusertoreport", `{"email": "evaluator@example.com", "role": "viewer"}`)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, http.StatusConflict, send(http.MethodPost, "/report/report1/api/addusertoreport", `{"email": "evaluator@example.com", "role": "editor"}`).Code)
+
+	invitations := list()
+	assert.Len(t, invitations, 1)
+	assert.Equal(t, reportRoles.Viewer, invitations[0].Role)
+	assert.Equal(t, "admin@example.com", invitations[0].InvitedBy)
+	firstToken := invitations[0].Token
+
+	// Resending replaces the token
+	resend := "/report/report1/api/invitations/" + invitations[0].ID + "/resend"
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, resend, "").Code)
+	assert.Equal(t, http.StatusNotFound, send(http.MethodPost, "/report/report1/api/invitations/missing/resend", "").Code)
+	token := list()[0].Token
+	assert.NotEqual(t, firstToken, token)
+
+	// An unverified email needs the current link
+	w = send(http.MethodPost, "/api/auth/verify", `{"invite": "`+firstToken+`"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"joinedReports":[]`)
+	inReport, _ := repo.IsUserInReport("evaluatorUID", "report1")
+	assert.False(t, inReport)
+
+	w = send(http.MethodPost, "/api/auth/verify", `{"invite": "`+token+`"}`)
+	assert.Contains(t, w.Body.String(), `"joinedReports":["report1"]`)
+	role, err := repo.GetMemberRole("evaluatorUID", "report1")
+	assert.NoError(t, err)
+	assert.Equal(t, reportRoles.Viewer, role)
+	assert.Empty(t, list())
+
+	// Verified emails need no link, revoked invitations are gone
+	assert.Equal(t, http.StatusAccepted, send(http.MethodPost, "/report/report1/api/addusertoreport", `{"email": "other@example.com"}`).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/report/report1/api/invitations/"+list()[0].ID, "").Code)
+	assert.Empty(t, list())
+	assert.Equal(t, http.StatusNotFound, send(http.MethodDelete, "/report/report1/api/invitations/missing", "").Code)
+
+	assert.NoError(t, repo.RemoveUserFromReport("evaluatorUID", "report1"))
+	assert.Equal(t, http.StatusAccepted, send(http.MethodPost, "/report/report1/api/addusertoreport", `{"email": "evaluator@example.com", "role": "commenter"}`).Code)
+	verified = true
+	w = send(http.MethodPost, "/api/auth/verify", "")
+	assert.Contains(t, w.Body.String(), `"joinedReports":["report1"]`)
+
+	allLogs := logLines(t, repo, "report1")
+	assert.Contains(t, allLogs, "invited evaluator@example.com as viewer")
+	assert.Contains(t, allLogs, "resent the invitation of evaluator@example.com")
+	assert.Contains(t, allLogs, "revoked the invitation of other@example.com")
+	assert.Contains(t, allLogs, "accepted the invitation as commenter")
+}
+
+func TestMembersHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "owner@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("ownerUID", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("readerUID", "report1", reportRoles.Viewer))
+	assert.NoError(t, repo.LinkReportWithUser("goneUID", "report1", reportRoles.Editor))
+
+	mockAuth := &mockAuthService{
+		GetUserByUIDFunc: func(uid string) (*auth.UserRecord, error) {
+			if uid == "goneUID" {
+				return nil, fmt.Errorf("no user %s", uid)
+			}
+			return &auth.UserRecord{UserInfo: &auth.UserInfo{UID: uid, Email: strings.TrimSuffix(uid, "UID") + "@example.com"}}, nil
+		},
+	}
+
+	router := gin.Default()
+	router.GET("/report/:reportID/api/members", handlers.MembersHandler(mockAuth, repo))
+	members := func() []repository.Member {
+		var result struct {
+			Members []repository.Member `json:"members"`
+		}
+		req, _ := http.NewRequest(http.MethodGet, "/report/report1/api/members", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result.Members
+	}
+
+	byUID := map[string]repository.Member{}
+	for _, member := range members() {
+		byUID[member.UID] = member
+	}
+	assert.Len(t, byUID, 3)
+	assert.Equal(t, reportRoles.Owner, byUID["ownerUID"].Role)
+	assert.Equal(t, "reader@example.com", byUID["readerUID"].Email)
+	assert.Equal(t, reportRoles.Viewer, byUID["readerUID"].Role)
+	assert.False(t, byUID["readerUID"].JoinedAt.IsZero())
+
+	// Members whose account is gone are still listed
+	assert.Empty(t, byUID["goneUID"].Email)
+
+	// The listing follows role changes and removals
+	assert.NoError(t, repo.SetMemberRole("readerUID", "report1", reportRoles.Commenter))
+	assert.NoError(t, repo.RemoveUserFromReport("goneUID", "report1"))
+	listed := members()
+	assert.Len(t, listed, 2)
+	for _, member := range listed {
+		if member.UID == "readerUID" {
+			assert.Equal(t, reportRoles.Commenter, member.Role)
+		}
+	}
+}
+
+// logLines is a report's log as the logs page shows it
+func logLines(t *testing.T, repo repository.ReportRepository, reportID string) string {
+	events, err := repo.FetchLogsForReport(reportID, audit.Query{})
+	assert.NoError(t, err)
+	lines := make([]string, len(events))
+	for i, event := range events {
+		lines[i] = event.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestAuditLogHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "owner@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("ownerUID", "report1", reportRoles.Owner))
+
+	mockAuth := &mockAuthService{
+		GetUIDFromEmailFunc: func(email string) (string, error) {
+			return strings.TrimSuffix(email, "@example.com") + "UID", nil
+		},
+	}
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "ownerUID")
+		c.Set("email", "owner@example.com")
+	})
+	router.POST("/report/:reportID/api/addusertoreport", handlers.AddUserToReport(mockAuth, repo))
+	router.POST("/report/:reportID/api/sections", handlers.AddSectionHandler(repo))
+	router.GET("/report/:reportID/api/logs", handlers.AuditLogHandler(repo))
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/report/report1/api/"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "audit-test")
+		req.RemoteAddr = "10.0.0.1:4321"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	page := func(query string) ([]audit.Event, string) {
+		w := send(http.MethodGet, "logs?"+query, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Events []audit.Event `json:"events"`
+			Next   string        `json:"next"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Events, body.Next
+	}
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "addusertoreport", `{"email": "member@example.com", "role": "viewer"}`).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "sections", `{"title": "Evaluation"}`).Code)
+
+	// Events are typed and carry the request they came from
+	events, next := page("")
+	assert.Len(t, events, 3)
+	assert.Empty(t, next)
+	assert.Equal(t, audit.ReportCreated, events[0].Action)
+	added := events[1]
+	assert.Equal(t, audit.MemberAdded, added.Action)
+	assert.Equal(t, "owner@example.com", added.Actor)
+	assert.Equal(t, "audit-test", added.UserAgent)
+	assert.Equal(t, "10.0.0.1", added.IP)
+	assert.Equal(t, "member@example.com", added.Payload["email"])
+	assert.NotEmpty(t, events[2].Payload["id"])
+
+	// Filters
+	events, _ = page("action=section.added")
+	assert.Len(t, events, 1)
+	events, _ = page("user=someone@example.com")
+	assert.Empty(t, events)
+	events, _ = page("from=" + url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339)))
+	assert.Empty(t, events)
+
+	// Pages follow on from the last event of the one before
+	events, next = page("limit=2")
+	assert.Len(t, events, 2)
+	assert.Equal(t, events[1].ID, next)
+	events, next = page("limit=2&after=" + next)
+	assert.Len(t, events, 1)
+	assert.Equal(t, audit.SectionAdded, events[0].Action)
+	assert.Empty(t, next)
+
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodGet, "logs?after=unknown", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodGet, "logs?limit=0", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodGet, "logs?from=yesterday", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodGet, "logs?format=xml", "").Code)
+
+	// Exports hold every matching event
+	w := send(http.MethodGet, "logs?format=csv&limit=1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "report1-audit.csv")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 4)
+	assert.Equal(t, "member.added", records[2][3])
+
+	w = send(http.MethodGet, "logs?format=jsonl&action=member.added", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 1)
+	var exported audit.Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &exported))
+	assert.Equal(t, added.ID, exported.ID)
+}
+
+func TestVerifyLogsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "report1", "st", "owner@example.com"))
+	repo.BufferLog(audit.Event{ReportID: "report1", Actor: "owner@example.com", Action: audit.ContentEdited, Message: "edited", Payload: map[string]interface{}{"revision": 3}})
+
+	router := gin.Default()
+	router.GET("/report/:reportID/api/logs/verify", handlers.VerifyLogsHandler(repo))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/report/report1/api/logs/verify", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var result audit.Verification
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.True(t, result.Intact)
+	assert.Equal(t, 2, result.Checked)
+	assert.Nil(t, result.Broken)
+}
+
+func TestCommentHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview"}},
+			{Title: "Evaluation", Subsections: []string{"Results"}},
+		},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "commentReport", "st", "owner@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("ownerUID", "commentReport", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("bobUID", "commentReport", reportRoles.Commenter))
+	sectionID, subsectionID := structureIDs(t, repo, "commentReport", "Introduction", "Overview")
+	otherSectionID, _ := structureIDs(t, repo, "commentReport", "Evaluation", "Results")
+
+	mockAuth := &mockAuthService{
+		GetUIDFromEmailFunc: func(email string) (string, error) {
+			return strings.TrimSuffix(email, "@example.com") + "UID", nil
+		},
+	}
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", "ownerUID")
+		c.Set("email", "owner@example.com")
+	})
+	router.GET("/report/:reportID/api/sections/:sectionID/comments", handlers.CommentThreadsHandler(repo))
+	router.POST("/report/:reportID/api/sections/:sectionID/subsections/:subsectionID/comments", handlers.AddCommentHandler(mockAuth, repo))
+	router.POST("/report/:reportID/api/sections/:sectionID/comments/:threadID/replies", handlers.ReplyCommentHandler(mockAuth, repo))
+	router.POST("/report/:reportID/api/sections/:sectionID/comments/:threadID/resolve", handlers.ResolveCommentHandler(repo, true))
+	router.POST("/report/:reportID/api/sections/:sectionID/comments/:threadID/reopen", handlers.ResolveCommentHandler(repo, false))
+
+	send := func(path, body string) (*httptest.ResponseRecorder, comment.Thread) {
+		method := http.MethodPost
+		if body == "" {
+			method = http.MethodGet
+		}
+		req, _ := http.NewRequest(method, "/report/commentReport/api/sections/"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var thread comment.Thread
+		json.Unmarshal(w.Body.Bytes(), &thread)
+		return w, thread
+	}
+
+	// Only people in the report can be mentioned
+	w, thread := send(sectionID+"/subsections/"+subsectionID+"/comments", `{"body": "Check with @bob@example.com and @stranger@example.com", "index": 0, "length": 5}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, thread.ID)
+	assert.Equal(t, "owner@example.com", thread.Author)
+	assert.Equal(t, comment.Anchor{Index: 0, Length: 5}, thread.Anchor)
+	assert.Equal(t, []string{"bob@example.com"}, thread.Mentions)
+
+	w, _ = send(sectionID+"/subsections/"+subsectionID+"/comments", `{"body": "  "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = send(sectionID+"/subsections/"+subsectionID+"/comments", `{"body": "Typo", "index": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = send(otherSectionID+"/subsections/"+subsectionID+"/comments", `{"body": "Typo"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, replied := send(sectionID+"/comments/"+thread.ID+"/replies", `{"body": "Fixed, @owner@example.com"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, replied.Replies, 1)
+	assert.Equal(t, []string{"owner@example.com"}, replied.Replies[0].Mentions)
+
+	w, resolved := send(sectionID+"/comments/"+thread.ID+"/resolve", `{}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, resolved.Resolved)
+	assert.Equal(t, "owner@example.com", resolved.ResolvedBy)
+	w, reopened := send(sectionID+"/comments/"+thread.ID+"/reopen", `{}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, reopened.Resolved)
+
+	// Threads are only reachable through their own section
+	w, _ = send(otherSectionID+"/comments/"+thread.ID+"/resolve", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = send(sectionID+"/comments/missing/replies", `{"body": "Hello"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = send(sectionID+"/comments", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed struct {
+		Threads []comment.Thread `json:"threads"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Len(t, listed.Threads, 1)
+	assert.Len(t, listed.Threads[0].Replies, 1)
+	w, _ = send(otherSectionID+"/comments", "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	assert.Empty(t, listed.Threads)
+
+	events, err := repo.FetchLogsForReport("commentReport", audit.Query{})
+	assert.NoError(t, err)
+	var actions []audit.Action
+	for _, event := range events {
+		if strings.HasPrefix(string(event.Action), "comment.") {
+			actions = append(actions, event.Action)
+			assert.Equal(t, subsectionID, event.SubsectionID)
+		}
+	}
+	assert.Equal(t, []audit.Action{audit.CommentCreated, audit.CommentReplied, audit.CommentResolved, audit.CommentReopened}, actions)
+}
+
+func TestReviewWorkflow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("st", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview", "Scope"}}},
+	})
+	assert.NoError(t, repo.CreateReport("Report", "reviewReport", "st", "owner@example.com"))
+	assert.NoError(t, repo.LinkReportWithUser("ownerUID", "reviewReport", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("bobUID", "reviewReport", reportRoles.Editor))
+	assert.NoError(t, repo.LinkReportWithUser("eveUID", "reviewReport", reportRoles.Commenter))
+	sectionID, overview := structureIDs(t, repo, "reviewReport", "Introduction", "Overview")
+	_, scope := structureIDs(t, repo, "reviewReport", "Introduction", "Scope")
+	assert.NoError(t, repo.SetSectionGrant("bobUID", "reviewReport", sectionID, sectionAccess.Edit))
+	assert.NoError(t, repo.SetSectionGrant("eveUID", "reviewReport", sectionID, sectionAccess.Comment))
+	assert.NoError(t, repo.UpdateReportSectionContents("reviewReport", sectionID, overview, `{"ops":[]}`, "bob@example.com"))
+	versions, _ := repo.ListSubsectionVersions("reviewReport", sectionID, overview, repository.VersionPage{})
+
+	mockAuth := &mockAuthService{
+		GetUIDFromEmailFunc: func(email string) (string, error) {
+			return strings.TrimSuffix(email, "@example.com") + "UID", nil
+		},
+	}
+
+	router := gin.Default()
+	router.Use(func(c *gin.Context) {
+		c.Set("uid", c.GetHeader("X-User")+"UID")
+		c.Set("email", c.GetHeader("X-User")+"@example.com")
+	})
+	base := "/report/:reportID/api/sections/:sectionID"
+	router.GET(base+"/review", handlers.SectionReviewHandler(repo))
+	router.POST(base+"/status", handlers.ReviewStatusHandler(repo))
+	router.POST(base+"/subsections/:subsectionID/status", handlers.ReviewStatusHandler(repo))
+	router.PUT(base+"/reviewers", handlers.ReviewersHandler(mockAuth, repo))
+	router.POST(base+"/subsections/:subsectionID/versions/:versionID/restore", handlers.RestoreSubsectionVersion(repo))
+
+	type sectionReview struct {
+		Status      review.Status            `json:"status"`
+		Subsections map[string]review.Review `json:"subsections"`
+	}
+	send := func(method, user, path, body string) (*httptest.ResponseRecorder, sectionReview) {
+		req, _ := http.NewRequest(method, "/report/reviewReport/api/sections/"+sectionID+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var result sectionReview
+		json.Unmarshal(w.Body.Bytes(), &result)
+		return w, result
+	}
+	move := func(user, subsectionID string, status review.Status) (*httptest.ResponseRecorder, sectionReview) {
+		path := "/status"
+		if subsectionID != "" {
+			path = "/subsections/" + subsectionID + "/status"
+		}
+		return send(http.MethodPost, user, path, `{"status": "`+string(status)+`", "note": "ready"}`)
+	}
+
+	w, result := send(http.MethodGet, "eve", "/review", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, review.Draft, result.Status)
+	assert.Len(t, result.Subsections, 2)
+
+	// Editors submit, reviewers can't
+	w, _ = move("eve", overview, review.InternalReview)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, result = move("bob", overview, review.InternalReview)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, review.InternalReview, result.Subsections[overview].Status)
+	assert.Equal(t, review.Draft, result.Status)
+	w, _ = move("bob", overview, review.Approved)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Only assigned reviewers pass it on and approve it
+	w, _ = move("eve", overview, review.EvaluatorReview)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = send(http.MethodPut, "owner", "/reviewers", `{"reviewers": ["stranger@example.com"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, result = send(http.MethodPut, "owner", "/reviewers", `{"reviewers": ["Eve@example.com", "eve@example.com"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"eve@example.com"}, result.Subsections[scope].Reviewers)
+
+	w, _ = move("eve", overview, review.EvaluatorReview)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, result = move("eve", overview, review.Approved)
+	assert.Equal(t, http.StatusOK, w.Code)
+	approvals := result.Subsections[overview].Approvals()
+	assert.Len(t, approvals, 1)
+	assert.Equal(t, "eve@example.com", approvals[0].By)
+	assert.Equal(t, "ready", approvals[0].Note)
+
+	// Approved content stays put until an admin reopens it
+	w, _ = send(http.MethodPost, "bob", "/subsections/"+overview+"/versions/"+versions[0].VersionID+"/restore", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w, _ = move("bob", overview, review.Draft)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = move("owner", overview, review.Draft)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = send(http.MethodPost, "bob", "/subsections/"+overview+"/versions/"+versions[0].VersionID+"/restore", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A whole section moves when its subsections are at the same status
+	w, result = move("bob", "", review.InternalReview)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, review.InternalReview, result.Status)
+	w, _ = move("eve", overview, review.EvaluatorReview)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = move("eve", "", review.EvaluatorReview)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, _ = move("owner", "missing", review.Draft)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = move("owner", overview, "done")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	events, err := repo.FetchLogsForReport("reviewReport", audit.Query{Action: audit.ReviewStatusChanged})
+	assert.NoError(t, err)
+	assert.Len(t, events, 7)
+	assert.Equal(t, overview, events[0].SubsectionID)
+}
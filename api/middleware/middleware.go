@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"sema/services/authentication"
+	"sema/services/sectionAccess"
 	"sema/repository"
 	"github.com/gin-gonic/gin"
 )
@@ -98,3 +99,71 @@ func AuthAdmininReport(authService *authentication.AuthService, repo repository.
 	}
 }
 
+// AuthTemplateAdmin lets only template admins through
+func AuthTemplateAdmin(authService *authentication.AuthService, repo repository.ReportRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uID, exists := c.Get("uid")
+		if !exists {
+			fmt.Println("UID not found in context")
+			c.Redirect(http.StatusFound, "/")
+			c.Abort()
+			return
+		}
+
+		uidStr, ok := uID.(string)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "uid is not a string"})
+			c.Abort()
+			return
+		}
+
+		if yes, err := repo.IsTemplateAdmin(uidStr); !yes {
+			fmt.Println("Error not template admin: ", err)
+			c.Redirect(http.StatusFound, "/")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AuthSectionAccess lets through members with at least the required level in
+// the section of the route, and sets their level as "sectionAccess" for the
+// handler. Use after AuthUserinReport.
+func AuthSectionAccess(authService *authentication.AuthService, repo repository.ReportRepository, required sectionAccess.Level) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uID, exists := c.Get("uid")
+		if !exists {
+			fmt.Println("UID not found in context")
+			c.Redirect(http.StatusFound, "/")
+			c.Abort()
+			return
+		}
+
+		uidStr, ok := uID.(string)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "uid is not a string"})
+			c.Abort()
+			return
+		}
+
+		access, err := sectionAccess.Lookup(repo, uidStr, c.Param("reportID"))
+		if err != nil {
+			fmt.Println("Error fetching section access: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check section access"})
+			c.Abort()
+			return
+		}
+
+		level := access.Section(c.Param("sectionID"))
+		if !level.Allows(required) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have " + string(required) + " access to this section"})
+			c.Abort()
+			return
+		}
+
+		c.Set("sectionAccess", level)
+		c.Next()
+	}
+}
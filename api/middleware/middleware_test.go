@@ -12,8 +12,10 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"sema/api/middleware"
+	"sema/models/reportRoles"
 	"sema/repository"
 	"sema/services/authentication"
+	"sema/services/sectionAccess"
 )
 
 
@@ -59,6 +61,21 @@ func (r *dummyRepo) IsAdminInReport(uid, reportID string) (bool, error) {
 	return uid == "user123" && reportID == "r1", nil
 }
 
+func (r *dummyRepo) IsTemplateAdmin(uid string) (bool, error) {
+	return uid == "user123", nil
+}
+
+func (r *dummyRepo) GetMemberRole(uid, reportID string) (reportRoles.Role, error) {
+	if uid == "user123" && reportID == "r1" {
+		return reportRoles.Admin, nil
+	}
+	return reportRoles.Editor, nil
+}
+
+func (r *dummyRepo) GetSectionGrants(uid, reportID string) (map[string]sectionAccess.Level, error) {
+	return map[string]sectionAccess.Level{"s1": sectionAccess.Read}, nil
+}
+
 
 func performRequestWithCookie(router *gin.Engine, method, path, cookieValue string) *httptest.ResponseRecorder {
 	req, _ := http.NewRequest(method, path, nil)
@@ -123,3 +140,54 @@ func TestAuthAdmininReport_IsAdmin(t *testing.T) {
 	router.ServeHTTP(resp, req)
 	assert.Equal(t, http.StatusOK, resp.Code)
 }
+
+func TestAuthTemplateAdmin(t *testing.T) {
+	auth := &authentication.AuthService{AuthClient: &dummyAuthClient{}}
+	repo := &dummyRepo{}
+
+	for uid, code := range map[string]int{"user123": http.StatusOK, "user456": http.StatusFound} {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("uid", uid)
+		})
+		router.Use(middleware.AuthTemplateAdmin(auth, repo))
+		router.GET("/", func(c *gin.Context) {
+			c.String(http.StatusOK, "passed")
+		})
+		req, _ := http.NewRequest("GET", "/", nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, code, resp.Code, uid)
+	}
+}
+
+func TestAuthSectionAccess(t *testing.T) {
+	auth := &authentication.AuthService{AuthClient: &dummyAuthClient{}}
+	repo := &dummyRepo{}
+
+	request := func(uid, sectionID string, required sectionAccess.Level) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("uid", uid)
+		})
+		router.GET("/report/:reportID/section/:sectionID", middleware.AuthSectionAccess(auth, repo, required), func(c *gin.Context) {
+			level, _ := c.Get("sectionAccess")
+			c.String(http.StatusOK, string(level.(sectionAccess.Level)))
+		})
+		req, _ := http.NewRequest("GET", "/report/r1/section/"+sectionID, nil)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := request("user456", "s1", sectionAccess.Read)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "read", resp.Body.String())
+	assert.Equal(t, http.StatusForbidden, request("user456", "s1", sectionAccess.Edit).Code)
+	assert.Equal(t, http.StatusForbidden, request("user456", "s2", sectionAccess.Read).Code)
+
+	// Report admins can edit every section
+	resp = request("user123", "s2", sectionAccess.Edit)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "edit", resp.Body.String())
+}
@@ -6,13 +6,14 @@ import (
 	"sema/repository"
 	"sema/services/authentication"
 	"sema/api/middleware"
+	"sema/services/sectionAccess"
 )
 
-func SetupRoutes(router *gin.Engine, authService *authentication.AuthService, repo *repository.FirestoreRepository) {
+func SetupRoutes(router *gin.Engine, authService *authentication.AuthService, repo repository.ReportRepository) {
 	// Public routes (No authentication required)
 
 	/* Routes for registering */
-	router.POST("/api/auth/verify", handlers.VerifyTokenHandler(authService))
+	router.POST("/api/auth/verify", handlers.VerifyTokenHandler(authService, repo))
 	router.GET("/register", handlers.RegisterHandler)
 
 	// Protected routes (Require authentication)
@@ -28,6 +29,15 @@ func SetupRoutes(router *gin.Engine, authService *authentication.AuthService, re
 	reportAdmin.Use(middleware.AuthMiddleware(authService))
 	reportAdmin.Use(middleware.AuthAdmininReport(authService, repo))
 
+	// Section level access, on top of being in the report
+	canRead := middleware.AuthSectionAccess(authService, repo, sectionAccess.Read)
+	canComment := middleware.AuthSectionAccess(authService, repo, sectionAccess.Comment)
+	canEdit := middleware.AuthSectionAccess(authService, repo, sectionAccess.Edit)
+
+	// Template management, for template admins only
+	templates := router.Group("/api/templates")
+	templates.Use(middleware.AuthMiddleware(authService))
+	templates.Use(middleware.AuthTemplateAdmin(authService, repo))
 
 
 
@@ -38,24 +48,70 @@ func SetupRoutes(router *gin.Engine, authService *authentication.AuthService, re
 
 
 	report.GET("/", handlers.ReportHandler(repo))
-	report.GET("/section/:sectionID", handlers.WebSocketHandler(repo))
+	report.GET("/section/:sectionID", canRead, handlers.WebSocketHandler(repo))
 	report.GET("/api/isadmin", handlers.IsAdmin(repo))
+	report.GET("/api/sections/:sectionID/subsections/:subsectionID/versions", canRead, handlers.SubsectionVersionsHandler(repo))
+	report.GET("/api/sections/:sectionID/subsections/:subsectionID/versions/:versionID", canRead, handlers.SubsectionVersionHandler(repo))
+	report.POST("/api/sections/:sectionID/subsections/:subsectionID/versions/:versionID/restore", canEdit, handlers.RestoreSubsectionVersion(repo))
+	report.GET("/api/sections/:sectionID/subsections/:subsectionID/diff", canRead, handlers.SubsectionDiffHandler(repo))
+	report.GET("/api/structure", handlers.ReportStructureHandler(repo))
+	report.GET("/api/editors", handlers.EditorsHandler(repo))
+	report.GET("/api/sections/:sectionID/comments", canRead, handlers.CommentThreadsHandler(repo))
+	report.POST("/api/sections/:sectionID/subsections/:subsectionID/comments", canComment, handlers.AddCommentHandler(authService, repo))
+	report.POST("/api/sections/:sectionID/comments/:threadID/replies", canComment, handlers.ReplyCommentHandler(authService, repo))
+	report.POST("/api/sections/:sectionID/comments/:threadID/resolve", canComment, handlers.ResolveCommentHandler(repo, true))
+	report.POST("/api/sections/:sectionID/comments/:threadID/reopen", canComment, handlers.ResolveCommentHandler(repo, false))
+	report.GET("/api/sections/:sectionID/review", canRead, handlers.SectionReviewHandler(repo))
+	report.POST("/api/sections/:sectionID/status", canRead, handlers.ReviewStatusHandler(repo))
+	report.POST("/api/sections/:sectionID/subsections/:subsectionID/status", canRead, handlers.ReviewStatusHandler(repo))
 
 	reportAdmin.POST("/api/addusertoreport", handlers.AddUserToReport(authService, repo))
 	reportAdmin.GET("/api/generateReport", handlers.GenerateReportHandler(repo))
+	reportAdmin.POST("/api/exports", handlers.StartExportHandler(repo))
+	reportAdmin.GET("/api/exports/:jobID", handlers.ExportStatusHandler())
+	reportAdmin.GET("/api/exports/:jobID/download", handlers.ExportDownloadHandler())
 	reportAdmin.DELETE("/api/removeuser", handlers.RemoveUserFromReport(authService, repo))
+	reportAdmin.GET("/api/members", handlers.MembersHandler(authService, repo))
+	reportAdmin.PUT("/api/members/role", handlers.MemberRoleHandler(authService, repo))
+	reportAdmin.POST("/api/transferownership", handlers.TransferOwnershipHandler(authService, repo))
+	reportAdmin.GET("/api/invitations", handlers.InvitationsHandler(repo))
+	reportAdmin.POST("/api/invitations/:invitationID/resend", handlers.ResendInvitationHandler(repo))
+	reportAdmin.DELETE("/api/invitations/:invitationID", handlers.RevokeInvitationHandler(repo))
 	reportAdmin.POST("/api/renamereport", handlers.RenameReport(repo))
 	reportAdmin.DELETE("/api/deletereport", handlers.DeleteReport(repo))
 	reportAdmin.GET("/logs", handlers.ReportLogsHandler(repo))
+	reportAdmin.GET("/api/logs", handlers.AuditLogHandler(repo))
+	reportAdmin.GET("/api/logs/verify", handlers.VerifyLogsHandler(repo))
+	reportAdmin.POST("/api/sections", handlers.AddSectionHandler(repo))
+	reportAdmin.PUT("/api/sections/:sectionID", handlers.UpdateSectionHandler(repo))
+	reportAdmin.DELETE("/api/sections/:sectionID", handlers.DeleteSectionHandler(repo))
+	reportAdmin.POST("/api/sections/:sectionID/subsections", handlers.AddSubsectionHandler(repo))
+	reportAdmin.PUT("/api/sections/:sectionID/subsections/:subsectionID", handlers.UpdateSubsectionHandler(repo))
+	reportAdmin.DELETE("/api/sections/:sectionID/subsections/:subsectionID", handlers.DeleteSubsectionHandler(repo))
+	reportAdmin.GET("/api/migration", handlers.MigrationPlanHandler(repo))
+	reportAdmin.POST("/api/migration", handlers.MigrateReportHandler(repo))
+	reportAdmin.GET("/api/grants", handlers.SectionGrantsHandler(authService, repo))
+	reportAdmin.PUT("/api/sections/:sectionID/grants", handlers.GrantSectionHandler(authService, repo))
+	reportAdmin.DELETE("/api/sections/:sectionID/grants", handlers.RevokeSectionHandler(authService, repo))
+	reportAdmin.PUT("/api/sections/:sectionID/reviewers", handlers.ReviewersHandler(authService, repo))
+	reportAdmin.PUT("/api/sections/:sectionID/subsections/:subsectionID/reviewers", handlers.ReviewersHandler(authService, repo))
+
+	templates.GET("", handlers.ListTemplatesHandler(repo))
+	templates.POST("", handlers.CreateTemplateHandler(repo))
+	templates.GET("/:templateID", handlers.GetTemplateHandler(repo))
+	templates.PUT("/:templateID", handlers.UpdateTemplateHandler(repo))
+	templates.POST("/:templateID/clone", handlers.CloneTemplateHandler(repo))
+	templates.GET("/:templateID/versions", handlers.TemplateVersionsHandler(repo))
+	templates.GET("/:templateID/versions/:version", handlers.TemplateVersionHandler(repo))
 
 }
 
 // Disabled middleware protection for load testing purposes
-func LoadTestSetupRoutes(router *gin.Engine, authService *authentication.AuthService, repo *repository.FirestoreRepository) {
+func LoadTestSetupRoutes(router *gin.Engine, authService *authentication.AuthService, repo repository.ReportRepository) {
 	// Public routes (No authentication required)
 
 	/* Routes for registering */
-	router.POST("/api/auth/verify", handlers.VerifyTokenHandler(authService))
+	router.POST("/api/auth/verify", handlers.VerifyTokenHandler(authService, repo))
 	router.GET("/register", handlers.RegisterHandler)
 
 	// Protected routes (Require authentication)
@@ -71,6 +127,9 @@ func LoadTestSetupRoutes(router *gin.Engine, authService *authentication.AuthSer
 	// reportAdmin.Use(middleware.AuthMiddleware(authService))
 	// reportAdmin.Use(middleware.AuthAdmininReport(authService, repo))
 
+	templates := router.Group("/api/templates")
+	// templates.Use(middleware.AuthMiddleware(authService))
+	// templates.Use(middleware.AuthTemplateAdmin(authService, repo))
 
 
 
@@ -83,12 +142,58 @@ func LoadTestSetupRoutes(router *gin.Engine, authService *authentication.AuthSer
 	report.GET("/", handlers.ReportHandler(repo))
 	report.GET("/section/:sectionID", handlers.WebSocketHandler(repo))
 	report.GET("/api/isadmin", handlers.IsAdmin(repo))
+	report.GET("/api/sections/:sectionID/subsections/:subsectionID/versions", handlers.SubsectionVersionsHandler(repo))
+	report.GET("/api/sections/:sectionID/subsections/:subsectionID/versions/:versionID", handlers.SubsectionVersionHandler(repo))
+	report.POST("/api/sections/:sectionID/subsections/:subsectionID/versions/:versionID/restore", handlers.RestoreSubsectionVersion(repo))
+	report.GET("/api/sections/:sectionID/subsections/:subsectionID/diff", handlers.SubsectionDiffHandler(repo))
+	report.GET("/api/structure", handlers.ReportStructureHandler(repo))
+	report.GET("/api/editors", handlers.EditorsHandler(repo))
+	report.GET("/api/sections/:sectionID/comments", handlers.CommentThreadsHandler(repo))
+	report.POST("/api/sections/:sectionID/subsections/:subsectionID/comments", handlers.AddCommentHandler(authService, repo))
+	report.POST("/api/sections/:sectionID/comments/:threadID/replies", handlers.ReplyCommentHandler(authService, repo))
+	report.POST("/api/sections/:sectionID/comments/:threadID/resolve", handlers.ResolveCommentHandler(repo, true))
+	report.POST("/api/sections/:sectionID/comments/:threadID/reopen", handlers.ResolveCommentHandler(repo, false))
+	report.GET("/api/sections/:sectionID/review", handlers.SectionReviewHandler(repo))
+	report.POST("/api/sections/:sectionID/status", handlers.ReviewStatusHandler(repo))
+	report.POST("/api/sections/:sectionID/subsections/:subsectionID/status", handlers.ReviewStatusHandler(repo))
 
 	reportAdmin.POST("/api/addusertoreport", handlers.AddUserToReport(authService, repo))
 	reportAdmin.GET("/api/generateReport", handlers.GenerateReportHandler(repo))
+	reportAdmin.POST("/api/exports", handlers.StartExportHandler(repo))
+	reportAdmin.GET("/api/exports/:jobID", handlers.ExportStatusHandler())
+	reportAdmin.GET("/api/exports/:jobID/download", handlers.ExportDownloadHandler())
 	reportAdmin.DELETE("/api/removeuser", handlers.RemoveUserFromReport(authService, repo))
+	reportAdmin.GET("/api/members", handlers.MembersHandler(authService, repo))
+	reportAdmin.PUT("/api/members/role", handlers.MemberRoleHandler(authService, repo))
+	reportAdmin.POST("/api/transferownership", handlers.TransferOwnershipHandler(authService, repo))
+	reportAdmin.GET("/api/invitations", handlers.InvitationsHandler(repo))
+	reportAdmin.POST("/api/invitations/:invitationID/resend", handlers.ResendInvitationHandler(repo))
+	reportAdmin.DELETE("/api/invitations/:invitationID", handlers.RevokeInvitationHandler(repo))
 	reportAdmin.POST("/api/renamereport", handlers.RenameReport(repo))
 	reportAdmin.DELETE("/api/deletereport", handlers.DeleteReport(repo))
 	reportAdmin.GET("/logs", handlers.ReportLogsHandler(repo))
+	reportAdmin.GET("/api/logs", handlers.AuditLogHandler(repo))
+	reportAdmin.GET("/api/logs/verify", handlers.VerifyLogsHandler(repo))
+	reportAdmin.POST("/api/sections", handlers.AddSectionHandler(repo))
+	reportAdmin.PUT("/api/sections/:sectionID", handlers.UpdateSectionHandler(repo))
+	reportAdmin.DELETE("/api/sections/:sectionID", handlers.DeleteSectionHandler(repo))
+	reportAdmin.POST("/api/sections/:sectionID/subsections", handlers.AddSubsectionHandler(repo))
+	reportAdmin.PUT("/api/sections/:sectionID/subsections/:subsectionID", handlers.UpdateSubsectionHandler(repo))
+	reportAdmin.DELETE("/api/sections/:sectionID/subsections/:subsectionID", handlers.DeleteSubsectionHandler(repo))
+	reportAdmin.GET("/api/migration", handlers.MigrationPlanHandler(repo))
+	reportAdmin.POST("/api/migration", handlers.MigrateReportHandler(repo))
+	reportAdmin.GET("/api/grants", handlers.SectionGrantsHandler(authService, repo))
+	reportAdmin.PUT("/api/sections/:sectionID/grants", handlers.GrantSectionHandler(authService, repo))
+	reportAdmin.DELETE("/api/sections/:sectionID/grants", handlers.RevokeSectionHandler(authService, repo))
+	reportAdmin.PUT("/api/sections/:sectionID/reviewers", handlers.ReviewersHandler(authService, repo))
+	reportAdmin.PUT("/api/sections/:sectionID/subsections/:subsectionID/reviewers", handlers.ReviewersHandler(authService, repo))
+
+	templates.GET("", handlers.ListTemplatesHandler(repo))
+	templates.POST("", handlers.CreateTemplateHandler(repo))
+	templates.GET("/:templateID", handlers.GetTemplateHandler(repo))
+	templates.PUT("/:templateID", handlers.UpdateTemplateHandler(repo))
+	templates.POST("/:templateID/clone", handlers.CloneTemplateHandler(repo))
+	templates.GET("/:templateID/versions", handlers.TemplateVersionsHandler(repo))
+	templates.GET("/:templateID/versions/:version", handlers.TemplateVersionHandler(repo))
 
 }
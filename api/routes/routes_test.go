@@ -64,9 +64,45 @@ func TestProtectedRoutesWithoutToken(t *testing.T) {
 		"POST /report/abc/api/addusertoreport",
 		"GET /report/abc/api/generateReport",
 		"DELETE /report/abc/api/removeuser",
+		"GET /report/abc/api/members",
+		"PUT /report/abc/api/members/role",
+		"POST /report/abc/api/transferownership",
+		"GET /report/abc/api/invitations",
+		"POST /report/abc/api/invitations/xyz/resend",
+		"DELETE /report/abc/api/invitations/xyz",
 		"POST /report/abc/api/renamereport",
 		"DELETE /report/abc/api/deletereport",
 		"GET /report/abc/logs",
+		"GET /report/abc/api/logs",
+		"GET /report/abc/api/logs/verify",
+		"GET /report/abc/api/structure",
+		"GET /report/abc/api/editors",
+		"GET /report/abc/api/sections/xyz/comments",
+		"POST /report/abc/api/sections/xyz/subsections/sub/comments",
+		"POST /report/abc/api/sections/xyz/comments/t1/replies",
+		"POST /report/abc/api/sections/xyz/comments/t1/resolve",
+		"POST /report/abc/api/sections/xyz/comments/t1/reopen",
+		"GET /report/abc/api/sections/xyz/review",
+		"POST /report/abc/api/sections/xyz/status",
+		"POST /report/abc/api/sections/xyz/subsections/sub/status",
+		"POST /report/abc/api/sections",
+		"PUT /report/abc/api/sections/xyz",
+		"DELETE /report/abc/api/sections/xyz",
+		"POST /report/abc/api/sections/xyz/subsections",
+		"PUT /report/abc/api/sections/xyz/subsections/sub",
+		"DELETE /report/abc/api/sections/xyz/subsections/sub",
+		"GET /report/abc/api/migration",
+		"POST /report/abc/api/migration",
+		"GET /report/abc/api/grants",
+		"PUT /report/abc/api/sections/xyz/grants",
+		"DELETE /report/abc/api/sections/xyz/grants",
+		"PUT /report/abc/api/sections/xyz/reviewers",
+		"PUT /report/abc/api/sections/xyz/subsections/sub/reviewers",
+		"GET /api/templates",
+		"POST /api/templates",
+		"PUT /api/templates/abc",
+		"POST /api/templates/abc/clone",
+		"GET /api/templates/abc/versions/1",
 	}
 
 	for _, route := range protectedRoutes {
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"log"
+	"os"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"sema/api/routes"
+	"sema/config"
+	"sema/models/audit"
+	"sema/models/reportTemplates"
 	"sema/repository"
 	"sema/services/authentication"
 	"sema/services/firebase"
@@ -13,29 +21,101 @@ const (
 	projectID = "sema-7c193"
 )
 
+// backendFlags are the flags that only apply to one repository backend
+var backendFlags = map[string]string{
+	"templates":       "memory",
+	"admins":          "memory",
+	"migrate-ids":     "firestore",
+	"migrate-members": "firestore",
+	"verify-logs":     "firestore",
+}
+
 
 func main() {
+	repoBackend := flag.String("repo", "firestore", "report repository backend: firestore or memory")
+	templatesPath := flag.String("templates", "", "report templates loaded into the memory repository instead of the built in config/memory_templates.json")
+	admins := flag.String("admins", "", "comma separated UIDs of template admins in the memory repository")
+	migrateIDs := flag.Bool("migrate-ids", false, "give sections and subsections of existing Firestore reports generated IDs, then exit")
+	migrateMembers := flag.Bool("migrate-members", false, "fill the members index of existing Firestore reports, then exit")
+	verifyLogs := flag.String("verify-logs", "", "check the hash chain of a Firestore report's logs, then exit")
+	flag.Parse()
 
+	flag.Visit(func(f *flag.Flag) {
+		if backend := backendFlags[f.Name]; backend != "" && backend != *repoBackend {
+			log.Fatalf("-%s only works with -repo=%s", f.Name, backend)
+		}
+	})
 
 	r := gin.Default()
 
+	// Sign in goes through Firebase Auth whatever the repository backend
 	firebaseApp, err := firebase.NewFirebaseApp("../../config/firebase_credentials.json")
 	if err != nil {
 		log.Fatalf("Failed to initialize Firebase: %v", err)
 	}
 
-	// Initialize Firestore Repo using the shared Firebase App
-	repo, err := repository.NewFirestoreRepository(firebaseApp, projectID)
-	if err != nil {
-		log.Fatalf("Failed to initialize Firestore: %v", err)
+	var repo repository.ReportRepository
+	switch *repoBackend {
+	case "firestore":
+		// Initialize Firestore Repo using the shared Firebase App
+		firestoreRepo, err := repository.NewFirestoreRepository(firebaseApp, projectID)
+		if err != nil {
+			log.Fatalf("Failed to initialize Firestore: %v", err)
+		}
+		defer firestoreRepo.Client.Close() // Ensure Firestore client is closed on exit
+		repo = firestoreRepo
+
+		if *migrateIDs {
+			migrated, err := firestoreRepo.MigrateStructureIDs()
+			if err != nil {
+				log.Fatalf("Failed to migrate section IDs after %d reports: %v", migrated, err)
+			}
+			log.Printf("Migrated section IDs of %d reports", migrated)
+			return
+		}
+		if *migrateMembers {
+			migrated, err := firestoreRepo.MigrateMembers()
+			if err != nil {
+				log.Fatalf("Failed to fill members index after %d members: %v", migrated, err)
+			}
+			log.Printf("Added %d members to report members indexes", migrated)
+			return
+		}
+		if *verifyLogs != "" {
+			events, head, err := firestoreRepo.FetchLogChain(*verifyLogs)
+			if err != nil {
+				log.Fatalf("Failed to fetch logs of report %s: %v", *verifyLogs, err)
+			}
+			result := audit.Verify(events, head)
+			if !result.Intact {
+				log.Fatalf("Log chain of report %s is broken at entry %d (%s): %s", *verifyLogs, result.Broken.Seq, result.Broken.EventID, result.Broken.Reason)
+			}
+			log.Printf("Log chain of report %s is intact: %d chained entries, %d from before chaining", *verifyLogs, result.Checked, result.Unchained)
+			return
+		}
+
+	case "memory":
+		memoryRepo := repository.NewMemoryRepository()
+		if err := loadMemoryTemplates(memoryRepo, *templatesPath); err != nil {
+			log.Fatalf("Failed to load report templates: %v", err)
+		}
+		for _, uid := range strings.Split(*admins, ",") {
+			if uid = strings.TrimSpace(uid); uid != "" {
+				memoryRepo.AddAdmin(uid)
+			}
+		}
+		log.Println("Using in-memory repository, data is lost on exit")
+		repo = memoryRepo
+
+	default:
+		log.Fatalf("Unknown repository backend: %s", *repoBackend)
 	}
-	defer repo.Client.Close() // Ensure Firestore client is closed on exit
 
 	// Initialize Auth Service using the shared Firebase App
 	authService, err := authentication.NewAuthService(firebaseApp)
 	if err != nil {
 		log.Fatalf("Failed to initialize Firebase Auth: %v", err)
-	}		
+	}
 
 
 	r.Static("/static", "../../static")
@@ -47,3 +127,26 @@ func main() {
 	r.Run(":8080")
 
 }
+
+// loadMemoryTemplates seeds the memory repository with templates keyed by
+// template ID, read from path or the built in ones when path is empty
+func loadMemoryTemplates(repo *repository.MemoryRepository, path string) error {
+	data := config.MemoryTemplates
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return err
+		}
+	}
+
+	var templates map[string]reportTemplates.ReportTemplate
+	if err := json.Unmarshal(data, &templates); err != nil {
+		return err
+	}
+
+	for templateID, template := range templates {
+		repo.AddTemplate(templateID, template)
+	}
+	log.Printf("Loaded %d report templates", len(templates))
+	return nil
+}
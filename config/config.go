@@ -0,0 +1,10 @@
+// Package config holds the configuration files built into the server
+package config
+
+import _ "embed"
+
+// MemoryTemplates are the report templates the memory repository starts with
+// unless others are given
+//
+//go:embed memory_templates.json
+var MemoryTemplates []byte
@@ -0,0 +1,152 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Action is what an audit event records, as "subject.verb"
+type Action string
+
+const (
+	ReportCreated  Action = "report.created"
+	ReportRenamed  Action = "report.renamed"
+	ReportDeleted  Action = "report.deleted"
+	ReportMigrated Action = "report.migrated"
+
+	MemberAdded          Action = "member.added"
+	MemberRemoved        Action = "member.removed"
+	MemberRoleChanged    Action = "member.role_changed"
+	OwnershipTransferred Action = "member.ownership_transferred"
+	InvitationCreated    Action = "invitation.created"
+	InvitationResent     Action = "invitation.resent"
+	InvitationRevoked    Action = "invitation.revoked"
+	InvitationAccepted   Action = "invitation.accepted"
+	SectionGrantChanged  Action = "grant.changed"
+	SectionGrantRevoked  Action = "grant.revoked"
+
+	SectionAdded      Action = "section.added"
+	SectionUpdated    Action = "section.updated"
+	SectionDeleted    Action = "section.deleted"
+	SubsectionAdded   Action = "subsection.added"
+	SubsectionUpdated Action = "subsection.updated"
+	SubsectionDeleted Action = "subsection.deleted"
+
+	SectionJoined   Action = "section.joined"
+	SectionLeft     Action = "section.left"
+	ContentEdited   Action = "content.edited"
+	VersionRestored Action = "version.restored"
+
+	CommentCreated  Action = "comment.created"
+	CommentReplied  Action = "comment.replied"
+	CommentResolved Action = "comment.resolved"
+	CommentReopened Action = "comment.reopened"
+
+	ReviewStatusChanged Action = "review.status_changed"
+	ReviewersAssigned   Action = "review.reviewers_assigned"
+)
+
+// Event is one entry of a report's audit log. Message is a readable summary
+// without the actor, Payload holds whatever else the action needs.
+type Event struct {
+	ID           string                 `json:"id"`
+	ReportID     string                 `json:"reportID"`
+	Time         time.Time              `json:"time"`
+	Actor        string                 `json:"actor"`  // Email of who acted, empty for the server
+	Action       Action                 `json:"action"` // Empty for entries from before typed events
+	SectionID    string                 `json:"sectionID,omitempty"`
+	SubsectionID string                 `json:"subsectionID,omitempty"`
+	IP           string                 `json:"ip,omitempty"`
+	UserAgent    string                 `json:"userAgent,omitempty"`
+	Message      string                 `json:"message"`
+	Payload      map[string]interface{} `json:"payload,omitempty"`
+
+	// Place in the report's hash chain, see Link. Zero for events logged
+	// before chaining.
+	Seq      int64  `json:"seq,omitempty"`
+	PrevHash string `json:"prevHash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// String is the event as a line of the logs page
+func (e Event) String() string {
+	line := fmt.Sprintf("[%s]", e.Time.Format("2006-01-02 15:04:05"))
+	if e.Actor != "" {
+		line += " " + e.Actor
+	}
+	return line + " " + e.Message
+}
+
+// Query picks events of a report. Zero fields match everything, From is
+// inclusive and To exclusive. Events come oldest first, After is the ID of
+// the last event of the page before.
+type Query struct {
+	Actor  string
+	Action Action
+	From   time.Time
+	To     time.Time
+	After  string
+	Limit  int
+}
+
+// Matches reports whether an event passes the query's filters. After and
+// Limit are left to whoever pages through the events.
+func (q Query) Matches(e Event) bool {
+	switch {
+	case q.Actor != "" && e.Actor != q.Actor:
+		return false
+	case q.Action != "" && e.Action != q.Action:
+		return false
+	case !q.From.IsZero() && e.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !e.Time.Before(q.To):
+		return false
+	}
+	return true
+}
+
+var csvHeader = []string{"id", "time", "actor", "action", "sectionID", "subsectionID", "ip", "userAgent", "message", "payload", "seq", "prevHash", "hash"}
+
+// WriteCSV writes events as CSV with a header row. Times are RFC 3339 in
+// UTC and the payload is JSON.
+func WriteCSV(w io.Writer, events []Event) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range events {
+		payload := ""
+		if len(e.Payload) > 0 {
+			encoded, err := json.Marshal(e.Payload)
+			if err != nil {
+				return err
+			}
+			payload = string(encoded)
+		}
+		seq := ""
+		if e.Seq > 0 {
+			seq = strconv.FormatInt(e.Seq, 10)
+		}
+		record := []string{e.ID, e.Time.UTC().Format(time.RFC3339Nano), e.Actor, string(e.Action), e.SectionID, e.SubsectionID, e.IP, e.UserAgent, e.Message, payload, seq, e.PrevHash, e.Hash}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSONLines writes events as JSON Lines, one event per line
+func WriteJSONLines(w io.Writer, events []Event) error {
+	encoder := json.NewEncoder(w)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
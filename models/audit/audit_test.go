@@ -0,0 +1,59 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/audit"
+)
+
+func TestQueryMatches(t *testing.T) {
+	now := time.Now()
+	event := audit.Event{Time: now, Actor: "alice@example.com", Action: audit.ContentEdited}
+
+	assert.True(t, audit.Query{}.Matches(event))
+	assert.True(t, audit.Query{Actor: "alice@example.com", Action: audit.ContentEdited}.Matches(event))
+	assert.False(t, audit.Query{Actor: "bob@example.com"}.Matches(event))
+	assert.False(t, audit.Query{Action: audit.MemberAdded}.Matches(event))
+
+	// From is inclusive, To exclusive
+	assert.True(t, audit.Query{From: now, To: now.Add(time.Second)}.Matches(event))
+	assert.False(t, audit.Query{From: now.Add(time.Second)}.Matches(event))
+	assert.False(t, audit.Query{To: now}.Matches(event))
+}
+
+func TestString(t *testing.T) {
+	when := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	assert.Equal(t, "[2024-03-01 12:30:00] alice@example.com added section Intro", audit.Event{Time: when, Actor: "alice@example.com", Message: "added section Intro"}.String())
+	assert.Equal(t, "[2024-03-01 12:30:00] Report was deleted", audit.Event{Time: when, Message: "Report was deleted"}.String())
+}
+
+func TestExports(t *testing.T) {
+	events := []audit.Event{
+		{ID: "e1", Time: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Actor: "alice@example.com", Action: audit.MemberAdded, Message: "added bob, as viewer", Payload: map[string]interface{}{"role": "viewer"}},
+		{ID: "e2", Time: time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), Action: audit.ReportDeleted, Message: "Report was deleted"},
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, audit.WriteCSV(&out, events))
+	records, err := csv.NewReader(&out).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 3)
+	assert.Equal(t, "id", records[0][0])
+	assert.Equal(t, []string{"e1", "2024-03-01T12:00:00Z", "alice@example.com", "member.added", "", "", "", "", "added bob, as viewer", `{"role":"viewer"}`, "", "", ""}, records[1])
+	assert.Equal(t, "", records[2][9])
+
+	out.Reset()
+	assert.NoError(t, audit.WriteJSONLines(&out, events))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 2)
+	var decoded audit.Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+	assert.Equal(t, "e1", decoded.ID)
+	assert.Equal(t, "viewer", decoded.Payload["role"])
+}
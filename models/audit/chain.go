@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Head is the last link of a report's chain, kept apart from the events so
+// that dropping the newest events is noticed too
+type Head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Link puts the event after the head, giving it the next sequence number,
+// the head's hash and its own hash, and returns the new head. The time is
+// cut to microseconds and the payload to JSON values, as Firestore keeps
+// them, so the hash still matches once the event is read back.
+func (e *Event) Link(head Head) Head {
+	e.Time = e.Time.Truncate(time.Microsecond)
+	e.Payload = jsonPayload(e.Payload)
+	e.Seq = head.Seq + 1
+	e.PrevHash = head.Hash
+	e.Hash = e.ComputeHash()
+	return Head{Seq: e.Seq, Hash: e.Hash}
+}
+
+// ComputeHash is the SHA-256 of everything the event records, its place in
+// the chain and the hash of the event before it
+func (e Event) ComputeHash() string {
+	// Fields are hashed in a fixed order, JSON sorts the payload's keys
+	encoded, _ := json.Marshal(struct {
+		Seq          int64                  `json:"seq"`
+		PrevHash     string                 `json:"prevHash"`
+		ID           string                 `json:"id"`
+		ReportID     string                 `json:"reportID"`
+		Time         string                 `json:"time"`
+		Actor        string                 `json:"actor"`
+		Action       Action                 `json:"action"`
+		SectionID    string                 `json:"sectionID"`
+		SubsectionID string                 `json:"subsectionID"`
+		IP           string                 `json:"ip"`
+		UserAgent    string                 `json:"userAgent"`
+		Message      string                 `json:"message"`
+		Payload      map[string]interface{} `json:"payload"`
+	}{e.Seq, e.PrevHash, e.ID, e.ReportID, e.Time.UTC().Format(time.RFC3339Nano), e.Actor, e.Action, e.SectionID, e.SubsectionID, e.IP, e.UserAgent, e.Message, e.Payload})
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// jsonPayload turns a payload into the maps, slices, strings, float64s and
+// bools it decodes to from JSON
+func jsonPayload(payload map[string]interface{}) map[string]interface{} {
+	if len(payload) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(encoded, &decoded)
+	return decoded
+}
+
+// Break is the first place a chain does not hold
+type Break struct {
+	Seq     int64  `json:"seq"`               // Where the event should be in the chain
+	EventID string `json:"eventID,omitempty"` // Empty when the event is missing
+	Reason  string `json:"reason"`
+}
+
+// Verification is the outcome of checking a report's chain. Events logged
+// before chaining have no hash and are only counted.
+type Verification struct {
+	Intact    bool   `json:"intact"`
+	Checked   int    `json:"checked"`
+	Unchained int    `json:"unchained"`
+	Broken    *Break `json:"broken,omitempty"`
+}
+
+// Verify walks a report's events in chain order and reports the first one
+// that was changed, removed, added or moved, checking the last against head
+func Verify(events []Event, head Head) Verification {
+	var chained []Event
+	var unchained []Event
+	for _, e := range events {
+		if e.Seq == 0 {
+			unchained = append(unchained, e)
+		} else {
+			chained = append(chained, e)
+		}
+	}
+	sort.SliceStable(chained, func(i, j int) bool { return chained[i].Seq < chained[j].Seq })
+
+	result := Verification{Unchained: len(unchained)}
+	broken := func(b Break) Verification {
+		result.Broken = &b
+		return result
+	}
+
+	prev := Head{}
+	for _, e := range chained {
+		want := prev.Seq + 1
+		switch {
+		case e.Seq < want:
+			return broken(Break{Seq: e.Seq, EventID: e.ID, Reason: "event appears twice in the chain"})
+		case e.Seq > want:
+			return broken(Break{Seq: want, Reason: "event is missing"})
+		case e.PrevHash != prev.Hash:
+			return broken(Break{Seq: e.Seq, EventID: e.ID, Reason: "event does not follow the one before it"})
+		case e.ComputeHash() != e.Hash:
+			return broken(Break{Seq: e.Seq, EventID: e.ID, Reason: "event was altered"})
+		}
+		prev = Head{Seq: e.Seq, Hash: e.Hash}
+		result.Checked++
+	}
+
+	if prev != head {
+		if prev.Seq < head.Seq {
+			return broken(Break{Seq: prev.Seq + 1, Reason: "event is missing"})
+		}
+		return broken(Break{Seq: prev.Seq, Reason: "chain does not end at its recorded head"})
+	}
+
+	// Events without a hash can only be older than the chain
+	if len(chained) > 0 {
+		for _, e := range unchained {
+			if !e.Time.Before(chained[0].Time) {
+				return broken(Break{EventID: e.ID, Reason: "event without a hash was added to the chained log"})
+			}
+		}
+	}
+
+	result.Intact = true
+	return result
+}
@@ -0,0 +1,103 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/audit"
+)
+
+// chain links n events the way the repositories do
+func chain(n int) ([]audit.Event, audit.Head) {
+	var events []audit.Event
+	head := audit.Head{}
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		event := audit.Event{ID: string(rune('a' + i)), ReportID: "report1", Time: start.Add(time.Duration(i) * time.Second), Actor: "alice@example.com", Action: audit.ContentEdited, Message: "edited", Payload: map[string]interface{}{"revision": i}}
+		head = event.Link(head)
+		events = append(events, event)
+	}
+	return events, head
+}
+
+func TestLink(t *testing.T) {
+	events, head := chain(3)
+	assert.Equal(t, int64(3), head.Seq)
+	assert.Equal(t, events[2].Hash, head.Hash)
+	assert.Equal(t, events[0].Hash, events[1].PrevHash)
+	assert.Empty(t, events[0].PrevHash)
+
+	// The hash survives a round trip through storage
+	encoded, err := json.Marshal(events[1])
+	assert.NoError(t, err)
+	var decoded audit.Event
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, events[1].Hash, decoded.ComputeHash())
+}
+
+func TestVerify(t *testing.T) {
+	events, head := chain(4)
+	result := audit.Verify(events, head)
+	assert.True(t, result.Intact)
+	assert.Equal(t, 4, result.Checked)
+
+	// Order of the events does not matter
+	shuffled := []audit.Event{events[2], events[0], events[3], events[1]}
+	assert.True(t, audit.Verify(shuffled, head).Intact)
+
+	// Older events without a hash are counted
+	legacy := audit.Event{ID: "old", Time: events[0].Time.Add(-time.Hour), Message: "alice joined"}
+	result = audit.Verify(append([]audit.Event{legacy}, events...), head)
+	assert.True(t, result.Intact)
+	assert.Equal(t, 1, result.Unchained)
+
+	assert.True(t, audit.Verify(nil, audit.Head{}).Intact)
+}
+
+func TestVerifyBreaks(t *testing.T) {
+	tests := map[string]struct {
+		tamper func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head)
+		seq    int64
+		reason string
+	}{
+		"altered": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			events[1].Actor = "mallory@example.com"
+			return events, head
+		}, 2, "event was altered"},
+		"altered payload": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			events[2].Payload = map[string]interface{}{"revision": 7}
+			return events, head
+		}, 3, "event was altered"},
+		"removed": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			return append(events[:1], events[2:]...), head
+		}, 2, "event is missing"},
+		"newest removed": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			return events[:3], head
+		}, 4, "event is missing"},
+		"rehashed": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			events[1].Message = "did nothing"
+			events[1].Hash = events[1].ComputeHash()
+			return events, head
+		}, 3, "event does not follow the one before it"},
+		"duplicated": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			return append(events, events[1]), head
+		}, 2, "event appears twice in the chain"},
+		"added without hash": {func(events []audit.Event, head audit.Head) ([]audit.Event, audit.Head) {
+			return append(events, audit.Event{ID: "forged", Time: events[3].Time}), head
+		}, 0, "event without a hash was added to the chained log"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			events, head := chain(4)
+			result := audit.Verify(test.tamper(events, head))
+			assert.False(t, result.Intact)
+			if assert.NotNil(t, result.Broken) {
+				assert.Equal(t, test.seq, result.Broken.Seq)
+				assert.Equal(t, test.reason, result.Broken.Reason)
+			}
+		})
+	}
+}
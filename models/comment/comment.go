@@ -0,0 +1,116 @@
+package comment
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+
+	"sema/models/delta"
+)
+
+// MaxBodyLength caps comments and replies, in bytes
+const MaxBodyLength = 10000
+
+// Anchor is the range of a subsection's content a thread is about, in
+// Quill's positions
+type Anchor struct {
+	Index  int `json:"index"`
+	Length int `json:"length"`
+}
+
+// Transform moves the anchor over an edit so it covers the same text. Text
+// typed at either end is left out, and an anchor whose text is deleted
+// collapses to where it was.
+func (a Anchor) Transform(ops delta.DeltaOps) Anchor {
+	start := ops.TransformPosition(a.Index, false)
+	end := ops.TransformPosition(a.Index+a.Length, true)
+	if end < start {
+		end = start
+	}
+	return Anchor{Index: start, Length: end - start}
+}
+
+// Thread is a comment on a range of a subsection with the replies to it.
+// Mentions are the emails @mentioned in the body.
+type Thread struct {
+	ID           string     `json:"id"`
+	ReportID     string     `json:"reportID"`
+	SubsectionID string     `json:"subsectionID"`
+	Anchor       Anchor     `json:"anchor"`
+	Author       string     `json:"author"`
+	Body         string     `json:"body"`
+	Mentions     []string   `json:"mentions"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	Replies      []Reply    `json:"replies"`
+	Resolved     bool       `json:"resolved"`
+	ResolvedBy   string     `json:"resolvedBy,omitempty"`
+	ResolvedAt   *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Reply is an answer in a thread
+type Reply struct {
+	ID        string    `json:"id"`
+	Author    string    `json:"author"`
+	Body      string    `json:"body"`
+	Mentions  []string  `json:"mentions"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NewThread starts an open thread. The repository gives it its ID.
+func NewThread(reportID, subsectionID string, anchor Anchor, author, body string, now time.Time) Thread {
+	return Thread{
+		ReportID:     reportID,
+		SubsectionID: subsectionID,
+		Anchor:       anchor,
+		Author:       author,
+		Body:         body,
+		Mentions:     Mentions(body),
+		CreatedAt:    now,
+		Replies:      []Reply{},
+	}
+}
+
+// NewReply creates a reply with a fresh ID
+func NewReply(author, body string, now time.Time) Reply {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return Reply{ID: hex.EncodeToString(b), Author: author, Body: body, Mentions: Mentions(body), CreatedAt: now}
+}
+
+// SetResolved resolves or reopens the thread
+func (t *Thread) SetResolved(resolved bool, by string, now time.Time) {
+	t.Resolved = resolved
+	if resolved {
+		t.ResolvedBy = by
+		t.ResolvedAt = &now
+	} else {
+		t.ResolvedBy = ""
+		t.ResolvedAt = nil
+	}
+}
+
+// ValidBody rejects blank and overlong comments
+func ValidBody(body string) bool {
+	return strings.TrimSpace(body) != "" && len(body) <= MaxBodyLength
+}
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w.@])@([\w.%+-]+@[\w-]+(?:\.[\w-]+)+)`)
+
+// Mentions returns the emails written as @email in a body, lowercased, once
+// each and in order
+func Mentions(body string) []string {
+	mentions := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		email := strings.ToLower(match[1])
+		if !seen[email] {
+			seen[email] = true
+			mentions = append(mentions, email)
+		}
+	}
+	return mentions
+}
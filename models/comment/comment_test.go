@@ -0,0 +1,72 @@
+package comment_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/comment"
+	"sema/models/delta"
+)
+
+func ops(t *testing.T, raw string) delta.DeltaOps {
+	var d delta.DeltaOps
+	assert.NoError(t, json.Unmarshal([]byte(raw), &d))
+	return d
+}
+
+func TestAnchorTransform(t *testing.T) {
+	// "Hello world", the anchor is on "world"
+	anchor := comment.Anchor{Index: 6, Length: 5}
+
+	// Text before the range moves it
+	assert.Equal(t, comment.Anchor{Index: 9, Length: 5}, anchor.Transform(ops(t, `{"ops":[{"insert":"Oh "}]}`)))
+	assert.Equal(t, comment.Anchor{Index: 4, Length: 5}, anchor.Transform(ops(t, `{"ops":[{"delete":2}]}`)))
+
+	// Text typed at either end stays outside, inside it grows the range
+	assert.Equal(t, comment.Anchor{Index: 7, Length: 5}, anchor.Transform(ops(t, `{"ops":[{"retain":6},{"insert":"!"}]}`)))
+	assert.Equal(t, comment.Anchor{Index: 6, Length: 5}, anchor.Transform(ops(t, `{"ops":[{"retain":11},{"insert":"!"}]}`)))
+	assert.Equal(t, comment.Anchor{Index: 6, Length: 7}, anchor.Transform(ops(t, `{"ops":[{"retain":8},{"insert":"!!"}]}`)))
+
+	// Deleting part of the range shrinks it, deleting all of it collapses it
+	assert.Equal(t, comment.Anchor{Index: 5, Length: 3}, anchor.Transform(ops(t, `{"ops":[{"retain":5},{"delete":3}]}`)))
+	assert.Equal(t, comment.Anchor{Index: 4, Length: 0}, anchor.Transform(ops(t, `{"ops":[{"retain":4},{"delete":7}]}`)))
+
+	// Formatting leaves it alone
+	assert.Equal(t, anchor, anchor.Transform(ops(t, `{"ops":[{"retain":11,"attributes":{"bold":true}}]}`)))
+}
+
+func TestMentions(t *testing.T) {
+	body := "@Alice@example.com and @bob@example.org, see mail@example.com and @alice@example.com again"
+	assert.Equal(t, []string{"alice@example.com", "bob@example.org"}, comment.Mentions(body))
+	assert.Empty(t, comment.Mentions("no one here"))
+
+	thread := comment.NewThread("report1", "sub1", comment.Anchor{}, "carol@example.com", "cc @bob@example.org", time.Now())
+	assert.Equal(t, []string{"bob@example.org"}, thread.Mentions)
+	reply := comment.NewReply("bob@example.org", "thanks @carol@example.com", time.Now())
+	assert.Equal(t, []string{"carol@example.com"}, reply.Mentions)
+	assert.NotEqual(t, reply.ID, comment.NewReply("bob@example.org", "again", time.Now()).ID)
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Now()
+	thread := comment.NewThread("report1", "sub1", comment.Anchor{}, "carol@example.com", "Typo", now)
+
+	thread.SetResolved(true, "bob@example.org", now)
+	assert.True(t, thread.Resolved)
+	assert.Equal(t, "bob@example.org", thread.ResolvedBy)
+	assert.Equal(t, now, *thread.ResolvedAt)
+
+	thread.SetResolved(false, "carol@example.com", now)
+	assert.False(t, thread.Resolved)
+	assert.Empty(t, thread.ResolvedBy)
+	assert.Nil(t, thread.ResolvedAt)
+}
+
+func TestValidBody(t *testing.T) {
+	assert.True(t, comment.ValidBody("Looks good"))
+	assert.False(t, comment.ValidBody("  \n"))
+	assert.False(t, comment.ValidBody(strings.Repeat("a", comment.MaxBodyLength+1)))
+}
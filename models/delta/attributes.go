@@ -0,0 +1,159 @@
+package delta
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// knownFormats are the formats Attributes has a field for, by JSON name
+var knownFormats = func() map[string]bool {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(Attributes{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	return known
+}()
+
+// MarshalJSON writes the set formats, the other formats as they were read
+// and a null for every removed format.
+func (a Attributes) MarshalJSON() ([]byte, error) {
+	type plain Attributes
+	data, err := json.Marshal(plain(a))
+	if err != nil || len(a.Removed) == 0 && len(a.Other) == 0 {
+		return data, err
+	}
+
+	var formats map[string]interface{}
+	if err := json.Unmarshal(data, &formats); err != nil {
+		return nil, err
+	}
+	for name, value := range a.Other {
+		if !knownFormats[name] {
+			formats[name] = value
+		}
+	}
+	for _, name := range a.Removed {
+		if _, ok := formats[name]; !ok {
+			formats[name] = nil
+		}
+	}
+	return json.Marshal(formats)
+}
+
+// UnmarshalJSON reads the known formats, keeps the others and remembers
+// which ones were null.
+func (a *Attributes) UnmarshalJSON(data []byte) error {
+	type plain Attributes
+	var attributes plain
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for name, value := range raw {
+		if string(value) == "null" {
+			attributes.Removed = append(attributes.Removed, name)
+			continue
+		}
+		if knownFormats[name] {
+			continue
+		}
+		var other interface{}
+		if err := json.Unmarshal(value, &other); err != nil {
+			return err
+		}
+		if attributes.Other == nil {
+			attributes.Other = make(map[string]interface{})
+		}
+		attributes.Other[name] = other
+	}
+	sort.Strings(attributes.Removed)
+
+	*a = Attributes(attributes)
+	return nil
+}
+
+// attributeMap converts attributes to a format name -> value map, where a nil
+// value means the format is removed. The delta algebra works on these maps.
+func attributeMap(a *Attributes) map[string]interface{} {
+	if a == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil
+	}
+
+	var formats map[string]interface{}
+	if err := json.Unmarshal(data, &formats); err != nil || len(formats) == 0 {
+		return nil
+	}
+	return formats
+}
+
+func attributesFromMap(formats map[string]interface{}) *Attributes {
+	if len(formats) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(formats)
+	if err != nil {
+		return nil
+	}
+
+	var attributes Attributes
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil
+	}
+	return &attributes
+}
+
+func attributesEqual(a, b *Attributes) bool {
+	return reflect.DeepEqual(attributeMap(a), attributeMap(b))
+}
+
+// composeAttributes applies the formats in b on top of a. Removals are only
+// kept when the result is a retain, since an insert has nothing to clear.
+func composeAttributes(a, b *Attributes, keepNull bool) *Attributes {
+	formats := make(map[string]interface{})
+	bFormats := attributeMap(b)
+	for name, value := range bFormats {
+		if value == nil && !keepNull {
+			continue
+		}
+		formats[name] = value
+	}
+	for name, value := range attributeMap(a) {
+		if _, ok := bFormats[name]; !ok {
+			formats[name] = value
+		}
+	}
+	return attributesFromMap(formats)
+}
+
+// transformAttributes returns the formats of b that still apply after a. When
+// a has priority, its formats win over the ones in b.
+func transformAttributes(a, b *Attributes, priority bool) *Attributes {
+	if a == nil || !priority {
+		return b
+	}
+
+	formats := make(map[string]interface{})
+	aFormats := attributeMap(a)
+	for name, value := range attributeMap(b) {
+		if _, ok := aFormats[name]; !ok {
+			formats[name] = value
+		}
+	}
+	return attributesFromMap(formats)
+}
@@ -13,6 +13,7 @@ type Delta struct {
 // DeltaData represents the data for the delta, including the editorId and the actual delta operations.
 type DeltaData struct {
     EditorId string   `json:"editorId"` // The ID of the editor that the delta is related to
+    Revision int      `json:"revision"` // The server revision the delta is based on, or produced once applied
     Delta    DeltaOps `json:"delta"`    // The delta operations to be applied
 }
 
@@ -34,12 +35,20 @@ type DeltaOp struct {
 type Attributes struct {
     Bold   *bool  `json:"bold,omitempty"`   // Bold text
     Italic *bool  `json:"italic,omitempty"` // Italic text
-		List  *string `json:"list"` 	// Lists
+		List  *string `json:"list,omitempty"` 	// Lists
     Underline *bool  `json:"underline,omitempty"` // Underline text
     Link   *string `json:"link,omitempty"`   // Hyperlink
+    Header *int    `json:"header,omitempty"` // Heading level, set on the line's newline
     // Color  *string `json:"color,omitempty"`  // Text color
     // Font   *string `json:"font,omitempty"`   // Font type
     // Size   *string `json:"size,omitempty"`   // Font size
+
+    // Removed holds formats sent as null, which Quill uses to clear a format.
+    // They are written back as null by MarshalJSON.
+    Removed []string `json:"-"`
+    // Other holds the formats without a field above, such as align, color or
+    // code-block, as they were sent, so they are kept and written back.
+    Other map[string]interface{} `json:"-"`
 }
 
 /* ImageEmbed represents an embedded image in the Quill delta. */
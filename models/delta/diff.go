@@ -0,0 +1,302 @@
+package delta
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"unicode/utf16"
+)
+
+// maxDiffEdits bounds the work done by Diff. Documents further apart than
+// this are diffed as one replacement of everything between their common
+// prefix and suffix.
+const maxDiffEdits = 2000
+
+// DiffRun is a stretch of a diff between two documents. Runs of type "equal",
+// "insert" and "delete" carry the formatting they have in the version they
+// come from; "format" runs are unchanged text whose formatting changed.
+type DiffRun struct {
+	Type       string          `json:"type"`
+	Text       string          `json:"text,omitempty"`
+	Embed      json.RawMessage `json:"embed,omitempty"`
+	Attributes *Attributes     `json:"attributes,omitempty"`
+	Changes    *Attributes     `json:"changes,omitempty"`
+}
+
+// diffToken is one character (or embed) of a document, compared by value
+type diffToken struct {
+	char  rune
+	embed string
+	units int // UTF-16 length
+}
+
+type diffChunk struct {
+	kind   opType
+	length int // UTF-16 length
+}
+
+// Diff returns the delta that turns the document d into other, the same way
+// quill-delta's diff does. Both deltas must be documents made of inserts.
+func (d DeltaOps) Diff(other DeltaOps) (DeltaOps, error) {
+	a, err := diffTokens(d)
+	if err != nil {
+		return DeltaOps{}, err
+	}
+	b, err := diffTokens(other)
+	if err != nil {
+		return DeltaOps{}, err
+	}
+
+	result := DeltaOps{}
+	thisIter := newOpIterator(d.Ops)
+	otherIter := newOpIterator(other.Ops)
+	for _, chunk := range diffChunks(a, b) {
+		length := chunk.length
+		for length > 0 {
+			var opLength int
+			switch chunk.kind {
+			case opInsert:
+				opLength = min(otherIter.peekLength(), length)
+				result.push(otherIter.next(opLength))
+			case opDelete:
+				opLength = min(thisIter.peekLength(), length)
+				thisIter.next(opLength)
+				result.push(DeltaOp{Delete: opLength})
+			default:
+				opLength = min(thisIter.peekLength(), otherIter.peekLength(), length)
+				thisOp := thisIter.next(opLength)
+				otherOp := otherIter.next(opLength)
+				result.push(DeltaOp{Retain: opLength, Attributes: diffAttributes(thisOp.Attributes, otherOp.Attributes)})
+			}
+			length -= opLength
+		}
+	}
+	return result.chop(), nil
+}
+
+// DiffRuns splits the document d and a diff made by d.Diff into runs of
+// unchanged, inserted, deleted and reformatted content, in reading order.
+func (d DeltaOps) DiffRuns(diff DeltaOps) []DiffRun {
+	var runs []DiffRun
+	thisIter := newOpIterator(d.Ops)
+
+	for _, op := range diff.Ops {
+		if op.IsInsert() {
+			runs = appendDiffRun(runs, "insert", op, nil)
+			continue
+		}
+
+		length := op.Length()
+		for length > 0 && thisIter.hasNext() {
+			piece := thisIter.next(length)
+			length -= piece.Length()
+			switch {
+			case op.IsDelete():
+				runs = appendDiffRun(runs, "delete", piece, nil)
+			case op.Attributes != nil:
+				piece.Attributes = composeAttributes(piece.Attributes, op.Attributes, false)
+				runs = appendDiffRun(runs, "format", piece, op.Attributes)
+			default:
+				runs = appendDiffRun(runs, "equal", piece, nil)
+			}
+		}
+	}
+
+	// The diff leaves out the unchanged end of the document
+	for thisIter.hasNext() {
+		runs = appendDiffRun(runs, "equal", thisIter.next(infinity), nil)
+	}
+	return runs
+}
+
+// appendDiffRun adds an insert op as a run, merging it into the previous run
+// when both are text of the same type and formatting.
+func appendDiffRun(runs []DiffRun, runType string, op DeltaOp, changes *Attributes) []DiffRun {
+	text, isText := op.Text()
+	if isText && len(runs) > 0 {
+		last := &runs[len(runs)-1]
+		if last.Type == runType && last.Embed == nil &&
+			attributesEqual(last.Attributes, op.Attributes) && attributesEqual(last.Changes, changes) {
+			last.Text += text
+			return runs
+		}
+	}
+
+	run := DiffRun{Type: runType, Attributes: op.Attributes, Changes: changes}
+	if isText {
+		run.Text = text
+	} else {
+		run.Embed = op.Insert
+	}
+	return append(runs, run)
+}
+
+// diffAttributes returns the formats that change going from a to b, with a
+// null for every format b no longer has.
+func diffAttributes(a, b *Attributes) *Attributes {
+	aFormats := attributeMap(a)
+	bFormats := attributeMap(b)
+
+	formats := make(map[string]interface{})
+	for name, value := range bFormats {
+		if !reflect.DeepEqual(aFormats[name], value) {
+			formats[name] = value
+		}
+	}
+	for name := range aFormats {
+		if _, ok := bFormats[name]; !ok {
+			formats[name] = nil
+		}
+	}
+	return attributesFromMap(formats)
+}
+
+func diffTokens(d DeltaOps) ([]diffToken, error) {
+	var tokens []diffToken
+	for _, op := range d.Ops {
+		if !op.IsInsert() {
+			return nil, fmt.Errorf("diff needs a document of inserts, found a retain or delete")
+		}
+		text, isText := op.Text()
+		if !isText {
+			tokens = append(tokens, diffToken{embed: string(op.Insert), units: 1})
+			continue
+		}
+		for _, char := range text {
+			tokens = append(tokens, diffToken{char: char, units: len(utf16.Encode([]rune{char}))})
+		}
+	}
+	return tokens, nil
+}
+
+// diffChunks finds the shortest edit script from a to b with Myers' algorithm
+// and returns it as equal, insert and delete chunks measured in UTF-16 units.
+func diffChunks(a, b []diffToken) []diffChunk {
+	var chunks []diffChunk
+	add := func(kind opType, tokens []diffToken) {
+		length := 0
+		for _, token := range tokens {
+			length += token.units
+		}
+		if length == 0 {
+			return
+		}
+		if len(chunks) > 0 && chunks[len(chunks)-1].kind == kind {
+			chunks[len(chunks)-1].length += length
+			return
+		}
+		chunks = append(chunks, diffChunk{kind, length})
+	}
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	add(opRetain, a[:prefix])
+	middleA := a[prefix : len(a)-suffix]
+	middleB := b[prefix : len(b)-suffix]
+
+	edits, ok := myersEdits(middleA, middleB)
+	if !ok {
+		add(opDelete, middleA)
+		add(opInsert, middleB)
+	} else {
+		x, y := 0, 0
+		for _, kind := range edits {
+			switch kind {
+			case opInsert:
+				add(opInsert, middleB[y:y+1])
+				y++
+			case opDelete:
+				add(opDelete, middleA[x:x+1])
+				x++
+			default:
+				add(opRetain, middleA[x:x+1])
+				x++
+				y++
+			}
+		}
+	}
+
+	add(opRetain, a[len(a)-suffix:])
+	return chunks
+}
+
+// myersEdits returns the edit script from a to b one token at a time, or false
+// when it needs more than maxDiffEdits insertions and deletions.
+func myersEdits(a, b []diffToken) ([]opType, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] holds v[-d-1 .. d+1] as it was before step d
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk back from the end to recover the path
+	var edits []opType
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, opRetain)
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, opInsert)
+			} else {
+				edits = append(edits, opDelete)
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits, true
+}
@@ -0,0 +1,63 @@
+package delta_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		expected string
+	}{
+		{"unchanged", `[{"insert":"Same\n"}]`, `[{"insert":"Same\n"}]`, `[]`},
+		{"insert", `[{"insert":"Hello\n"}]`, `[{"insert":"Hello world\n"}]`, `[{"retain":5},{"insert":" world"}]`},
+		{"delete", `[{"insert":"Hello world\n"}]`, `[{"insert":"Hello\n"}]`, `[{"retain":5},{"delete":6}]`},
+		{"replace", `[{"insert":"cat\n"}]`, `[{"insert":"cut\n"}]`, `[{"retain":1},{"insert":"u"},{"delete":1}]`},
+		{"format", `[{"insert":"Bold\n"}]`, `[{"insert":"Bold","attributes":{"bold":true}},{"insert":"\n"}]`, `[{"retain":4,"attributes":{"bold":true}}]`},
+		{"unformat", `[{"insert":"Bold","attributes":{"bold":true}},{"insert":"\n"}]`, `[{"insert":"Bold\n"}]`, `[{"retain":4,"attributes":{"bold":null}}]`},
+		{"embed", `[{"insert":{"image":"a.png"}},{"insert":"\n"}]`, `[{"insert":{"image":"b.png"}},{"insert":"\n"}]`, `[{"insert":{"image":"b.png"}},{"delete":1}]`},
+		{"astral", `[{"insert":"a😀b\n"}]`, `[{"insert":"a😀c\n"}]`, `[{"retain":3},{"insert":"c"},{"delete":1}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := parseOps(t, tt.from)
+			to := parseOps(t, tt.to)
+
+			diff, err := from.Diff(to)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expected, opsJSON(t, diff))
+
+			// Applying the diff always gives the new document
+			assert.JSONEq(t, opsJSON(t, to), opsJSON(t, from.Compose(diff)))
+		})
+	}
+}
+
+func TestDiffNeedsDocuments(t *testing.T) {
+	_, err := parseOps(t, `[{"insert":"a\n"}]`).Diff(parseOps(t, `[{"retain":1}]`))
+	assert.Error(t, err)
+}
+
+func TestDiffRuns(t *testing.T) {
+	from := parseOps(t, `[{"insert":"The old text"},{"insert":" stays\n"}]`)
+	to := parseOps(t, `[{"insert":"The new text","attributes":{"bold":true}},{"insert":" stays\n"}]`)
+
+	diff, err := from.Diff(to)
+	assert.NoError(t, err)
+	runs := from.DiffRuns(diff)
+
+	var types, texts []string
+	for _, run := range runs {
+		types = append(types, run.Type)
+		texts = append(texts, run.Text)
+	}
+	assert.Equal(t, []string{"format", "insert", "delete", "format", "equal"}, types)
+	assert.Equal(t, []string{"The ", "new", "old", " text", " stays\n"}, texts)
+	assert.True(t, *runs[0].Changes.Bold)
+	assert.True(t, *runs[1].Attributes.Bold)
+	assert.Nil(t, runs[2].Attributes)
+}
@@ -0,0 +1,15 @@
+package delta
+
+import "net/url"
+
+// linkSchemes are the schemes links are kept for. "ref" is a cross-reference
+// to a section of the report.
+var linkSchemes = map[string]bool{"http": true, "https": true, "mailto": true, "ref": true}
+
+// SafeLink reports whether a link may be written out, so scripts such as
+// javascript: links never end up in redlines or exports. Anyone who can edit
+// a section can set any link.
+func SafeLink(link string) bool {
+	parsed, err := url.Parse(link)
+	return err == nil && linkSchemes[parsed.Scheme]
+}
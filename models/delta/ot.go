@@ -0,0 +1,345 @@
+package delta
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"unicode/utf16"
+)
+
+// The delta algebra below follows the quill-delta library so the server and
+// the browser editors agree on how concurrent edits are merged. Lengths are
+// counted in UTF-16 code units like JavaScript strings, and embeds count as 1.
+
+// IsInsert reports whether the op inserts text or an embed
+func (op DeltaOp) IsInsert() bool {
+	return len(op.Insert) > 0
+}
+
+// IsDelete reports whether the op deletes characters
+func (op DeltaOp) IsDelete() bool {
+	return op.Delete > 0
+}
+
+// IsRetain reports whether the op keeps (and possibly formats) characters
+func (op DeltaOp) IsRetain() bool {
+	return !op.IsInsert() && !op.IsDelete()
+}
+
+// Text returns the inserted string, or false when the op is not a text insert
+func (op DeltaOp) Text() (string, bool) {
+	if !op.IsInsert() || op.Insert[0] != '"' {
+		return "", false
+	}
+	var text string
+	if err := json.Unmarshal(op.Insert, &text); err != nil {
+		return "", false
+	}
+	return text, true
+}
+
+// Length returns the number of characters the op covers
+func (op DeltaOp) Length() int {
+	switch {
+	case op.IsDelete():
+		return op.Delete
+	case op.IsInsert():
+		if text, ok := op.Text(); ok {
+			return len(utf16.Encode([]rune(text)))
+		}
+		return 1 // Embeds such as images
+	default:
+		return op.Retain
+	}
+}
+
+// Length returns the length of the document a delta made of inserts describes
+func (d DeltaOps) Length() int {
+	length := 0
+	for _, op := range d.Ops {
+		if op.IsInsert() {
+			length += op.Length()
+		}
+	}
+	return length
+}
+
+// BaseLength returns the length of the document a delta applies to, that is
+// what it retains and deletes. Composing it onto a shorter document leaves
+// retains and deletes past the end.
+func (d DeltaOps) BaseLength() int {
+	length := 0
+	for _, op := range d.Ops {
+		if !op.IsInsert() {
+			length += op.Length()
+		}
+	}
+	return length
+}
+
+// EndsWithNewline reports whether a document ends in a line break, as Quill
+// documents always do
+func (d DeltaOps) EndsWithNewline() bool {
+	if len(d.Ops) == 0 {
+		return false
+	}
+	text, ok := d.Ops[len(d.Ops)-1].Text()
+	return ok && len(text) > 0 && text[len(text)-1] == '\n'
+}
+
+// Compose returns a single delta that has the same effect as applying d and then other
+func (d DeltaOps) Compose(other DeltaOps) DeltaOps {
+	thisIter := newOpIterator(d.Ops)
+	otherIter := newOpIterator(other.Ops)
+	var result DeltaOps
+
+	// Inserts at the start of d are untouched by a leading plain retain in other
+	if firstOther, ok := otherIter.peek(); ok && firstOther.IsRetain() && firstOther.Attributes == nil {
+		firstLeft := firstOther.Retain
+		for thisIter.peekType() == opInsert && thisIter.peekLength() <= firstLeft {
+			firstLeft -= thisIter.peekLength()
+			result.push(thisIter.next(infinity))
+		}
+		if firstOther.Retain-firstLeft > 0 {
+			otherIter.next(firstOther.Retain - firstLeft)
+		}
+	}
+
+	for thisIter.hasNext() || otherIter.hasNext() {
+		if otherIter.peekType() == opInsert {
+			result.push(otherIter.next(infinity))
+		} else if thisIter.peekType() == opDelete {
+			result.push(thisIter.next(infinity))
+		} else {
+			length := min(thisIter.peekLength(), otherIter.peekLength())
+			thisOp := thisIter.next(length)
+			otherOp := otherIter.next(length)
+
+			if otherOp.IsRetain() {
+				var newOp DeltaOp
+				if thisOp.IsRetain() {
+					newOp.Retain = length
+				} else {
+					newOp.Insert = thisOp.Insert
+				}
+				newOp.Attributes = composeAttributes(thisOp.Attributes, otherOp.Attributes, thisOp.IsRetain())
+				result.push(newOp)
+			} else if otherOp.IsDelete() && thisOp.IsRetain() {
+				result.push(otherOp)
+			}
+			// Otherwise an insert in d is deleted by other and both cancel out
+		}
+	}
+
+	return result.chop()
+}
+
+// Transform rewrites other, which was made concurrently with d, so that it can
+// be applied after d. When priority is true d is treated as happening first,
+// so inserts at the same position are placed before the ones in other.
+func (d DeltaOps) Transform(other DeltaOps, priority bool) DeltaOps {
+	thisIter := newOpIterator(d.Ops)
+	otherIter := newOpIterator(other.Ops)
+	var result DeltaOps
+
+	for thisIter.hasNext() || otherIter.hasNext() {
+		if thisIter.peekType() == opInsert && (priority || otherIter.peekType() != opInsert) {
+			result.push(DeltaOp{Retain: thisIter.next(infinity).Length()})
+		} else if otherIter.peekType() == opInsert {
+			result.push(otherIter.next(infinity))
+		} else {
+			length := min(thisIter.peekLength(), otherIter.peekLength())
+			thisOp := thisIter.next(length)
+			otherOp := otherIter.next(length)
+
+			if thisOp.IsDelete() {
+				// Our delete makes their delete or retain redundant
+				continue
+			} else if otherOp.IsDelete() {
+				result.push(otherOp)
+			} else {
+				result.push(DeltaOp{
+					Retain:     length,
+					Attributes: transformAttributes(thisOp.Attributes, otherOp.Attributes, priority),
+				})
+			}
+		}
+	}
+
+	return result.chop()
+}
+
+// TransformPosition moves a cursor index so it points at the same character
+// after d is applied.
+func (d DeltaOps) TransformPosition(index int, priority bool) int {
+	iter := newOpIterator(d.Ops)
+	offset := 0
+
+	for iter.hasNext() && offset <= index {
+		length := iter.peekLength()
+		nextType := iter.peekType()
+		iter.next(infinity)
+
+		if nextType == opDelete {
+			index -= min(length, index-offset)
+			continue
+		} else if nextType == opInsert && (offset < index || !priority) {
+			index += length
+		}
+		offset += length
+	}
+
+	return index
+}
+
+// push appends an op, merging it into the previous one where possible
+func (d *DeltaOps) push(newOp DeltaOp) {
+	if newOp.Length() == 0 && !newOp.IsInsert() {
+		return
+	}
+
+	index := len(d.Ops)
+	if index > 0 {
+		lastOp := &d.Ops[index-1]
+
+		if newOp.IsDelete() && lastOp.IsDelete() {
+			lastOp.Delete += newOp.Delete
+			return
+		}
+
+		// Inserts always go before deletes at the same position
+		if lastOp.IsDelete() && newOp.IsInsert() {
+			index--
+			if index == 0 {
+				d.Ops = append([]DeltaOp{newOp}, d.Ops...)
+				return
+			}
+			lastOp = &d.Ops[index-1]
+		}
+
+		if attributesEqual(newOp.Attributes, lastOp.Attributes) {
+			lastText, lastIsText := lastOp.Text()
+			newText, newIsText := newOp.Text()
+			if lastIsText && newIsText {
+				lastOp.Insert = encodeText(lastText + newText)
+				return
+			} else if lastOp.IsRetain() && newOp.IsRetain() {
+				lastOp.Retain += newOp.Retain
+				return
+			}
+		}
+	}
+
+	d.Ops = append(d.Ops, DeltaOp{})
+	copy(d.Ops[index+1:], d.Ops[index:])
+	d.Ops[index] = newOp
+}
+
+// chop drops a trailing plain retain, which has no effect
+func (d DeltaOps) chop() DeltaOps {
+	if n := len(d.Ops); n > 0 {
+		last := d.Ops[n-1]
+		if last.IsRetain() && last.Attributes == nil {
+			d.Ops = d.Ops[:n-1]
+		}
+	}
+	if d.Ops == nil {
+		d.Ops = []DeltaOp{}
+	}
+	return d
+}
+
+type opType int
+
+const (
+	opRetain opType = iota
+	opInsert
+	opDelete
+)
+
+const infinity = math.MaxInt
+
+// opIterator walks a list of ops, handing out pieces of a given length
+type opIterator struct {
+	ops    []DeltaOp
+	index  int
+	offset int
+}
+
+func newOpIterator(ops []DeltaOp) *opIterator {
+	return &opIterator{ops: ops}
+}
+
+func (it *opIterator) hasNext() bool {
+	return it.peekLength() < infinity
+}
+
+func (it *opIterator) peek() (DeltaOp, bool) {
+	if it.index >= len(it.ops) {
+		return DeltaOp{}, false
+	}
+	return it.ops[it.index], true
+}
+
+func (it *opIterator) peekLength() int {
+	op, ok := it.peek()
+	if !ok {
+		return infinity
+	}
+	return op.Length() - it.offset
+}
+
+func (it *opIterator) peekType() opType {
+	op, ok := it.peek()
+	switch {
+	case !ok:
+		return opRetain
+	case op.IsDelete():
+		return opDelete
+	case op.IsInsert():
+		return opInsert
+	default:
+		return opRetain
+	}
+}
+
+func (it *opIterator) next(length int) DeltaOp {
+	op, ok := it.peek()
+	if !ok {
+		return DeltaOp{Retain: infinity}
+	}
+
+	offset := it.offset
+	opLength := op.Length()
+	if length >= opLength-offset {
+		length = opLength - offset
+		it.index++
+		it.offset = 0
+	} else {
+		it.offset += length
+	}
+
+	if op.IsDelete() {
+		return DeltaOp{Delete: length}
+	}
+
+	piece := DeltaOp{Attributes: op.Attributes}
+	if op.IsRetain() {
+		piece.Retain = length
+	} else if text, isText := op.Text(); isText {
+		units := utf16.Encode([]rune(text))
+		piece.Insert = encodeText(string(utf16.Decode(units[offset : offset+length])))
+	} else {
+		piece.Insert = op.Insert
+	}
+	return piece
+}
+
+// encodeText encodes an insert string without escaping HTML characters
+func encodeText(text string) json.RawMessage {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(text)
+	return json.RawMessage(bytes.TrimRight(buf.Bytes(), "\n"))
+}
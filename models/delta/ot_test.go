@@ -0,0 +1,136 @@
+package delta_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/delta"
+)
+
+func parseOps(t *testing.T, raw string) delta.DeltaOps {
+	var ops delta.DeltaOps
+	if err := json.Unmarshal([]byte(`{"ops":`+raw+`}`), &ops); err != nil {
+		t.Fatalf("invalid ops %s: %v", raw, err)
+	}
+	return ops
+}
+
+func opsJSON(t *testing.T, ops delta.DeltaOps) string {
+	data, err := json.Marshal(ops.Ops)
+	if err != nil {
+		t.Fatalf("failed to marshal ops: %v", err)
+	}
+	return string(data)
+}
+
+func TestCompose(t *testing.T) {
+	doc := parseOps(t, `[{"insert":"Hello world\n"}]`)
+
+	composed := doc.Compose(parseOps(t, `[{"retain":6},{"delete":5},{"insert":"SEMA"}]`))
+	assert.JSONEq(t, `[{"insert":"Hello SEMA\n"}]`, opsJSON(t, composed))
+
+	composed = composed.Compose(parseOps(t, `[{"retain":5,"attributes":{"bold":true}}]`))
+	assert.JSONEq(t, `[{"insert":"Hello","attributes":{"bold":true}},{"insert":" SEMA\n"}]`, opsJSON(t, composed))
+
+	// Removing a format from an insert drops it instead of storing a null
+	composed = composed.Compose(parseOps(t, `[{"retain":5,"attributes":{"bold":null}}]`))
+	assert.JSONEq(t, `[{"insert":"Hello SEMA\n"}]`, opsJSON(t, composed))
+}
+
+func TestComposeRetainsKeepRemovals(t *testing.T) {
+	a := parseOps(t, `[{"retain":3,"attributes":{"bold":true}}]`)
+	b := parseOps(t, `[{"retain":3,"attributes":{"bold":null,"italic":true}}]`)
+
+	composed := a.Compose(b)
+	assert.JSONEq(t, `[{"retain":3,"attributes":{"bold":null,"italic":true}}]`, opsJSON(t, composed))
+}
+
+func TestTransformConverges(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		a    string
+		b    string
+	}{
+		{"same position inserts", `[{"insert":"abc\n"}]`, `[{"retain":1},{"insert":"X"}]`, `[{"retain":1},{"insert":"Y"}]`},
+		{"insert inside delete", `[{"insert":"abcdef\n"}]`, `[{"retain":1},{"delete":4}]`, `[{"retain":3},{"insert":"Z"}]`},
+		{"overlapping deletes", `[{"insert":"abcdef\n"}]`, `[{"retain":1},{"delete":3}]`, `[{"retain":2},{"delete":3}]`},
+		{"format and delete", `[{"insert":"abcdef\n"}]`, `[{"retain":4,"attributes":{"italic":true}}]`, `[{"delete":2}]`},
+		{"conflicting formats", `[{"insert":"abc\n"}]`, `[{"retain":3,"attributes":{"bold":true}}]`, `[{"retain":3,"attributes":{"bold":null}}]`},
+		{"surrogate pairs", `[{"insert":"a😀b\n"}]`, `[{"retain":3},{"insert":"!"}]`, `[{"retain":1},{"delete":2}]`},
+		{"embeds", `[{"insert":"a"},{"insert":{"image":"x.png"}},{"insert":"b\n"}]`, `[{"retain":1},{"delete":1}]`, `[{"retain":2},{"insert":"c"}]`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := parseOps(t, tc.doc)
+			a := parseOps(t, tc.a)
+			b := parseOps(t, tc.b)
+
+			// a reached the server first, b is transformed against it and vice versa
+			left := doc.Compose(a).Compose(a.Transform(b, true))
+			right := doc.Compose(b).Compose(b.Transform(a, false))
+			assert.JSONEq(t, opsJSON(t, left), opsJSON(t, right))
+		})
+	}
+}
+
+func TestTransformPriority(t *testing.T) {
+	a := parseOps(t, `[{"insert":"A"}]`)
+	b := parseOps(t, `[{"insert":"B"}]`)
+
+	assert.JSONEq(t, `[{"retain":1},{"insert":"B"}]`, opsJSON(t, a.Transform(b, true)))
+	assert.JSONEq(t, `[{"insert":"B"}]`, opsJSON(t, a.Transform(b, false)))
+}
+
+func TestTransformPosition(t *testing.T) {
+	d := parseOps(t, `[{"retain":2},{"insert":"xyz"},{"retain":3},{"delete":2}]`)
+
+	assert.Equal(t, 1, d.TransformPosition(1, false))
+	assert.Equal(t, 5, d.TransformPosition(2, false))
+	assert.Equal(t, 2, d.TransformPosition(2, true))
+	assert.Equal(t, 8, d.TransformPosition(6, false)) // Inside the deleted range
+	assert.Equal(t, 9, d.TransformPosition(8, false))
+}
+
+func TestAttributesNullRoundTrip(t *testing.T) {
+	var attributes delta.Attributes
+	err := json.Unmarshal([]byte(`{"bold":null,"italic":true}`), &attributes)
+	assert.NoError(t, err)
+	assert.Nil(t, attributes.Bold)
+	assert.Equal(t, []string{"bold"}, attributes.Removed)
+
+	data, err := json.Marshal(attributes)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"bold":null,"italic":true}`, string(data))
+}
+
+func TestAttributesKeepOtherFormats(t *testing.T) {
+	var attributes delta.Attributes
+	err := json.Unmarshal([]byte(`{"bold":true,"align":"center","color":"#ff0000","strike":true,"indent":2,"code-block":"plain","font":null}`), &attributes)
+	assert.NoError(t, err)
+	assert.Equal(t, "center", attributes.Other["align"])
+	assert.Equal(t, []string{"font"}, attributes.Removed)
+
+	data, err := json.Marshal(attributes)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"bold":true,"align":"center","color":"#ff0000","strike":true,"indent":2,"code-block":"plain","font":null}`, string(data))
+
+	// and through composing and transforming
+	base := parseOps(t, `[{"insert":"Hello\n"}]`)
+	aligned := parseOps(t, `[{"retain":5},{"retain":1,"attributes":{"align":"right"}}]`)
+	struck := parseOps(t, `[{"retain":5,"attributes":{"strike":true,"color":"blue"}}]`)
+	assert.JSONEq(t, `[{"insert":"Hello","attributes":{"strike":true,"color":"blue"}},{"insert":"\n","attributes":{"align":"right"}}]`, opsJSON(t, base.Compose(aligned).Compose(struck)))
+	assert.JSONEq(t, `[{"retain":5,"attributes":{"strike":true,"color":"blue"}}]`, opsJSON(t, aligned.Transform(struck, true)))
+}
+
+func TestBaseLengthAndNewline(t *testing.T) {
+	assert.Equal(t, 0, parseOps(t, `[{"insert":"Hello\n"}]`).BaseLength())
+	assert.Equal(t, 9, parseOps(t, `[{"retain":4},{"insert":"X"},{"delete":5}]`).BaseLength())
+
+	assert.True(t, parseOps(t, `[{"insert":"Hello"},{"insert":"\n","attributes":{"header":1}}]`).EndsWithNewline())
+	assert.False(t, parseOps(t, `[{"insert":"Hello"}]`).EndsWithNewline())
+	assert.False(t, parseOps(t, `[{"insert":"\n"},{"insert":{"image":"a.png"}}]`).EndsWithNewline())
+	assert.False(t, delta.DeltaOps{}.EndsWithNewline())
+}
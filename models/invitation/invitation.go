@@ -0,0 +1,70 @@
+package invitation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"sema/models/reportRoles"
+)
+
+// Lifetime is how long an invitation can be accepted after it is sent
+const Lifetime = 7 * 24 * time.Hour
+
+// Invitation is a pending invitation to a report for someone without an
+// account. It is accepted when they sign in with the invited email, as long
+// as the email is verified or they came through the invitation link, which
+// carries the token.
+type Invitation struct {
+	ID        string           `json:"id"`
+	ReportID  string           `json:"reportID"`
+	Email     string           `json:"email"`
+	Role      reportRoles.Role `json:"role"`
+	Token     string           `json:"token"`
+	InvitedBy string           `json:"invitedBy"`
+	CreatedAt time.Time        `json:"createdAt"`
+	ExpiresAt time.Time        `json:"expiresAt"`
+}
+
+// New creates an invitation with a fresh token, valid for Lifetime
+func New(reportID, email string, role reportRoles.Role, invitedBy string, now time.Time) Invitation {
+	return Invitation{
+		ReportID:  reportID,
+		Email:     NormalizeEmail(email),
+		Role:      role,
+		Token:     NewToken(),
+		InvitedBy: invitedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(Lifetime),
+	}
+}
+
+// NewToken returns a random token for an invitation link
+func NewToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NormalizeEmail is the form emails are stored and matched in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Expired reports whether an invitation can no longer be accepted
+func (i Invitation) Expired(now time.Time) bool {
+	return !now.Before(i.ExpiresAt)
+}
+
+// AcceptableBy reports whether someone signing in with an email may accept
+// the invitation: the email must match and be verified, or they must have
+// the token from the link.
+func (i Invitation) AcceptableBy(email string, verified bool, token string, now time.Time) bool {
+	if i.Expired(now) || NormalizeEmail(email) != i.Email {
+		return false
+	}
+	return verified || (token != "" && token == i.Token)
+}
@@ -0,0 +1,37 @@
+package invitation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/invitation"
+	"sema/models/reportRoles"
+)
+
+func TestNew(t *testing.T) {
+	now := time.Now()
+	invite := invitation.New("report1", " Evaluator@Example.com ", reportRoles.Viewer, "admin@example.com", now)
+
+	assert.Equal(t, "evaluator@example.com", invite.Email)
+	assert.Len(t, invite.Token, 32)
+	assert.Equal(t, now.Add(invitation.Lifetime), invite.ExpiresAt)
+	assert.NotEqual(t, invite.Token, invitation.New("report1", "other@example.com", reportRoles.Viewer, "", now).Token)
+}
+
+func TestAcceptableBy(t *testing.T) {
+	now := time.Now()
+	invite := invitation.New("report1", "evaluator@example.com", reportRoles.Viewer, "admin@example.com", now)
+
+	assert.True(t, invite.AcceptableBy("Evaluator@example.com", true, "", now))
+	assert.True(t, invite.AcceptableBy("evaluator@example.com", false, invite.Token, now))
+
+	// Unverified emails need the link
+	assert.False(t, invite.AcceptableBy("evaluator@example.com", false, "", now))
+	assert.False(t, invite.AcceptableBy("evaluator@example.com", false, "wrong", now))
+	assert.False(t, invite.AcceptableBy("someone@example.com", true, invite.Token, now))
+
+	later := now.Add(invitation.Lifetime)
+	assert.True(t, invite.Expired(later))
+	assert.False(t, invite.AcceptableBy("evaluator@example.com", true, invite.Token, later))
+}
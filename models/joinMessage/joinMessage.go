@@ -1,8 +0,0 @@
-package joinMessage 
-
-
-type JoinMessage struct {
-	Type string `json:"type"`
-	ReportID string `json:"reportid"`
-	Section string  `json:"section"`
-}
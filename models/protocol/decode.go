@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// maxIDLength keeps message ids, which are echoed back, short
+const maxIDLength = 64
+
+// ClientMessage is a message a client sends: Join, DeltaMessage, Cursor or
+// Close
+type ClientMessage interface {
+	MessageID() string
+	Validate() *Error
+}
+
+// MessageID is the id the client gave the message, if any
+func (e Envelope) MessageID() string {
+	return e.ID
+}
+
+// Decode parses a client message and checks it against its schema. The error
+// is ready to send back.
+func Decode(raw []byte) (ClientMessage, *Error) {
+	var envelope Envelope
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return nil, NewError(CodeInvalidMessage, "", "message is not a JSON object: %v", err)
+	}
+	if len(envelope.ID) > maxIDLength {
+		return nil, NewError(CodeInvalidMessage, "", "id is longer than %d characters", maxIDLength)
+	}
+
+	var message ClientMessage
+	switch envelope.Type {
+	case TypeJoin:
+		message = &Join{}
+	case TypeDelta:
+		message = &DeltaMessage{}
+	case TypeCursor:
+		message = &Cursor{}
+	case TypeClose:
+		message = &Close{}
+	case "":
+		return nil, NewError(CodeInvalidMessage, envelope.ID, "message has no type")
+	default:
+		return nil, NewError(CodeUnknownType, envelope.ID, "unknown message type %q", envelope.Type)
+	}
+
+	if err := json.Unmarshal(raw, message); err != nil {
+		return nil, NewError(CodeInvalidMessage, envelope.ID, "invalid %s message: %v", envelope.Type, err)
+	}
+	if err := message.Validate(); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Validate checks the join asks for a version the server speaks, and that a
+// resume has revisions to resume from
+func (m *Join) Validate() *Error {
+	if m.Version != 0 && m.Version != Version {
+		return NewError(CodeUnsupportedVersion, m.ID, "protocol version %d is not supported, the server speaks version %d", m.Version, Version)
+	}
+	if m.Resume != "" && len(m.Revisions) == 0 {
+		return NewError(CodeInvalidMessage, m.ID, "resume needs the revision of each subsection")
+	}
+	for editorID, revision := range m.Revisions {
+		if revision < 0 {
+			return NewError(CodeInvalidMessage, m.ID, "revision %d of %s is negative", revision, editorID)
+		}
+	}
+	return nil
+}
+
+// Validate checks the delta names an editor and a revision, and that every
+// operation does exactly one thing. Whether the delta fits the document is
+// only known once it is transformed to the current revision, so the hub
+// checks that retains and deletes stay inside the subsection and keep its
+// final line break, and answers CodeOutOfStep otherwise.
+func (m *DeltaMessage) Validate() *Error {
+	if m.Delta.EditorId == "" {
+		return NewError(CodeInvalidMessage, m.ID, "delta has no editorId")
+	}
+	if m.Delta.Revision < 0 {
+		return NewError(CodeInvalidMessage, m.ID, "delta revision %d is negative", m.Delta.Revision)
+	}
+	if len(m.Delta.Delta.Ops) == 0 {
+		return NewError(CodeInvalidMessage, m.ID, "delta has no operations")
+	}
+	for i, op := range m.Delta.Delta.Ops {
+		kinds := 0
+		for _, is := range []bool{op.IsInsert(), op.Retain > 0, op.Delete > 0} {
+			if is {
+				kinds++
+			}
+		}
+		if kinds != 1 || op.Retain < 0 || op.Delete < 0 {
+			return NewError(CodeInvalidMessage, m.ID, "operation %d must insert, retain or delete", i)
+		}
+		// Text is a non-empty string and embeds are objects
+		if op.IsInsert() && (op.Insert[0] != '"' && op.Insert[0] != '{' || op.Length() == 0) {
+			return NewError(CodeInvalidMessage, m.ID, "operation %d inserts nothing", i)
+		}
+	}
+	return nil
+}
+
+// Validate checks the ranges are inside a subsection, which needs one to be
+// focused
+func (m *Cursor) Validate() *Error {
+	if m.Revision < 0 {
+		return NewError(CodeInvalidMessage, m.ID, "cursor revision %d is negative", m.Revision)
+	}
+	if m.EditorId == "" && len(m.Ranges) > 0 {
+		return NewError(CodeInvalidMessage, m.ID, "cursor ranges need an editorId")
+	}
+	for i, r := range m.Ranges {
+		if r.Index < 0 || r.Length < 0 {
+			return NewError(CodeInvalidMessage, m.ID, "range %d is negative", i)
+		}
+	}
+	return nil
+}
+
+// Validate has nothing to check
+func (m *Close) Validate() *Error {
+	return nil
+}
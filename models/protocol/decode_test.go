@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	message, err := Decode([]byte(`{"type":"join","id":"j1","version":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &Join{Envelope: Envelope{Type: TypeJoin, ID: "j1"}, Version: 1}, message)
+
+	// Clients from before versioning send no version
+	message, err = Decode([]byte(`{"type":"join"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "", message.MessageID())
+
+	message, err = Decode([]byte(`{"type":"join","version":1,"resume":"token","revisions":{"e1":3}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"e1": 3}, message.(*Join).Revisions)
+
+	message, err = Decode([]byte(`{"type":"delta","id":"d1","delta":{"editorId":"e1","revision":2,"delta":{"ops":[{"retain":1},{"insert":"Hi"},{"insert":{"image":"x.png"}},{"delete":2}]}}}`))
+	assert.Nil(t, err)
+	delta := message.(*DeltaMessage)
+	assert.Equal(t, "d1", delta.ID)
+	assert.Equal(t, "e1", delta.Delta.EditorId)
+	assert.Equal(t, 2, delta.Delta.Revision)
+	assert.Len(t, delta.Delta.Delta.Ops, 4)
+
+	message, err = Decode([]byte(`{"type":"cursor","editorId":"e1","ranges":[{"index":1,"length":2}],"revision":3}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []Range{{Index: 1, Length: 2}}, message.(*Cursor).Ranges)
+
+	message, err = Decode([]byte(`{"type":"close"}`))
+	assert.Nil(t, err)
+	assert.IsType(t, &Close{}, message)
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		raw     string
+		code    Code
+		replyTo string
+	}{
+		{`not json`, CodeInvalidMessage, ""},
+		{`{"id":"m1"}`, CodeInvalidMessage, "m1"},
+		{`{"type":"sync","id":"m1"}`, CodeUnknownType, "m1"},
+		{`{"type":"join","id":"j1","version":2}`, CodeUnsupportedVersion, "j1"},
+		{`{"type":"join","id":"j2","resume":"token"}`, CodeInvalidMessage, "j2"},
+		{`{"type":"join","resume":"token","revisions":{"e1":-1}}`, CodeInvalidMessage, ""},
+		{`{"type":"delta","id":"d1","delta":{"revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`, CodeInvalidMessage, "d1"},
+		{`{"type":"delta","delta":{"editorId":"e1","revision":-1,"delta":{"ops":[{"insert":"Hi"}]}}}`, CodeInvalidMessage, ""},
+		{`{"type":"delta","delta":{"editorId":"e1","revision":0,"delta":{"ops":[]}}}`, CodeInvalidMessage, ""},
+		{`{"type":"delta","delta":{"editorId":"e1","revision":0,"delta":{"ops":[{"retain":1,"delete":1}]}}}`, CodeInvalidMessage, ""},
+		{`{"type":"delta","delta":{"editorId":"e1","revision":0,"delta":{"ops":[{"retain":-1}]}}}`, CodeInvalidMessage, ""},
+		{`{"type":"delta","delta":{"editorId":"e1","revision":0,"delta":{"ops":[{"insert":""}]}}}`, CodeInvalidMessage, ""},
+		{`{"type":"delta","delta":{"editorId":"e1","revision":"one"}}`, CodeInvalidMessage, ""},
+		{`{"type":"cursor","ranges":[{"index":0,"length":0}]}`, CodeInvalidMessage, ""},
+		{`{"type":"cursor","editorId":"e1","ranges":[{"index":-1,"length":0}]}`, CodeInvalidMessage, ""},
+	}
+	for _, test := range tests {
+		message, err := Decode([]byte(test.raw))
+		assert.Nil(t, message, test.raw)
+		if assert.NotNil(t, err, test.raw) {
+			assert.Equal(t, test.code, err.Code, test.raw)
+			assert.Equal(t, test.replyTo, err.ReplyTo, test.raw)
+			assert.Equal(t, TypeError, err.Type)
+		}
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	frame := NewError(CodeUnavailable, "d1", "report %s is being restructured", "r1")
+	assert.True(t, frame.Retryable)
+	assert.Equal(t, "unavailable: report r1 is being restructured", frame.Error())
+
+	raw, err := json.Marshal(NewError(CodeApproved, "", "approved"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"error","code":"approved","message":"approved","retryable":false}`, string(raw))
+}
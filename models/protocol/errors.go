@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+// Code says why a client message was rejected or something failed
+type Code string
+
+const (
+	CodeInvalidMessage     Code = "invalid_message"     // not JSON, or a field is missing or out of range
+	CodeUnknownType        Code = "unknown_type"        // the type is not one clients send
+	CodeUnsupportedVersion Code = "unsupported_version" // the join asked for a version the server does not speak
+	CodeNotJoined          Code = "not_joined"          // the message needs a join first
+	CodeForbidden          Code = "forbidden"           // the user's access to the section does not allow it
+	CodeUnknownEditor      Code = "unknown_editor"      // the subsection is not in the section
+	CodeApproved           Code = "approved"            // the subsection is approved and takes no edits
+	CodeOutOfStep          Code = "out_of_step"         // the delta does not fit the revision it was made at, or reaches past the end of the subsection
+	CodeUnavailable        Code = "unavailable"         // the report is being restructured
+	CodeSaveFailed         Code = "save_failed"         // changes could not be written to the repository yet
+	CodeInternal           Code = "internal"
+)
+
+// retryable are the codes where the same message may succeed if sent again
+// later
+var retryable = map[Code]bool{CodeUnavailable: true, CodeInternal: true}
+
+// Error is sent when a client message is rejected, with the id of the
+// message if it had one, or when the server fails at something the client
+// should know about. Deltas rejected for an editor are followed by a snapshot
+// of it.
+type Error struct {
+	Type      string `json:"type"`
+	ReplyTo   string `json:"replyTo,omitempty"`
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	EditorId  string `json:"editorId,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// NewError builds an error frame answering the message with id replyTo
+func NewError(code Code, replyTo, format string, args ...interface{}) *Error {
+	return &Error{
+		Type:      TypeError,
+		ReplyTo:   replyTo,
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		Retryable: retryable[code],
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
@@ -0,0 +1,183 @@
+// Package protocol defines the messages sent over a section's WebSocket.
+//
+// A client opens the socket and sends a join message with the protocol
+// version it speaks. The server answers with a snapshot of every subsection,
+// their reviews, the comment threads, who else is in the section and last a
+// welcome, which completes the handshake. Client messages may carry an id,
+// which the server puts in the ack or error that answers them.
+//
+// The welcome carries a resume token. A client whose connection dropped can
+// join again with it and the last revision it has of every subsection, and is
+// sent the deltas it missed instead of snapshots. Its own deltas among them
+// come as acks.
+package protocol
+
+import (
+	"time"
+
+	"sema/models/comment"
+	"sema/models/delta"
+	"sema/models/reportStructure"
+	"sema/models/review"
+)
+
+// Version is the protocol version the server speaks. Clients from before
+// versioning send none with their join and are taken to speak version 1.
+const Version = 1
+
+// Message types clients send
+const (
+	TypeJoin   = "join"
+	TypeDelta  = "delta"
+	TypeCursor = "cursor"
+	TypeClose  = "close"
+)
+
+// Message types the server sends. Snapshots and deltas are delta.Delta
+// messages.
+const (
+	TypeWelcome   = "welcome"
+	TypeSnapshot  = "snapshot"
+	TypeAck       = "ack"
+	TypeError     = "error"
+	TypeSaved     = "saved"
+	TypeReviews   = "reviews"
+	TypeReview    = "review"
+	TypeComments  = "comments"
+	TypeComment   = "comment"
+	TypePresence  = "presence"
+	TypeJoined    = "joined"
+	TypeLeft      = "left"
+	TypeStructure = "structure"
+)
+
+// Envelope holds what every client message has. The id is chosen by the
+// client and only has to be unique among its unanswered messages.
+type Envelope struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+}
+
+// Join asks for the section's contents and to be announced to the others.
+// Resume is the token from an earlier welcome, with the revision the client
+// has of each subsection.
+type Join struct {
+	Envelope
+	Version   int            `json:"version,omitempty"`
+	Resume    string         `json:"resume,omitempty"`
+	Revisions map[string]int `json:"revisions,omitempty"` // editorId -> revision
+}
+
+// DeltaMessage is a change to a subsection, made at a revision
+type DeltaMessage struct {
+	Envelope
+	Delta delta.DeltaData `json:"delta"`
+}
+
+// Cursor is a client's focused subsection and its cursor and selections
+// there, picked at revision. An empty editorId means no subsection has focus.
+type Cursor struct {
+	Envelope
+	EditorId string  `json:"editorId"`
+	Ranges   []Range `json:"ranges"`
+	Revision int     `json:"revision"`
+}
+
+// Close tells the server the client is leaving the section
+type Close struct {
+	Envelope
+}
+
+// Welcome completes a join. Everything the client needs has been sent before
+// it. Resumed says whether the client was sent what it missed rather than
+// snapshots, and the resume token is what to join with if the connection
+// drops.
+type Welcome struct {
+	Type        string `json:"type"`
+	ReplyTo     string `json:"replyTo,omitempty"`
+	Version     int    `json:"version"`
+	SessionID   string `json:"sessionId"`
+	ResumeToken string `json:"resumeToken"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// Ack confirms a client message was applied. Acks of deltas carry the
+// revision the editor is at afterwards, and are sent whether or not the delta
+// had an id.
+type Ack struct {
+	Type     string `json:"type"`
+	ReplyTo  string `json:"replyTo,omitempty"`
+	EditorId string `json:"editorId,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+}
+
+// Saved tells clients a subsection was written to the repository up to a
+// revision
+type Saved struct {
+	Type     string `json:"type"`
+	EditorId string `json:"editorId"`
+	Revision int    `json:"revision"`
+}
+
+// Range is a cursor (an empty range) or a selection in a subsection. It moves
+// over edits the same way a comment anchor does.
+type Range = comment.Anchor
+
+// Presence is someone with a section open. Every connection has its own, so
+// a user with the section open twice is listed twice.
+type Presence struct {
+	SessionID string    `json:"sessionId"`
+	UID       string    `json:"uid"`
+	Email     string    `json:"email"`
+	ReportID  string    `json:"reportId"`
+	SectionID string    `json:"sectionId"`
+	EditorId  string    `json:"editorId,omitempty"` // the focused subsection
+	Ranges    []Range   `json:"ranges"`             // cursor and selections in the focused subsection
+	JoinedAt  time.Time `json:"joinedAt"`
+}
+
+// PresenceMessage sends a joining client everyone else in the section
+type PresenceMessage struct {
+	Type    string     `json:"type"`
+	Editors []Presence `json:"editors"`
+}
+
+// PresenceChangeMessage tells clients someone joined ("joined") or left
+// ("left") the section, or moved their cursor ("cursor")
+type PresenceChangeMessage struct {
+	Type     string   `json:"type"`
+	Presence Presence `json:"presence"`
+}
+
+// StructureMessage tells clients a report's sections changed, with the new
+// sections and subsections in order
+type StructureMessage struct {
+	Type     string                    `json:"type"`
+	Sections []reportStructure.Section `json:"sections"`
+}
+
+// CommentsMessage sends a joining client every comment thread of the section
+type CommentsMessage struct {
+	Type    string           `json:"type"`
+	Threads []comment.Thread `json:"threads"`
+}
+
+// CommentMessage tells clients a thread was started, replied to, resolved or
+// reopened
+type CommentMessage struct {
+	Type   string         `json:"type"`
+	Thread comment.Thread `json:"thread"`
+}
+
+// ReviewsMessage sends a joining client the review of every subsection
+type ReviewsMessage struct {
+	Type    string                   `json:"type"`
+	Reviews map[string]review.Review `json:"reviews"` // editorId -> review
+}
+
+// ReviewMessage tells clients a subsection's review changed
+type ReviewMessage struct {
+	Type     string        `json:"type"`
+	EditorId string        `json:"editorId"`
+	Review   review.Review `json:"review"`
+}
@@ -0,0 +1,57 @@
+package reportRoles
+
+// Role is what a member may do in a report. Each role may do everything the
+// ones before it may. Viewers, commenters and editors work in the sections
+// granted to them, at most at the level of their role. Admins manage the
+// report and its members, owners also manage ownership, and a report always
+// has at least one owner.
+type Role string
+
+const (
+	None      Role = ""
+	Viewer    Role = "viewer"
+	Commenter Role = "commenter"
+	Editor    Role = "editor"
+	Admin     Role = "admin"
+	Owner     Role = "owner"
+)
+
+var ranks = map[Role]int{None: 0, Viewer: 1, Commenter: 2, Editor: 3, Admin: 4, Owner: 5}
+
+// Valid reports whether a role can be given to a member
+func (r Role) Valid() bool {
+	return ranks[r] > 0
+}
+
+// AtLeast reports whether a role may do everything another may
+func (r Role) AtLeast(other Role) bool {
+	return ranks[r] >= ranks[other]
+}
+
+// IsAdmin reports whether a role manages the report
+func (r Role) IsAdmin() bool {
+	return r.AtLeast(Admin)
+}
+
+// Manages reports whether a member with this role may give someone the
+// target role or take it away from them. Admins manage everyone but owners,
+// only owners manage owners.
+func (r Role) Manages(target Role) bool {
+	if target == Owner {
+		return r == Owner
+	}
+	return r.IsAdmin()
+}
+
+// FromFlags is the role of a membership stored before roles, as the
+// privilege and owner flags
+func FromFlags(privilege, owner bool) Role {
+	switch {
+	case owner:
+		return Owner
+	case privilege:
+		return Admin
+	default:
+		return Editor
+	}
+}
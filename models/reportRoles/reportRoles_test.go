@@ -0,0 +1,31 @@
+package reportRoles_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/reportRoles"
+)
+
+func TestRoles(t *testing.T) {
+	assert.True(t, reportRoles.Owner.AtLeast(reportRoles.Admin))
+	assert.False(t, reportRoles.Viewer.AtLeast(reportRoles.Commenter))
+	assert.True(t, reportRoles.Admin.IsAdmin())
+	assert.False(t, reportRoles.Editor.IsAdmin())
+	assert.False(t, reportRoles.Role("superuser").Valid())
+	assert.False(t, reportRoles.None.Valid())
+}
+
+func TestManages(t *testing.T) {
+	assert.True(t, reportRoles.Admin.Manages(reportRoles.Admin))
+	assert.True(t, reportRoles.Admin.Manages(reportRoles.Viewer))
+	assert.False(t, reportRoles.Admin.Manages(reportRoles.Owner))
+	assert.True(t, reportRoles.Owner.Manages(reportRoles.Owner))
+	assert.False(t, reportRoles.Editor.Manages(reportRoles.Viewer))
+}
+
+func TestFromFlags(t *testing.T) {
+	assert.Equal(t, reportRoles.Owner, reportRoles.FromFlags(true, true))
+	assert.Equal(t, reportRoles.Admin, reportRoles.FromFlags(true, false))
+	assert.Equal(t, reportRoles.Editor, reportRoles.FromFlags(false, false))
+}
@@ -0,0 +1,19 @@
+package reportStructure
+
+// Section is a section of a report, with its subsections in order. IDs are
+// given out when a section or subsection is created and never change, so
+// anything that refers to one uses its ID. Titles are only shown.
+type Section struct {
+	ID          string       `json:"id"`
+	Title       string       `json:"title"`
+	Subsections []Subsection `json:"subsections"`
+
+	// What the member asking may do in the section: "read", "comment" or
+	// "edit". Only set when the structure is fetched for one member.
+	Access string `json:"access,omitempty"`
+}
+
+type Subsection struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
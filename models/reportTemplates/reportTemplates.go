@@ -1,12 +1,96 @@
 package reportTemplates
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Section struct {
 	Title      string       `firestore:"title"`
 	Subsections []string `firestore:"subsections"`
 }
 
+// ReportTemplate is the outline new reports are created from. Every update
+// is kept as a numbered version, and reports remember the version they were
+// created from. Templates put into Firestore by hand have version 0.
 type ReportTemplate struct {
-	Sections []Section `firestore:"sections"`
+	Name       string    `firestore:"name"`
+	Sections   []Section `firestore:"sections"`
+	Style      Style     `firestore:"style"`
+	Version    int       `firestore:"version"`
+	UpdatedBy  string    `firestore:"updatedBy"`
+	UpdatedAt  time.Time `firestore:"updatedAt"`
+	ClonedFrom string    `firestore:"clonedFrom"` // "templateID@version" of the template this one was copied from
 }
 
+// Validate checks that a template has a name and at least one section, and
+// that section titles, and subsection titles within a section, are set and
+// unique. Titles are compared the way reports keep them unique, ignoring case
+// and surrounding spaces.
+func (t ReportTemplate) Validate() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("template name is required")
+	}
+	if len(t.Sections) == 0 {
+		return fmt.Errorf("template needs at least one section")
+	}
+
+	sections := make(map[string]bool)
+	for _, section := range t.Sections {
+		if err := checkTitle(sections, section.Title); err != nil {
+			return fmt.Errorf("section %q: %w", section.Title, err)
+		}
+
+		subsections := make(map[string]bool)
+		for _, subsection := range section.Subsections {
+			if err := checkTitle(subsections, subsection); err != nil {
+				return fmt.Errorf("subsection %q of section %q: %w", subsection, section.Title, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkTitle(seen map[string]bool, title string) error {
+	if strings.TrimSpace(title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	key := strings.ToLower(strings.TrimSpace(title))
+	if seen[key] {
+		return fmt.Errorf("title is used more than once")
+	}
+	seen[key] = true
+	return nil
+}
+
+// Copy returns a deep copy of the template
+func (t ReportTemplate) Copy() ReportTemplate {
+	copied := t
+	copied.Sections = nil
+	for _, section := range t.Sections {
+		copied.Sections = append(copied.Sections, Section{
+			Title:       section.Title,
+			Subsections: append([]string(nil), section.Subsections...),
+		})
+	}
+	return copied
+}
+
+// Style is the branding applied to exports of reports made from a template.
+// Every field is optional, the zero Style gives the plain default layout.
+//
+// Header and Footer may contain the placeholders {title}, {organization},
+// {classification}, {version}, {date}, {page} and {pages}.
+type Style struct {
+	Stylesheet      string `firestore:"stylesheet"`     // CSS added after the default styles in HTML and Chrome PDF exports
+	TitlePage       string `firestore:"titlePage"`      // html/template for the title page of HTML and Chrome PDF exports
+	Logo            string `firestore:"logo"`           // Image data URI shown on the title page
+	Organization    string `firestore:"organization"`   // Lab or vendor name shown on the title page
+	Classification  string `firestore:"classification"` // Banner at the top and bottom of every page, e.g. "CONFIDENTIAL"
+	Header          string `firestore:"header"`
+	Footer          string `firestore:"footer"`
+	HidePageNumbers bool   `firestore:"hidePageNumbers"` // Leaves "Page N of M" out of the footer
+	Version         string `firestore:"version"`         // Document version shown on the title page
+	DateFormat      string `firestore:"dateFormat"`      // Go time layout for the date, defaults to "January 2, 2006"
+}
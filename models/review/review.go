@@ -0,0 +1,123 @@
+package review
+
+import (
+	"time"
+)
+
+// Status is how far a subsection is through review. A section is as far as
+// its least reviewed subsection.
+type Status string
+
+const (
+	Draft           Status = "draft"
+	InternalReview  Status = "internal_review"
+	EvaluatorReview Status = "evaluator_review"
+	Approved        Status = "approved"
+)
+
+var ranks = map[Status]int{Draft: 1, InternalReview: 2, EvaluatorReview: 3, Approved: 4}
+
+// Valid reports whether a status exists
+func (s Status) Valid() bool {
+	return ranks[s] > 0
+}
+
+// Review is the review state of a subsection. Reviewers are the emails of
+// the members assigned to review it, History every status change, oldest
+// first.
+type Review struct {
+	Status    Status       `json:"status"`
+	Reviewers []string     `json:"reviewers"`
+	History   []Transition `json:"history"`
+}
+
+// New is the review of a subsection nobody has touched yet
+func New() Review {
+	return Review{Status: Draft, Reviewers: []string{}, History: []Transition{}}
+}
+
+// Transition is a status change, with who made it and why
+type Transition struct {
+	From Status    `json:"from"`
+	To   Status    `json:"to"`
+	By   string    `json:"by"`
+	At   time.Time `json:"at"`
+	Note string    `json:"note,omitempty"`
+}
+
+// Approvals are the transitions that approved the subsection, oldest first
+func (r Review) Approvals() []Transition {
+	approvals := []Transition{}
+	for _, t := range r.History {
+		if t.To == Approved {
+			approvals = append(approvals, t)
+		}
+	}
+	return approvals
+}
+
+// IsReviewer reports whether an email is assigned to review the subsection
+func (r Review) IsReviewer(email string) bool {
+	for _, reviewer := range r.Reviewers {
+		if reviewer == email {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor is who wants to change a status: a report admin, a member who may
+// edit the section, or one of the subsection's reviewers
+type Actor struct {
+	Admin    bool
+	Editor   bool
+	Reviewer bool
+}
+
+// rule is who besides admins may make a transition
+type rule struct {
+	editors   bool
+	reviewers bool
+}
+
+// Editors submit their work for review and may take it back, reviewers pass
+// it on, approve it or send it back. Only admins reopen approved work.
+var rules = map[[2]Status]rule{
+	{Draft, InternalReview}:           {editors: true},
+	{InternalReview, Draft}:           {editors: true, reviewers: true},
+	{InternalReview, EvaluatorReview}: {reviewers: true},
+	{EvaluatorReview, Draft}:          {reviewers: true},
+	{EvaluatorReview, Approved}:       {reviewers: true},
+	{Approved, Draft}:                 {},
+}
+
+// Allowed reports whether a transition exists at all
+func Allowed(from, to Status) bool {
+	_, ok := rules[[2]Status{from, to}]
+	return ok
+}
+
+// MayTransition reports whether the actor may move a subsection from one
+// status to another
+func MayTransition(from, to Status, actor Actor) bool {
+	rule, ok := rules[[2]Status{from, to}]
+	if !ok {
+		return false
+	}
+	return actor.Admin || (rule.editors && actor.Editor) || (rule.reviewers && actor.Reviewer)
+}
+
+// SectionStatus is the status of a section with the given subsection
+// statuses, the least reviewed of them. An empty section is a draft.
+func SectionStatus(statuses []Status) Status {
+	if len(statuses) == 0 {
+		return Draft
+	}
+	section := Approved
+	for _, status := range statuses {
+		if ranks[status] < ranks[section] {
+			section = status
+		}
+	}
+	return section
+}
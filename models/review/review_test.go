@@ -0,0 +1,49 @@
+package review_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/review"
+)
+
+func TestMayTransition(t *testing.T) {
+	editor := review.Actor{Editor: true}
+	reviewer := review.Actor{Reviewer: true}
+	admin := review.Actor{Admin: true}
+
+	assert.True(t, review.MayTransition(review.Draft, review.InternalReview, editor))
+	assert.False(t, review.MayTransition(review.Draft, review.InternalReview, reviewer))
+	assert.True(t, review.MayTransition(review.InternalReview, review.EvaluatorReview, reviewer))
+	assert.False(t, review.MayTransition(review.InternalReview, review.EvaluatorReview, editor))
+	assert.True(t, review.MayTransition(review.EvaluatorReview, review.Approved, reviewer))
+	assert.False(t, review.MayTransition(review.EvaluatorReview, review.Approved, editor))
+
+	// Only admins reopen, and nobody skips a step
+	assert.False(t, review.MayTransition(review.Approved, review.Draft, reviewer))
+	assert.True(t, review.MayTransition(review.Approved, review.Draft, admin))
+	assert.False(t, review.MayTransition(review.Draft, review.Approved, admin))
+	assert.False(t, review.Allowed(review.Draft, review.Draft))
+}
+
+func TestSectionStatus(t *testing.T) {
+	assert.Equal(t, review.Draft, review.SectionStatus(nil))
+	assert.Equal(t, review.Approved, review.SectionStatus([]review.Status{review.Approved, review.Approved}))
+	assert.Equal(t, review.InternalReview, review.SectionStatus([]review.Status{review.Approved, review.InternalReview, review.EvaluatorReview}))
+}
+
+func TestApprovals(t *testing.T) {
+	now := time.Now()
+	r := review.New()
+	r.History = []review.Transition{
+		{From: review.EvaluatorReview, To: review.Approved, By: "eve@example.com", At: now},
+		{From: review.Approved, To: review.Draft, By: "owner@example.com", At: now},
+		{From: review.EvaluatorReview, To: review.Approved, By: "eve@example.com", At: now},
+	}
+	assert.Len(t, r.Approvals(), 2)
+
+	r.Reviewers = []string{"eve@example.com"}
+	assert.True(t, r.IsReviewer("eve@example.com"))
+	assert.False(t, r.IsReviewer("bob@example.com"))
+}
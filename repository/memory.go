@@ -0,0 +1,1196 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"sema/models/audit"
+	"sema/models/comment"
+	"sema/models/invitation"
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
+	"sema/models/reportTemplates"
+	"sema/models/review"
+	"sema/services/sectionAccess"
+)
+
+// MemoryRepository is an in-memory ReportRepository used for local development
+// and tests. It mirrors the behaviour of FirestoreRepository without needing
+// the Firestore emulator.
+type MemoryRepository struct {
+	mu               sync.RWMutex
+	templates        map[string]reportTemplates.ReportTemplate
+	templateVersions map[string][]reportTemplates.ReportTemplate // templateID -> versions, oldest first
+	admins           map[string]bool
+	reports          map[string]*memoryReport
+	links            map[string]map[string]*memoryLink // uid -> reportID -> link
+	logs             map[string][]audit.Event          // reportID -> events, oldest first
+	logChains        map[string]audit.Head             // reportID -> last chained event
+}
+
+type memoryReport struct {
+	reportID        string
+	templateID      string
+	templateVersion int
+	reportName      string
+	creationTime    time.Time
+	sections        []*memorySection        // Kept in order
+	invitations     []invitation.Invitation // Oldest first
+	comments        []comment.Thread        // Oldest first
+}
+
+type memorySection struct {
+	id          string
+	title       string
+	subsections []*memorySubsection // Kept in order
+}
+
+type memorySubsection struct {
+	id       string
+	title    string
+	content  string
+	versions []SubsectionVersion // Oldest first
+	review   review.Review       // Zero until the first status change or assignment
+}
+
+type memoryLink struct {
+	role     reportRoles.Role
+	joinedAt time.Time
+	sections map[string]sectionAccess.Level // sectionID -> granted level
+}
+
+// NewMemoryRepository creates an empty in-memory repository
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		templates:        make(map[string]reportTemplates.ReportTemplate),
+		templateVersions: make(map[string][]reportTemplates.ReportTemplate),
+		admins:           make(map[string]bool),
+		reports:          make(map[string]*memoryReport),
+		links:            make(map[string]map[string]*memoryLink),
+		logs:             make(map[string][]audit.Event),
+		logChains:        make(map[string]audit.Head),
+	}
+}
+
+// AddTemplate stores a report template, replacing any template with the same ID
+func (r *MemoryRepository) AddTemplate(templateID string, template reportTemplates.ReportTemplate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templates[templateID] = template.Copy()
+}
+
+// AddAdmin lets a user manage report templates
+func (r *MemoryRepository) AddAdmin(uID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.admins[uID] = true
+}
+
+func (r *MemoryRepository) IsTemplateAdmin(uID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.admins[uID], nil
+}
+
+func (r *MemoryRepository) BufferLog(event audit.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logLocked(event)
+}
+
+func (r *MemoryRepository) GetTemplate(templateID string) (*reportTemplates.ReportTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	template, ok := r.templates[templateID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+	}
+
+	// Copy the sections so callers can't modify the stored template
+	copied := template.Copy()
+	return &copied, nil
+}
+
+func (r *MemoryRepository) ListTemplates() ([]TemplateSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	templates := []TemplateSummary{}
+	for templateID, template := range r.templates {
+		templates = append(templates, templateSummary(templateID, template))
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].TemplateID < templates[j].TemplateID
+	})
+	return templates, nil
+}
+
+func (r *MemoryRepository) CreateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.templates[templateID]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateExists, templateID)
+	}
+	return r.saveTemplateLocked(templateID, template, 1, author), nil
+}
+
+func (r *MemoryRepository) UpdateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.templates[templateID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+	}
+	template.ClonedFrom = current.ClonedFrom
+	return r.saveTemplateLocked(templateID, template, current.Version+1, author), nil
+}
+
+// saveTemplateLocked stores a template as its current and a new numbered version
+func (r *MemoryRepository) saveTemplateLocked(templateID string, template reportTemplates.ReportTemplate, version int, author string) *reportTemplates.ReportTemplate {
+	template = template.Copy()
+	template.Version = version
+	template.UpdatedBy = author
+	template.UpdatedAt = time.Now()
+
+	r.templates[templateID] = template
+	r.templateVersions[templateID] = append(r.templateVersions[templateID], template)
+
+	saved := template.Copy()
+	return &saved
+}
+
+func (r *MemoryRepository) ListTemplateVersions(templateID string) ([]TemplateSummary, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.templates[templateID]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+	}
+
+	versions := []TemplateSummary{}
+	stored := r.templateVersions[templateID]
+	for i := len(stored) - 1; i >= 0; i-- {
+		versions = append(versions, templateSummary(templateID, stored[i]))
+	}
+	return versions, nil
+}
+
+func (r *MemoryRepository) GetTemplateVersion(templateID string, version int) (*reportTemplates.ReportTemplate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, template := range r.templateVersions[templateID] {
+		if template.Version == version {
+			copied := template.Copy()
+			return &copied, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, templateID, version)
+}
+
+func (r *MemoryRepository) CreateReport(reportName, reportID, templateID, userEmail string) error {
+	template, err := r.GetTemplate(templateID)
+	if err != nil {
+		return err
+	}
+
+	report := &memoryReport{
+		reportID:        reportID,
+		templateID:      templateID,
+		templateVersion: template.Version,
+		reportName:      reportName,
+		creationTime:    time.Now(),
+	}
+
+	for _, section := range template.Sections {
+		memSection := &memorySection{id: newID(), title: section.Title}
+		for _, subsection := range section.Subsections {
+			memSection.subsections = append(memSection.subsections, &memorySubsection{id: newID(), title: subsection})
+		}
+		report.sections = append(report.sections, memSection)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reports[reportID] = report
+	r.logLocked(audit.Event{ReportID: reportID, Actor: userEmail, Action: audit.ReportCreated, Message: fmt.Sprintf("created the report %s", reportName)})
+
+	return nil
+}
+
+func (r *MemoryRepository) GetReportFieldTemplateID(reportID string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return "", fmt.Errorf("failed to get report: report %s not found", reportID)
+	}
+	return report.templateID, nil
+}
+
+func (r *MemoryRepository) GetReportTemplateVersion(reportID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return 0, fmt.Errorf("failed to get report: report %s not found", reportID)
+	}
+	return report.templateVersion, nil
+}
+
+func (r *MemoryRepository) SetReportTemplateVersion(reportID string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return fmt.Errorf("failed to set template version: report %s not found", reportID)
+	}
+	report.templateVersion = version
+	return nil
+}
+
+func (r *MemoryRepository) FetchReportSectionContents(reportID, sectionID string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	contents := make(map[string]string)
+
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return contents, nil
+	}
+
+	for _, subsection := range section.subsections {
+		contents[subsection.id] = subsection.content
+	}
+	return contents, nil
+}
+
+func (r *MemoryRepository) UpdateReportSectionContents(reportID, sectionID, subsectionID, newContent, author string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return fmt.Errorf("failed to update subsection content: subsection %s not found", subsectionID)
+	}
+
+	if subsection.content == newContent {
+		return nil
+	}
+	subsection.content = newContent
+
+	now := time.Now()
+	if n := len(subsection.versions); n > 0 && mergesInto(subsection.versions[n-1], author, now) {
+		latest := &subsection.versions[n-1]
+		latest.Timestamp, latest.Size, latest.Content = now, len(newContent), newContent
+		return nil
+	}
+	subsection.versions = append(subsection.versions, SubsectionVersion{
+		VersionID: strconv.Itoa(len(subsection.versions) + 1),
+		Author:    author,
+		Timestamp: now,
+		Created:   now,
+		Size:      len(newContent),
+		Content:   newContent,
+	})
+	return nil
+}
+
+func (r *MemoryRepository) ListSubsectionVersions(reportID, sectionID, subsectionID string, page VersionPage) ([]SubsectionVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return nil, fmt.Errorf("failed to fetch subsection versions: subsection %s not found", subsectionID)
+	}
+
+	start := len(subsection.versions) - 1
+	if page.After != "" {
+		for start >= 0 && subsection.versions[start].VersionID != page.After {
+			start--
+		}
+		if start < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, page.After)
+		}
+		start--
+	}
+
+	versions := []SubsectionVersion{}
+	for i := start; i >= 0 && (page.Limit == 0 || len(versions) < page.Limit); i-- {
+		version := subsection.versions[i]
+		version.Content = ""
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (r *MemoryRepository) GetSubsectionVersion(reportID, sectionID, subsectionID, versionID string) (*SubsectionVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return nil, fmt.Errorf("failed to fetch subsection version: subsection %s not found", subsectionID)
+	}
+
+	for _, version := range subsection.versions {
+		if version.VersionID == versionID {
+			return &version, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to fetch subsection version: %w: %s", ErrVersionNotFound, versionID)
+}
+
+func (r *MemoryRepository) FetchReportContent(reportID string) (string, []map[string]interface{}, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return "", nil, fmt.Errorf("failed to fetch report: report %s not found", reportID)
+	}
+
+	var orderedReportContent []map[string]interface{}
+	for _, section := range report.sections {
+		subsections := []map[string]interface{}{}
+		for _, subsection := range section.subsections {
+			subsections = append(subsections, map[string]interface{}{
+				"id":      subsection.id,
+				"title":   subsection.title,
+				"content": subsection.content,
+			})
+		}
+
+		orderedReportContent = append(orderedReportContent, map[string]interface{}{
+			"sectionID":    section.id,
+			"sectionTitle": section.title,
+			"subsections":  subsections,
+		})
+	}
+
+	return report.reportName, orderedReportContent, nil
+}
+
+func (r *MemoryRepository) LinkReportWithUser(uID, reportID string, role reportRoles.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.linkLocked(uID, reportID, role)
+}
+
+func (r *MemoryRepository) linkLocked(uID, reportID string, role reportRoles.Role) error {
+	if _, ok := r.links[uID][reportID]; ok {
+		return fmt.Errorf("%w: %s", ErrAlreadyInReport, uID)
+	}
+	if _, ok := r.reports[reportID]; !ok && role == reportRoles.Owner {
+		return fmt.Errorf("%w: %s", ErrReportNotFound, reportID)
+	}
+	if r.links[uID] == nil {
+		r.links[uID] = make(map[string]*memoryLink)
+	}
+	r.links[uID][reportID] = &memoryLink{role: role, joinedAt: time.Now(), sections: make(map[string]sectionAccess.Level)}
+	return nil
+}
+
+func (r *MemoryRepository) CreateInvitation(invite invitation.Invitation) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[invite.ReportID]
+	if !ok {
+		return "", fmt.Errorf("failed to create invitation: report %s not found", invite.ReportID)
+	}
+	for _, existing := range report.invitations {
+		if existing.Email == invite.Email {
+			return "", fmt.Errorf("%w: %s", ErrInvitationExists, invite.Email)
+		}
+	}
+
+	invite.ID = newID()
+	report.invitations = append(report.invitations, invite)
+	return invite.ID, nil
+}
+
+func (r *MemoryRepository) ListInvitations(reportID string) ([]invitation.Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	invitations := []invitation.Invitation{}
+	if report, ok := r.reports[reportID]; ok {
+		invitations = append(invitations, report.invitations...)
+	}
+	return invitations, nil
+}
+
+func (r *MemoryRepository) RenewInvitation(reportID, invitationID, token string, expiresAt time.Time) (*invitation.Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.findInvitationLocked(reportID, invitationID)
+	if err != nil {
+		return nil, err
+	}
+	invite := &r.reports[reportID].invitations[index]
+	invite.Token = token
+	invite.ExpiresAt = expiresAt
+
+	renewed := *invite
+	return &renewed, nil
+}
+
+func (r *MemoryRepository) RevokeInvitation(reportID, invitationID string) (*invitation.Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.findInvitationLocked(reportID, invitationID)
+	if err != nil {
+		return nil, err
+	}
+	report := r.reports[reportID]
+	revoked := report.invitations[index]
+	report.invitations = append(report.invitations[:index], report.invitations[index+1:]...)
+	return &revoked, nil
+}
+
+func (r *MemoryRepository) FindInvitations(email string) ([]invitation.Invitation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	email = invitation.NormalizeEmail(email)
+	invitations := []invitation.Invitation{}
+	for _, report := range r.reports {
+		for _, invite := range report.invitations {
+			if invite.Email == email {
+				invitations = append(invitations, invite)
+			}
+		}
+	}
+	return invitations, nil
+}
+
+func (r *MemoryRepository) AcceptInvitation(uID string, invite invitation.Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.findInvitationLocked(invite.ReportID, invite.ID)
+	if err != nil {
+		return err
+	}
+	if err := r.linkLocked(uID, invite.ReportID, invite.Role); err != nil && !errors.Is(err, ErrAlreadyInReport) {
+		return err
+	}
+	report := r.reports[invite.ReportID]
+	report.invitations = append(report.invitations[:index], report.invitations[index+1:]...)
+	return nil
+}
+
+// findInvitationLocked returns the index of an invitation in its report
+func (r *MemoryRepository) findInvitationLocked(reportID, invitationID string) (int, error) {
+	if report, ok := r.reports[reportID]; ok {
+		for i, invite := range report.invitations {
+			if invite.ID == invitationID {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvitationNotFound, invitationID)
+}
+
+func (r *MemoryRepository) CreateCommentThread(thread comment.Thread) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[thread.ReportID]
+	if !ok {
+		return "", fmt.Errorf("report %s not found", thread.ReportID)
+	}
+	thread.ID = newID()
+	report.comments = append(report.comments, copyThread(thread))
+	return thread.ID, nil
+}
+
+func (r *MemoryRepository) FetchCommentThreads(reportID, sectionID string) ([]comment.Thread, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	threads := []comment.Thread{}
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return threads, nil
+	}
+	inSection := make(map[string]bool)
+	for _, subsection := range section.subsections {
+		inSection[subsection.id] = true
+	}
+	for _, thread := range r.reports[reportID].comments {
+		if inSection[thread.SubsectionID] {
+			threads = append(threads, copyThread(thread))
+		}
+	}
+	return threads, nil
+}
+
+func (r *MemoryRepository) GetCommentThread(reportID, threadID string) (*comment.Thread, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	thread, err := r.findCommentLocked(reportID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	found := copyThread(*thread)
+	return &found, nil
+}
+
+func (r *MemoryRepository) AddCommentReply(reportID, threadID string, reply comment.Reply) (*comment.Thread, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	thread, err := r.findCommentLocked(reportID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	thread.Replies = append(thread.Replies, reply)
+	updated := copyThread(*thread)
+	return &updated, nil
+}
+
+func (r *MemoryRepository) SetCommentResolved(reportID, threadID string, resolved bool, by string, at time.Time) (*comment.Thread, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	thread, err := r.findCommentLocked(reportID, threadID)
+	if err != nil {
+		return nil, err
+	}
+	thread.SetResolved(resolved, by, at)
+	updated := copyThread(*thread)
+	return &updated, nil
+}
+
+func (r *MemoryRepository) UpdateCommentAnchors(reportID string, anchors map[string]comment.Anchor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if report, ok := r.reports[reportID]; ok {
+		for i := range report.comments {
+			if anchor, ok := anchors[report.comments[i].ID]; ok {
+				report.comments[i].Anchor = anchor
+			}
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) findCommentLocked(reportID, threadID string) (*comment.Thread, error) {
+	if report, ok := r.reports[reportID]; ok {
+		for i := range report.comments {
+			if report.comments[i].ID == threadID {
+				return &report.comments[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCommentNotFound, threadID)
+}
+
+// copyThread copies a thread so callers can't change the stored one
+func copyThread(thread comment.Thread) comment.Thread {
+	thread.Mentions = append([]string{}, thread.Mentions...)
+	replies := make([]comment.Reply, len(thread.Replies))
+	for i, reply := range thread.Replies {
+		reply.Mentions = append([]string{}, reply.Mentions...)
+		replies[i] = reply
+	}
+	thread.Replies = replies
+	if thread.ResolvedAt != nil {
+		resolvedAt := *thread.ResolvedAt
+		thread.ResolvedAt = &resolvedAt
+	}
+	return thread
+}
+
+func (r *MemoryRepository) GetSubsectionReview(reportID, sectionID, subsectionID string) (*review.Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+	}
+	subsectionReview := copyReview(subsection.review)
+	return &subsectionReview, nil
+}
+
+func (r *MemoryRepository) FetchSectionReviews(reportID, sectionID string) (map[string]review.Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reviews := make(map[string]review.Review)
+	if section := r.findSection(reportID, sectionID); section != nil {
+		for _, subsection := range section.subsections {
+			reviews[subsection.id] = copyReview(subsection.review)
+		}
+	}
+	return reviews, nil
+}
+
+func (r *MemoryRepository) ChangeSubsectionStatus(reportID, sectionID, subsectionID string, change review.Transition) (*review.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+	}
+	changed := copyReview(subsection.review)
+	if changed.Status != change.From {
+		return nil, fmt.Errorf("%w: %s is %s", ErrStatusChanged, subsectionID, changed.Status)
+	}
+	changed.Status = change.To
+	changed.History = append(changed.History, change)
+	subsection.review = copyReview(changed)
+	return &changed, nil
+}
+
+func (r *MemoryRepository) SetSubsectionReviewers(reportID, sectionID, subsectionID string, reviewers []string) (*review.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+	}
+	changed := copyReview(subsection.review)
+	changed.Reviewers = append([]string{}, reviewers...)
+	subsection.review = copyReview(changed)
+	return &changed, nil
+}
+
+// copyReview copies a stored review, filling in a draft for subsections that
+// have none
+func copyReview(stored review.Review) review.Review {
+	copied := review.New()
+	if stored.Status != "" {
+		copied.Status = stored.Status
+	}
+	copied.Reviewers = append(copied.Reviewers, stored.Reviewers...)
+	copied.History = append(copied.History, stored.History...)
+	return copied
+}
+
+func (r *MemoryRepository) GetMemberRole(uID, reportID string) (reportRoles.Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return reportRoles.None, fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	return link.role, nil
+}
+
+func (r *MemoryRepository) ListMembers(reportID string) ([]Member, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	members := []Member{}
+	if _, ok := r.reports[reportID]; !ok {
+		return members, nil
+	}
+	for uID, links := range r.links {
+		if link, ok := links[reportID]; ok {
+			members = append(members, Member{UID: uID, Role: link.role, JoinedAt: link.joinedAt})
+		}
+	}
+
+	// In the order they joined, same as Firestore
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].UID < members[j].UID
+	})
+	return members, nil
+}
+
+func (r *MemoryRepository) SetMemberRole(uID, reportID string, role reportRoles.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	if link.role == reportRoles.Owner && role != reportRoles.Owner && r.lastOwnerLocked(uID, reportID) {
+		return fmt.Errorf("%w: %s", ErrLastOwner, reportID)
+	}
+	link.role = role
+	return nil
+}
+
+func (r *MemoryRepository) TransferOwnership(reportID, fromUID, toUID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from, ok := r.links[fromUID][reportID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, fromUID)
+	}
+	if from.role != reportRoles.Owner {
+		return fmt.Errorf("%w: %s", ErrNotOwner, fromUID)
+	}
+	to, ok := r.links[toUID][reportID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, toUID)
+	}
+	if fromUID == toUID {
+		return nil
+	}
+
+	to.role = reportRoles.Owner
+	from.role = reportRoles.Admin
+	return nil
+}
+
+// lastOwnerLocked reports whether no one but uID owns a report
+func (r *MemoryRepository) lastOwnerLocked(uID, reportID string) bool {
+	for otherUID, links := range r.links {
+		if link, ok := links[reportID]; ok && otherUID != uID && link.role == reportRoles.Owner {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *MemoryRepository) GetSectionGrants(uID, reportID string) (map[string]sectionAccess.Level, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	grants := make(map[string]sectionAccess.Level, len(link.sections))
+	for sectionID, level := range link.sections {
+		grants[sectionID] = level
+	}
+	return grants, nil
+}
+
+func (r *MemoryRepository) SetSectionGrant(uID, reportID, sectionID string, level sectionAccess.Level) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	link.sections[sectionID] = level
+	return nil
+}
+
+func (r *MemoryRepository) RevokeSectionGrant(uID, reportID, sectionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	delete(link.sections, sectionID)
+	return nil
+}
+
+func (r *MemoryRepository) GetUserReportLinks(uID string) ([]Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var reports []Report
+	for reportID := range r.links[uID] {
+		report, ok := r.reports[reportID]
+		if !ok {
+			// Delete broken link
+			delete(r.links[uID], reportID)
+			continue
+		}
+
+		reports = append(reports, Report{
+			ReportID:        reportID,
+			ReportTitle:     report.reportName,
+			CreationTime:    report.creationTime,
+			TemplateID:      report.templateID,
+			TemplateVersion: report.templateVersion,
+		})
+	}
+
+	// Sort the reports slice by creationTime (descending)
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].CreationTime.After(reports[j].CreationTime)
+	})
+
+	return reports, nil
+}
+
+func (r *MemoryRepository) IsUserInReport(uID, reportID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.links[uID][reportID]
+	return ok, nil
+}
+
+func (r *MemoryRepository) IsAdminInReport(uID, reportID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return false, nil
+	}
+	return link.role.IsAdmin(), nil
+}
+
+func (r *MemoryRepository) RemoveUserFromReport(uID, reportID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[uID][reportID]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	if link.role == reportRoles.Owner && r.lastOwnerLocked(uID, reportID) {
+		return fmt.Errorf("%w: %s", ErrLastOwner, reportID)
+	}
+
+	delete(r.links[uID], reportID)
+	return nil
+}
+
+func (r *MemoryRepository) RenameReport(reportID, reportName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return fmt.Errorf("failed to rename report: report %s not found", reportID)
+	}
+
+	report.reportName = reportName
+	return nil
+}
+
+func (r *MemoryRepository) DeleteReport(reportID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deleteReportLocked(reportID)
+	return nil
+}
+
+func (r *MemoryRepository) DestroyUser(uID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Reports the user is the last owner of go with them
+	for reportID, link := range r.links[uID] {
+		if link.role == reportRoles.Owner && r.lastOwnerLocked(uID, reportID) {
+			r.deleteReportLocked(reportID)
+		}
+	}
+
+	delete(r.links, uID)
+	return nil
+}
+
+func (r *MemoryRepository) FetchLogsForReport(reportID string, query audit.Query) ([]audit.Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Paging resumes after the cursor's place in the order, like a Firestore
+	// cursor, whether or not it matches the filters
+	entries := r.logs[reportID]
+	start := 0
+	if query.After != "" {
+		start = -1
+		for i, event := range entries {
+			if event.ID == query.After {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrEventNotFound, query.After)
+		}
+	}
+
+	events := []audit.Event{}
+	for _, event := range entries[start:] {
+		if query.Limit > 0 && len(events) == query.Limit {
+			break
+		}
+		if query.Matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (r *MemoryRepository) FetchLogChain(reportID string) ([]audit.Event, audit.Head, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]audit.Event(nil), r.logs[reportID]...), r.logChains[reportID], nil
+}
+
+// logLocked chains and records an event, keeping the report's events ordered
+// by time and then ID as Firestore returns them
+func (r *MemoryRepository) logLocked(event audit.Event) {
+	event = stampEvent(event)
+	r.logChains[event.ReportID] = event.Link(r.logChains[event.ReportID])
+	entries := append(r.logs[event.ReportID], event)
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	r.logs[event.ReportID] = entries
+}
+
+// deleteReportLocked removes a report and records the deletion. Logs are kept,
+// matching Firestore where the logs subcollection outlives the report document.
+func (r *MemoryRepository) deleteReportLocked(reportID string) {
+	delete(r.reports, reportID)
+	for _, links := range r.links {
+		delete(links, reportID)
+	}
+	r.logLocked(audit.Event{ReportID: reportID, Action: audit.ReportDeleted, Message: "Report was deleted"})
+}
+
+func (r *MemoryRepository) findSection(reportID, sectionID string) *memorySection {
+	report, ok := r.reports[reportID]
+	if !ok {
+		return nil
+	}
+
+	for _, section := range report.sections {
+		if section.id == sectionID {
+			return section
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) findSubsection(reportID, sectionID, subsectionID string) *memorySubsection {
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return nil
+	}
+
+	for _, subsection := range section.subsections {
+		if subsection.id == subsectionID {
+			return subsection
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) FetchReportStructure(reportID string) ([]reportStructure.Section, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return nil, fmt.Errorf("failed to fetch report: report %s not found", reportID)
+	}
+
+	sections := []reportStructure.Section{}
+	for _, section := range report.sections {
+		structure := reportStructure.Section{ID: section.id, Title: section.title, Subsections: []reportStructure.Subsection{}}
+		for _, subsection := range section.subsections {
+			structure.Subsections = append(structure.Subsections, reportStructure.Subsection{ID: subsection.id, Title: subsection.title})
+		}
+		sections = append(sections, structure)
+	}
+	return sections, nil
+}
+
+func (r *MemoryRepository) AddSection(reportID, title string, position int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, ok := r.reports[reportID]
+	if !ok {
+		return "", fmt.Errorf("%w: report %s", ErrSectionNotFound, reportID)
+	}
+	for _, section := range report.sections {
+		if sameTitle(section.title, title) {
+			return "", fmt.Errorf("%w: %s", ErrSectionExists, title)
+		}
+	}
+
+	section := &memorySection{id: newID(), title: title}
+	report.sections = insertAt(report.sections, section, position)
+	return section.id, nil
+}
+
+func (r *MemoryRepository) AddSubsection(reportID, sectionID, title string, position int) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return "", fmt.Errorf("%w: %s", ErrSectionNotFound, sectionID)
+	}
+	if subsectionTitleTaken(section, title, nil) {
+		return "", fmt.Errorf("%w: %s", ErrSectionExists, title)
+	}
+
+	subsection := &memorySubsection{id: newID(), title: title}
+	section.subsections = insertAt(section.subsections, subsection, position)
+	return subsection.id, nil
+}
+
+func (r *MemoryRepository) DeleteSection(reportID, sectionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, sectionID)
+	}
+
+	report := r.reports[reportID]
+	report.sections = removeFrom(report.sections, section)
+	return nil
+}
+
+func (r *MemoryRepository) DeleteSubsection(reportID, sectionID, subsectionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+	}
+
+	section := r.findSection(reportID, sectionID)
+	section.subsections = removeFrom(section.subsections, subsection)
+	return nil
+}
+
+func (r *MemoryRepository) MoveSection(reportID, sectionID string, position int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, sectionID)
+	}
+
+	report := r.reports[reportID]
+	report.sections = removeFrom(report.sections, section)
+	report.sections = insertAt(report.sections, section, position)
+	return nil
+}
+
+func (r *MemoryRepository) MoveSubsection(reportID, sectionID, subsectionID, toSectionID string, position int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+	}
+	to := r.findSection(reportID, toSectionID)
+	if to == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, toSectionID)
+	}
+
+	from := r.findSection(reportID, sectionID)
+	if subsectionTitleTaken(to, subsection.title, subsection) {
+		return fmt.Errorf("%w: %s", ErrSectionExists, subsection.title)
+	}
+
+	from.subsections = removeFrom(from.subsections, subsection)
+	to.subsections = insertAt(to.subsections, subsection, position)
+	return nil
+}
+
+func (r *MemoryRepository) RenameSection(reportID, sectionID, title string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	section := r.findSection(reportID, sectionID)
+	if section == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, sectionID)
+	}
+	for _, existing := range r.reports[reportID].sections {
+		if existing != section && sameTitle(existing.title, title) {
+			return fmt.Errorf("%w: %s", ErrSectionExists, title)
+		}
+	}
+
+	section.title = title
+	return nil
+}
+
+func (r *MemoryRepository) RenameSubsection(reportID, sectionID, subsectionID, title string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subsection := r.findSubsection(reportID, sectionID, subsectionID)
+	if subsection == nil {
+		return fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+	}
+	if subsectionTitleTaken(r.findSection(reportID, sectionID), title, subsection) {
+		return fmt.Errorf("%w: %s", ErrSectionExists, title)
+	}
+
+	subsection.title = title
+	return nil
+}
+
+// subsectionTitleTaken reports whether a subsection other than except has the title
+func subsectionTitleTaken(section *memorySection, title string, except *memorySubsection) bool {
+	for _, existing := range section.subsections {
+		if existing != except && sameTitle(existing.title, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertAt inserts an item at position, or at the end when position is out of range
+func insertAt[T any](items []T, item T, position int) []T {
+	if position < 0 || position > len(items) {
+		position = len(items)
+	}
+	items = append(items, item)
+	copy(items[position+1:], items[position:])
+	items[position] = item
+	return items
+}
+
+// removeFrom removes an item, keeping the order of the others
+func removeFrom[T comparable](items []T, item T) []T {
+	for i, existing := range items {
+		if existing == item {
+			return append(items[:i], items[i+1:]...)
+		}
+	}
+	return items
+}
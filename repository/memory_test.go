@@ -0,0 +1,558 @@
+package repository_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/audit"
+	"sema/models/comment"
+	"sema/models/invitation"
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
+	"sema/models/reportTemplates"
+	"sema/models/review"
+	"sema/repository"
+	"sema/services/sectionAccess"
+)
+
+func setupMemoryRepo(t *testing.T) *repository.MemoryRepository {
+	repo := repository.NewMemoryRepository()
+	repo.AddTemplate("template123", reportTemplates.ReportTemplate{
+		Sections: []reportTemplates.Section{
+			{Title: "Introduction", Subsections: []string{"Overview", "Scope"}},
+			{Title: "Design/Architecture", Subsections: []string{"Components"}},
+		},
+	})
+
+	err := repo.CreateReport("Test Report", "report1", "template123", "test@example.com")
+	assert.NoError(t, err)
+	return repo
+}
+
+// structureIDs looks up the IDs of a section and one of its subsections by title
+func structureIDs(t *testing.T, repo repository.ReportRepository, reportID, section, subsection string) (string, string) {
+	t.Helper()
+	sections, err := repo.FetchReportStructure(reportID)
+	assert.NoError(t, err)
+	for _, s := range sections {
+		if s.Title != section {
+			continue
+		}
+		for _, sub := range s.Subsections {
+			if sub.Title == subsection {
+				return s.ID, sub.ID
+			}
+		}
+		return s.ID, ""
+	}
+	t.Fatalf("section %s not found in report %s", section, reportID)
+	return "", ""
+}
+
+// titles drops the IDs from a report's structure
+func titles(sections []reportStructure.Section) []reportTemplates.Section {
+	titled := make([]reportTemplates.Section, len(sections))
+	for i, section := range sections {
+		titled[i] = reportTemplates.Section{Title: section.Title, Subsections: []string{}}
+		for _, subsection := range section.Subsections {
+			titled[i].Subsections = append(titled[i].Subsections, subsection.Title)
+		}
+	}
+	return titled
+}
+
+func TestMemoryCreateAndFetchReportContent(t *testing.T) {
+	repo := setupMemoryRepo(t)
+
+	templateID, err := repo.GetReportFieldTemplateID("report1")
+	assert.NoError(t, err)
+	assert.Equal(t, "template123", templateID)
+
+	name, content, err := repo.FetchReportContent("report1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Test Report", name)
+	assert.Len(t, content, 2)
+	assert.Equal(t, "Introduction", content[0]["sectionTitle"])
+	assert.Equal(t, "Design/Architecture", content[1]["sectionTitle"])
+
+	subsections := content[0]["subsections"].([]map[string]interface{})
+	assert.Equal(t, "Overview", subsections[0]["title"])
+	assert.Equal(t, "Scope", subsections[1]["title"])
+
+	sectionID, subsectionID := structureIDs(t, repo, "report1", "Introduction", "Overview")
+	assert.Equal(t, sectionID, content[0]["sectionID"])
+	assert.Equal(t, subsectionID, subsections[0]["id"])
+
+	err = repo.CreateReport("Bad", "report2", "missing-template", "test@example.com")
+	assert.Error(t, err)
+}
+
+func TestMemoryUpdateAndFetchSectionContents(t *testing.T) {
+	repo := setupMemoryRepo(t)
+
+	sectionID, subsectionID := structureIDs(t, repo, "report1", "Design/Architecture", "Components")
+	err := repo.UpdateReportSectionContents("report1", sectionID, subsectionID, "content", "test@example.com")
+	assert.NoError(t, err)
+
+	contents, err := repo.FetchReportSectionContents("report1", sectionID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{subsectionID: "content"}, contents)
+
+	// Subsections are found by ID, not title
+	err = repo.UpdateReportSectionContents("report1", sectionID, "Components", "content", "test@example.com")
+	assert.Error(t, err)
+}
+
+func TestMemorySubsectionVersions(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	introduction, overview := structureIDs(t, repo, "report1", "Introduction", "Overview")
+
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "first", "alice@example.com"))
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "second draft", "bob@example.com"))
+
+	versions, err := repo.ListSubsectionVersions("report1", introduction, overview, repository.VersionPage{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "bob@example.com", versions[0].Author)
+	assert.Equal(t, len("second draft"), versions[0].Size)
+	assert.Empty(t, versions[0].Content)
+	assert.Equal(t, "alice@example.com", versions[1].Author)
+
+	version, err := repo.GetSubsectionVersion("report1", introduction, overview, versions[1].VersionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", version.Content)
+
+	_, err = repo.GetSubsectionVersion("report1", introduction, overview, "missing")
+	assert.ErrorIs(t, err, repository.ErrVersionNotFound)
+
+	// Saving the same content keeps no version, and the author of the latest
+	// version keeps saving into it for a while
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "second draft", "alice@example.com"))
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "third draft", "bob@example.com"))
+	versions, err = repo.ListSubsectionVersions("report1", introduction, overview, repository.VersionPage{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, len("third draft"), versions[0].Size)
+	assert.False(t, versions[0].Timestamp.Before(versions[0].Created))
+	version, err = repo.GetSubsectionVersion("report1", introduction, overview, versions[0].VersionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "third draft", version.Content)
+
+	// Versions come a page at a time
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "fourth draft", "carol@example.com"))
+	page, err := repo.ListSubsectionVersions("report1", introduction, overview, repository.VersionPage{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	assert.Equal(t, "carol@example.com", page[0].Author)
+	page, err = repo.ListSubsectionVersions("report1", introduction, overview, repository.VersionPage{After: page[1].VersionID, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "alice@example.com", page[0].Author)
+	_, err = repo.ListSubsectionVersions("report1", introduction, overview, repository.VersionPage{After: "missing"})
+	assert.ErrorIs(t, err, repository.ErrVersionNotFound)
+}
+
+func TestMemoryLinkAndRemoveUser(t *testing.T) {
+	repo := setupMemoryRepo(t)
+
+	assert.NoError(t, repo.LinkReportWithUser("owner", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("member", "report1", reportRoles.Editor))
+
+	isAdmin, _ := repo.IsAdminInReport("owner", "report1")
+	assert.True(t, isAdmin)
+	isAdmin, _ = repo.IsAdminInReport("member", "report1")
+	assert.False(t, isAdmin)
+
+	// Roles are changed with SetMemberRole, not by adding a member again
+	assert.ErrorIs(t, repo.LinkReportWithUser("member", "report1", reportRoles.Admin), repository.ErrAlreadyInReport)
+
+	// Admins can be demoted and removed
+	assert.NoError(t, repo.SetMemberRole("member", "report1", reportRoles.Admin))
+	isAdmin, _ = repo.IsAdminInReport("member", "report1")
+	assert.True(t, isAdmin)
+	assert.NoError(t, repo.SetMemberRole("member", "report1", reportRoles.Viewer))
+	role, err := repo.GetMemberRole("member", "report1")
+	assert.NoError(t, err)
+	assert.Equal(t, reportRoles.Viewer, role)
+
+	assert.NoError(t, repo.RemoveUserFromReport("member", "report1"))
+	inReport, _ := repo.IsUserInReport("member", "report1")
+	assert.False(t, inReport)
+	assert.ErrorIs(t, repo.RemoveUserFromReport("member", "report1"), repository.ErrUserNotInReport)
+	_, err = repo.GetMemberRole("member", "report1")
+	assert.ErrorIs(t, err, repository.ErrUserNotInReport)
+}
+
+func TestMemoryReportKeepsAnOwner(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	assert.ErrorIs(t, repo.LinkReportWithUser("owner", "missing", reportRoles.Owner), repository.ErrReportNotFound)
+	assert.NoError(t, repo.LinkReportWithUser("owner", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("member", "report1", reportRoles.Editor))
+
+	assert.ErrorIs(t, repo.SetMemberRole("owner", "report1", reportRoles.Admin), repository.ErrLastOwner)
+	assert.ErrorIs(t, repo.RemoveUserFromReport("owner", "report1"), repository.ErrLastOwner)
+
+	// With a second owner the first can step down
+	assert.NoError(t, repo.SetMemberRole("member", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.SetMemberRole("owner", "report1", reportRoles.Admin))
+	assert.ErrorIs(t, repo.RemoveUserFromReport("member", "report1"), repository.ErrLastOwner)
+}
+
+func TestMemoryTransferOwnership(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	assert.NoError(t, repo.LinkReportWithUser("owner", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("member", "report1", reportRoles.Viewer))
+
+	assert.ErrorIs(t, repo.TransferOwnership("report1", "member", "owner"), repository.ErrNotOwner)
+	assert.ErrorIs(t, repo.TransferOwnership("report1", "owner", "stranger"), repository.ErrUserNotInReport)
+
+	assert.NoError(t, repo.TransferOwnership("report1", "owner", "member"))
+	role, _ := repo.GetMemberRole("member", "report1")
+	assert.Equal(t, reportRoles.Owner, role)
+	role, _ = repo.GetMemberRole("owner", "report1")
+	assert.Equal(t, reportRoles.Admin, role)
+}
+
+func TestMemoryDestroyUserDeletesOwnedReports(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	assert.NoError(t, repo.LinkReportWithUser("owner", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("member", "report1", reportRoles.Editor))
+
+	reports, err := repo.GetUserReportLinks("member")
+	assert.NoError(t, err)
+	assert.Len(t, reports, 1)
+
+	assert.NoError(t, repo.DestroyUser("owner"))
+
+	_, _, err = repo.FetchReportContent("report1")
+	assert.Error(t, err)
+
+	// Broken links are dropped
+	reports, err = repo.GetUserReportLinks("member")
+	assert.NoError(t, err)
+	assert.Len(t, reports, 0)
+}
+
+func TestMemoryLogs(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	repo.BufferLog(audit.Event{ReportID: "report1", Actor: "user@example.com", Action: audit.ContentEdited, SectionID: "s1", Message: "edited a section"})
+
+	events, err := repo.FetchLogsForReport("report1", audit.Query{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, audit.ReportCreated, events[0].Action)
+	assert.Equal(t, "test@example.com", events[0].Actor)
+	assert.NotEmpty(t, events[1].ID)
+	assert.Equal(t, "s1", events[1].SectionID)
+	assert.Contains(t, events[1].String(), "user@example.com edited a section")
+
+	assert.NoError(t, repo.DeleteReport("report1"))
+	events, _ = repo.FetchLogsForReport("report1", audit.Query{})
+	assert.Equal(t, audit.ReportDeleted, events[len(events)-1].Action)
+	assert.Contains(t, events[len(events)-1].String(), "Report was deleted")
+
+	// Every entry is chained, deletion included
+	events, head, err := repo.FetchLogChain("report1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), head.Seq)
+	assert.True(t, audit.Verify(events, head).Intact)
+}
+
+func TestMemoryLogQueries(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	start := time.Now().Add(time.Minute).Truncate(time.Second)
+	for i := 0; i < 5; i++ {
+		actor := "alice@example.com"
+		if i%2 == 1 {
+			actor = "bob@example.com"
+		}
+		repo.BufferLog(audit.Event{ReportID: "report1", Time: start.Add(time.Duration(i) * time.Second), Actor: actor, Action: audit.ContentEdited, Message: fmt.Sprint(i)})
+	}
+
+	events, err := repo.FetchLogsForReport("report1", audit.Query{Actor: "bob@example.com"})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	events, _ = repo.FetchLogsForReport("report1", audit.Query{Action: audit.ReportCreated})
+	assert.Len(t, events, 1)
+
+	events, _ = repo.FetchLogsForReport("report1", audit.Query{From: start.Add(time.Second), To: start.Add(3 * time.Second)})
+	assert.Len(t, events, 2)
+	assert.Equal(t, "1", events[0].Message)
+
+	// Pages resume after the cursor, even one the filters leave out
+	page, _ := repo.FetchLogsForReport("report1", audit.Query{Action: audit.ContentEdited, Limit: 2})
+	assert.Len(t, page, 2)
+	page, _ = repo.FetchLogsForReport("report1", audit.Query{Action: audit.ContentEdited, After: page[1].ID, Limit: 2})
+	assert.Equal(t, "2", page[0].Message)
+	page, _ = repo.FetchLogsForReport("report1", audit.Query{Actor: "alice@example.com", After: page[1].ID})
+	assert.Len(t, page, 1)
+	assert.Equal(t, "4", page[0].Message)
+
+	_, err = repo.FetchLogsForReport("report1", audit.Query{After: "missing"})
+	assert.ErrorIs(t, err, repository.ErrEventNotFound)
+}
+
+func TestMemoryTemplateVersions(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	template := reportTemplates.ReportTemplate{
+		Name:     "Standard",
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	}
+
+	saved, err := repo.CreateTemplate("standard", template, "admin@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, saved.Version)
+	_, err = repo.CreateTemplate("standard", template, "admin@example.com")
+	assert.ErrorIs(t, err, repository.ErrTemplateExists)
+
+	assert.NoError(t, repo.CreateReport("Report", "report1", "standard", "user@example.com"))
+
+	template.Sections[0].Subsections = append(template.Sections[0].Subsections, "Scope")
+	saved, err = repo.UpdateTemplate("standard", template, "admin@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, saved.Version)
+	_, err = repo.UpdateTemplate("missing", template, "admin@example.com")
+	assert.ErrorIs(t, err, repository.ErrTemplateNotFound)
+
+	versions, err := repo.ListTemplateVersions("standard")
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Version)
+
+	first, err := repo.GetTemplateVersion("standard", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Overview"}, first.Sections[0].Subsections)
+
+	// The report keeps the outline and version it was created from
+	_, content, _ := repo.FetchReportContent("report1")
+	assert.Len(t, content[0]["subsections"], 1)
+	assert.NoError(t, repo.LinkReportWithUser("user1", "report1", reportRoles.Editor))
+	reports, _ := repo.GetUserReportLinks("user1")
+	assert.Equal(t, 1, reports[0].TemplateVersion)
+
+	isAdmin, _ := repo.IsTemplateAdmin("user1")
+	assert.False(t, isAdmin)
+	repo.AddAdmin("user1")
+	isAdmin, _ = repo.IsTemplateAdmin("user1")
+	assert.True(t, isAdmin)
+}
+
+func TestMemoryReportStructure(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	introduction, overview := structureIDs(t, repo, "report1", "Introduction", "Overview")
+	_, scope := structureIDs(t, repo, "report1", "Introduction", "Scope")
+	design, _ := structureIDs(t, repo, "report1", "Design/Architecture", "")
+	assert.NoError(t, repo.UpdateReportSectionContents("report1", introduction, overview, "content", "alice@example.com"))
+
+	evaluation, err := repo.AddSection("report1", "Evaluation", 1)
+	assert.NoError(t, err)
+	results, err := repo.AddSubsection("report1", evaluation, "Results", -1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, evaluation, results)
+	_, err = repo.AddSection("report1", "introduction ", 0)
+	assert.ErrorIs(t, err, repository.ErrSectionExists)
+	_, err = repo.AddSubsection("report1", "Missing", "Results", 0)
+	assert.ErrorIs(t, err, repository.ErrSectionNotFound)
+
+	// Renaming and moving keep IDs, content and versions
+	assert.NoError(t, repo.RenameSubsection("report1", introduction, overview, "Summary"))
+	assert.NoError(t, repo.MoveSubsection("report1", introduction, overview, evaluation, 0))
+	assert.NoError(t, repo.RenameSection("report1", evaluation, "Evaluation Results"))
+	assert.NoError(t, repo.MoveSection("report1", evaluation, 0))
+	assert.ErrorIs(t, repo.RenameSection("report1", introduction, "Design/Architecture"), repository.ErrSectionExists)
+
+	contents, err := repo.FetchReportSectionContents("report1", evaluation)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", contents[overview])
+	versions, err := repo.ListSubsectionVersions("report1", evaluation, overview, repository.VersionPage{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	// Titles that would once have collided as document IDs don't
+	_, err = repo.AddSubsection("report1", introduction, "A/B", -1)
+	assert.NoError(t, err)
+	_, err = repo.AddSubsection("report1", introduction, "A_B", -1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.DeleteSubsection("report1", introduction, scope))
+	assert.NoError(t, repo.DeleteSection("report1", design))
+	assert.ErrorIs(t, repo.DeleteSection("report1", design), repository.ErrSectionNotFound)
+
+	sections, err := repo.FetchReportStructure("report1")
+	assert.NoError(t, err)
+	assert.Equal(t, []reportTemplates.Section{
+		{Title: "Evaluation Results", Subsections: []string{"Summary", "Results"}},
+		{Title: "Introduction", Subsections: []string{"A/B", "A_B"}},
+	}, titles(sections))
+	assert.Equal(t, evaluation, sections[0].ID)
+	assert.Equal(t, []reportStructure.Subsection{{ID: overview, Title: "Summary"}, {ID: results, Title: "Results"}}, sections[0].Subsections)
+}
+
+func TestMemorySectionGrants(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	assert.NoError(t, repo.LinkReportWithUser("member", "report1", reportRoles.Editor))
+	introduction, _ := structureIDs(t, repo, "report1", "Introduction", "")
+
+	assert.NoError(t, repo.SetSectionGrant("member", "report1", introduction, sectionAccess.Edit))
+	grants, err := repo.GetSectionGrants("member", "report1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]sectionAccess.Level{introduction: sectionAccess.Edit}, grants)
+
+	// Changing a member's role keeps their grants
+	assert.NoError(t, repo.SetMemberRole("member", "report1", reportRoles.Viewer))
+	grants, _ = repo.GetSectionGrants("member", "report1")
+	assert.Len(t, grants, 1)
+
+	assert.NoError(t, repo.RevokeSectionGrant("member", "report1", introduction))
+	grants, _ = repo.GetSectionGrants("member", "report1")
+	assert.Empty(t, grants)
+
+	assert.ErrorIs(t, repo.SetSectionGrant("stranger", "report1", introduction, sectionAccess.Read), repository.ErrUserNotInReport)
+	_, err = repo.GetSectionGrants("stranger", "report1")
+	assert.ErrorIs(t, err, repository.ErrUserNotInReport)
+}
+
+func TestMemoryDestroyUserKeepsCoOwnedReports(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	assert.NoError(t, repo.LinkReportWithUser("owner", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("coowner", "report1", reportRoles.Owner))
+
+	assert.NoError(t, repo.DestroyUser("owner"))
+
+	_, _, err := repo.FetchReportContent("report1")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, repo.RemoveUserFromReport("coowner", "report1"), repository.ErrLastOwner)
+
+	// Deleting the report takes the remaining links with it
+	assert.NoError(t, repo.DeleteReport("report1"))
+	_, err = repo.GetMemberRole("coowner", "report1")
+	assert.ErrorIs(t, err, repository.ErrUserNotInReport)
+	assert.NoError(t, repo.DestroyUser("coowner"))
+}
+
+func TestMemoryInvitations(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	now := time.Now()
+
+	invitationID, err := repo.CreateInvitation(invitation.New("report1", "Evaluator@example.com", reportRoles.Viewer, "admin@example.com", now))
+	assert.NoError(t, err)
+	_, err = repo.CreateInvitation(invitation.New("report1", "evaluator@example.com", reportRoles.Editor, "admin@example.com", now))
+	assert.ErrorIs(t, err, repository.ErrInvitationExists)
+
+	invitations, err := repo.FindInvitations("EVALUATOR@example.com")
+	assert.NoError(t, err)
+	assert.Len(t, invitations, 1)
+	assert.Equal(t, invitationID, invitations[0].ID)
+
+	renewed, err := repo.RenewInvitation("report1", invitationID, "new-token", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "new-token", renewed.Token)
+	_, err = repo.RenewInvitation("report1", "missing", "token", now)
+	assert.ErrorIs(t, err, repository.ErrInvitationNotFound)
+
+	assert.NoError(t, repo.AcceptInvitation("evaluator", *renewed))
+	role, err := repo.GetMemberRole("evaluator", "report1")
+	assert.NoError(t, err)
+	assert.Equal(t, reportRoles.Viewer, role)
+	invitations, _ = repo.ListInvitations("report1")
+	assert.Empty(t, invitations)
+	assert.ErrorIs(t, repo.AcceptInvitation("evaluator", *renewed), repository.ErrInvitationNotFound)
+
+	invitationID, err = repo.CreateInvitation(invitation.New("report1", "other@example.com", reportRoles.Editor, "admin@example.com", now))
+	assert.NoError(t, err)
+	revoked, err := repo.RevokeInvitation("report1", invitationID)
+	assert.NoError(t, err)
+	assert.Equal(t, "other@example.com", revoked.Email)
+	invitations, _ = repo.FindInvitations("other@example.com")
+	assert.Empty(t, invitations)
+}
+
+func TestMemoryListMembers(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	assert.NoError(t, repo.LinkReportWithUser("owner", "report1", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("member", "report1", reportRoles.Viewer))
+
+	members, err := repo.ListMembers("report1")
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+
+	assert.NoError(t, repo.TransferOwnership("report1", "owner", "member"))
+	assert.NoError(t, repo.RemoveUserFromReport("owner", "report1"))
+	members, _ = repo.ListMembers("report1")
+	assert.Len(t, members, 1)
+	assert.Equal(t, "member", members[0].UID)
+	assert.Equal(t, reportRoles.Owner, members[0].Role)
+
+	// Deleting the last owner's account deletes the report and its members
+	assert.NoError(t, repo.DestroyUser("member"))
+	members, _ = repo.ListMembers("report1")
+	assert.Empty(t, members)
+}
+
+func TestMemoryCommentThreads(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	introduction, overview := structureIDs(t, repo, "report1", "Introduction", "Overview")
+	design, _ := structureIDs(t, repo, "report1", "Design/Architecture", "")
+	now := time.Now()
+
+	threadID, err := repo.CreateCommentThread(comment.NewThread("report1", overview, comment.Anchor{Index: 2, Length: 4}, "test@example.com", "Typo", now))
+	assert.NoError(t, err)
+
+	thread, err := repo.AddCommentReply("report1", threadID, comment.NewReply("other@example.com", "Fixed", now))
+	assert.NoError(t, err)
+	assert.Len(t, thread.Replies, 1)
+	thread, err = repo.SetCommentResolved("report1", threadID, true, "other@example.com", now)
+	assert.NoError(t, err)
+	assert.True(t, thread.Resolved)
+	assert.NoError(t, repo.UpdateCommentAnchors("report1", map[string]comment.Anchor{threadID: {Index: 5, Length: 4}, "gone": {}}))
+
+	// Threads handed out are copies
+	thread.Replies[0].Body = "Changed"
+
+	threads, err := repo.FetchCommentThreads("report1", introduction)
+	assert.NoError(t, err)
+	assert.Len(t, threads, 1)
+	assert.Equal(t, comment.Anchor{Index: 5, Length: 4}, threads[0].Anchor)
+	assert.Equal(t, "Fixed", threads[0].Replies[0].Body)
+	assert.Equal(t, "other@example.com", threads[0].ResolvedBy)
+	threads, _ = repo.FetchCommentThreads("report1", design)
+	assert.Empty(t, threads)
+
+	_, err = repo.GetCommentThread("report1", "missing")
+	assert.ErrorIs(t, err, repository.ErrCommentNotFound)
+	_, err = repo.AddCommentReply("report1", "missing", comment.NewReply("other@example.com", "Hi", now))
+	assert.ErrorIs(t, err, repository.ErrCommentNotFound)
+}
+
+func TestMemorySubsectionReviews(t *testing.T) {
+	repo := setupMemoryRepo(t)
+	sectionID, overview := structureIDs(t, repo, "report1", "Introduction", "Overview")
+	now := time.Now()
+
+	reviews, err := repo.FetchSectionReviews("report1", sectionID)
+	assert.NoError(t, err)
+	assert.Len(t, reviews, 2)
+	assert.Equal(t, review.Draft, reviews[overview].Status)
+
+	submit := review.Transition{From: review.Draft, To: review.InternalReview, By: "test@example.com", At: now}
+	changed, err := repo.ChangeSubsectionStatus("report1", sectionID, overview, submit)
+	assert.NoError(t, err)
+	assert.Equal(t, review.InternalReview, changed.Status)
+	_, err = repo.ChangeSubsectionStatus("report1", sectionID, overview, submit)
+	assert.ErrorIs(t, err, repository.ErrStatusChanged)
+
+	_, err = repo.SetSubsectionReviewers("report1", sectionID, overview, []string{"eve@example.com"})
+	assert.NoError(t, err)
+	stored, err := repo.GetSubsectionReview("report1", sectionID, overview)
+	assert.NoError(t, err)
+	assert.Equal(t, review.InternalReview, stored.Status)
+	assert.Equal(t, []string{"eve@example.com"}, stored.Reviewers)
+	assert.Len(t, stored.History, 1)
+	assert.Equal(t, "test@example.com", stored.History[0].By)
+
+	_, err = repo.GetSubsectionReview("report1", sectionID, "missing")
+	assert.ErrorIs(t, err, repository.ErrSectionNotFound)
+}
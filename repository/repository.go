@@ -2,18 +2,27 @@ package repository
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"sema/models/audit"
+	"sema/models/comment"
+	"sema/models/invitation"
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
 	"sema/models/reportTemplates"
+	"sema/models/review"
 	"sema/services/firebase"
+	"sema/services/sectionAccess"
 
 	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -23,25 +32,100 @@ type ReportRepository interface {
 	IsUserInReport(uid, reportID string) (bool, error)
 	IsAdminInReport(uid, reportID string) (bool, error)
 	GetUserReportLinks(uid string) ([]Report, error)
-	LinkReportWithUser(uID, reportID string, privilege bool, ownership bool) error
+	LinkReportWithUser(uID, reportID string, role reportRoles.Role) error
+	CreateInvitation(invite invitation.Invitation) (string, error)
+	ListInvitations(reportID string) ([]invitation.Invitation, error)
+	RenewInvitation(reportID, invitationID, token string, expiresAt time.Time) (*invitation.Invitation, error)
+	RevokeInvitation(reportID, invitationID string) (*invitation.Invitation, error)
+	FindInvitations(email string) ([]invitation.Invitation, error)
+	AcceptInvitation(uID string, invite invitation.Invitation) error
+	CreateCommentThread(thread comment.Thread) (string, error)
+	FetchCommentThreads(reportID, sectionID string) ([]comment.Thread, error)
+	GetCommentThread(reportID, threadID string) (*comment.Thread, error)
+	AddCommentReply(reportID, threadID string, reply comment.Reply) (*comment.Thread, error)
+	SetCommentResolved(reportID, threadID string, resolved bool, by string, at time.Time) (*comment.Thread, error)
+	UpdateCommentAnchors(reportID string, anchors map[string]comment.Anchor) error
+	GetSubsectionReview(reportID, sectionID, subsectionID string) (*review.Review, error)
+	FetchSectionReviews(reportID, sectionID string) (map[string]review.Review, error)
+	ChangeSubsectionStatus(reportID, sectionID, subsectionID string, change review.Transition) (*review.Review, error)
+	SetSubsectionReviewers(reportID, sectionID, subsectionID string, reviewers []string) (*review.Review, error)
+	GetMemberRole(uID, reportID string) (reportRoles.Role, error)
+	ListMembers(reportID string) ([]Member, error)
+	SetMemberRole(uID, reportID string, role reportRoles.Role) error
+	TransferOwnership(reportID, fromUID, toUID string) error
+	GetSectionGrants(uID, reportID string) (map[string]sectionAccess.Level, error)
+	SetSectionGrant(uID, reportID, sectionID string, level sectionAccess.Level) error
+	RevokeSectionGrant(uID, reportID, sectionID string) error
 	GetReportFieldTemplateID(reportID string) (string, error)
 	GetTemplate(templateID string) (*reportTemplates.ReportTemplate, error)
+	IsTemplateAdmin(uid string) (bool, error)
+	ListTemplates() ([]TemplateSummary, error)
+	CreateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error)
+	UpdateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error)
+	ListTemplateVersions(templateID string) ([]TemplateSummary, error)
+	GetTemplateVersion(templateID string, version int) (*reportTemplates.ReportTemplate, error)
 	CreateReport(reportName, reportID, templateID, userEmail string) error
 	FetchReportContent(reportID string) (string, []map[string]interface{}, error)
-	FetchLogsForReport(reportID string) ([]string, error)
+	FetchReportStructure(reportID string) ([]reportStructure.Section, error)
+	GetReportTemplateVersion(reportID string) (int, error)
+	SetReportTemplateVersion(reportID string, version int) error
+	AddSection(reportID, title string, position int) (string, error)
+	AddSubsection(reportID, sectionID, title string, position int) (string, error)
+	DeleteSection(reportID, sectionID string) error
+	DeleteSubsection(reportID, sectionID, subsectionID string) error
+	MoveSection(reportID, sectionID string, position int) error
+	MoveSubsection(reportID, sectionID, subsectionID, toSectionID string, position int) error
+	RenameSection(reportID, sectionID, title string) error
+	RenameSubsection(reportID, sectionID, subsectionID, title string) error
+	FetchLogsForReport(reportID string, query audit.Query) ([]audit.Event, error)
+	FetchLogChain(reportID string) ([]audit.Event, audit.Head, error)
 	RemoveUserFromReport(uID, reportID string) error
 	RenameReport(reportID, reportName string) error
 	DeleteReport(reportID string) error
 	DestroyUser(uID string) error
-	FetchReportSectionContents(reportID, sectionTitle string) (map[string]string, error)
-	UpdateReportSectionContents(reportID, sectionTitle, subsectionTitle, newContent string) error
-	BufferLog(reportID, message, user string)
+	FetchReportSectionContents(reportID, sectionID string) (map[string]string, error)
+	UpdateReportSectionContents(reportID, sectionID, subsectionID, newContent, author string) error
+	ListSubsectionVersions(reportID, sectionID, subsectionID string, page VersionPage) ([]SubsectionVersion, error)
+	GetSubsectionVersion(reportID, sectionID, subsectionID, versionID string) (*SubsectionVersion, error)
+	BufferLog(event audit.Event)
 }
 
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrTemplateExists   = errors.New("template already exists")
+
+	// Also returned for subsections. Titles are unique among siblings,
+	// ignoring case and surrounding spaces, so they can be referred to.
+	ErrSectionNotFound = errors.New("section not found")
+	ErrSectionExists   = errors.New("section already exists")
+
+	ErrUserNotInReport = errors.New("user is not in report")
+	ErrAlreadyInReport = errors.New("user is already in report")
+	ErrNotOwner        = errors.New("user is not an owner of report")
+	ErrLastOwner       = errors.New("report must keep an owner")
+
+	// Only one invitation is pending per email in a report
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExists   = errors.New("invitation already exists")
+
+	ErrCommentNotFound = errors.New("comment thread not found")
+
+	ErrReportNotFound = errors.New("report not found")
+
+	// Returned when a subsection is no longer in the status a change is from
+	ErrStatusChanged = errors.New("subsection status changed")
+
+	// Returned for a page cursor that names no event of the report
+	ErrEventNotFound = errors.New("audit event not found")
+
+	// Also returned for a page cursor that names no version of the subsection
+	ErrVersionNotFound = errors.New("subsection version not found")
+)
+
 type FirestoreRepository struct {
 	Client     *firestore.Client
 	Ctx        context.Context
-	logBuffers map[string][]map[string]interface{}
+	logBuffers map[string][]audit.Event
 	logMu      sync.Mutex
 }
 
@@ -58,7 +142,7 @@ func NewFirestoreRepository(firebaseApp *firebase.FirebaseApp, projectID string)
 	repo := &FirestoreRepository{
 		Client:     firestoreClient,
 		Ctx:        ctx,
-		logBuffers: make(map[string][]map[string]interface{}),
+		logBuffers: make(map[string][]audit.Event),
 	}
 
 	// Start periodic log flushing
@@ -67,19 +151,15 @@ func NewFirestoreRepository(firebaseApp *firebase.FirebaseApp, projectID string)
 	return repo, nil
 }
 
-func (r *FirestoreRepository) BufferLog(reportID, message, user string) {
+func (r *FirestoreRepository) BufferLog(event audit.Event) {
 	r.logMu.Lock()
 	defer r.logMu.Unlock()
 
-	entry := map[string]interface{}{
-		"timestamp": time.Now(),
-		"message":   user + " " + message,
-		"userID":    user,
-	}
-	r.logBuffers[reportID] = append(r.logBuffers[reportID], entry)
+	event = stampEvent(event)
+	r.logBuffers[event.ReportID] = append(r.logBuffers[event.ReportID], event)
 
-	if len(r.logBuffers[reportID]) >= 10 {
-		go r.FlushLogs(reportID)
+	if len(r.logBuffers[event.ReportID]) >= 10 {
+		go r.FlushLogs(event.ReportID)
 	}
 }
 
@@ -93,20 +173,59 @@ func (r *FirestoreRepository) FlushLogs(reportID string) {
 		return
 	}
 
-	batch := r.Client.Batch()
+	if err := r.appendLogs(reportID, logs); err != nil {
+		log.Printf("Failed to flush logs for report %s: %v", reportID, err)
+	} else {
+		log.Printf("Flushed %d logs for report %s", len(logs), reportID)
+	}
+}
+
+// logChainHead holds the last link of a report's log chain. It is kept after
+// the report is deleted, like the logs.
+func (r *FirestoreRepository) logChainHead(reportID string) *firestore.DocumentRef {
+	return r.Client.Collection("reports").Doc(reportID).Collection("logChain").Doc("head")
+}
+
+// appendLogs writes events to a report's logs, chaining each to the one
+// before. The head is read and moved in one transaction so concurrent writers
+// take turns.
+func (r *FirestoreRepository) appendLogs(reportID string, events []audit.Event) error {
 	logCol := r.Client.Collection("reports").Doc(reportID).Collection("logs")
+	headRef := r.logChainHead(reportID)
 
-	for _, entry := range logs {
-		doc := logCol.NewDoc()
-		batch.Set(doc, entry)
-	}
+	return r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		head, err := readChainHead(tx, headRef)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			head = event.Link(head)
+			if err := tx.Set(logCol.Doc(event.ID), eventFields(event)); err != nil {
+				return err
+			}
+		}
+		return tx.Set(headRef, map[string]interface{}{"seq": head.Seq, "hash": head.Hash})
+	})
+}
 
-	_, err := batch.Commit(r.Ctx)
+// readChainHead reads a report's chain head, the zero head before the first
+// chained event
+func readChainHead(tx *firestore.Transaction, headRef *firestore.DocumentRef) (audit.Head, error) {
+	doc, err := tx.Get(headRef)
+	if status.Code(err) == codes.NotFound {
+		return audit.Head{}, nil
+	}
 	if err != nil {
-		log.Printf("Failed to flush logs for report %s: %v", reportID, err)
-	} else {
-		log.Printf("Flushed %d logs for report %s", len(logs), reportID)
+		return audit.Head{}, fmt.Errorf("failed to read log chain: %w", err)
 	}
+	return chainHead(doc), nil
+}
+
+func chainHead(doc *firestore.DocumentSnapshot) audit.Head {
+	var head audit.Head
+	head.Seq, _ = doc.Data()["seq"].(int64)
+	head.Hash, _ = doc.Data()["hash"].(string)
+	return head
 }
 
 func (r *FirestoreRepository) StartLogFlusher(interval time.Duration) {
@@ -131,6 +250,9 @@ func (r *FirestoreRepository) GetTemplate(templateID string) (*reportTemplates.R
 
 	doc, err := r.Client.Collection("templates").Doc(templateID).Get(r.Ctx)
 	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+		}
 		return nil, err
 	}
 
@@ -140,6 +262,166 @@ func (r *FirestoreRepository) GetTemplate(templateID string) (*reportTemplates.R
 
 }
 
+// TemplateSummary describes a template, or one version of it, without its sections
+type TemplateSummary struct {
+	TemplateID string    `json:"templateID"`
+	Name       string    `json:"name"`
+	Version    int       `json:"version"`
+	UpdatedBy  string    `json:"updatedBy,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	ClonedFrom string    `json:"clonedFrom,omitempty"`
+}
+
+func templateSummary(templateID string, template reportTemplates.ReportTemplate) TemplateSummary {
+	return TemplateSummary{
+		TemplateID: templateID,
+		Name:       template.Name,
+		Version:    template.Version,
+		UpdatedBy:  template.UpdatedBy,
+		UpdatedAt:  template.UpdatedAt,
+		ClonedFrom: template.ClonedFrom,
+	}
+}
+
+// IsTemplateAdmin reports whether a user may manage templates. Admins are the
+// documents of the "admins" collection, keyed by UID.
+func (r *FirestoreRepository) IsTemplateAdmin(uID string) (bool, error) {
+	_, err := r.Client.Collection("admins").Doc(uID).Get(r.Ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check template admin: %w", err)
+	}
+	return true, nil
+}
+
+func (r *FirestoreRepository) ListTemplates() ([]TemplateSummary, error) {
+	docs, err := r.Client.Collection("templates").Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch templates: %w", err)
+	}
+
+	templates := []TemplateSummary{}
+	for _, doc := range docs {
+		var template reportTemplates.ReportTemplate
+		if err := doc.DataTo(&template); err != nil {
+			log.Printf("Skipping unreadable template %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		templates = append(templates, templateSummary(doc.Ref.ID, template))
+	}
+	return templates, nil
+}
+
+// CreateTemplate stores a new template as version 1
+func (r *FirestoreRepository) CreateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error) {
+	templateRef := r.Client.Collection("templates").Doc(templateID)
+
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, err := tx.Get(templateRef)
+		if err == nil {
+			return fmt.Errorf("%w: %s", ErrTemplateExists, templateID)
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		template = savedTemplate(template, 1, author)
+		return setTemplate(tx, templateRef, template)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create template: %w", err)
+	}
+	return &template, nil
+}
+
+// UpdateTemplate replaces a template's sections and style with a new version.
+// Earlier versions are kept, so reports made from them keep their outline.
+func (r *FirestoreRepository) UpdateTemplate(templateID string, template reportTemplates.ReportTemplate, author string) (*reportTemplates.ReportTemplate, error) {
+	templateRef := r.Client.Collection("templates").Doc(templateID)
+
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(templateRef)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+			}
+			return err
+		}
+
+		var current reportTemplates.ReportTemplate
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+
+		template.ClonedFrom = current.ClonedFrom
+		template = savedTemplate(template, current.Version+1, author)
+		return setTemplate(tx, templateRef, template)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update template: %w", err)
+	}
+	return &template, nil
+}
+
+func savedTemplate(template reportTemplates.ReportTemplate, version int, author string) reportTemplates.ReportTemplate {
+	template.Version = version
+	template.UpdatedBy = author
+	template.UpdatedAt = time.Now()
+	return template
+}
+
+// setTemplate writes a template as the current one and as its numbered version
+func setTemplate(tx *firestore.Transaction, templateRef *firestore.DocumentRef, template reportTemplates.ReportTemplate) error {
+	if err := tx.Set(templateRef, template); err != nil {
+		return err
+	}
+	return tx.Set(templateRef.Collection("versions").Doc(strconv.Itoa(template.Version)), template)
+}
+
+// ListTemplateVersions returns the versions of a template, newest first
+func (r *FirestoreRepository) ListTemplateVersions(templateID string) ([]TemplateSummary, error) {
+	templateRef := r.Client.Collection("templates").Doc(templateID)
+	if _, err := templateRef.Get(r.Ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateID)
+		}
+		return nil, fmt.Errorf("failed to fetch template: %w", err)
+	}
+
+	docs, err := templateRef.Collection("versions").OrderBy("version", firestore.Desc).Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template versions: %w", err)
+	}
+
+	versions := []TemplateSummary{}
+	for _, doc := range docs {
+		var template reportTemplates.ReportTemplate
+		if err := doc.DataTo(&template); err != nil {
+			return nil, fmt.Errorf("failed to read template version %s: %w", doc.Ref.ID, err)
+		}
+		versions = append(versions, templateSummary(templateID, template))
+	}
+	return versions, nil
+}
+
+func (r *FirestoreRepository) GetTemplateVersion(templateID string, version int) (*reportTemplates.ReportTemplate, error) {
+	doc, err := r.Client.Collection("templates").Doc(templateID).Collection("versions").Doc(strconv.Itoa(version)).Get(r.Ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s version %d", ErrTemplateNotFound, templateID, version)
+		}
+		return nil, fmt.Errorf("failed to fetch template version: %w", err)
+	}
+
+	var template reportTemplates.ReportTemplate
+	if err := doc.DataTo(&template); err != nil {
+		return nil, fmt.Errorf("failed to read template version: %w", err)
+	}
+	return &template, nil
+}
+
 
 
 func (r *FirestoreRepository) CreateReport(reportName, reportID, templateID, userEmail string) error {
@@ -154,6 +436,8 @@ func (r *FirestoreRepository) CreateReport(reportName, reportID, templateID, use
 	_, err = newReportDoc.Set(r.Ctx, map[string]interface{}{
 		"reportID" : reportID,
 		"templateID" : templateID,
+		"templateVersion": template.Version,
+		"stableIDs": true, // Sections are keyed by generated IDs, see MigrateStructureIDs
 		"reportName": reportName,
 		"creationTime": time.Now(),	
 	})
@@ -162,13 +446,8 @@ func (r *FirestoreRepository) CreateReport(reportName, reportID, templateID, use
 	}
 
 
-	logEntry := map[string]interface{}{
-		"timestamp": time.Now(),
-		"message":   fmt.Sprintf("Report created by user %s", userEmail),
-		"userID":    userEmail,
-	}
-
-	_, err = newReportDoc.Collection("logs").NewDoc().Set(r.Ctx, logEntry)
+	created := stampEvent(audit.Event{ReportID: reportID, Actor: userEmail, Action: audit.ReportCreated, Message: fmt.Sprintf("created the report %s", reportName)})
+	err = r.appendLogs(reportID, []audit.Event{created})
 	if err != nil {
 		return fmt.Errorf("failed to create report log: %w", err)
 	}
@@ -178,9 +457,7 @@ func (r *FirestoreRepository) CreateReport(reportName, reportID, templateID, use
 		fmt.Printf("Adding Section: %s\n", section.Title)
 
 
-		// hash section title
-		sectionDocTitle := sanitizeFirebaseDocName(section.Title)
-		sectionDocRef := newReportDoc.Collection("sections").Doc(sectionDocTitle)
+		sectionDocRef := newReportDoc.Collection("sections").Doc(newID())
 
 		// Store section with its order index
 		_, err := sectionDocRef.Set(r.Ctx, map[string]interface{}{
@@ -195,10 +472,7 @@ func (r *FirestoreRepository) CreateReport(reportName, reportID, templateID, use
 		for subsectionIndex, subsection := range section.Subsections {
 			fmt.Printf("Adding Subsection: %s under Section: %s\n", subsection, section.Title)
 
-			//subsectionDocRef := sectionDocRef.Collection("subsections").NewDoc()
-			// hash the subsection title to generate a id 
-			subSectionDocTitle := sanitizeFirebaseDocName(subsection)
-			subsectionDocRef := sectionDocRef.Collection("subsections").Doc(subSectionDocTitle)
+			subsectionDocRef := sectionDocRef.Collection("subsections").Doc(newID())
 
 			// Store subsection with its order index
 			_, err := subsectionDocRef.Set(r.Ctx, map[string]interface{}{
@@ -236,8 +510,31 @@ func (r *FirestoreRepository) GetReportFieldTemplateID(reportID string) (string,
 
 
 
-func (r *FirestoreRepository) FetchReportSectionContents(reportID, sectionTitle string) (map[string]string, error) {
-	sectionDocRef := r.Client.Collection("reports").Doc(reportID).Collection("sections").Doc(sectionTitle)
+// GetReportTemplateVersion returns the version of its template a report was
+// created from or last migrated to, 0 for reports older than versioning
+func (r *FirestoreRepository) GetReportTemplateVersion(reportID string) (int, error) {
+	reportSnap, err := r.Client.Collection("reports").Doc(reportID).Get(r.Ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get report: %w", err)
+	}
+
+	version, _ := reportSnap.Data()["templateVersion"].(int64)
+	return int(version), nil
+}
+
+func (r *FirestoreRepository) SetReportTemplateVersion(reportID string, version int) error {
+	_, err := r.Client.Collection("reports").Doc(reportID).Update(r.Ctx, []firestore.Update{
+		{Path: "templateVersion", Value: version},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set template version: %w", err)
+	}
+	return nil
+}
+
+// FetchReportSectionContents returns the content of a section's subsections by subsection ID
+func (r *FirestoreRepository) FetchReportSectionContents(reportID, sectionID string) (map[string]string, error) {
+	sectionDocRef := r.sectionDocRef(reportID, sectionID)
 	subsectionsSnap, err := sectionDocRef.Collection("subsections").Documents(r.Ctx).GetAll()
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch subsections: %v", err)
@@ -245,20 +542,51 @@ func (r *FirestoreRepository) FetchReportSectionContents(reportID, sectionTitle
 
 	contents := make(map[string]string)
 	for _, doc := range subsectionsSnap {
-		data := doc.Data()
-		title, _ := data["title"].(string)
-		content, _ := data["content"].(string)
-		contents[title] = content
+		content, _ := doc.Data()["content"].(string)
+		contents[doc.Ref.ID] = content
 	}
 	return contents, nil
 }
 
-func (r *FirestoreRepository) UpdateReportSectionContents(reportID, sectionTitle, subsectionTitle, newContent string) error {
-	sanitieSectionTitle := sanitizeFirebaseDocName(sectionTitle)
-	sanitizeSubSectionTitle := sanitizeFirebaseDocName(subsectionTitle)
-	subsectionDocRef := r.Client.Collection("reports").Doc(reportID).Collection("sections").Doc(sanitieSectionTitle).Collection("subsections").Doc(sanitizeSubSectionTitle)
-	_, err := subsectionDocRef.Update(r.Ctx, []firestore.Update{
-		{Path: "content", Value: newContent},
+// UpdateReportSectionContents saves the new content and keeps it as a version
+// of the subsection. Saves that change nothing are skipped, and saves by the
+// author of the latest version within versionWindow of its first save go into
+// that version.
+func (r *FirestoreRepository) UpdateReportSectionContents(reportID, sectionID, subsectionID, newContent, author string) error {
+	subsectionDocRef := r.subsectionDocRef(reportID, sectionID, subsectionID)
+	versions := subsectionDocRef.Collection("versions")
+
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		subsectionSnap, err := tx.Get(subsectionDocRef)
+		if err != nil {
+			return err
+		}
+		if current, _ := subsectionSnap.Data()["content"].(string); current == newContent {
+			return nil
+		}
+		latest, err := tx.Documents(versions.OrderBy("timestamp", firestore.Desc).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		versionRef, created := versions.NewDoc(), now
+		if len(latest) == 1 {
+			if previous := subsectionVersionFromData(latest[0].Ref.ID, latest[0].Data()); mergesInto(previous, author, now) {
+				versionRef, created = latest[0].Ref, previous.Created
+			}
+		}
+
+		if err := tx.Update(subsectionDocRef, []firestore.Update{{Path: "content", Value: newContent}}); err != nil {
+			return err
+		}
+		return tx.Set(versionRef, map[string]interface{}{
+			"content":   newContent,
+			"author":    author,
+			"timestamp": now,
+			"created":   created,
+			"size":      len(newContent),
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("failed to update subsection content: %v", err)
@@ -266,6 +594,105 @@ func (r *FirestoreRepository) UpdateReportSectionContents(reportID, sectionTitle
 	return nil
 }
 
+// versionWindow is how long saves by one author keep going into the version
+// their first save started. The editor saves every few seconds, so without it
+// a subsection being edited would get hundreds of versions an hour.
+const versionWindow = 10 * time.Minute
+
+// mergesInto reports whether a save by author at now goes into the latest
+// version rather than starting a new one
+func mergesInto(latest SubsectionVersion, author string, now time.Time) bool {
+	return latest.Author == author && now.Sub(latest.Created) < versionWindow
+}
+
+// SubsectionVersion is a saved copy of a subsection's content. Timestamp is
+// its latest save and Created the first save that went into it.
+type SubsectionVersion struct {
+	VersionID string    `json:"versionID"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Created   time.Time `json:"created"`
+	Size      int       `json:"size"`
+	Content   string    `json:"content,omitempty"`
+}
+
+// VersionPage picks versions of a subsection, newest first. After is the ID of
+// the last version of the page before, and a zero Limit returns every version.
+type VersionPage struct {
+	After string
+	Limit int
+}
+
+// ListSubsectionVersions returns a page of the versions of a subsection, newest first, without their content
+func (r *FirestoreRepository) ListSubsectionVersions(reportID, sectionID, subsectionID string, page VersionPage) ([]SubsectionVersion, error) {
+	versionsCollection := r.subsectionDocRef(reportID, sectionID, subsectionID).Collection("versions")
+	versionsQuery := versionsCollection.
+		Select("author", "timestamp", "created", "size").
+		OrderBy("timestamp", firestore.Desc).
+		OrderBy(firestore.DocumentID, firestore.Desc)
+
+	if page.After != "" {
+		cursor, err := versionsCollection.Doc(page.After).Get(r.Ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, page.After)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch version cursor: %w", err)
+		}
+		versionsQuery = versionsQuery.StartAfter(cursor)
+	}
+	if page.Limit > 0 {
+		versionsQuery = versionsQuery.Limit(page.Limit)
+	}
+
+	docs, err := versionsQuery.Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subsection versions: %w", err)
+	}
+
+	versions := []SubsectionVersion{}
+	for _, doc := range docs {
+		versions = append(versions, subsectionVersionFromData(doc.Ref.ID, doc.Data()))
+	}
+	return versions, nil
+}
+
+func (r *FirestoreRepository) GetSubsectionVersion(reportID, sectionID, subsectionID, versionID string) (*SubsectionVersion, error) {
+	doc, err := r.subsectionDocRef(reportID, sectionID, subsectionID).Collection("versions").Doc(versionID).Get(r.Ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, versionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subsection version: %w", err)
+	}
+
+	version := subsectionVersionFromData(doc.Ref.ID, doc.Data())
+	return &version, nil
+}
+
+func subsectionVersionFromData(versionID string, data map[string]interface{}) SubsectionVersion {
+	version := SubsectionVersion{VersionID: versionID}
+	version.Author, _ = data["author"].(string)
+	version.Timestamp, _ = data["timestamp"].(time.Time)
+	version.Created, _ = data["created"].(time.Time)
+	if version.Created.IsZero() {
+		version.Created = version.Timestamp // Versions from before merging
+	}
+	version.Content, _ = data["content"].(string)
+	if size, ok := data["size"].(int64); ok {
+		version.Size = int(size)
+	}
+	return version
+}
+
+func (r *FirestoreRepository) sectionDocRef(reportID, sectionID string) *firestore.DocumentRef {
+	return r.Client.Collection("reports").Doc(reportID).Collection("sections").Doc(sectionID)
+}
+
+func (r *FirestoreRepository) subsectionDocRef(reportID, sectionID, subsectionID string) *firestore.DocumentRef {
+	return r.sectionDocRef(reportID, sectionID).Collection("subsections").Doc(subsectionID)
+}
+
 func (r *FirestoreRepository) FetchReportContent(reportID string) (string ,[]map[string]interface{}, error) {
 	docRef := r.Client.Collection("reports").Doc(reportID)
 
@@ -309,6 +736,7 @@ func (r *FirestoreRepository) FetchReportContent(reportID string) (string ,[]map
 
 			// Append the subsection as a map with title and content
 			subsections = append(subsections, map[string]interface{}{
+				"id":      subsectionDoc.Ref.ID,
 				"title":   subsectionTitle,
 				"content": subsectionContent,
 			})
@@ -316,6 +744,7 @@ func (r *FirestoreRepository) FetchReportContent(reportID string) (string ,[]map
 
 		// Add the section and its subsections to the report content as a map
 		orderedReportContent = append(orderedReportContent, map[string]interface{}{
+			"sectionID":    sectionDoc.Ref.ID,
 			"sectionTitle": sectionTitle,
 			"subsections":  subsections,
 		})
@@ -325,78 +754,1071 @@ func (r *FirestoreRepository) FetchReportContent(reportID string) (string ,[]map
 }
 
 
-func (r *FirestoreRepository) LinkReportWithUser(uID, reportID string, privilege bool, ownership bool) error {
-	// Get a reference to the subcollection "linkedReports" inside the user document
-	reportDocRef := r.Client.Collection("users").Doc(uID).Collection("linkedReports").Doc(reportID)
+// FetchReportStructure returns a report's sections and subsections in order
+func (r *FirestoreRepository) FetchReportStructure(reportID string) ([]reportStructure.Section, error) {
+	sectionDocs, err := r.orderedDocs(r.Client.Collection("reports").Doc(reportID).Collection("sections"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sections: %w", err)
+	}
 
-	// Get the document snapshot to check if it exists and if the privilege is already set to true
-	docSnapshot, _ := reportDocRef.Get(r.Ctx)
+	sections := []reportStructure.Section{}
+	for _, sectionDoc := range sectionDocs {
+		title, _ := sectionDoc.Data()["title"].(string)
+		section := reportStructure.Section{ID: sectionDoc.Ref.ID, Title: title, Subsections: []reportStructure.Subsection{}}
 
-	if docSnapshot.Exists() {
-		// If the document exists, check the current privilege level
-		existingPrivilege, exists := docSnapshot.Data()["privilege"].(bool)
-		if exists && existingPrivilege {
-			// If the privilege is already true, no further changes should be made
-			fmt.Println("Privilege level is already true, no changes allowed.")
-			return nil
+		subsectionDocs, err := r.orderedDocs(sectionDoc.Ref.Collection("subsections"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch subsections for section %s: %w", title, err)
 		}
+		for _, subsectionDoc := range subsectionDocs {
+			subsectionTitle, _ := subsectionDoc.Data()["title"].(string)
+			section.Subsections = append(section.Subsections, reportStructure.Subsection{ID: subsectionDoc.Ref.ID, Title: subsectionTitle})
+		}
+		sections = append(sections, section)
 	}
+	return sections, nil
+}
+
+// AddSection inserts an empty section at position, or at the end when the
+// position is out of range, and returns its ID
+func (r *FirestoreRepository) AddSection(reportID, title string, position int) (string, error) {
+	sections := r.Client.Collection("reports").Doc(reportID).Collection("sections")
+	return r.insertDoc(sections, title, position, map[string]interface{}{"title": title})
+}
 
-	// If the document doesn't exist or privilege is not true, update the document
-	_, err := reportDocRef.Set(r.Ctx, map[string]interface{}{
-		"privilege": privilege,
-		"owner": ownership,
+func (r *FirestoreRepository) AddSubsection(reportID, sectionID, title string, position int) (string, error) {
+	sectionRef, err := r.existingDoc(r.sectionDocRef(reportID, sectionID))
+	if err != nil {
+		return "", err
+	}
+	return r.insertDoc(sectionRef.Collection("subsections"), title, position, map[string]interface{}{
+		"title":   title,
+		"content": "",
 	})
+}
+
+// DeleteSection removes a section with its subsections and their versions
+func (r *FirestoreRepository) DeleteSection(reportID, sectionID string) error {
+	sectionRef, err := r.existingDoc(r.sectionDocRef(reportID, sectionID))
+	if err != nil {
+		return err
+	}
+	if err := r.deleteTree(sectionRef); err != nil {
+		return fmt.Errorf("failed to delete section: %w", err)
+	}
+	return r.renumber(sectionRef.Parent, nil, -1)
+}
 
+func (r *FirestoreRepository) DeleteSubsection(reportID, sectionID, subsectionID string) error {
+	subsectionRef, err := r.existingDoc(r.subsectionDocRef(reportID, sectionID, subsectionID))
 	if err != nil {
-		return fmt.Errorf("failed to link report with user: %v", err)
+		return err
 	}
+	if err := r.deleteTree(subsectionRef); err != nil {
+		return fmt.Errorf("failed to delete subsection: %w", err)
+	}
+	return r.renumber(subsectionRef.Parent, nil, -1)
+}
 
-	fmt.Println("Linked report with user in subcollection")
-	return nil
+func (r *FirestoreRepository) MoveSection(reportID, sectionID string, position int) error {
+	sectionRef, err := r.existingDoc(r.sectionDocRef(reportID, sectionID))
+	if err != nil {
+		return err
+	}
+	return r.renumber(sectionRef.Parent, sectionRef, position)
 }
 
+// MoveSubsection moves a subsection to position in the same or another
+// section. Moving to another section copies it with its versions and deletes
+// the original, since the section is part of the document path. The ID stays
+// the same.
+func (r *FirestoreRepository) MoveSubsection(reportID, sectionID, subsectionID, toSectionID string, position int) error {
+	subsectionRef, err := r.existingDoc(r.subsectionDocRef(reportID, sectionID, subsectionID))
+	if err != nil {
+		return err
+	}
+	toSectionRef, err := r.existingDoc(r.sectionDocRef(reportID, toSectionID))
+	if err != nil {
+		return err
+	}
 
+	if toSectionRef.ID == sectionID {
+		return r.renumber(subsectionRef.Parent, subsectionRef, position)
+	}
 
+	subsection, err := subsectionRef.Get(r.Ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch subsection: %w", err)
+	}
+	title, _ := subsection.Data()["title"].(string)
+	if err := r.checkTitle(toSectionRef.Collection("subsections"), title, ""); err != nil {
+		return err
+	}
 
-type Report struct {
-	ReportID    string    `json:"reportID"`
-	ReportTitle  string    `json:"reportTitle"`
-	CreationTime time.Time `json:"creationTime"`
+	movedRef := toSectionRef.Collection("subsections").Doc(subsectionID)
+	if err := r.moveTree(subsectionRef, movedRef); err != nil {
+		return err
+	}
+	if err := r.renumber(subsectionRef.Parent, nil, -1); err != nil {
+		return err
+	}
+	return r.renumber(movedRef.Parent, movedRef, position)
 }
 
-func (r *FirestoreRepository) GetUserReportLinks(uID string) ([]Report, error) {
-	// Get a reference to the "linkedReports" subcollection inside the user document
-	reportsCollection := r.Client.Collection("users").Doc(uID).Collection("linkedReports")
+// RenameSection changes a section's title, which is all that changes
+func (r *FirestoreRepository) RenameSection(reportID, sectionID, title string) error {
+	sectionRef, err := r.existingDoc(r.sectionDocRef(reportID, sectionID))
+	if err != nil {
+		return err
+	}
+	return r.renameDoc(sectionRef, title)
+}
 
-	// Query all documents in the subcollection
-	docs, err := reportsCollection.Documents(r.Ctx).GetAll()
+func (r *FirestoreRepository) RenameSubsection(reportID, sectionID, subsectionID, title string) error {
+	subsectionRef, err := r.existingDoc(r.subsectionDocRef(reportID, sectionID, subsectionID))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get linked reports for user: %v", err)
+		return err
 	}
+	return r.renameDoc(subsectionRef, title)
+}
 
-	// Initialize a slice to store the reports
-	var reports []Report
+// existingDoc returns ref if the document exists, or ErrSectionNotFound
+func (r *FirestoreRepository) existingDoc(ref *firestore.DocumentRef) (*firestore.DocumentRef, error) {
+	if _, err := ref.Get(r.Ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrSectionNotFound, ref.ID)
+		}
+		return nil, fmt.Errorf("failed to fetch %s: %w", ref.ID, err)
+	}
+	return ref, nil
+}
+
+func (r *FirestoreRepository) orderedDocs(collection *firestore.CollectionRef) ([]*firestore.DocumentSnapshot, error) {
+	return collection.OrderBy("order", firestore.Asc).Documents(r.Ctx).GetAll()
+}
 
+// checkTitle returns ErrSectionExists if a document in the collection other
+// than exceptID has the title
+func (r *FirestoreRepository) checkTitle(collection *firestore.CollectionRef, title, exceptID string) error {
+	docs, err := collection.Documents(r.Ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to check titles: %w", err)
+	}
 	for _, doc := range docs {
-		reportID := doc.Ref.ID // Report ID is the document ID
+		existing, _ := doc.Data()["title"].(string)
+		if doc.Ref.ID != exceptID && sameTitle(existing, title) {
+			return fmt.Errorf("%w: %s", ErrSectionExists, title)
+		}
+	}
+	return nil
+}
 
-		// Fetch the actual report document from the "reports" collection
-		reportDocRef := r.Client.Collection("reports").Doc(reportID)
-		reportDoc, err := reportDocRef.Get(r.Ctx)
-		if err != nil {
-			log.Printf("failed to get report document for reportID: %v\n", reportID)
-			log.Printf("removing reportID: %v, from users: %s, colleciton\n", reportID, uID)
-			// remove the report collection here 
-			// Delete broken link
-			_, delErr := doc.Ref.Delete(r.Ctx)
-			if delErr != nil {
-				log.Printf("Failed to delete broken report link: %v", delErr)
-			}
+// insertDoc creates a section or subsection with a new ID at position
+func (r *FirestoreRepository) insertDoc(collection *firestore.CollectionRef, title string, position int, data map[string]interface{}) (string, error) {
+	if err := r.checkTitle(collection, title, ""); err != nil {
+		return "", err
+	}
 
-			continue // Skips broken report
+	ref := collection.Doc(newID())
+	data["order"] = position
+	if _, err := ref.Create(r.Ctx, data); err != nil {
+		return "", fmt.Errorf("failed to add %s: %w", title, err)
+	}
+	return ref.ID, r.renumber(collection, ref, position)
+}
 
-		}
+func (r *FirestoreRepository) renameDoc(ref *firestore.DocumentRef, title string) error {
+	if err := r.checkTitle(ref.Parent, title, ref.ID); err != nil {
+		return err
+	}
+	if _, err := ref.Update(r.Ctx, []firestore.Update{{Path: "title", Value: title}}); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", ref.ID, err)
+	}
+	return nil
+}
+
+// renumber rewrites the order of the documents in a collection, with moved
+// placed at position. Without a moved document the order is only closed up.
+func (r *FirestoreRepository) renumber(collection *firestore.CollectionRef, moved *firestore.DocumentRef, position int) error {
+	docs, err := r.orderedDocs(collection)
+	if err != nil {
+		return fmt.Errorf("failed to fetch order: %w", err)
+	}
+
+	var refs []*firestore.DocumentRef
+	for _, doc := range docs {
+		if moved == nil || doc.Ref.ID != moved.ID {
+			refs = append(refs, doc.Ref)
+		}
+	}
+	if moved != nil {
+		if position < 0 || position > len(refs) {
+			position = len(refs)
+		}
+		refs = append(refs[:position], append([]*firestore.DocumentRef{moved}, refs[position:]...)...)
+	}
+
+	batch := r.Client.Batch()
+	for i, ref := range refs {
+		batch.Update(ref, []firestore.Update{{Path: "order", Value: i}})
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+	if _, err := batch.Commit(r.Ctx); err != nil {
+		return fmt.Errorf("failed to update order: %w", err)
+	}
+	return nil
+}
+
+// moveTree copies a document and every document under it to another path,
+// then deletes the original. The copy is created first so a failure part way
+// leaves the original in place.
+func (r *FirestoreRepository) moveTree(from, to *firestore.DocumentRef) error {
+	if _, err := to.Get(r.Ctx); err == nil {
+		return fmt.Errorf("%w: %s", ErrSectionExists, to.ID)
+	} else if status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to check %s: %w", to.ID, err)
+	}
+
+	writer := r.Client.BulkWriter(r.Ctx)
+	jobs, err := r.queueCopy(writer, from, to)
+	writer.End()
+	if err == nil {
+		err = jobErrors(jobs)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", from.ID, to.ID, err)
+	}
+
+	if err := r.deleteTree(from); err != nil {
+		return fmt.Errorf("failed to delete %s after copying it: %w", from.ID, err)
+	}
+	return nil
+}
+
+func (r *FirestoreRepository) queueCopy(writer *firestore.BulkWriter, from, to *firestore.DocumentRef) ([]*firestore.BulkWriterJob, error) {
+	snapshot, err := from.Get(r.Ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := writer.Create(to, snapshot.Data())
+	if err != nil {
+		return nil, err
+	}
+	jobs := []*firestore.BulkWriterJob{job}
+
+	collections, err := from.Collections(r.Ctx).GetAll()
+	if err != nil {
+		return jobs, err
+	}
+	for _, collection := range collections {
+		docs, err := collection.DocumentRefs(r.Ctx).GetAll()
+		if err != nil {
+			return jobs, err
+		}
+		for _, doc := range docs {
+			copied, err := r.queueCopy(writer, doc, to.Collection(collection.ID).Doc(doc.ID))
+			jobs = append(jobs, copied...)
+			if err != nil {
+				return jobs, err
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// deleteTree deletes a document and every document under it
+func (r *FirestoreRepository) deleteTree(ref *firestore.DocumentRef) error {
+	writer := r.Client.BulkWriter(r.Ctx)
+	jobs, err := r.queueDelete(writer, ref)
+	writer.End()
+	if err != nil {
+		return err
+	}
+	return jobErrors(jobs)
+}
+
+func (r *FirestoreRepository) queueDelete(writer *firestore.BulkWriter, ref *firestore.DocumentRef) ([]*firestore.BulkWriterJob, error) {
+	var jobs []*firestore.BulkWriterJob
+
+	collections, err := ref.Collections(r.Ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, collection := range collections {
+		docs, err := collection.DocumentRefs(r.Ctx).GetAll()
+		if err != nil {
+			return jobs, err
+		}
+		for _, doc := range docs {
+			deleted, err := r.queueDelete(writer, doc)
+			jobs = append(jobs, deleted...)
+			if err != nil {
+				return jobs, err
+			}
+		}
+	}
+
+	job, err := writer.Delete(ref)
+	if err != nil {
+		return jobs, err
+	}
+	return append(jobs, job), nil
+}
+
+// MigrateStructureIDs gives the sections and subsections of reports created
+// before stable IDs a generated ID each. Their documents used to be named
+// after their titles, so titles like "A/B" and "A_B" collided. Each is moved
+// to its new ID with everything under it, and the report is marked so it is
+// only migrated once. Run it while the server is stopped. It returns the
+// number of reports migrated.
+func (r *FirestoreRepository) MigrateStructureIDs() (int, error) {
+	reports, err := r.Client.Collection("reports").Documents(r.Ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch reports: %w", err)
+	}
+
+	migrated := 0
+	for _, report := range reports {
+		if stable, _ := report.Data()["stableIDs"].(bool); stable {
+			continue
+		}
+		if err := r.migrateReportIDs(report.Ref); err != nil {
+			return migrated, fmt.Errorf("failed to migrate report %s: %w", report.Ref.ID, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+func (r *FirestoreRepository) migrateReportIDs(report *firestore.DocumentRef) error {
+	sections, err := report.Collection("sections").DocumentRefs(r.Ctx).GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to fetch sections: %w", err)
+	}
+
+	for _, section := range sections {
+		movedSection := section.Parent.Doc(newID())
+		if err := r.moveTree(section, movedSection); err != nil {
+			return err
+		}
+
+		subsections, err := movedSection.Collection("subsections").DocumentRefs(r.Ctx).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to fetch subsections: %w", err)
+		}
+		for _, subsection := range subsections {
+			if err := r.moveTree(subsection, subsection.Parent.Doc(newID())); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = report.Update(r.Ctx, []firestore.Update{{Path: "stableIDs", Value: true}})
+	return err
+}
+
+// jobErrors waits for bulk writes and returns the first that failed
+func jobErrors(jobs []*firestore.BulkWriterJob) error {
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Members are kept in users/{uid}/linkedReports/{reportID} with their role,
+// and the report document lists its owners so the last one is never lost.
+// privilege and owner are still written for readers from before roles.
+// reports/{reportID}/members/{uid} indexes the same memberships by report and
+// is written in the same transactions.
+func (r *FirestoreRepository) linkRef(uID, reportID string) *firestore.DocumentRef {
+	return r.Client.Collection("users").Doc(uID).Collection("linkedReports").Doc(reportID)
+}
+
+func (r *FirestoreRepository) memberRef(uID, reportID string) *firestore.DocumentRef {
+	return r.Client.Collection("reports").Doc(reportID).Collection("members").Doc(uID)
+}
+
+// setRole writes a member's role to their link and the report's index
+func (r *FirestoreRepository) setRole(tx *firestore.Transaction, uID, reportID string, role reportRoles.Role) error {
+	if err := tx.Set(r.linkRef(uID, reportID), roleFields(role), firestore.MergeAll); err != nil {
+		return err
+	}
+	return tx.Set(r.memberRef(uID, reportID), map[string]interface{}{"role": string(role)}, firestore.MergeAll)
+}
+
+func roleFields(role reportRoles.Role) map[string]interface{} {
+	return map[string]interface{}{
+		"role":      string(role),
+		"privilege": role.IsAdmin(),
+		"owner":     role == reportRoles.Owner,
+	}
+}
+
+// linkRole reads the role of a linkedReports document, falling back to the
+// privilege and owner flags of memberships from before roles
+func linkRole(data map[string]interface{}) reportRoles.Role {
+	if role, ok := data["role"].(string); ok {
+		return reportRoles.Role(role)
+	}
+	privilege, _ := data["privilege"].(bool)
+	owner, _ := data["owner"].(bool)
+	return reportRoles.FromFlags(privilege, owner)
+}
+
+// memberRole reads a member's role in a transaction
+func (r *FirestoreRepository) memberRole(tx *firestore.Transaction, uID, reportID string) (reportRoles.Role, error) {
+	doc, err := tx.Get(r.linkRef(uID, reportID))
+	if status.Code(err) == codes.NotFound {
+		return reportRoles.None, fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	if err != nil {
+		return reportRoles.None, fmt.Errorf("failed to fetch member role: %w", err)
+	}
+	return linkRole(doc.Data()), nil
+}
+
+// reportOwners reads the owners of a report in a transaction. Reports from
+// before roles have no list, so it is made from the owners in the members
+// index and knownOwner, if the caller read one from a membership.
+func (r *FirestoreRepository) reportOwners(tx *firestore.Transaction, reportID, knownOwner string) ([]string, error) {
+	report := r.Client.Collection("reports").Doc(reportID)
+	doc, err := tx.Get(report)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrReportNotFound, reportID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch report owners: %w", err)
+	}
+
+	owners := []string{}
+	if list, ok := doc.Data()["owners"].([]interface{}); ok {
+		for _, owner := range list {
+			if owner, ok := owner.(string); ok {
+				owners = append(owners, owner)
+			}
+		}
+		return owners, nil
+	}
+
+	members, err := tx.Documents(report.Collection("members").Where("role", "==", string(reportRoles.Owner))).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch report owners: %w", err)
+	}
+	for _, member := range members {
+		owners = changeOwner(owners, member.Ref.ID, true)
+	}
+	if knownOwner != "" {
+		owners = changeOwner(owners, knownOwner, true)
+	}
+	return owners, nil
+}
+
+// setOwners writes the owners of a report in a transaction, refusing to leave
+// it without one. The report must exist, see reportOwners.
+func (r *FirestoreRepository) setOwners(tx *firestore.Transaction, reportID string, owners []string) error {
+	if len(owners) == 0 {
+		return fmt.Errorf("%w: %s", ErrLastOwner, reportID)
+	}
+	return tx.Update(r.Client.Collection("reports").Doc(reportID), []firestore.Update{{Path: "owners", Value: owners}})
+}
+
+// changeOwner is owners with uID added or taken out
+func changeOwner(owners []string, uID string, owner bool) []string {
+	changed := []string{}
+	for _, existing := range owners {
+		if existing != uID {
+			changed = append(changed, existing)
+		}
+	}
+	if owner {
+		changed = append(changed, uID)
+	}
+	return changed
+}
+
+// knownOwner is uID if their role makes them an owner
+func knownOwner(uID string, role reportRoles.Role) string {
+	if role == reportRoles.Owner {
+		return uID
+	}
+	return ""
+}
+
+// LinkReportWithUser adds a member to a report. Members already in the report
+// keep their role, it is changed with SetMemberRole.
+func (r *FirestoreRepository) LinkReportWithUser(uID, reportID string, role reportRoles.Role) error {
+	return r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		return r.link(tx, uID, reportID, role)
+	})
+}
+
+// link adds a member in a transaction
+func (r *FirestoreRepository) link(tx *firestore.Transaction, uID, reportID string, role reportRoles.Role) error {
+	_, err := r.memberRole(tx, uID, reportID)
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrAlreadyInReport, uID)
+	}
+	if !errors.Is(err, ErrUserNotInReport) {
+		return err
+	}
+
+	if role == reportRoles.Owner {
+		owners, err := r.reportOwners(tx, reportID, "")
+		if err != nil {
+			return err
+		}
+		if err := r.setOwners(tx, reportID, changeOwner(owners, uID, true)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Set(r.linkRef(uID, reportID), roleFields(role)); err != nil {
+		return err
+	}
+	return tx.Set(r.memberRef(uID, reportID), map[string]interface{}{
+		"role":     string(role),
+		"joinedAt": time.Now(),
+	})
+}
+
+func (r *FirestoreRepository) GetMemberRole(uID, reportID string) (reportRoles.Role, error) {
+	doc, err := r.linkRef(uID, reportID).Get(r.Ctx)
+	if status.Code(err) == codes.NotFound {
+		return reportRoles.None, fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	if err != nil {
+		return reportRoles.None, fmt.Errorf("failed to fetch member role: %w", err)
+	}
+	return linkRole(doc.Data()), nil
+}
+
+// Member is someone in a report, as listed by the report's members index
+type Member struct {
+	UID      string           `json:"uid"`
+	Email    string           `json:"email,omitempty"` // Filled in by the API
+	Role     reportRoles.Role `json:"role"`
+	JoinedAt time.Time        `json:"joinedAt"`
+}
+
+// ListMembers returns the members of a report, in the order they joined
+func (r *FirestoreRepository) ListMembers(reportID string) ([]Member, error) {
+	docs, err := r.Client.Collection("reports").Doc(reportID).Collection("members").OrderBy("joinedAt", firestore.Asc).Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch members: %w", err)
+	}
+
+	members := []Member{}
+	for _, doc := range docs {
+		role, _ := doc.Data()["role"].(string)
+		joinedAt, _ := doc.Data()["joinedAt"].(time.Time)
+		members = append(members, Member{UID: doc.Ref.ID, Role: reportRoles.Role(role), JoinedAt: joinedAt})
+	}
+	return members, nil
+}
+
+// MigrateMembers fills the members index of every report from the links of
+// its members, for memberships from before the index. Members already in the
+// index are left alone, and memberships of deleted reports are skipped. The
+// join date of a membership is when its link was created. It returns the
+// number of members added to an index.
+func (r *FirestoreRepository) MigrateMembers() (int, error) {
+	links, err := r.Client.CollectionGroup("linkedReports").Documents(r.Ctx).GetAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch linked reports: %w", err)
+	}
+
+	reportExists := make(map[string]bool)
+	migrated := 0
+	for _, link := range links {
+		reportID, uID := link.Ref.ID, link.Ref.Parent.Parent.ID
+		exists, checked := reportExists[reportID]
+		if !checked {
+			_, err := r.Client.Collection("reports").Doc(reportID).Get(r.Ctx)
+			if err != nil && status.Code(err) != codes.NotFound {
+				return migrated, fmt.Errorf("failed to fetch report %s: %w", reportID, err)
+			}
+			exists = err == nil
+			reportExists[reportID] = exists
+		}
+		if !exists {
+			continue
+		}
+
+		_, err := r.memberRef(uID, reportID).Create(r.Ctx, map[string]interface{}{
+			"role":     string(linkRole(link.Data())),
+			"joinedAt": link.CreateTime,
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("failed to index member %s of report %s: %w", uID, reportID, err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// SetMemberRole changes the role of a member, keeping their section grants.
+// The last owner of a report cannot be given another role.
+func (r *FirestoreRepository) SetMemberRole(uID, reportID string, role reportRoles.Role) error {
+	return r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := r.memberRole(tx, uID, reportID)
+		if err != nil {
+			return err
+		}
+
+		if current == reportRoles.Owner || role == reportRoles.Owner {
+			owners, err := r.reportOwners(tx, reportID, knownOwner(uID, current))
+			if err != nil {
+				return err
+			}
+			if err := r.setOwners(tx, reportID, changeOwner(owners, uID, role == reportRoles.Owner)); err != nil {
+				return err
+			}
+		}
+		return r.setRole(tx, uID, reportID, role)
+	})
+}
+
+// TransferOwnership makes a member an owner in place of an owner, who stays
+// on as an admin
+func (r *FirestoreRepository) TransferOwnership(reportID, fromUID, toUID string) error {
+	return r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		from, err := r.memberRole(tx, fromUID, reportID)
+		if err != nil {
+			return err
+		}
+		if from != reportRoles.Owner {
+			return fmt.Errorf("%w: %s", ErrNotOwner, fromUID)
+		}
+		to, err := r.memberRole(tx, toUID, reportID)
+		if err != nil {
+			return err
+		}
+		if fromUID == toUID {
+			return nil
+		}
+
+		owners, err := r.reportOwners(tx, reportID, fromUID)
+		if err != nil {
+			return err
+		}
+		owners = changeOwner(changeOwner(owners, fromUID, false), toUID, true)
+		if err := r.setOwners(tx, reportID, owners); err != nil {
+			return err
+		}
+		if to != reportRoles.Owner {
+			if err := r.setRole(tx, toUID, reportID, reportRoles.Owner); err != nil {
+				return err
+			}
+		}
+		return r.setRole(tx, fromUID, reportID, reportRoles.Admin)
+	})
+}
+
+// Invitations are kept in reports/{reportID}/invitations/{invitationID}, and
+// found by email across reports when someone signs in.
+func (r *FirestoreRepository) invitationsRef(reportID string) *firestore.CollectionRef {
+	return r.Client.Collection("reports").Doc(reportID).Collection("invitations")
+}
+
+func invitationFromDoc(doc *firestore.DocumentSnapshot) invitation.Invitation {
+	data := doc.Data()
+	invite := invitation.Invitation{ID: doc.Ref.ID, ReportID: doc.Ref.Parent.Parent.ID}
+	invite.Email, _ = data["email"].(string)
+	role, _ := data["role"].(string)
+	invite.Role = reportRoles.Role(role)
+	invite.Token, _ = data["token"].(string)
+	invite.InvitedBy, _ = data["invitedBy"].(string)
+	invite.CreatedAt, _ = data["createdAt"].(time.Time)
+	invite.ExpiresAt, _ = data["expiresAt"].(time.Time)
+	return invite
+}
+
+// CreateInvitation stores a pending invitation and returns its ID
+func (r *FirestoreRepository) CreateInvitation(invite invitation.Invitation) (string, error) {
+	invitationID := newID()
+	invitations := r.invitationsRef(invite.ReportID)
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		existing, err := tx.Documents(invitations.Where("email", "==", invite.Email)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to fetch invitations: %w", err)
+		}
+		if len(existing) > 0 {
+			return fmt.Errorf("%w: %s", ErrInvitationExists, invite.Email)
+		}
+
+		return tx.Create(invitations.Doc(invitationID), map[string]interface{}{
+			"email":     invite.Email,
+			"role":      string(invite.Role),
+			"token":     invite.Token,
+			"invitedBy": invite.InvitedBy,
+			"createdAt": invite.CreatedAt,
+			"expiresAt": invite.ExpiresAt,
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return invitationID, nil
+}
+
+// ListInvitations returns the invitations of a report, oldest first. Expired
+// ones are kept until they are renewed or revoked.
+func (r *FirestoreRepository) ListInvitations(reportID string) ([]invitation.Invitation, error) {
+	docs, err := r.invitationsRef(reportID).OrderBy("createdAt", firestore.Asc).Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invitations: %w", err)
+	}
+
+	invitations := []invitation.Invitation{}
+	for _, doc := range docs {
+		invitations = append(invitations, invitationFromDoc(doc))
+	}
+	return invitations, nil
+}
+
+// RenewInvitation gives an invitation a new token and expiry, so the link
+// sent before stops working
+func (r *FirestoreRepository) RenewInvitation(reportID, invitationID, token string, expiresAt time.Time) (*invitation.Invitation, error) {
+	ref := r.invitationsRef(reportID).Doc(invitationID)
+	_, err := ref.Update(r.Ctx, []firestore.Update{
+		{Path: "token", Value: token},
+		{Path: "expiresAt", Value: expiresAt},
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrInvitationNotFound, invitationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew invitation: %w", err)
+	}
+
+	doc, err := ref.Get(r.Ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invitation: %w", err)
+	}
+	invite := invitationFromDoc(doc)
+	return &invite, nil
+}
+
+// RevokeInvitation deletes an invitation and returns what it was
+func (r *FirestoreRepository) RevokeInvitation(reportID, invitationID string) (*invitation.Invitation, error) {
+	ref := r.invitationsRef(reportID).Doc(invitationID)
+	var invite invitation.Invitation
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %s", ErrInvitationNotFound, invitationID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to fetch invitation: %w", err)
+		}
+		invite = invitationFromDoc(doc)
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// FindInvitations returns the invitations for an email in every report
+func (r *FirestoreRepository) FindInvitations(email string) ([]invitation.Invitation, error) {
+	docs, err := r.Client.CollectionGroup("invitations").Where("email", "==", invitation.NormalizeEmail(email)).Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invitations: %w", err)
+	}
+
+	invitations := []invitation.Invitation{}
+	for _, doc := range docs {
+		invitations = append(invitations, invitationFromDoc(doc))
+	}
+	return invitations, nil
+}
+
+// AcceptInvitation adds the user to the invitation's report with its role
+// and deletes it. Members already in the report keep their role.
+func (r *FirestoreRepository) AcceptInvitation(uID string, invite invitation.Invitation) error {
+	ref := r.invitationsRef(invite.ReportID).Doc(invite.ID)
+	return r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); status.Code(err) == codes.NotFound {
+			return fmt.Errorf("%w: %s", ErrInvitationNotFound, invite.ID)
+		} else if err != nil {
+			return fmt.Errorf("failed to fetch invitation: %w", err)
+		}
+
+		err := r.link(tx, uID, invite.ReportID, invite.Role)
+		if err != nil && !errors.Is(err, ErrAlreadyInReport) {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Comment threads are kept in reports/{reportID}/comments/{threadID} with the
+// ID of their subsection, so they stay put when the subsection moves
+func (r *FirestoreRepository) commentsRef(reportID string) *firestore.CollectionRef {
+	return r.Client.Collection("reports").Doc(reportID).Collection("comments")
+}
+
+func commentFromDoc(doc *firestore.DocumentSnapshot) comment.Thread {
+	data := doc.Data()
+	thread := comment.Thread{ID: doc.Ref.ID, ReportID: doc.Ref.Parent.Parent.ID, Replies: []comment.Reply{}}
+	thread.SubsectionID, _ = data["subsectionID"].(string)
+	if anchor, ok := data["anchor"].(map[string]interface{}); ok {
+		index, _ := anchor["index"].(int64)
+		length, _ := anchor["length"].(int64)
+		thread.Anchor = comment.Anchor{Index: int(index), Length: int(length)}
+	}
+	thread.Author, _ = data["author"].(string)
+	thread.Body, _ = data["body"].(string)
+	thread.Mentions = stringList(data["mentions"])
+	thread.CreatedAt, _ = data["createdAt"].(time.Time)
+	thread.Resolved, _ = data["resolved"].(bool)
+	thread.ResolvedBy, _ = data["resolvedBy"].(string)
+	if resolvedAt, ok := data["resolvedAt"].(time.Time); ok {
+		thread.ResolvedAt = &resolvedAt
+	}
+
+	replies, _ := data["replies"].([]interface{})
+	for _, value := range replies {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var reply comment.Reply
+		reply.ID, _ = fields["id"].(string)
+		reply.Author, _ = fields["author"].(string)
+		reply.Body, _ = fields["body"].(string)
+		reply.Mentions = stringList(fields["mentions"])
+		reply.CreatedAt, _ = fields["createdAt"].(time.Time)
+		thread.Replies = append(thread.Replies, reply)
+	}
+	return thread
+}
+
+func stringList(value interface{}) []string {
+	list := []string{}
+	values, _ := value.([]interface{})
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func anchorFields(anchor comment.Anchor) map[string]interface{} {
+	return map[string]interface{}{"index": anchor.Index, "length": anchor.Length}
+}
+
+// CreateCommentThread stores a new thread and returns its ID
+func (r *FirestoreRepository) CreateCommentThread(thread comment.Thread) (string, error) {
+	threadID := newID()
+	_, err := r.commentsRef(thread.ReportID).Doc(threadID).Create(r.Ctx, map[string]interface{}{
+		"subsectionID": thread.SubsectionID,
+		"anchor":       anchorFields(thread.Anchor),
+		"author":       thread.Author,
+		"body":         thread.Body,
+		"mentions":     thread.Mentions,
+		"createdAt":    thread.CreatedAt,
+		"replies":      []interface{}{},
+		"resolved":     false,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create comment thread: %w", err)
+	}
+	return threadID, nil
+}
+
+// FetchCommentThreads returns the threads on the subsections of a section,
+// oldest first
+func (r *FirestoreRepository) FetchCommentThreads(reportID, sectionID string) ([]comment.Thread, error) {
+	refs, err := r.sectionDocRef(reportID, sectionID).Collection("subsections").DocumentRefs(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subsections: %w", err)
+	}
+
+	// Firestore takes up to 30 values for "in"
+	threads := []comment.Thread{}
+	for start := 0; start < len(refs); start += 30 {
+		var subsectionIDs []string
+		for _, ref := range refs[start:min(start+30, len(refs))] {
+			subsectionIDs = append(subsectionIDs, ref.ID)
+		}
+		docs, err := r.commentsRef(reportID).Where("subsectionID", "in", subsectionIDs).Documents(r.Ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch comment threads: %w", err)
+		}
+		for _, doc := range docs {
+			threads = append(threads, commentFromDoc(doc))
+		}
+	}
+
+	sort.Slice(threads, func(i, j int) bool {
+		if !threads[i].CreatedAt.Equal(threads[j].CreatedAt) {
+			return threads[i].CreatedAt.Before(threads[j].CreatedAt)
+		}
+		return threads[i].ID < threads[j].ID
+	})
+	return threads, nil
+}
+
+func (r *FirestoreRepository) GetCommentThread(reportID, threadID string) (*comment.Thread, error) {
+	doc, err := r.commentsRef(reportID).Doc(threadID).Get(r.Ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrCommentNotFound, threadID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch comment thread: %w", err)
+	}
+	thread := commentFromDoc(doc)
+	return &thread, nil
+}
+
+// updateCommentThread changes a thread and returns it as it is afterwards
+func (r *FirestoreRepository) updateCommentThread(reportID, threadID string, updates []firestore.Update) (*comment.Thread, error) {
+	_, err := r.commentsRef(reportID).Doc(threadID).Update(r.Ctx, updates)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrCommentNotFound, threadID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update comment thread: %w", err)
+	}
+	return r.GetCommentThread(reportID, threadID)
+}
+
+func (r *FirestoreRepository) AddCommentReply(reportID, threadID string, reply comment.Reply) (*comment.Thread, error) {
+	return r.updateCommentThread(reportID, threadID, []firestore.Update{{
+		Path: "replies",
+		Value: firestore.ArrayUnion(map[string]interface{}{
+			"id":        reply.ID,
+			"author":    reply.Author,
+			"body":      reply.Body,
+			"mentions":  reply.Mentions,
+			"createdAt": reply.CreatedAt,
+		}),
+	}})
+}
+
+// SetCommentResolved resolves a thread, or reopens it when resolved is false
+func (r *FirestoreRepository) SetCommentResolved(reportID, threadID string, resolved bool, by string, at time.Time) (*comment.Thread, error) {
+	updates := []firestore.Update{
+		{Path: "resolved", Value: true},
+		{Path: "resolvedBy", Value: by},
+		{Path: "resolvedAt", Value: at},
+	}
+	if !resolved {
+		updates = []firestore.Update{
+			{Path: "resolved", Value: false},
+			{Path: "resolvedBy", Value: firestore.Delete},
+			{Path: "resolvedAt", Value: firestore.Delete},
+		}
+	}
+	return r.updateCommentThread(reportID, threadID, updates)
+}
+
+// UpdateCommentAnchors saves where threads moved to as their subsections
+// were edited. Threads that no longer exist are skipped.
+func (r *FirestoreRepository) UpdateCommentAnchors(reportID string, anchors map[string]comment.Anchor) error {
+	for threadID, anchor := range anchors {
+		_, err := r.commentsRef(reportID).Doc(threadID).Update(r.Ctx, []firestore.Update{{Path: "anchor", Value: anchorFields(anchor)}})
+		if err != nil && status.Code(err) != codes.NotFound {
+			return fmt.Errorf("failed to update comment anchor: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetSectionGrants returns the levels a member has been granted in the
+// sections of a report, by section ID. Grants are kept in a "sections" map on
+// the member's linkedReports document.
+func (r *FirestoreRepository) GetSectionGrants(uID, reportID string) (map[string]sectionAccess.Level, error) {
+	doc, err := r.Client.Collection("users").Doc(uID).Collection("linkedReports").Doc(reportID).Get(r.Ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch section grants: %w", err)
+	}
+
+	grants := make(map[string]sectionAccess.Level)
+	sections, _ := doc.Data()["sections"].(map[string]interface{})
+	for sectionID, level := range sections {
+		if level, ok := level.(string); ok {
+			grants[sectionID] = sectionAccess.Level(level)
+		}
+	}
+	return grants, nil
+}
+
+// SetSectionGrant gives a member a level in a section, replacing any they had
+func (r *FirestoreRepository) SetSectionGrant(uID, reportID, sectionID string, level sectionAccess.Level) error {
+	return r.updateSectionGrant(uID, reportID, sectionID, string(level))
+}
+
+// RevokeSectionGrant takes away a member's grant in a section
+func (r *FirestoreRepository) RevokeSectionGrant(uID, reportID, sectionID string) error {
+	return r.updateSectionGrant(uID, reportID, sectionID, firestore.Delete)
+}
+
+func (r *FirestoreRepository) updateSectionGrant(uID, reportID, sectionID string, value interface{}) error {
+	linkRef := r.Client.Collection("users").Doc(uID).Collection("linkedReports").Doc(reportID)
+	_, err := linkRef.Update(r.Ctx, []firestore.Update{{FieldPath: firestore.FieldPath{"sections", sectionID}, Value: value}})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("%w: %s", ErrUserNotInReport, uID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update section grant: %w", err)
+	}
+	return nil
+}
+
+type Report struct {
+	ReportID    string    `json:"reportID"`
+	ReportTitle  string    `json:"reportTitle"`
+	CreationTime time.Time `json:"creationTime"`
+	TemplateID      string `json:"templateID,omitempty"`
+	TemplateVersion int    `json:"templateVersion"` // 0 for templates without versions
+}
+
+func (r *FirestoreRepository) GetUserReportLinks(uID string) ([]Report, error) {
+	// Get a reference to the "linkedReports" subcollection inside the user document
+	reportsCollection := r.Client.Collection("users").Doc(uID).Collection("linkedReports")
+
+	// Query all documents in the subcollection
+	docs, err := reportsCollection.Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get linked reports for user: %v", err)
+	}
+
+	// Initialize a slice to store the reports
+	var reports []Report
+
+	for _, doc := range docs {
+		reportID := doc.Ref.ID // Report ID is the document ID
+
+		// Fetch the actual report document from the "reports" collection
+		reportDocRef := r.Client.Collection("reports").Doc(reportID)
+		reportDoc, err := reportDocRef.Get(r.Ctx)
+		if err != nil {
+			log.Printf("failed to get report document for reportID: %v\n", reportID)
+			log.Printf("removing reportID: %v, from users: %s, colleciton\n", reportID, uID)
+			// remove the report collection here 
+			// Delete broken link
+			_, delErr := doc.Ref.Delete(r.Ctx)
+			if delErr != nil {
+				log.Printf("Failed to delete broken report link: %v", delErr)
+			}
+
+			continue // Skips broken report
+
+		}
 
 		// Extract relevant fields from the report document
 		reportData := reportDoc.Data()
@@ -411,11 +1833,16 @@ func (r *FirestoreRepository) GetUserReportLinks(uID string) ([]Report, error) {
 			return nil, fmt.Errorf("missing reportName in report document for reportID: %v", reportID)
 		}
 
+		templateID, _ := reportData["templateID"].(string)
+		templateVersion, _ := reportData["templateVersion"].(int64)
+
 		// Append the report to the reports slice
 		reports = append(reports, Report{
 			ReportID:     reportID,
 			ReportTitle:  reportName,
 			CreationTime: creationTime,
+			TemplateID:      templateID,
+			TemplateVersion: int(templateVersion),
 		})
 	}
 	// Sort the reports slice by creationTime (descending)
@@ -456,36 +1883,35 @@ func (r *FirestoreRepository) IsAdminInReport(uID, reportID string) (bool, error
 		return false, fmt.Errorf("failed to check if user is in report: %w", err)
 	}
 
-	// Check privilege flag
-	privilege, ok := doc.Data()["privilege"].(bool)
-	if !ok {
-		return false, nil // Privilege not set or wrong type
-	}
-
-	return privilege, nil
+	return linkRole(doc.Data()).IsAdmin(), nil
 }
 
 
+// RemoveUserFromReport takes a member out of a report. The last owner of a
+// report cannot be removed.
 func (r *FirestoreRepository) RemoveUserFromReport(uID, reportID string) error {
-	reportDocRef := r.Client.Collection("users").Doc(uID).Collection("linkedReports").Doc(reportID)
-
-	// Get the document snapshot
-	docSnapshot, err := reportDocRef.Get(r.Ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get report document: %w", err)
-	}
-
-	if docSnapshot.Exists() {
-		if privilege, ok := docSnapshot.Data()["privilege"].(bool); ok && privilege {
-			fmt.Println("User is Admin, cannot be removed")
-			return nil
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		role, err := r.memberRole(tx, uID, reportID)
+		if err != nil {
+			return err
 		}
-	}
 
-	// Delete the document to remove user from report
-	_, err = reportDocRef.Delete(r.Ctx)
+		if role == reportRoles.Owner {
+			owners, err := r.reportOwners(tx, reportID, uID)
+			if err != nil {
+				return err
+			}
+			if err := r.setOwners(tx, reportID, changeOwner(owners, uID, false)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(r.linkRef(uID, reportID)); err != nil {
+			return err
+		}
+		return tx.Delete(r.memberRef(uID, reportID))
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete report document: %w", err)
+		return err
 	}
 
 	fmt.Println("Removed user from report")
@@ -509,48 +1935,29 @@ func (r *FirestoreRepository) RenameReport(reportID, reportName string) error {
 func (r *FirestoreRepository) DeleteReport(reportID string) error {
 	reportDoc := r.Client.Collection("reports").Doc(reportID)
 
-	// Get all sections
-	sectionsIter := reportDoc.Collection("sections").Documents(r.Ctx)
-	defer sectionsIter.Stop()
-
-	for {
-		sectionDoc, err := sectionsIter.Next()
-		if err == iterator.Done {
-			break
-		}
+	// Sections go with their subsections and every version of them.
+	// Invitations to a deleted report cannot be accepted, and it has no
+	// members left to list or subsections to comment on. The logs are kept.
+	for _, collection := range []string{"sections", "invitations", "members", "comments"} {
+		docs, err := reportDoc.Collection(collection).DocumentRefs(r.Ctx).GetAll()
 		if err != nil {
-			return fmt.Errorf("failed to iterate sections: %w", err)
+			return fmt.Errorf("failed to fetch %s: %w", collection, err)
 		}
-
-		// Get all subsections in this section
-		subsectionsIter := sectionDoc.Ref.Collection("subsections").Documents(r.Ctx)
-		for {
-			subDoc, err := subsectionsIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				return fmt.Errorf("failed to iterate subsections: %w", err)
+		for _, doc := range docs {
+			// Members are indexed by UID, their links to the report go first
+			if collection == "members" {
+				if _, err := r.linkRef(doc.ID, reportID).Delete(r.Ctx); err != nil {
+					return fmt.Errorf("failed to delete link of member %s: %w", doc.ID, err)
+				}
 			}
-			_, err = subDoc.Ref.Delete(r.Ctx)
-			if err != nil {
-				return fmt.Errorf("failed to delete subsection: %w", err)
+			if err := r.deleteTree(doc); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", collection, err)
 			}
 		}
-
-		// Delete section after its subsections
-		_, err = sectionDoc.Ref.Delete(r.Ctx)
-		if err != nil {
-			return fmt.Errorf("failed to delete section: %w", err)
-		}
 	}
 
-	logEntry := map[string]interface{}{
-		"timestamp": time.Now(),
-		"message":   "Report was deleted",
-	}
-
-	_, err := reportDoc.Collection("logs").NewDoc().Set(r.Ctx, logEntry)
+	deleted := stampEvent(audit.Event{ReportID: reportID, Action: audit.ReportDeleted, Message: "Report was deleted"})
+	err := r.appendLogs(reportID, []audit.Event{deleted})
 	if err != nil {
 		return fmt.Errorf("failed to log report deletion: %w", err)
 	}
@@ -578,12 +1985,16 @@ func (r *FirestoreRepository) DestroyUser(uID string) error {
 	}
 
 	for _, doc := range docs {
-		isOwner, exists := doc.Data()["owner"].(bool)
-		if exists && isOwner {
-			reportID := doc.Ref.ID
+		// Reports the user is the last owner of go with them. Links to
+		// reports deleted before are broken and only deleted.
+		reportID := doc.Ref.ID
+		err = r.RemoveUserFromReport(uID, reportID)
+		if errors.Is(err, ErrLastOwner) {
 			if err := r.DeleteReport(reportID); err != nil {
 				return fmt.Errorf("failed to delete owned report %s: %w", reportID, err)
 			}
+		} else if err != nil && !errors.Is(err, ErrReportNotFound) {
+			return fmt.Errorf("failed to remove user from report %s: %w", reportID, err)
 		}
 
 		// Delete the linked report reference
@@ -604,33 +2015,276 @@ func (r *FirestoreRepository) DestroyUser(uID string) error {
 }
 
 
-func (r *FirestoreRepository) FetchLogsForReport(reportID string) ([]string, error) {
+// FetchLogsForReport returns the report's audit events matching the query,
+// oldest first. Filtering on more than one field needs a composite index on
+// the logs collection.
+func (r *FirestoreRepository) FetchLogsForReport(reportID string, query audit.Query) ([]audit.Event, error) {
 	logsCollection := r.Client.Collection("reports").Doc(reportID).Collection("logs")
 
-	// Get all log documents
-	docs, err := logsCollection.OrderBy("timestamp", firestore.Asc).Documents(r.Ctx).GetAll()
+	// Entries from before typed events keep the actor in userID too
+	q := logsCollection.Query
+	if query.Actor != "" {
+		q = q.Where("userID", "==", query.Actor)
+	}
+	if query.Action != "" {
+		q = q.Where("action", "==", string(query.Action))
+	}
+	if !query.From.IsZero() {
+		q = q.Where("timestamp", ">=", query.From)
+	}
+	if !query.To.IsZero() {
+		q = q.Where("timestamp", "<", query.To)
+	}
+	q = q.OrderBy("timestamp", firestore.Asc).OrderBy(firestore.DocumentID, firestore.Asc)
+
+	if query.After != "" {
+		cursor, err := logsCollection.Doc(query.After).Get(r.Ctx)
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrEventNotFound, query.After)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch log cursor: %w", err)
+		}
+		q = q.StartAfter(cursor)
+	}
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	docs, err := q.Documents(r.Ctx).GetAll()
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch logs for report: %w", err)
 	}
 
-	var logs []string
+	events := make([]audit.Event, 0, len(docs))
+	for _, doc := range docs {
+		if event, ok := eventFromDoc(reportID, doc); ok {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// FetchLogChain returns every event of a report's logs with the head of its
+// chain, for audit.Verify
+func (r *FirestoreRepository) FetchLogChain(reportID string) ([]audit.Event, audit.Head, error) {
+	docs, err := r.Client.Collection("reports").Doc(reportID).Collection("logs").Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, audit.Head{}, fmt.Errorf("failed to fetch logs for report: %w", err)
+	}
+
+	events := make([]audit.Event, 0, len(docs))
 	for _, doc := range docs {
-		message, ok := doc.Data()["message"].(string)
+		event, ok := eventFromDoc(reportID, doc)
+		if !ok {
+			// Kept so verification sees an entry was changed
+			event = audit.Event{ID: doc.Ref.ID, ReportID: reportID}
+			event.Seq, _ = doc.Data()["seq"].(int64)
+		}
+		events = append(events, event)
+	}
+
+	doc, err := r.logChainHead(reportID).Get(r.Ctx)
+	if status.Code(err) == codes.NotFound {
+		return events, audit.Head{}, nil
+	}
+	if err != nil {
+		return nil, audit.Head{}, fmt.Errorf("failed to read log chain: %w", err)
+	}
+	return events, chainHead(doc), nil
+}
+
+// stampEvent gives a new event its ID and time
+func stampEvent(event audit.Event) audit.Event {
+	if event.ID == "" {
+		event.ID = newID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	return event
+}
+
+// eventFields is how an audit event is stored in a report's logs, keyed by
+// the event ID
+func eventFields(event audit.Event) map[string]interface{} {
+	fields := map[string]interface{}{
+		"timestamp": event.Time,
+		"userID":    event.Actor,
+		"action":    string(event.Action),
+		"message":   event.Message,
+	}
+	optional := map[string]string{
+		"sectionID":    event.SectionID,
+		"subsectionID": event.SubsectionID,
+		"ip":           event.IP,
+		"userAgent":    event.UserAgent,
+	}
+	for field, value := range optional {
+		if value != "" {
+			fields[field] = value
+		}
+	}
+	if len(event.Payload) > 0 {
+		fields["payload"] = event.Payload
+	}
+	if event.Seq > 0 {
+		fields["seq"] = event.Seq
+		fields["prevHash"] = event.PrevHash
+		fields["hash"] = event.Hash
+	}
+	return fields
+}
+
+// eventFromDoc reads a stored audit event. Entries from before typed events
+// have no action and start their message with the actor, which is dropped.
+func eventFromDoc(reportID string, doc *firestore.DocumentSnapshot) (audit.Event, bool) {
+	data := doc.Data()
+	message, ok := data["message"].(string)
+	if !ok {
+		return audit.Event{}, false // skip invalid entries
+	}
+
+	event := audit.Event{ID: doc.Ref.ID, ReportID: reportID, Message: message}
+	event.Time, _ = data["timestamp"].(time.Time)
+	event.Actor, _ = data["userID"].(string)
+	action, _ := data["action"].(string)
+	event.Action = audit.Action(action)
+	event.SectionID, _ = data["sectionID"].(string)
+	event.SubsectionID, _ = data["subsectionID"].(string)
+	event.IP, _ = data["ip"].(string)
+	event.UserAgent, _ = data["userAgent"].(string)
+	event.Payload, _ = data["payload"].(map[string]interface{})
+	event.Seq, _ = data["seq"].(int64)
+	event.PrevHash, _ = data["prevHash"].(string)
+	event.Hash, _ = data["hash"].(string)
+
+	if event.Action == "" && event.Actor != "" {
+		event.Message = strings.TrimPrefix(message, event.Actor+" ")
+	}
+	return event, true
+}
+
+
+// Reviews are kept on the subsection document, so they move with it. A
+// subsection without a status is a draft.
+func reviewFromData(data map[string]interface{}) review.Review {
+	r := review.New()
+	if s, ok := data["status"].(string); ok && review.Status(s).Valid() {
+		r.Status = review.Status(s)
+	}
+	r.Reviewers = stringList(data["reviewers"])
+	history, _ := data["reviewHistory"].([]interface{})
+	for _, value := range history {
+		fields, ok := value.(map[string]interface{})
 		if !ok {
-			continue // skip invalid entries
+			continue
+		}
+		var t review.Transition
+		from, _ := fields["from"].(string)
+		to, _ := fields["to"].(string)
+		t.From, t.To = review.Status(from), review.Status(to)
+		t.By, _ = fields["by"].(string)
+		t.At, _ = fields["at"].(time.Time)
+		t.Note, _ = fields["note"].(string)
+		r.History = append(r.History, t)
+	}
+	return r
+}
+
+func (r *FirestoreRepository) GetSubsectionReview(reportID, sectionID, subsectionID string) (*review.Review, error) {
+	doc, err := r.subsectionDocRef(reportID, sectionID, subsectionID).Get(r.Ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+		}
+		return nil, fmt.Errorf("failed to fetch subsection: %w", err)
+	}
+	subsectionReview := reviewFromData(doc.Data())
+	return &subsectionReview, nil
+}
+
+// FetchSectionReviews returns the review of every subsection of a section
+func (r *FirestoreRepository) FetchSectionReviews(reportID, sectionID string) (map[string]review.Review, error) {
+	docs, err := r.sectionDocRef(reportID, sectionID).Collection("subsections").Documents(r.Ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subsections: %w", err)
+	}
+	reviews := make(map[string]review.Review)
+	for _, doc := range docs {
+		reviews[doc.Ref.ID] = reviewFromData(doc.Data())
+	}
+	return reviews, nil
+}
+
+// ChangeSubsectionStatus moves a subsection from change.From to change.To and
+// records the change, or returns ErrStatusChanged if it is no longer at
+// change.From
+func (r *FirestoreRepository) ChangeSubsectionStatus(reportID, sectionID, subsectionID string, change review.Transition) (*review.Review, error) {
+	ref := r.subsectionDocRef(reportID, sectionID, subsectionID)
+	var changed review.Review
+	err := r.Client.RunTransaction(r.Ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return fmt.Errorf("%w: %s", ErrSectionNotFound, subsectionID)
+			}
+			return err
+		}
+		changed = reviewFromData(doc.Data())
+		if changed.Status != change.From {
+			return fmt.Errorf("%w: %s is %s", ErrStatusChanged, subsectionID, changed.Status)
 		}
 
-		// Optionally include timestamp
-		timestamp, _ := doc.Data()["timestamp"].(time.Time)
-		logs = append(logs, fmt.Sprintf("[%s] %s", timestamp.Format("2006-01-02 15:04:05"), message))
+		changed.Status = change.To
+		changed.History = append(changed.History, change)
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: string(change.To)},
+			{Path: "reviewHistory", Value: firestore.ArrayUnion(map[string]interface{}{
+				"from": string(change.From),
+				"to":   string(change.To),
+				"by":   change.By,
+				"at":   change.At,
+				"note": change.Note,
+			})},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to change subsection status: %w", err)
 	}
+	return &changed, nil
+}
 
-	return logs, nil
+// SetSubsectionReviewers replaces who is assigned to review a subsection
+func (r *FirestoreRepository) SetSubsectionReviewers(reportID, sectionID, subsectionID string, reviewers []string) (*review.Review, error) {
+	ref, err := r.existingDoc(r.subsectionDocRef(reportID, sectionID, subsectionID))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ref.Update(r.Ctx, []firestore.Update{{Path: "reviewers", Value: reviewers}}); err != nil {
+		return nil, fmt.Errorf("failed to set reviewers: %w", err)
+	}
+	return r.GetSubsectionReview(reportID, sectionID, subsectionID)
 }
 
+// newID generates the ID of a new section or subsection, in the same
+// alphabet and length as Firestore's own document IDs
+func newID() string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return string(b)
+}
 
-func sanitizeFirebaseDocName(name string) string {
-	return strings.ReplaceAll(name, "/", "_")
+// sameTitle compares titles the way cross-references match them
+func sameTitle(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
 }
 
 
@@ -640,7 +2294,7 @@ func NewTestFirestoreRepository(client *firestore.Client, ctx context.Context) *
 	repo := &FirestoreRepository{
 		Client:     client,
 		Ctx:        ctx,
-		logBuffers: make(map[string][]map[string]interface{}),
+		logBuffers: make(map[string][]audit.Event),
 	}
 	repo.StartLogFlusher(1 * time.Second) // optional during tests
 	return repo
@@ -4,10 +4,18 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/stretchr/testify/assert"
+	"sema/models/audit"
+	"sema/models/comment"
+	"sema/models/invitation"
+	"sema/models/reportRoles"
+	"sema/models/reportTemplates"
+	"sema/models/review"
 	"sema/repository"
+	"sema/services/sectionAccess"
 )
 
 func setupTestRepo(t *testing.T) *repository.FirestoreRepository {
@@ -42,17 +50,18 @@ func TestCreateReport(t *testing.T) {
 
 func TestLinkReportWithUser(t *testing.T) {
 	repo := setupTestRepo(t)
-	err := repo.LinkReportWithUser("user123", "test-report-123", true, true)
+	err := repo.LinkReportWithUser("user123", "test-report-123", reportRoles.Owner)
 	assert.NoError(t, err)
 	doc, err := repo.Client.Collection("users").Doc("user123").Collection("linkedReports").Doc("test-report-123").Get(repo.Ctx)
 	assert.NoError(t, err)
 	assert.Equal(t, true, doc.Data()["privilege"])
+	assert.Equal(t, "owner", doc.Data()["role"])
 }
 
 func TestBufferAndFlushLogs(t *testing.T) {
 	repo := setupTestRepo(t)
 	reportID := "log-test-report"
-	repo.BufferLog(reportID, "log message", "user@example.com")
+	repo.BufferLog(audit.Event{ReportID: reportID, Actor: "user@example.com", Action: audit.ContentEdited, Message: "log message"})
 	repo.FlushLogs(reportID)
 	docs, err := repo.Client.Collection("reports").Doc(reportID).Collection("logs").Documents(repo.Ctx).GetAll()
 	assert.NoError(t, err)
@@ -77,11 +86,41 @@ func TestGetReportFieldTemplateID(t *testing.T) {
 func TestFetchReportSectionContentsAndUpdate(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("Test", "section-test-id", "template123", "test@example.com")
-	err := repo.UpdateReportSectionContents("section-test-id", "Introduction", "Overview", "Updated Content")
+	introduction, overview := structureIDs(t, repo, "section-test-id", "Introduction", "Overview")
+	err := repo.UpdateReportSectionContents("section-test-id", introduction, overview, "Updated Content", "test@example.com")
 	assert.NoError(t, err)
-	contents, err := repo.FetchReportSectionContents("section-test-id", "Introduction")
+	contents, err := repo.FetchReportSectionContents("section-test-id", introduction)
 	assert.NoError(t, err)
-	assert.Equal(t, "Updated Content", contents["Overview"])
+	assert.Equal(t, "Updated Content", contents[overview])
+
+	versions, err := repo.ListSubsectionVersions("section-test-id", introduction, overview, repository.VersionPage{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	assert.Equal(t, "test@example.com", versions[0].Author)
+
+	version, err := repo.GetSubsectionVersion("section-test-id", introduction, overview, versions[0].VersionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated Content", version.Content)
+
+	// Unchanged saves are skipped and the same author saves into the latest version
+	assert.NoError(t, repo.UpdateReportSectionContents("section-test-id", introduction, overview, "Updated Content", "other@example.com"))
+	assert.NoError(t, repo.UpdateReportSectionContents("section-test-id", introduction, overview, "Edited Content", "test@example.com"))
+	versions, err = repo.ListSubsectionVersions("section-test-id", introduction, overview, repository.VersionPage{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+	version, err = repo.GetSubsectionVersion("section-test-id", introduction, overview, versions[0].VersionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Edited Content", version.Content)
+
+	assert.NoError(t, repo.UpdateReportSectionContents("section-test-id", introduction, overview, "Other Content", "other@example.com"))
+	page, err := repo.ListSubsectionVersions("section-test-id", introduction, overview, repository.VersionPage{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "other@example.com", page[0].Author)
+	page, err = repo.ListSubsectionVersions("section-test-id", introduction, overview, repository.VersionPage{After: page[0].VersionID})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "test@example.com", page[0].Author)
 }
 
 func TestFetchReportContent(t *testing.T) {
@@ -96,7 +135,7 @@ func TestFetchReportContent(t *testing.T) {
 func TestUserReportLinkingAndFetching(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("User Linked Report", "linked-report-id", "template123", "user@test.com")
-	repo.LinkReportWithUser("userUID", "linked-report-id", true, true)
+	repo.LinkReportWithUser("userUID", "linked-report-id", reportRoles.Owner)
 	reports, err := repo.GetUserReportLinks("userUID")
 	assert.NoError(t, err)
 	assert.NotEmpty(t, reports)
@@ -105,7 +144,7 @@ func TestUserReportLinkingAndFetching(t *testing.T) {
 func TestUserAdminChecks(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("Admin Report", "admin-report-id", "template123", "admin@test.com")
-	repo.LinkReportWithUser("adminUID", "admin-report-id", true, true)
+	repo.LinkReportWithUser("adminUID", "admin-report-id", reportRoles.Owner)
 	isIn, _ := repo.IsUserInReport("adminUID", "admin-report-id")
 	isAdmin, _ := repo.IsAdminInReport("adminUID", "admin-report-id")
 	assert.True(t, isIn)
@@ -115,11 +154,57 @@ func TestUserAdminChecks(t *testing.T) {
 func TestRemoveUserFromReport(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("Removable Report", "remove-report-id", "template123", "user@test.com")
-	repo.LinkReportWithUser("userToRemove", "remove-report-id", false, false)
+	repo.LinkReportWithUser("userToRemove", "remove-report-id", reportRoles.Editor)
 	err := repo.RemoveUserFromReport("userToRemove", "remove-report-id")
 	assert.NoError(t, err)
 }
 
+func TestMemberRoles(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Roles Report", "roles-report-id", "template123", "owner@test.com")
+	assert.NoError(t, repo.LinkReportWithUser("rolesOwner", "roles-report-id", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("rolesMember", "roles-report-id", reportRoles.Viewer))
+	assert.ErrorIs(t, repo.LinkReportWithUser("rolesMember", "roles-report-id", reportRoles.Admin), repository.ErrAlreadyInReport)
+
+	assert.ErrorIs(t, repo.SetMemberRole("rolesOwner", "roles-report-id", reportRoles.Admin), repository.ErrLastOwner)
+	assert.ErrorIs(t, repo.RemoveUserFromReport("rolesOwner", "roles-report-id"), repository.ErrLastOwner)
+
+	assert.NoError(t, repo.TransferOwnership("roles-report-id", "rolesOwner", "rolesMember"))
+	role, err := repo.GetMemberRole("rolesMember", "roles-report-id")
+	assert.NoError(t, err)
+	assert.Equal(t, reportRoles.Owner, role)
+	role, _ = repo.GetMemberRole("rolesOwner", "roles-report-id")
+	assert.Equal(t, reportRoles.Admin, role)
+
+	// A membership from before roles is read from its flags
+	_, err = repo.Client.Collection("users").Doc("legacyMember").Collection("linkedReports").Doc("roles-report-id").Set(repo.Ctx, map[string]interface{}{"privilege": true, "owner": false})
+	assert.NoError(t, err)
+	role, _ = repo.GetMemberRole("legacyMember", "roles-report-id")
+	assert.Equal(t, reportRoles.Admin, role)
+}
+
+func TestLegacyReportOwners(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Legacy Owners", "legacy-owners-report-id", "template123", "owner@test.com")
+	report := repo.Client.Collection("reports").Doc("legacy-owners-report-id")
+	assert.NoError(t, repo.LinkReportWithUser("legacyOwner", "legacy-owners-report-id", reportRoles.Owner))
+
+	// Reports from before roles have no owners list, the existing owners
+	// are kept when another is added
+	_, err := report.Update(repo.Ctx, []firestore.Update{{Path: "owners", Value: firestore.Delete}})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.LinkReportWithUser("secondOwner", "legacy-owners-report-id", reportRoles.Owner))
+	doc, err := report.Get(repo.Ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []interface{}{"legacyOwner", "secondOwner"}, doc.Data()["owners"])
+
+	// Owners are not added to reports that don't exist
+	err = repo.LinkReportWithUser("legacyOwner", "missing-owners-report-id", reportRoles.Owner)
+	assert.ErrorIs(t, err, repository.ErrReportNotFound)
+	_, err = repo.Client.Collection("reports").Doc("missing-owners-report-id").Get(repo.Ctx)
+	assert.Error(t, err)
+}
+
 func TestRenameReport(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("Rename Me", "rename-report-id", "template123", "renamer@test.com")
@@ -132,25 +217,372 @@ func TestRenameReport(t *testing.T) {
 func TestDeleteReport(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("Delete Me", "delete-report-id", "template123", "deleter@test.com")
+	introduction, overview := structureIDs(t, repo, "delete-report-id", "Introduction", "Overview")
+	assert.NoError(t, repo.UpdateReportSectionContents("delete-report-id", introduction, overview, "Versioned", "deleter@test.com"))
 	err := repo.DeleteReport("delete-report-id")
 	assert.NoError(t, err)
+
+	// Firestore keeps subcollections of deleted documents unless they are deleted too
+	versions, err := repo.Client.Collection("reports").Doc("delete-report-id").Collection("sections").Doc(introduction).
+		Collection("subsections").Doc(overview).Collection("versions").DocumentRefs(repo.Ctx).GetAll()
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
 }
 
 func TestDestroyUser(t *testing.T) {
 	repo := setupTestRepo(t)
 	repo.CreateReport("Own Report", "owned-report-id", "template123", "owner@test.com")
-	repo.LinkReportWithUser("ownerUID", "owned-report-id", true, true)
+	repo.LinkReportWithUser("ownerUID", "owned-report-id", reportRoles.Owner)
 	err := repo.DestroyUser("ownerUID")
 	assert.NoError(t, err)
 }
 
+func TestDestroyUserAfterCoOwnedReportIsDeleted(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Shared Report", "shared-report-id", "template123", "first@test.com")
+	assert.NoError(t, repo.LinkReportWithUser("firstOwner", "shared-report-id", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("secondOwner", "shared-report-id", reportRoles.Owner))
+	assert.NoError(t, repo.DeleteReport("shared-report-id"))
+
+	// The members' links go with the report
+	_, err := repo.Client.Collection("users").Doc("secondOwner").Collection("linkedReports").Doc("shared-report-id").Get(repo.Ctx)
+	assert.Error(t, err)
+
+	// and links left by reports deleted before that are dropped
+	_, err = repo.Client.Collection("users").Doc("secondOwner").Collection("linkedReports").Doc("gone-report-id").Set(repo.Ctx, map[string]interface{}{"role": "owner"})
+	assert.NoError(t, err)
+	assert.NoError(t, repo.DestroyUser("secondOwner"))
+	links, err := repo.Client.Collection("users").Doc("secondOwner").Collection("linkedReports").DocumentRefs(repo.Ctx).GetAll()
+	assert.NoError(t, err)
+	assert.Empty(t, links)
+}
+
 func TestFetchLogsForReport(t *testing.T) {
 	repo := setupTestRepo(t)
 	reportID := "logs-report-id"
-	repo.BufferLog(reportID, "first log", "tester@test.com")
+	logs := repo.Client.Collection("reports").Doc(reportID).Collection("logs")
+	old, _ := logs.Documents(repo.Ctx).GetAll()
+	for _, doc := range old {
+		_, _ = doc.Ref.Delete(repo.Ctx)
+	}
+
+	// Entries from before typed events have the actor in front of the message
+	_, err := logs.Doc("legacy").Set(repo.Ctx, map[string]interface{}{
+		"timestamp": time.Now().Add(-time.Hour),
+		"message":   "tester@test.com joined a report section",
+		"userID":    "tester@test.com",
+	})
+	assert.NoError(t, err)
+	repo.BufferLog(audit.Event{ReportID: reportID, Actor: "tester@test.com", Action: audit.SectionAdded, IP: "10.0.0.1", Message: "first log", Payload: map[string]interface{}{"title": "Intro"}})
+	repo.BufferLog(audit.Event{ReportID: reportID, Actor: "other@test.com", Action: audit.ContentEdited, Message: "second log"})
 	repo.FlushLogs(reportID)
-	logs, err := repo.FetchLogsForReport(reportID)
+
+	events, err := repo.FetchLogsForReport(reportID, audit.Query{})
+	assert.NoError(t, err)
+	assert.Len(t, events, 3)
+	assert.Equal(t, "joined a report section", events[0].Message)
+	assert.Equal(t, audit.Action(""), events[0].Action)
+	assert.Equal(t, "10.0.0.1", events[1].IP)
+	assert.Equal(t, "Intro", events[1].Payload["title"])
+
+	events, err = repo.FetchLogsForReport(reportID, audit.Query{Actor: "tester@test.com"})
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	page, err := repo.FetchLogsForReport(reportID, audit.Query{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, page, 2)
+	page, err = repo.FetchLogsForReport(reportID, audit.Query{After: page[1].ID})
+	assert.NoError(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, "second log", page[0].Message)
+
+	_, err = repo.FetchLogsForReport(reportID, audit.Query{After: "missing"})
+	assert.ErrorIs(t, err, repository.ErrEventNotFound)
+}
+
+
+func TestLogChain(t *testing.T) {
+	repo := setupTestRepo(t)
+	reportID := "log-chain-report"
+	logs := repo.Client.Collection("reports").Doc(reportID).Collection("logs")
+	old, _ := logs.Documents(repo.Ctx).GetAll()
+	for _, doc := range old {
+		_, _ = doc.Ref.Delete(repo.Ctx)
+	}
+	_, _ = repo.Client.Collection("reports").Doc(reportID).Collection("logChain").Doc("head").Delete(repo.Ctx)
+
+	for i := 0; i < 3; i++ {
+		repo.BufferLog(audit.Event{ReportID: reportID, Actor: "tester@test.com", Action: audit.ContentEdited, Message: "edited", Payload: map[string]interface{}{"revision": i, "ops": []map[string]interface{}{{"insert": "x"}}}})
+		repo.FlushLogs(reportID)
+	}
+
+	events, head, err := repo.FetchLogChain(reportID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), head.Seq)
+	assert.True(t, audit.Verify(events, head).Intact)
+
+	// Changing a stored entry breaks the chain there
+	for _, event := range events {
+		if event.Seq == 2 {
+			_, err = logs.Doc(event.ID).Update(repo.Ctx, []firestore.Update{{Path: "message", Value: "did nothing"}})
+			assert.NoError(t, err)
+		}
+	}
+	events, head, err = repo.FetchLogChain(reportID)
+	assert.NoError(t, err)
+	result := audit.Verify(events, head)
+	assert.False(t, result.Intact)
+	assert.Equal(t, int64(2), result.Broken.Seq)
+}
+
+
+func TestTemplateVersions(t *testing.T) {
+	repo := setupTestRepo(t)
+	_, _ = repo.Client.Collection("templates").Doc("versioned-template").Delete(repo.Ctx)
+
+	template := reportTemplates.ReportTemplate{
+		Name:     "Versioned",
+		Sections: []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}},
+	}
+	saved, err := repo.CreateTemplate("versioned-template", template, "admin@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, saved.Version)
+
+	_, err = repo.CreateTemplate("versioned-template", template, "admin@example.com")
+	assert.ErrorIs(t, err, repository.ErrTemplateExists)
+
+	saved, err = repo.UpdateTemplate("versioned-template", template, "admin@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, saved.Version)
+
+	versions, err := repo.ListTemplateVersions("versioned-template")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, versions[0].Version)
+
+	_, err = repo.GetTemplateVersion("versioned-template", 3)
+	assert.ErrorIs(t, err, repository.ErrTemplateNotFound)
+}
+
+func TestReportStructure(t *testing.T) {
+	repo := setupTestRepo(t)
+	reportID := "structure-test-id"
+	repo.DeleteReport(reportID)
+	assert.NoError(t, repo.CreateReport("Structure", reportID, "template123", "test@example.com"))
+	introduction, overview := structureIDs(t, repo, reportID, "Introduction", "Overview")
+	_, scope := structureIDs(t, repo, reportID, "Introduction", "Scope")
+	assert.NoError(t, repo.UpdateReportSectionContents(reportID, introduction, overview, "content", "test@example.com"))
+
+	evaluation, err := repo.AddSection(reportID, "Evaluation", 0)
+	assert.NoError(t, err)
+	_, err = repo.AddSubsection(reportID, evaluation, "Results", -1)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, logs)
+	_, err = repo.AddSection(reportID, "Introduction", 0)
+	assert.ErrorIs(t, err, repository.ErrSectionExists)
+
+	assert.NoError(t, repo.RenameSubsection(reportID, introduction, overview, "Summary"))
+	assert.NoError(t, repo.MoveSubsection(reportID, introduction, overview, evaluation, 0))
+	assert.NoError(t, repo.MoveSection(reportID, evaluation, 1))
+	assert.NoError(t, repo.DeleteSubsection(reportID, introduction, scope))
+	assert.ErrorIs(t, repo.DeleteSection(reportID, "Missing"), repository.ErrSectionNotFound)
+	_, err = repo.AddSubsection(reportID, introduction, "A/B", -1)
+	assert.NoError(t, err)
+	_, err = repo.AddSubsection(reportID, introduction, "A_B", -1)
+	assert.NoError(t, err)
+
+	contents, err := repo.FetchReportSectionContents(reportID, evaluation)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", contents[overview])
+	versions, err := repo.ListSubsectionVersions(reportID, evaluation, overview, repository.VersionPage{})
+	assert.NoError(t, err)
+	assert.Len(t, versions, 1)
+
+	sections, err := repo.FetchReportStructure(reportID)
+	assert.NoError(t, err)
+	assert.Equal(t, []reportTemplates.Section{
+		{Title: "Introduction", Subsections: []string{"A/B", "A_B"}},
+		{Title: "Evaluation", Subsections: []string{"Summary", "Results"}},
+	}, titles(sections))
+	assert.Equal(t, overview, sections[1].Subsections[0].ID)
+}
+
+func TestMigrateStructureIDs(t *testing.T) {
+	repo := setupTestRepo(t)
+
+	// A report from before IDs, with documents named after titles
+	reportID := "legacy-ids-report"
+	repo.DeleteReport(reportID)
+	report := repo.Client.Collection("reports").Doc(reportID)
+	_, err := report.Set(repo.Ctx, map[string]interface{}{"name": "Legacy", "templateID": "template123"})
+	assert.NoError(t, err)
+	section := report.Collection("sections").Doc("Design_Architecture")
+	_, err = section.Set(repo.Ctx, map[string]interface{}{"title": "Design/Architecture", "order": 0})
+	assert.NoError(t, err)
+	_, err = section.Collection("subsections").Doc("Components").Set(repo.Ctx, map[string]interface{}{"title": "Components", "content": "kept", "order": 0})
+	assert.NoError(t, err)
+
+	migrated, err := repo.MigrateStructureIDs()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, migrated, 1)
+
+	sectionID, subsectionID := structureIDs(t, repo, reportID, "Design/Architecture", "Components")
+	assert.NotEqual(t, "Design_Architecture", sectionID)
+	contents, err := repo.FetchReportSectionContents(reportID, sectionID)
+	assert.NoError(t, err)
+	assert.Equal(t, "kept", contents[subsectionID])
+
+	// Reports already migrated are left alone
+	again, err := repo.MigrateStructureIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, again)
+}
+
+func TestReportTemplateVersion(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Versioned Report", "template-version-id", "template123", "test@example.com")
+
+	assert.NoError(t, repo.SetReportTemplateVersion("template-version-id", 3))
+	version, err := repo.GetReportTemplateVersion("template-version-id")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, version)
+}
+
+func TestSectionGrants(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Grants", "grants-report-id", "template123", "test@example.com")
+	assert.NoError(t, repo.LinkReportWithUser("grants-member", "grants-report-id", reportRoles.Editor))
+	introduction, _ := structureIDs(t, repo, "grants-report-id", "Introduction", "")
+
+	assert.NoError(t, repo.SetSectionGrant("grants-member", "grants-report-id", introduction, sectionAccess.Comment))
+	grants, err := repo.GetSectionGrants("grants-member", "grants-report-id")
+	assert.NoError(t, err)
+	assert.Equal(t, sectionAccess.Comment, grants[introduction])
+
+	assert.NoError(t, repo.RevokeSectionGrant("grants-member", "grants-report-id", introduction))
+	grants, _ = repo.GetSectionGrants("grants-member", "grants-report-id")
+	assert.Empty(t, grants)
+
+	assert.ErrorIs(t, repo.SetSectionGrant("grants-stranger", "grants-report-id", introduction, sectionAccess.Read), repository.ErrUserNotInReport)
+}
+
+func TestInvitations(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Invitations", "invite-report-id", "template123", "owner@test.com")
+	now := time.Now()
+
+	invitationID, err := repo.CreateInvitation(invitation.New("invite-report-id", "evaluator@test.com", reportRoles.Commenter, "owner@test.com", now))
+	assert.NoError(t, err)
+	_, err = repo.CreateInvitation(invitation.New("invite-report-id", "evaluator@test.com", reportRoles.Viewer, "owner@test.com", now))
+	assert.ErrorIs(t, err, repository.ErrInvitationExists)
+
+	invitations, err := repo.FindInvitations("Evaluator@test.com")
+	assert.NoError(t, err)
+	assert.Len(t, invitations, 1)
+	assert.Equal(t, "invite-report-id", invitations[0].ReportID)
+
+	renewed, err := repo.RenewInvitation("invite-report-id", invitationID, "new-token", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, "new-token", renewed.Token)
+
+	assert.NoError(t, repo.AcceptInvitation("evaluatorUID", *renewed))
+	role, err := repo.GetMemberRole("evaluatorUID", "invite-report-id")
+	assert.NoError(t, err)
+	assert.Equal(t, reportRoles.Commenter, role)
+	invitations, _ = repo.ListInvitations("invite-report-id")
+	assert.Empty(t, invitations)
+
+	_, err = repo.RevokeInvitation("invite-report-id", invitationID)
+	assert.ErrorIs(t, err, repository.ErrInvitationNotFound)
+}
+
+func TestListMembers(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Members", "members-report-id", "template123", "owner@test.com")
+	assert.NoError(t, repo.LinkReportWithUser("membersOwner", "members-report-id", reportRoles.Owner))
+	assert.NoError(t, repo.LinkReportWithUser("membersReader", "members-report-id", reportRoles.Viewer))
+
+	members, err := repo.ListMembers("members-report-id")
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+	assert.Equal(t, "membersOwner", members[0].UID)
+	assert.Equal(t, reportRoles.Owner, members[0].Role)
+
+	assert.NoError(t, repo.SetMemberRole("membersReader", "members-report-id", reportRoles.Commenter))
+	members, _ = repo.ListMembers("members-report-id")
+	assert.Equal(t, reportRoles.Commenter, members[1].Role)
+
+	assert.NoError(t, repo.RemoveUserFromReport("membersReader", "members-report-id"))
+	members, _ = repo.ListMembers("members-report-id")
+	assert.Len(t, members, 1)
+
+	// Memberships from before the index are added by MigrateMembers
+	_, err = repo.Client.Collection("users").Doc("legacyReader").Collection("linkedReports").Doc("members-report-id").Set(repo.Ctx, map[string]interface{}{"privilege": false, "owner": false})
+	assert.NoError(t, err)
+	migrated, err := repo.MigrateMembers()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, migrated, 1)
+	members, _ = repo.ListMembers("members-report-id")
+	assert.Len(t, members, 2)
+}
+
+func TestCommentThreads(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Comments", "comments-report-id", "template123", "test@example.com")
+	introduction, overview := structureIDs(t, repo, "comments-report-id", "Introduction", "Overview")
+	design, _ := structureIDs(t, repo, "comments-report-id", "Design/Architecture", "")
+	now := time.Now()
+
+	threadID, err := repo.CreateCommentThread(comment.NewThread("comments-report-id", overview, comment.Anchor{Index: 2, Length: 4}, "test@example.com", "Typo", now))
+	assert.NoError(t, err)
+
+	thread, err := repo.AddCommentReply("comments-report-id", threadID, comment.NewReply("other@test.com", "Fixed", now))
+	assert.NoError(t, err)
+	assert.Len(t, thread.Replies, 1)
+	thread, err = repo.SetCommentResolved("comments-report-id", threadID, true, "other@test.com", now)
+	assert.NoError(t, err)
+	assert.True(t, thread.Resolved)
+	assert.NoError(t, repo.UpdateCommentAnchors("comments-report-id", map[string]comment.Anchor{threadID: {Index: 5, Length: 4}, "gone": {}}))
+
+	threads, err := repo.FetchCommentThreads("comments-report-id", introduction)
+	assert.NoError(t, err)
+	assert.Len(t, threads, 1)
+	assert.Equal(t, comment.Anchor{Index: 5, Length: 4}, threads[0].Anchor)
+	assert.Equal(t, "Fixed", threads[0].Replies[0].Body)
+	assert.Equal(t, "other@test.com", threads[0].ResolvedBy)
+	threads, _ = repo.FetchCommentThreads("comments-report-id", design)
+	assert.Empty(t, threads)
+
+	_, err = repo.GetCommentThread("comments-report-id", "missing")
+	assert.ErrorIs(t, err, repository.ErrCommentNotFound)
 }
 
+func TestSubsectionReviews(t *testing.T) {
+	repo := setupTestRepo(t)
+	repo.CreateReport("Reviews", "reviews-report-id", "template123", "test@example.com")
+	sectionID, overview := structureIDs(t, repo, "reviews-report-id", "Introduction", "Overview")
+	now := time.Now()
+
+	reviews, err := repo.FetchSectionReviews("reviews-report-id", sectionID)
+	assert.NoError(t, err)
+	assert.Len(t, reviews, 2)
+	assert.Equal(t, review.Draft, reviews[overview].Status)
+
+	submit := review.Transition{From: review.Draft, To: review.InternalReview, By: "test@example.com", At: now}
+	changed, err := repo.ChangeSubsectionStatus("reviews-report-id", sectionID, overview, submit)
+	assert.NoError(t, err)
+	assert.Equal(t, review.InternalReview, changed.Status)
+	_, err = repo.ChangeSubsectionStatus("reviews-report-id", sectionID, overview, submit)
+	assert.ErrorIs(t, err, repository.ErrStatusChanged)
+
+	_, err = repo.SetSubsectionReviewers("reviews-report-id", sectionID, overview, []string{"eve@example.com"})
+	assert.NoError(t, err)
+	stored, err := repo.GetSubsectionReview("reviews-report-id", sectionID, overview)
+	assert.NoError(t, err)
+	assert.Equal(t, review.InternalReview, stored.Status)
+	assert.Equal(t, []string{"eve@example.com"}, stored.Reviewers)
+	assert.Len(t, stored.History, 1)
+	assert.Equal(t, "test@example.com", stored.History[0].By)
+
+	_, err = repo.GetSubsectionReview("reviews-report-id", sectionID, "missing")
+	assert.ErrorIs(t, err, repository.ErrSectionNotFound)
+}
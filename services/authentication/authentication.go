@@ -2,6 +2,7 @@ package authentication
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"sema/services/firebase"
@@ -26,6 +27,8 @@ type AuthServiceInterface interface {
 	DestroyUser(uid string) error
 }
 
+var ErrUserNotFound = errors.New("user not found")
+
 // AuthService handles Firebase Authentication
 type AuthService struct {
 	AuthClient AuthClientInterface
@@ -53,8 +56,13 @@ func (s *AuthService) GetUserByUID(uid string) (*auth.UserRecord, error) {
 	return s.AuthClient.GetUser(context.Background(), uid)
 }
 
+// GetUIDFromEmail returns ErrUserNotFound when no one has registered with
+// the email
 func (s *AuthService) GetUIDFromEmail(email string) (string, error) {
 	user, err := s.AuthClient.GetUserByEmail(context.Background(), email)
+	if auth.IsUserNotFound(err) {
+		return "", fmt.Errorf("%w: %s", ErrUserNotFound, email)
+	}
 	if err != nil {
 		return "", fmt.Errorf("error fetching user by email: %v", err)
 	}
@@ -0,0 +1,226 @@
+package exportJobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sema/models/reportTemplates"
+	"sema/services/reportGeneration"
+	"sync"
+	"time"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// maxRunning is how many exports render at the same time. Later jobs wait
+// in the queue.
+const maxRunning = 2
+
+// FetchFunc returns the report name and content to export, as returned by
+// the repository's FetchReportContent, and the style of its template
+type FetchFunc func() (string, []map[string]interface{}, reportTemplates.Style, error)
+
+// Job is the state of one export. Exporters render in one step, so its
+// status is all there is to tell of its progress.
+type Job struct {
+	ID          string    `json:"jobID"`
+	ReportID    string    `json:"reportID"`
+	Format      string    `json:"format"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	FileName    string    `json:"fileName,omitempty"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	CompletedAt time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt,omitempty"`
+
+	path        string
+	contentType string
+}
+
+// Manager runs export jobs in the background and keeps finished artifacts
+// on disk until they expire. Each artifact is named after its job so exports
+// of reports with the same name never collide.
+type Manager struct {
+	dir  string
+	ttl  time.Duration
+	jobs map[string]*Job
+	slot chan struct{}
+	mu   sync.Mutex
+}
+
+// SpawnManager creates a manager storing artifacts in dir for ttl and starts
+// removing expired ones
+func SpawnManager(dir string, ttl time.Duration) *Manager {
+	manager := NewManager(dir, ttl)
+	go func() {
+		for range time.Tick(ttl / 2) {
+			manager.Cleanup()
+		}
+	}()
+	return manager
+}
+
+// NewManager creates a manager without the background cleanup
+func NewManager(dir string, ttl time.Duration) *Manager {
+	return &Manager{
+		dir:  dir,
+		ttl:  ttl,
+		jobs: make(map[string]*Job),
+		slot: make(chan struct{}, maxRunning),
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Start queues an export of a report and returns the job straight away
+func (manager *Manager) Start(reportID, format, user string, fetch FetchFunc) (Job, error) {
+	exporter, err := reportGeneration.GetExporter(format)
+	if err != nil {
+		return Job{}, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to create job ID: %v", err)
+	}
+
+	job := &Job{
+		ID:          id,
+		ReportID:    reportID,
+		Format:      format,
+		Status:      StatusQueued,
+		CreatedBy:   user,
+		CreatedAt:   time.Now(),
+		contentType: exporter.ContentType(),
+	}
+
+	manager.mu.Lock()
+	manager.jobs[id] = job
+	snapshot := *job
+	manager.mu.Unlock()
+
+	go manager.run(job, exporter, fetch)
+	return snapshot, nil
+}
+
+func (manager *Manager) run(job *Job, exporter reportGeneration.Exporter, fetch FetchFunc) {
+	manager.slot <- struct{}{}
+	defer func() { <-manager.slot }()
+
+	// A panicking exporter fails its job instead of taking the server down
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Export job %s panicked: %v\n%s", job.ID, r, debug.Stack())
+			manager.fail(job, fmt.Errorf("failed to generate report: %v", r))
+		}
+	}()
+
+	manager.update(job, StatusRunning)
+	reportName, reportContent, style, err := fetch()
+	if err != nil {
+		manager.fail(job, fmt.Errorf("failed to fetch report content: %v", err))
+		return
+	}
+
+	data, err := exporter.Export(reportName, reportContent, style)
+	if err != nil {
+		manager.fail(job, fmt.Errorf("failed to generate report: %v", err))
+		return
+	}
+
+	if err := os.MkdirAll(manager.dir, 0o700); err != nil {
+		manager.fail(job, fmt.Errorf("failed to create export directory: %v", err))
+		return
+	}
+	path := filepath.Join(manager.dir, job.ID+"."+exporter.Extension())
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		manager.fail(job, fmt.Errorf("failed to write export: %v", err))
+		return
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	now := time.Now()
+	job.Status = StatusDone
+	job.FileName = reportName + "." + exporter.Extension()
+	job.CompletedAt = now
+	job.ExpiresAt = now.Add(manager.ttl)
+	job.path = path
+}
+
+func (manager *Manager) update(job *Job, status string) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	job.Status = status
+}
+
+func (manager *Manager) fail(job *Job, err error) {
+	log.Printf("Export job %s for report %s failed: %v", job.ID, job.ReportID, err)
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	now := time.Now()
+	job.Status = StatusFailed
+	job.Error = err.Error()
+	job.CompletedAt = now
+	job.ExpiresAt = now.Add(manager.ttl)
+}
+
+// Get returns a copy of a job belonging to a report
+func (manager *Manager) Get(reportID, jobID string) (Job, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	job, ok := manager.jobs[jobID]
+	if !ok || job.ReportID != reportID {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Artifact returns the path and content type of a finished job's file
+func (manager *Manager) Artifact(reportID, jobID string) (Job, string, string, error) {
+	job, ok := manager.Get(reportID, jobID)
+	if !ok {
+		return Job{}, "", "", fmt.Errorf("job %s not found", jobID)
+	}
+	if job.Status != StatusDone {
+		return job, "", "", fmt.Errorf("job %s is %s", jobID, job.Status)
+	}
+	return job, job.path, job.contentType, nil
+}
+
+// Cleanup forgets expired jobs and deletes their files
+func (manager *Manager) Cleanup() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	now := time.Now()
+	for id, job := range manager.jobs {
+		if job.ExpiresAt.IsZero() || now.Before(job.ExpiresAt) {
+			continue
+		}
+		if job.path != "" {
+			if err := os.Remove(job.path); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove export %s: %v", job.path, err)
+			}
+		}
+		delete(manager.jobs, id)
+	}
+}
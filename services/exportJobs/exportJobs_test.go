@@ -0,0 +1,131 @@
+package exportJobs
+
+import (
+	"errors"
+	"os"
+	"sema/models/reportTemplates"
+	"sema/services/reportGeneration"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reportContent() (string, []map[string]interface{}, reportTemplates.Style, error) {
+	return "Test Report", []map[string]interface{}{
+		{
+			"sectionTitle": "Introduction",
+			"subsections": []map[string]interface{}{
+				{"title": "Overview", "content": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Hello\n"}]}}}`},
+			},
+		},
+	}, reportTemplates.Style{}, nil
+}
+
+// waitFor polls a job until it stops running
+func waitFor(t *testing.T, manager *Manager, reportID, jobID string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := manager.Get(reportID, jobID)
+		if !ok {
+			t.Fatalf("job %s not found", jobID)
+		}
+		if job.Status == StatusDone || job.Status == StatusFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", jobID)
+	return Job{}
+}
+
+func TestExportJob(t *testing.T) {
+	manager := NewManager(t.TempDir(), time.Hour)
+
+	first, err := manager.Start("report1", "md", "user@example.com", reportContent)
+	assert.NoError(t, err)
+	second, err := manager.Start("report1", "md", "user@example.com", reportContent)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+
+	job := waitFor(t, manager, "report1", first.ID)
+	assert.Equal(t, StatusDone, job.Status)
+	assert.Equal(t, "Test Report.md", job.FileName)
+	assert.WithinDuration(t, job.CompletedAt.Add(time.Hour), job.ExpiresAt, time.Second)
+
+	_, path, contentType, err := manager.Artifact("report1", first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/markdown; charset=utf-8", contentType)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "# Test Report")
+
+	// Reports with the same name get their own files
+	waitFor(t, manager, "report1", second.ID)
+	_, secondPath, _, _ := manager.Artifact("report1", second.ID)
+	assert.NotEqual(t, path, secondPath)
+
+	// Jobs are only visible from their own report
+	_, ok := manager.Get("report2", first.ID)
+	assert.False(t, ok)
+}
+
+func TestExportJobErrors(t *testing.T) {
+	manager := NewManager(t.TempDir(), time.Hour)
+
+	_, err := manager.Start("report1", "rtf", "", reportContent)
+	assert.ErrorIs(t, err, reportGeneration.ErrUnsupportedFormat)
+
+	job, err := manager.Start("report1", "md", "", func() (string, []map[string]interface{}, reportTemplates.Style, error) {
+		return "", nil, reportTemplates.Style{}, errors.New("report not found")
+	})
+	assert.NoError(t, err)
+
+	job = waitFor(t, manager, "report1", job.ID)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Contains(t, job.Error, "report not found")
+
+	_, _, _, err = manager.Artifact("report1", job.ID)
+	assert.Error(t, err)
+}
+
+type panickingExporter struct{}
+
+func (panickingExporter) Export(string, []map[string]interface{}, reportTemplates.Style) ([]byte, error) {
+	panic("broken exporter")
+}
+func (panickingExporter) ContentType() string { return "text/plain" }
+func (panickingExporter) Extension() string   { return "txt" }
+
+func TestPanickingExporterFailsItsJob(t *testing.T) {
+	reportGeneration.RegisterExporter("panicking", panickingExporter{})
+	manager := NewManager(t.TempDir(), time.Hour)
+
+	job, err := manager.Start("report1", "panicking", "", reportContent)
+	assert.NoError(t, err)
+	job = waitFor(t, manager, "report1", job.ID)
+	assert.Equal(t, StatusFailed, job.Status)
+	assert.Contains(t, job.Error, "broken exporter")
+
+	// and gives its slot back
+	for i := 0; i < maxRunning; i++ {
+		job, _ = manager.Start("report1", "md", "", reportContent)
+		assert.Equal(t, StatusDone, waitFor(t, manager, "report1", job.ID).Status)
+	}
+}
+
+func TestCleanupRemovesExpiredArtifacts(t *testing.T) {
+	manager := NewManager(t.TempDir(), time.Millisecond)
+
+	job, _ := manager.Start("report1", "md", "", reportContent)
+	waitFor(t, manager, "report1", job.ID)
+	_, path, _, _ := manager.Artifact("report1", job.ID)
+
+	time.Sleep(5 * time.Millisecond)
+	manager.Cleanup()
+
+	_, ok := manager.Get("report1", job.ID)
+	assert.False(t, ok)
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
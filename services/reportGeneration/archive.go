@@ -0,0 +1,50 @@
+package reportGeneration
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// zipFile is one file in an office document package
+type zipFile struct {
+	Name string
+	Data []byte
+}
+
+// writeZip packages files in order. ODF needs its first file, the mimetype,
+// stored without compression so it can be sniffed.
+func writeZip(files []zipFile, storeFirst bool) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	for i, file := range files {
+		header := &zip.FileHeader{Name: file.Name, Method: zip.Deflate}
+		if i == 0 && storeFirst {
+			header.Method = zip.Store
+		}
+
+		w, err := archive.CreateHeader(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to archive: %w", file.Name, err)
+		}
+		if _, err := w.Write(file.Data); err != nil {
+			return nil, fmt.Errorf("failed to write %s to archive: %w", file.Name, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// escapeXML escapes text for XML content and attributes, replacing
+// characters XML can't hold
+func escapeXML(text string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
@@ -0,0 +1,179 @@
+package reportGeneration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"sema/models/delta"
+	"sema/models/reportTemplates"
+)
+
+// Document is a report parsed out of its Quill deltas, which every exporter
+// writes from.
+type Document struct {
+	Title    string
+	Date     time.Time
+	Style    reportTemplates.Style
+	Sections []Section
+}
+
+type Section struct {
+	ID          string
+	Title       string
+	Subsections []Subsection
+}
+
+type Subsection struct {
+	ID    string
+	Title string
+	Lines []Line
+}
+
+// Line is one line of an editor. Quill keeps line formats such as lists and
+// headings on the newline that ends the line.
+type Line struct {
+	Spans  []Span
+	Header int    // Heading level, 0 for a normal paragraph
+	List   string // "bullet", "ordered" or empty
+}
+
+// Span is a run of text with the same formatting, or an image
+type Span struct {
+	Text      string
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Link      string
+	Ref       string // Anchor of the heading a cross-reference points to, see references.go
+	Image     string // Image source, usually a data URI
+}
+
+// IsEmpty reports whether a line has no text and no images
+func (l Line) IsEmpty() bool {
+	for _, span := range l.Spans {
+		if span.Text != "" || span.Image != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseReport builds a Document from the content returned by FetchReportContent
+// and the style of the report's template
+func ParseReport(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) (*Document, error) {
+	doc := &Document{Title: reportName, Date: time.Now(), Style: style}
+
+	for _, sectionData := range reportContent {
+		sectionTitle, _ := sectionData["sectionTitle"].(string)
+		sectionID, _ := sectionData["sectionID"].(string)
+		section := Section{ID: sectionID, Title: sectionTitle}
+
+		subsections, _ := sectionData["subsections"].([]map[string]interface{})
+		for _, subsectionData := range subsections {
+			subsectionID, _ := subsectionData["id"].(string)
+			subsectionTitle, _ := subsectionData["title"].(string)
+			content, _ := subsectionData["content"].(string)
+
+			lines, err := parseContent(content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid content for subsection: %s, in section: %s: %w", subsectionTitle, sectionTitle, err)
+			}
+			section.Subsections = append(section.Subsections, Subsection{ID: subsectionID, Title: subsectionTitle, Lines: lines})
+		}
+
+		doc.Sections = append(doc.Sections, section)
+	}
+
+	doc.resolveReferences()
+	return doc, nil
+}
+
+// parseContent splits stored subsection content into lines
+func parseContent(content string) ([]Line, error) {
+	if content == "" {
+		return nil, nil
+	}
+
+	var parsedDelta delta.Delta
+	if err := json.Unmarshal([]byte(content), &parsedDelta); err != nil {
+		return nil, err
+	}
+
+	var lines []Line
+	var current Line
+	for _, op := range parsedDelta.Delta.Delta.Ops {
+		if !op.IsInsert() {
+			continue
+		}
+
+		text, isText := op.Text()
+		if !isText {
+			var image delta.ImageEmbed
+			if err := json.Unmarshal(op.Insert, &image); err == nil && image.Image != "" {
+				current.Spans = append(current.Spans, Span{Image: image.Image})
+			}
+			continue
+		}
+
+		parts := strings.Split(text, "\n")
+		for i, part := range parts {
+			if part != "" {
+				current.Spans = append(current.Spans, newSpan(part, op.Attributes))
+			}
+			if i == len(parts)-1 {
+				break
+			}
+
+			// The newline ends the line and carries its format
+			if op.Attributes != nil {
+				if op.Attributes.List != nil {
+					current.List = *op.Attributes.List
+				}
+				if op.Attributes.Header != nil {
+					current.Header = *op.Attributes.Header
+				}
+			}
+			lines = append(lines, current)
+			current = Line{}
+		}
+	}
+	if len(current.Spans) > 0 {
+		lines = append(lines, current)
+	}
+
+	// Drop the empty line every editor ends with
+	for len(lines) > 0 && lines[len(lines)-1].IsEmpty() && lines[len(lines)-1].List == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+func newSpan(text string, attributes *delta.Attributes) Span {
+	span := Span{Text: text}
+	if attributes == nil {
+		return span
+	}
+	span.Bold = attributes.Bold != nil && *attributes.Bold
+	span.Italic = attributes.Italic != nil && *attributes.Italic
+	span.Underline = attributes.Underline != nil && *attributes.Underline
+	if attributes.Link != nil && delta.SafeLink(*attributes.Link) {
+		span.Link = *attributes.Link
+	}
+	return span
+}
+
+// listGroups splits lines into runs, where consecutive lines of the same list
+// type are grouped together and every other line is on its own.
+func listGroups(lines []Line) [][]Line {
+	var groups [][]Line
+	for _, line := range lines {
+		if n := len(groups); n > 0 && line.List != "" && groups[n-1][0].List == line.List {
+			groups[n-1] = append(groups[n-1], line)
+			continue
+		}
+		groups = append(groups, []Line{line})
+	}
+	return groups
+}
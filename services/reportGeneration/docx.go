@@ -0,0 +1,322 @@
+package reportGeneration
+
+import (
+	"fmt"
+	"log"
+	"sema/models/reportTemplates"
+	"strings"
+)
+
+// emuPerPoint converts points to the English Metric Units used by DrawingML
+const emuPerPoint = 12700
+
+// docxExporter writes an Office Open XML (Word) document
+type docxExporter struct{}
+
+func (docxExporter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+}
+func (docxExporter) Extension() string { return "docx" }
+
+// docxWriter collects the body and the parts it refers to while a document is written
+type docxWriter struct {
+	body          strings.Builder
+	relationships []string // Extra entries for word/_rels/document.xml.rels
+	media         []zipFile
+	orderedLists  int // Each ordered list gets its own numbering so it restarts at 1
+	images        int
+	bookmarks     int
+	header        string // word/header1.xml, empty when the style has no header
+	footer        string
+}
+
+func (docxExporter) Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error) {
+	doc, err := ParseReport(reportName, reportContent, style)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &docxWriter{}
+	if logo := doc.Style.Logo; logo != "" {
+		w.paragraph("Subtitle", "", []Span{{Image: logo}})
+	}
+	if doc.Style.Organization != "" {
+		w.paragraph("Subtitle", "", []Span{{Text: doc.Style.Organization}})
+	}
+	w.paragraph("Title", "", []Span{{Text: doc.Title}})
+	if doc.Style.Version != "" {
+		w.paragraph("Subtitle", "", []Span{{Text: "Version " + doc.Style.Version}})
+	}
+	w.paragraph("Subtitle", "", []Span{{Text: doc.dateText()}})
+	w.header = docxPagePart("hdr", doc, doc.headerLines())
+	w.footer = docxPagePart("ftr", doc, doc.footerLines())
+	w.contents(doc.headings())
+
+	for i, section := range doc.Sections {
+		w.pageBreak()
+		w.heading("Heading1", sectionHeading(i, section.Title))
+		for j, subsection := range section.Subsections {
+			w.heading("Heading2", subsectionHeading(i, j, subsection.Title))
+			w.lines(subsection.Lines)
+		}
+	}
+
+	return w.archive()
+}
+
+func (w *docxWriter) lines(lines []Line) {
+	for _, group := range listGroups(lines) {
+		if list := group[0].List; list != "" {
+			numID := "1" // Bullets share one numbering
+			if list == "ordered" {
+				w.orderedLists++
+				numID = fmt.Sprint(w.orderedLists + 1)
+			}
+			numbering := `<w:numPr><w:ilvl w:val="0"/><w:numId w:val="` + numID + `"/></w:numPr>`
+			for _, line := range group {
+				w.paragraph("ListParagraph", numbering, line.Spans)
+			}
+			continue
+		}
+
+		line := group[0]
+		style := "Normal"
+		if line.Header > 0 {
+			style = fmt.Sprintf("Heading%d", min(line.Header+2, 6))
+		}
+		w.paragraph(style, "", line.Spans)
+	}
+}
+
+// contents writes the table of contents as a TOC field. Its entries link to
+// the heading bookmarks, and Word fills in the page numbers when it updates
+// the field on opening.
+func (w *docxWriter) contents(headings []heading) {
+	if len(headings) == 0 {
+		return
+	}
+
+	w.pageBreak()
+	w.paragraph("TOCHeading", "", []Span{{Text: "Contents"}})
+	for i, h := range headings {
+		fmt.Fprintf(&w.body, `<w:p><w:pPr><w:pStyle w:val="TOC%d"/><w:tabs><w:tab w:val="right" w:leader="dot" w:pos="9628"/></w:tabs></w:pPr>`, h.Level)
+		if i == 0 {
+			w.body.WriteString(`<w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText xml:space="preserve"> TOC \o "1-2" \h \z \u </w:instrText></w:r><w:r><w:fldChar w:fldCharType="separate"/></w:r>`)
+		}
+		fmt.Fprintf(&w.body, `<w:hyperlink w:anchor="%s" w:history="1">%s</w:hyperlink>`, h.Anchor, w.run(Span{Text: h.text()}))
+		if i == len(headings)-1 {
+			w.body.WriteString(`<w:r><w:fldChar w:fldCharType="end"/></w:r>`)
+		}
+		w.body.WriteString(`</w:p>`)
+	}
+}
+
+// heading writes a numbered heading with a bookmark cross-references link to
+func (w *docxWriter) heading(style string, h heading) {
+	fmt.Fprintf(&w.body, `<w:p><w:pPr><w:pStyle w:val="%s"/></w:pPr><w:bookmarkStart w:id="%d" w:name="%s"/>%s<w:bookmarkEnd w:id="%d"/></w:p>`,
+		style, w.bookmarks, h.Anchor, w.run(Span{Text: h.text()}), w.bookmarks)
+	w.bookmarks++
+}
+
+func (w *docxWriter) pageBreak() {
+	w.body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+}
+
+func (w *docxWriter) paragraph(style, properties string, spans []Span) {
+	fmt.Fprintf(&w.body, `<w:p><w:pPr><w:pStyle w:val="%s"/>%s</w:pPr>`, style, properties)
+	for _, span := range spans {
+		if span.Image != "" {
+			w.image(span.Image)
+			continue
+		}
+
+		run := w.run(span)
+		if span.Ref != "" {
+			run = `<w:hyperlink w:anchor="` + span.Ref + `" w:history="1">` + run + `</w:hyperlink>`
+		} else if span.Link != "" {
+			id := w.relationship("http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink", span.Link, true)
+			run = `<w:hyperlink r:id="` + id + `">` + run + `</w:hyperlink>`
+		}
+		w.body.WriteString(run)
+	}
+	w.body.WriteString(`</w:p>`)
+}
+
+func (w *docxWriter) run(span Span) string {
+	var properties strings.Builder
+	if span.Link != "" || span.Ref != "" {
+		properties.WriteString(`<w:rStyle w:val="Hyperlink"/>`)
+	}
+	if span.Bold {
+		properties.WriteString(`<w:b/>`)
+	}
+	if span.Italic {
+		properties.WriteString(`<w:i/>`)
+	}
+	if span.Underline {
+		properties.WriteString(`<w:u w:val="single"/>`)
+	}
+	return `<w:r><w:rPr>` + properties.String() + `</w:rPr><w:t xml:space="preserve">` + escapeXML(span.Text) + `</w:t></w:r>`
+}
+
+// image embeds a data URI image inline. Images that can't be read are left out.
+func (w *docxWriter) image(source string) {
+	img, err := decodeImage(source)
+	if err != nil {
+		log.Printf("Skipping image in DOCX export: %v", err)
+		return
+	}
+
+	w.images++
+	name := fmt.Sprintf("image%d.%s", w.images, img.extension())
+	w.media = append(w.media, zipFile{"word/media/" + name, img.Data})
+	id := w.relationship("http://schemas.openxmlformats.org/officeDocument/2006/relationships/image", "media/"+name, false)
+
+	width, height := img.size()
+	cx, cy := int(width*emuPerPoint), int(height*emuPerPoint)
+	fmt.Fprintf(&w.body, `<w:r><w:drawing><wp:inline><wp:extent cx="%d" cy="%d"/><wp:docPr id="%d" name="Image %d"/>`+
+		`<a:graphic><a:graphicData uri="http://schemas.openxmlformats.org/drawingml/2006/picture"><pic:pic>`+
+		`<pic:nvPicPr><pic:cNvPr id="%d" name="%s"/><pic:cNvPicPr/></pic:nvPicPr>`+
+		`<pic:blipFill><a:blip r:embed="%s"/><a:stretch><a:fillRect/></a:stretch></pic:blipFill>`+
+		`<pic:spPr><a:xfrm><a:off x="0" y="0"/><a:ext cx="%d" cy="%d"/></a:xfrm><a:prstGeom prst="rect"><a:avLst/></a:prstGeom></pic:spPr>`+
+		`</pic:pic></a:graphicData></a:graphic></wp:inline></w:drawing></w:r>`,
+		cx, cy, w.images, w.images, w.images, name, id, cx, cy)
+}
+
+// docxPagePart writes a header ("hdr") or footer ("ftr") part. The page
+// placeholders become PAGE and NUMPAGES fields.
+func docxPagePart(tag string, doc *Document, lines []pageLine) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, xmlHeader+`<w:%s xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`, tag)
+	for _, line := range lines {
+		properties := ""
+		if line.Classification {
+			properties = `<w:b/>`
+		}
+		b.WriteString(`<w:p><w:pPr><w:jc w:val="center"/></w:pPr>`)
+		// Fields sit between runs, so each one closes the run before it
+		field := func(instruction string) string {
+			return `</w:t></w:r><w:fldSimple w:instr=" ` + instruction + ` "><w:r><w:rPr>` + properties + `</w:rPr><w:t>1</w:t></w:r></w:fldSimple>` +
+				`<w:r><w:rPr>` + properties + `</w:rPr><w:t xml:space="preserve">`
+		}
+		text := escapeXML(doc.expandFields(line.Text, "{page}", "{pages}"))
+		text = strings.NewReplacer("{pages}", field("NUMPAGES"), "{page}", field("PAGE")).Replace(text)
+		fmt.Fprintf(&b, `<w:r><w:rPr>%s</w:rPr><w:t xml:space="preserve">%s</w:t></w:r>`, properties, text)
+		b.WriteString(`</w:p>`)
+	}
+	fmt.Fprintf(&b, `</w:%s>`, tag)
+	return b.String()
+}
+
+// relationship adds a relationship from the document and returns its id
+func (w *docxWriter) relationship(relType, target string, external bool) string {
+	id := fmt.Sprintf("rId%d", len(w.relationships)+10) // rId1-9 are the fixed parts
+	mode := ""
+	if external {
+		mode = ` TargetMode="External"`
+	}
+	w.relationships = append(w.relationships, fmt.Sprintf(`<Relationship Id="%s" Type="%s" Target="%s"%s/>`, id, relType, escapeXML(target), mode))
+	return id
+}
+
+func (w *docxWriter) archive() ([]byte, error) {
+	var numbering strings.Builder
+	numbering.WriteString(xmlHeader + `<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">`)
+	numbering.WriteString(`<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="•"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>`)
+	numbering.WriteString(`<w:abstractNum w:abstractNumId="1"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%1."/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>`)
+	numbering.WriteString(`<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>`)
+	for i := 1; i <= w.orderedLists; i++ {
+		fmt.Fprintf(&numbering, `<w:num w:numId="%d"><w:abstractNumId w:val="1"/><w:lvlOverride w:ilvl="0"><w:startOverride w:val="1"/></w:lvlOverride></w:num>`, i+1)
+	}
+	numbering.WriteString(`</w:numbering>`)
+
+	// rId3 and rId4 are the header and footer, rId5 the settings
+	var pageReferences, pageRels, pageTypes string
+	var pageParts []zipFile
+	if w.header != "" {
+		pageReferences += `<w:headerReference w:type="default" r:id="rId3"/>`
+		pageRels += `<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/header" Target="header1.xml"/>`
+		pageTypes += `<Override PartName="/word/header1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.header+xml"/>`
+		pageParts = append(pageParts, zipFile{"word/header1.xml", []byte(w.header)})
+	}
+	if w.footer != "" {
+		pageReferences += `<w:footerReference w:type="default" r:id="rId4"/>`
+		pageRels += `<Relationship Id="rId4" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/footer" Target="footer1.xml"/>`
+		pageTypes += `<Override PartName="/word/footer1.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.footer+xml"/>`
+		pageParts = append(pageParts, zipFile{"word/footer1.xml", []byte(w.footer)})
+	}
+
+	document := xmlHeader + `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships" ` +
+		`xmlns:wp="http://schemas.openxmlformats.org/drawingml/2006/wordprocessingDrawing" ` +
+		`xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" ` +
+		`xmlns:pic="http://schemas.openxmlformats.org/drawingml/2006/picture">` +
+		`<w:body>` + w.body.String() +
+		`<w:sectPr>` + pageReferences + `<w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1134" w:right="1134" w:bottom="1134" w:left="1134" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>` +
+		`</w:body></w:document>`
+
+	documentRels := xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>` +
+		`<Relationship Id="rId5" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/settings" Target="settings.xml"/>` +
+		pageRels + strings.Join(w.relationships, "") + `</Relationships>`
+
+	files := []zipFile{
+		{"[Content_Types].xml", []byte(strings.Replace(docxContentTypes, "</Types>", pageTypes+"</Types>", 1))},
+		{"_rels/.rels", []byte(docxRootRels)},
+		{"word/document.xml", []byte(document)},
+		{"word/_rels/document.xml.rels", []byte(documentRels)},
+		{"word/styles.xml", []byte(docxStyles)},
+		{"word/numbering.xml", []byte(numbering.String())},
+		{"word/settings.xml", []byte(docxSettings)},
+	}
+	files = append(files, pageParts...)
+	return writeZip(append(files, w.media...), false)
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const docxContentTypes = xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Default Extension="png" ContentType="image/png"/>` +
+	`<Default Extension="jpg" ContentType="image/jpeg"/>` +
+	`<Default Extension="gif" ContentType="image/gif"/>` +
+	`<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>` +
+	`<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>` +
+	`<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>` +
+	`<Override PartName="/word/settings.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.settings+xml"/>` +
+	`</Types>`
+
+// docxSettings asks Word to update the fields, so the table of contents gets
+// its page numbers, when the document is opened
+const docxSettings = xmlHeader + `<w:settings xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:updateFields w:val="true"/>` +
+	`</w:settings>`
+
+const docxRootRels = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>` +
+	`</Relationships>`
+
+const docxStyles = xmlHeader + `<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Times New Roman" w:hAnsi="Times New Roman" w:cs="Times New Roman"/><w:sz w:val="24"/></w:rPr></w:rPrDefault>` +
+	`<w:pPrDefault><w:pPr><w:spacing w:after="120" w:line="360" w:lineRule="auto"/><w:jc w:val="both"/></w:pPr></w:pPrDefault></w:docDefaults>` +
+	`<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:before="4000"/><w:jc w:val="center"/></w:pPr><w:rPr><w:b/><w:caps/><w:sz w:val="48"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Subtitle"><w:name w:val="Subtitle"/><w:basedOn w:val="Normal"/><w:pPr><w:jc w:val="center"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:after="240"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:caps/><w:sz w:val="36"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="360"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="28"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="2"/></w:pPr><w:rPr><w:b/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading4"><w:name w:val="heading 4"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="3"/></w:pPr><w:rPr><w:b/><w:i/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading5"><w:name w:val="heading 5"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="4"/></w:pPr><w:rPr><w:i/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading6"><w:name w:val="heading 6"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:outlineLvl w:val="5"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="0"/><w:contextualSpacing/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOCHeading"><w:name w:val="TOC Heading"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:after="240"/></w:pPr><w:rPr><w:b/><w:caps/><w:sz w:val="36"/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOC1"><w:name w:val="toc 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:spacing w:after="60"/><w:jc w:val="left"/></w:pPr><w:rPr><w:b/></w:rPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOC2"><w:name w:val="toc 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:spacing w:after="60"/><w:ind w:left="240"/><w:jc w:val="left"/></w:pPr></w:style>` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/><w:rPr><w:color w:val="0563C1"/><w:u w:val="single"/></w:rPr></w:style>` +
+	`</w:styles>`
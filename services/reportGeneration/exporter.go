@@ -0,0 +1,71 @@
+package reportGeneration
+
+import (
+	"errors"
+	"fmt"
+	"sema/models/reportTemplates"
+	"sort"
+	"time"
+)
+
+// ErrUnsupportedFormat is returned for format names no exporter is
+// registered for
+var ErrUnsupportedFormat = errors.New("unsupported export format")
+
+// Exporter writes a report in one file format
+type Exporter interface {
+	// Export renders the content returned by FetchReportContent
+	Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error)
+	ContentType() string
+	Extension() string
+}
+
+var exporters = map[string]Exporter{
+	"pdf":        nativePDFExporter{},
+	"pdf-chrome": chromePDFExporter{},
+	"docx":       docxExporter{},
+	"odt":        odtExporter{},
+	"md":         markdownExporter{},
+	"html":       htmlExporter{},
+}
+
+// RegisterExporter makes an exporter available under a format name,
+// replacing any exporter already registered for it.
+func RegisterExporter(format string, exporter Exporter) {
+	exporters[format] = exporter
+}
+
+// GetExporter returns the exporter for a format name such as "pdf" or "docx"
+func GetExporter(format string) (Exporter, error) {
+	exporter, ok := exporters[format]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	return exporter, nil
+}
+
+// Formats lists the registered format names
+func Formats() []string {
+	formats := make([]string, 0, len(exporters))
+	for format := range exporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// chromePDFExporter prints the report HTML with headless Chrome. It needs a
+// local Chrome binary, so the native renderer is the default for "pdf".
+type chromePDFExporter struct{}
+
+func (chromePDFExporter) Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error) {
+	doc := &Document{Title: reportName, Date: time.Now(), Style: style}
+	htmlContent, err := renderPDFHTML(doc, reportContent)
+	if err != nil {
+		return nil, err
+	}
+	return htmlToPDF(htmlContent, chromePageLines(doc, doc.headerLines()), chromePageLines(doc, doc.footerLines()))
+}
+
+func (chromePDFExporter) ContentType() string { return "application/pdf" }
+func (chromePDFExporter) Extension() string   { return "pdf" }
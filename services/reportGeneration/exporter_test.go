@@ -0,0 +1,500 @@
+package reportGeneration_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/reportTemplates"
+	"sema/services/reportGeneration"
+)
+
+func pngDataURI(t *testing.T) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 1))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func exportContent(t *testing.T) []map[string]interface{} {
+	overview := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[` +
+		`{"insert":"The "},{"insert":"TOE","attributes":{"bold":true}},{"insert":" & its <scope>.\n"},` +
+		`{"insert":"First"},{"insert":"\n","attributes":{"list":"ordered"}},` +
+		`{"insert":"Second"},{"insert":"\n","attributes":{"list":"ordered"}},` +
+		`{"insert":"See "},{"insert":"the guide","attributes":{"link":"https://example.com/guide"}},{"insert":"\n"},` +
+		`{"insert":{"image":"` + pngDataURI(t) + `"}},{"insert":"\n"}]}}}`
+
+	return []map[string]interface{}{
+		{
+			"sectionTitle": "Introduction",
+			"subsections": []map[string]interface{}{
+				{"title": "Overview", "content": overview},
+				{"title": "Scope", "content": ""},
+			},
+		},
+	}
+}
+
+func readZipFile(t *testing.T, data []byte, name string) string {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid archive: %v", err)
+	}
+	for _, file := range archive.File {
+		if file.Name == name {
+			r, _ := file.Open()
+			defer r.Close()
+			content, _ := io.ReadAll(r)
+			return string(content)
+		}
+	}
+	t.Fatalf("%s not found in archive", name)
+	return ""
+}
+
+// assertWellFormed checks every XML part of an office document parses
+func assertWellFormed(t *testing.T, data []byte, names ...string) {
+	for _, name := range names {
+		decoder := xml.NewDecoder(strings.NewReader(readZipFile(t, data, name)))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(t, err, name) {
+				break
+			}
+		}
+	}
+}
+
+func TestGetExporter(t *testing.T) {
+	for _, format := range []string{"pdf", "docx", "odt", "md", "html"} {
+		exporter, err := reportGeneration.GetExporter(format)
+		assert.NoError(t, err)
+		assert.Equal(t, format, exporter.Extension())
+	}
+
+	_, err := reportGeneration.GetExporter("rtf")
+	assert.Error(t, err)
+}
+
+func TestMarkdownExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("md")
+	data, err := exporter.Export("Test Report", exportContent(t), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	markdown := string(data)
+	assert.True(t, strings.HasPrefix(markdown, "# Test Report\n"))
+	assert.Contains(t, markdown, "## 1 Introduction\n")
+	assert.Contains(t, markdown, "### 1.1 Overview\n")
+	assert.Contains(t, markdown, "### 1.2 Scope\n")
+	assert.Contains(t, markdown, "The **TOE** & its \\<scope\\>.\n")
+	assert.Contains(t, markdown, "1. First\n2. Second\n")
+	assert.Contains(t, markdown, "See [the guide](<https://example.com/guide>)")
+	assert.Contains(t, markdown, "![](data:image/png;base64,")
+}
+
+func TestHTMLExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("html")
+	data, err := exporter.Export("Test Report", exportContent(t), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	page := string(data)
+	assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Contains(t, page, "<title>Test Report</title>")
+	assert.Contains(t, page, "<p>The <strong>TOE</strong> &amp; its &lt;scope&gt;.</p>")
+	assert.Contains(t, page, "<ol>\n<li>First</li>\n<li>Second</li>\n</ol>")
+	assert.Contains(t, page, `<a href="https://example.com/guide">the guide</a>`)
+	assert.Contains(t, page, `<img src="data:image/png;base64,`)
+}
+
+func TestExportsDropUnsafeLinks(t *testing.T) {
+	overview := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[` +
+		`{"insert":"web","attributes":{"link":"https://example.com/guide"}},` +
+		`{"insert":"script","attributes":{"link":"JavaScript:alert(1)"}},` +
+		`{"insert":"tab","attributes":{"link":"java\tscript:alert(1)"}},` +
+		`{"insert":"data","attributes":{"link":"data:text/html,<script>alert(1)</script>"}},` +
+		`{"insert":"\n"}]}}}`
+	content := []map[string]interface{}{
+		{"sectionTitle": "Introduction", "subsections": []map[string]interface{}{{"title": "Overview", "content": overview}}},
+	}
+
+	for _, format := range []string{"html", "md", "docx", "odt", "pdf"} {
+		exporter, _ := reportGeneration.GetExporter(format)
+		data, err := exporter.Export("Test Report", content, reportTemplates.Style{})
+		assert.NoError(t, err, format)
+
+		// Office documents are archives, every part of them is checked
+		written := string(data)
+		if archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			for _, file := range archive.File {
+				written += readZipFile(t, data, file.Name)
+			}
+		}
+		assert.Contains(t, written, "https://example.com/guide", format)
+		assert.NotContains(t, strings.ToLower(written), "script:", format)
+		assert.NotContains(t, written, "data:text/html", format)
+	}
+}
+
+func TestDOCXExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("docx")
+	data, err := exporter.Export("Test Report", exportContent(t), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	assertWellFormed(t, data, "[Content_Types].xml", "_rels/.rels", "word/document.xml", "word/_rels/document.xml.rels", "word/styles.xml", "word/numbering.xml", "word/settings.xml")
+
+	document := readZipFile(t, data, "word/document.xml")
+	assert.Contains(t, document, `<w:pStyle w:val="Heading1"/></w:pPr><w:bookmarkStart w:id="0" w:name="section_1"/><w:r><w:rPr></w:rPr><w:t xml:space="preserve">1 Introduction</w:t>`)
+	assert.Contains(t, document, `<w:rPr><w:b/></w:rPr><w:t xml:space="preserve">TOE</w:t>`)
+	assert.Contains(t, document, ` &amp; its &lt;scope&gt;.`)
+	assert.Contains(t, document, `<w:numId w:val="2"/>`)
+	assert.Contains(t, document, `<w:hyperlink r:id="rId10">`)
+	assert.Contains(t, document, `<a:blip r:embed="rId11"/>`)
+
+	rels := readZipFile(t, data, "word/_rels/document.xml.rels")
+	assert.Contains(t, rels, `Target="https://example.com/guide" TargetMode="External"`)
+	assert.Contains(t, rels, `Target="media/image1.png"`)
+	assert.NotEmpty(t, readZipFile(t, data, "word/media/image1.png"))
+	assert.Contains(t, readZipFile(t, data, "word/numbering.xml"), `<w:num w:numId="2">`)
+}
+
+func TestODTExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("odt")
+	data, err := exporter.Export("Test Report", exportContent(t), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	// The mimetype must be the first file and stored uncompressed
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, "mimetype", archive.File[0].Name)
+	assert.Equal(t, zip.Store, archive.File[0].Method)
+
+	assertWellFormed(t, data, "META-INF/manifest.xml", "content.xml", "styles.xml")
+
+	content := readZipFile(t, data, "content.xml")
+	assert.Contains(t, content, `<text:h text:style-name="Heading_20_2" text:outline-level="2"><text:bookmark text:name="section_1_1"/>1.1 Overview</text:h>`)
+	assert.Contains(t, content, `<text:span text:style-name="T_b">TOE</text:span>`)
+	assert.Contains(t, content, `<text:list text:style-name="List_Number">`)
+	assert.Contains(t, content, `xlink:href="https://example.com/guide"`)
+	assert.Contains(t, content, `xlink:href="Pictures/image1.png"`)
+	assert.Contains(t, readZipFile(t, data, "META-INF/manifest.xml"), `manifest:full-path="Pictures/image1.png" manifest:media-type="image/png"`)
+}
+
+func TestExportRejectsInvalidContent(t *testing.T) {
+	content := []map[string]interface{}{
+		{
+			"sectionTitle": "Introduction",
+			"subsections": []map[string]interface{}{
+				{"title": "Overview", "content": "not json"},
+			},
+		},
+	}
+
+	exporter, _ := reportGeneration.GetExporter("docx")
+	_, err := exporter.Export("Test Report", content, reportTemplates.Style{})
+	assert.Error(t, err)
+}
+
+// pdfStreams inflates every Flate stream in a PDF
+func pdfStreams(t *testing.T, data []byte) string {
+	var out strings.Builder
+	rest := data
+	for {
+		start := bytes.Index(rest, []byte("/FlateDecode"))
+		if start < 0 {
+			break
+		}
+		rest = rest[start:]
+		begin := bytes.Index(rest, []byte("stream\n")) + len("stream\n")
+		end := bytes.Index(rest, []byte("\nendstream"))
+		r, err := zlib.NewReader(bytes.NewReader(rest[begin:end]))
+		if err != nil {
+			t.Fatalf("invalid stream: %v", err)
+		}
+		inflated, _ := io.ReadAll(r)
+		out.Write(inflated)
+		rest = rest[end:]
+	}
+	return out.String()
+}
+
+func TestNativePDFExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("pdf")
+	data, err := exporter.Export("Test Report", exportContent(t), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	assert.True(t, bytes.HasPrefix(data, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(data, []byte("%%EOF\n")))
+	assert.Contains(t, string(data), "/BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding")
+	assert.Contains(t, string(data), "/Subtype /Image /Width 2 /Height 1")
+	assert.Contains(t, string(data), "/URI (https://example.com/guide)")
+	assert.Contains(t, string(data), "/Type /Pages /Kids [")
+	assert.Contains(t, string(data), "/Count 3") // Title, contents and one section
+
+	// Every cross reference entry points at its object
+	xref := bytes.LastIndex(data, []byte("xref\n"))
+	entries := strings.Split(string(data[xref:]), "\n")[3:]
+	for i, entry := range entries {
+		if !strings.HasSuffix(entry, " n ") {
+			break
+		}
+		var offset int
+		fmt.Sscanf(entry, "%d", &offset)
+		assert.True(t, bytes.HasPrefix(data[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))), "object %d", i+1)
+	}
+
+	content := pdfStreams(t, data)
+	assert.Contains(t, content, "(Test Report) Tj")
+	assert.Contains(t, content, "(1 Introduction) Tj")
+	assert.Contains(t, content, "(1.1 Overview) Tj")
+	assert.Contains(t, content, "/F2 11 Tf")
+	assert.Contains(t, content, "(TOE) Tj")
+	assert.Contains(t, content, "( & its <scope>.) Tj")
+	assert.Contains(t, content, "(1.) Tj")
+	assert.Contains(t, content, "(2.) Tj")
+	assert.Contains(t, content, "/Im1 Do")
+}
+
+func TestNativePDFSkipsHugeImages(t *testing.T) {
+	// One pixel over the cap, which compresses to next to nothing
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 5001, 5000))); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	content := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[` +
+		`{"insert":{"image":"data:image/png;base64,` + base64.StdEncoding.EncodeToString(encoded.Bytes()) + `"}},{"insert":"After\n"}]}}}`
+	exporter, _ := reportGeneration.GetExporter("pdf")
+	data, err := exporter.Export("Test Report", []map[string]interface{}{
+		{"sectionTitle": "Introduction", "subsections": []map[string]interface{}{{"title": "Overview", "content": content}}},
+	}, reportTemplates.Style{})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "/Subtype /Image")
+	assert.Contains(t, pdfStreams(t, data), "(After) Tj")
+}
+
+func TestNativePDFWrapsLongText(t *testing.T) {
+	long := strings.Repeat("evaluation ", 200)
+	content := []map[string]interface{}{
+		{
+			"sectionTitle": "Introduction",
+			"subsections": []map[string]interface{}{
+				{"title": "Overview", "content": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"` + long + `\n"}]}}}`},
+			},
+		},
+	}
+
+	exporter, _ := reportGeneration.GetExporter("pdf")
+	data, err := exporter.Export("Test Report", content, reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	// Each wrapped line is drawn on its own and none runs past the margin
+	lines := strings.Count(pdfStreams(t, data), "(evaluation")
+	assert.Greater(t, lines, 15)
+	assert.Less(t, lines, 40)
+}
+
+func testStyle(t *testing.T) reportTemplates.Style {
+	return reportTemplates.Style{
+		Stylesheet:     "h1 { color: navy; }",
+		Logo:           pngDataURI(t),
+		Organization:   "Example Lab",
+		Classification: "Confidential",
+		Header:         "{title} v{version}",
+		Footer:         "{organization} & partners",
+		Version:        "1.2",
+		DateFormat:     "2006-01-02",
+	}
+}
+
+func TestStyledHTMLExport(t *testing.T) {
+	style := testStyle(t)
+	style.TitlePage = `<header class="cover">{{.Organization}} / {{.Title}} / {{.Version}}<img src="{{.Logo}}"></header>`
+
+	exporter, _ := reportGeneration.GetExporter("html")
+	data, err := exporter.Export("Test Report", exportContent(t), style)
+	assert.NoError(t, err)
+
+	page := string(data)
+	assert.Contains(t, page, "h1 { color: navy; }")
+	assert.Contains(t, page, `<header class="cover">Example Lab / Test Report / 1.2<img src="data:image/png;base64,`)
+	assert.Contains(t, page, `<div class="classification">Confidential</div>`)
+	assert.Contains(t, page, `<div class="page-header">Test Report v1.2</div>`)
+	assert.Contains(t, page, `<div class="page-footer">Example Lab &amp; partners</div>`)
+	assert.NotContains(t, page, "{page}")
+
+	style.TitlePage = "{{.Missing"
+	_, err = exporter.Export("Test Report", exportContent(t), style)
+	assert.Error(t, err)
+}
+
+func TestStyledMarkdownExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("md")
+	data, err := exporter.Export("Test Report", exportContent(t), testStyle(t))
+	assert.NoError(t, err)
+
+	markdown := string(data)
+	assert.True(t, strings.HasPrefix(markdown, "**Confidential**\n\nTest Report v1.2\n\n![](data:image/png;base64,"))
+	assert.Contains(t, markdown, "Example Lab\n\n# Test Report\n\nVersion 1.2\n\n")
+	assert.True(t, strings.HasSuffix(markdown, "Example Lab & partners\n\n**Confidential**\n\n"))
+}
+
+func TestStyledNativePDFExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("pdf")
+	data, err := exporter.Export("Test Report", exportContent(t), testStyle(t))
+	assert.NoError(t, err)
+
+	content := pdfStreams(t, data)
+	assert.Contains(t, content, "(Example Lab) Tj")
+	assert.Contains(t, content, "(Version 1.2) Tj")
+	assert.Contains(t, content, "/Im1 Do") // The logo
+	assert.Contains(t, content, "/F2 9 Tf")
+	assert.Contains(t, content, "(Confidential) Tj")
+	assert.Contains(t, content, "(Test Report v1.2) Tj")
+	assert.Contains(t, content, "(Page 1 of 3) Tj")
+	assert.Contains(t, content, "(Page 3 of 3) Tj")
+}
+
+func TestStyledDOCXExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("docx")
+	data, err := exporter.Export("Test Report", exportContent(t), testStyle(t))
+	assert.NoError(t, err)
+
+	assertWellFormed(t, data, "[Content_Types].xml", "word/document.xml", "word/_rels/document.xml.rels", "word/header1.xml", "word/footer1.xml")
+
+	assert.Contains(t, readZipFile(t, data, "word/document.xml"), `<w:headerReference w:type="default" r:id="rId3"/><w:footerReference w:type="default" r:id="rId4"/>`)
+	assert.Contains(t, readZipFile(t, data, "word/_rels/document.xml.rels"), `Target="header1.xml"`)
+	assert.Contains(t, readZipFile(t, data, "[Content_Types].xml"), `PartName="/word/footer1.xml"`)
+
+	header := readZipFile(t, data, "word/header1.xml")
+	assert.Contains(t, header, `<w:rPr><w:b/></w:rPr><w:t xml:space="preserve">Confidential</w:t>`)
+	assert.Contains(t, header, `Test Report v1.2`)
+
+	footer := readZipFile(t, data, "word/footer1.xml")
+	assert.Contains(t, footer, `Example Lab &amp; partners`)
+	assert.Contains(t, footer, `<w:fldSimple w:instr=" PAGE ">`)
+	assert.Contains(t, footer, `<w:fldSimple w:instr=" NUMPAGES ">`)
+}
+
+func TestStyledODTExport(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("odt")
+	data, err := exporter.Export("Test Report", exportContent(t), testStyle(t))
+	assert.NoError(t, err)
+
+	assertWellFormed(t, data, "content.xml", "styles.xml")
+
+	content := readZipFile(t, data, "content.xml")
+	assert.Contains(t, content, `<text:p text:style-name="Subtitle">Example Lab</text:p>`)
+	assert.Contains(t, content, `<text:p text:style-name="Subtitle">Version 1.2</text:p>`)
+
+	styles := readZipFile(t, data, "styles.xml")
+	assert.Contains(t, styles, `<style:header><text:p text:style-name="Classification">Confidential</text:p>`)
+	assert.Contains(t, styles, `Page <text:page-number text:select-page="current">1</text:page-number> of <text:page-count>1</text:page-count>`)
+}
+
+func referenceContent() []map[string]interface{} {
+	overview := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[` +
+		`{"insert":"As set out in "},` +
+		`{"insert":"the ","attributes":{"link":"ref:introduction/Scope"}},{"insert":"scope","attributes":{"link":"ref:introduction/Scope","bold":true}},` +
+		`{"insert":" and "},{"insert":"this","attributes":{"link":"ref:Missing"}},{"insert":".\n"}]}}}`
+
+	return []map[string]interface{}{
+		{
+			"sectionTitle": "Introduction",
+			"subsections": []map[string]interface{}{
+				{"title": "Overview", "content": overview},
+				{"title": "Scope", "content": ""},
+			},
+		},
+	}
+}
+
+func TestCrossReferences(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("md")
+	data, err := exporter.Export("Test Report", referenceContent(), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	markdown := string(data)
+	assert.Contains(t, markdown, "## Contents\n\n- [1 Introduction](#section_1)\n  - [1.1 Overview](#section_1_1)\n  - [1.2 Scope](#section_1_2)\n")
+	assert.Contains(t, markdown, "<a id=\"section_1_2\"></a>\n\n### 1.2 Scope\n")
+	assert.Contains(t, markdown, "As set out in [1.2 Scope](#section_1_2) and this.")
+
+	exporter, _ = reportGeneration.GetExporter("html")
+	data, err = exporter.Export("Test Report", referenceContent(), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	page := string(data)
+	assert.Contains(t, page, `<li><a href="#section_1_1">1.1 Overview</a></li>`)
+	assert.Contains(t, page, `<h2 id="section_1_2">1.2 Scope</h2>`)
+	assert.Contains(t, page, `As set out in <a class="ref" href="#section_1_2">1.2 Scope</a> and this.`)
+
+	exporter, _ = reportGeneration.GetExporter("docx")
+	data, err = exporter.Export("Test Report", referenceContent(), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	document := readZipFile(t, data, "word/document.xml")
+	assert.Contains(t, document, `<w:instrText xml:space="preserve"> TOC \o "1-2" \h \z \u </w:instrText>`)
+	assert.Contains(t, document, `<w:hyperlink w:anchor="section_1_1" w:history="1"><w:r><w:rPr></w:rPr><w:t xml:space="preserve">1.1 Overview</w:t>`)
+	assert.Contains(t, document, `<w:bookmarkStart w:id="2" w:name="section_1_2"/>`)
+	assert.Contains(t, document, `<w:hyperlink w:anchor="section_1_2" w:history="1"><w:r><w:rPr><w:rStyle w:val="Hyperlink"/></w:rPr><w:t xml:space="preserve">1.2 Scope</w:t>`)
+	assert.Contains(t, readZipFile(t, data, "word/settings.xml"), `<w:updateFields w:val="true"/>`)
+
+	exporter, _ = reportGeneration.GetExporter("odt")
+	data, err = exporter.Export("Test Report", referenceContent(), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	content := readZipFile(t, data, "content.xml")
+	assert.Contains(t, content, `<text:p text:style-name="Contents_20_2"><text:a xlink:type="simple" xlink:href="#section_1_2">1.2 Scope</text:a></text:p>`)
+	assert.Contains(t, content, `<text:bookmark text:name="section_1_2"/>1.2 Scope</text:h>`)
+	assert.Contains(t, content, `As set out in <text:a xlink:type="simple" xlink:href="#section_1_2">1.2 Scope</text:a> and this.`)
+}
+
+func TestCrossReferencesByID(t *testing.T) {
+	overview := `{"type":"delta","delta":{"editorId":"u1","delta":{"ops":[` +
+		`{"insert":"See "},{"insert":"below","attributes":{"link":"ref:u2"}},{"insert":" in "},{"insert":"here","attributes":{"link":"ref:s1"}},{"insert":".\n"}]}}}`
+	content := []map[string]interface{}{
+		{
+			"sectionID":    "s1",
+			"sectionTitle": "Introduction",
+			"subsections": []map[string]interface{}{
+				{"id": "u1", "title": "Overview", "content": overview},
+				{"id": "u2", "title": "Scope/Limits", "content": ""},
+			},
+		},
+	}
+
+	exporter, _ := reportGeneration.GetExporter("md")
+	data, err := exporter.Export("Test Report", content, reportTemplates.Style{})
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "See [1.2 Scope/Limits](#section_1_2) in [1 Introduction](#section_1).")
+}
+
+func TestNativePDFContentsAndReferences(t *testing.T) {
+	exporter, _ := reportGeneration.GetExporter("pdf")
+	data, err := exporter.Export("Test Report", referenceContent(), reportTemplates.Style{})
+	assert.NoError(t, err)
+
+	// The contents page lists each heading with the page it is on
+	content := pdfStreams(t, data)
+	assert.Contains(t, content, "(Contents) Tj")
+	assert.Contains(t, content, "(1.1 Overview) Tj")
+	assert.Regexp(t, `\(1\.2 Scope\) Tj ET\n.*\( \. \. \.[ .]*\) Tj ET\n.*\(3\) Tj`, content)
+	assert.Contains(t, content, "(1.2 Scope) Tj ET\n0 g")
+	assert.NotContains(t, content, "ref:")
+
+	// Contents entries and the reference link to the page of the section
+	assert.Equal(t, 4, strings.Count(string(data), "/Dest ["))
+	assert.Contains(t, string(data), "/Count 3")
+}
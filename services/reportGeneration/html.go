@@ -0,0 +1,158 @@
+package reportGeneration
+
+import (
+	"fmt"
+	"html"
+	"sema/models/reportTemplates"
+	"strings"
+)
+
+const htmlStyle = `body { font-family: "Times New Roman", serif; font-size: 12pt; line-height: 1.5; color: #333; max-width: 50em; margin: 2em auto; padding: 0 1em; }
+h1 { font-size: 18pt; text-transform: uppercase; }
+h2 { font-size: 14pt; margin-top: 2em; }
+h3, h4, h5 { font-size: 12pt; }
+img { max-width: 100%; }
+.title-page { text-align: center; margin-bottom: 4em; }
+.title-page .logo { max-width: 200px; max-height: 100px; }
+.classification { text-align: center; font-weight: bold; text-transform: uppercase; }
+.page-header, .page-footer { text-align: center; font-size: 10pt; color: #666; }
+.toc ul { list-style: none; }`
+
+// htmlExporter writes a standalone HTML page with the styles inlined
+type htmlExporter struct{}
+
+func (htmlExporter) Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error) {
+	doc, err := ParseReport(reportName, reportContent, style)
+	if err != nil {
+		return nil, err
+	}
+
+	titlePage, err := doc.renderTitlePage()
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	title := html.EscapeString(doc.Title)
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s\n%s\n</style>\n</head>\n<body>\n", title, htmlStyle, doc.Style.Stylesheet)
+
+	writeHTMLPageLines(&b, doc, doc.headerLines(), "page-header")
+	b.WriteString(titlePage + "\n")
+
+	writeHTMLContents(&b, doc)
+
+	for i, section := range doc.Sections {
+		fmt.Fprintf(&b, "%s\n", htmlHeading("h1", sectionHeading(i, section.Title)))
+		for j, subsection := range section.Subsections {
+			fmt.Fprintf(&b, "%s\n", htmlHeading("h2", subsectionHeading(i, j, subsection.Title)))
+			writeHTMLLines(&b, subsection.Lines)
+		}
+	}
+
+	writeHTMLPageLines(&b, doc, doc.footerLines(), "page-footer")
+	b.WriteString("</body>\n</html>\n")
+	return []byte(b.String()), nil
+}
+
+func (htmlExporter) ContentType() string { return "text/html; charset=utf-8" }
+func (htmlExporter) Extension() string   { return "html" }
+
+func htmlHeading(tag string, h heading) string {
+	return fmt.Sprintf(`<%s id="%s">%s</%s>`, tag, h.Anchor, html.EscapeString(h.text()), tag)
+}
+
+// writeHTMLContents writes the table of contents as nested lists of links
+func writeHTMLContents(b *strings.Builder, doc *Document) {
+	if len(doc.Sections) == 0 {
+		return
+	}
+
+	b.WriteString("<nav class=\"toc\">\n<h1>Contents</h1>\n<ul>\n")
+	for i, section := range doc.Sections {
+		h := sectionHeading(i, section.Title)
+		fmt.Fprintf(b, "<li><a href=\"#%s\">%s</a>", h.Anchor, html.EscapeString(h.text()))
+		if len(section.Subsections) > 0 {
+			b.WriteString("\n<ul>\n")
+			for j, subsection := range section.Subsections {
+				h := subsectionHeading(i, j, subsection.Title)
+				fmt.Fprintf(b, "<li><a href=\"#%s\">%s</a></li>\n", h.Anchor, html.EscapeString(h.text()))
+			}
+			b.WriteString("</ul>\n")
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n</nav>\n")
+}
+
+// writeHTMLPageLines writes the header or footer once, as an HTML page isn't
+// split into pages. Page numbers are left out.
+func writeHTMLPageLines(b *strings.Builder, doc *Document, lines []pageLine, class string) {
+	for _, line := range lines {
+		if line.PageNumbers {
+			continue
+		}
+		lineClass := class
+		if line.Classification {
+			lineClass = "classification"
+		}
+		fmt.Fprintf(b, "<div class=\"%s\">%s</div>\n", lineClass, html.EscapeString(doc.expandFields(line.Text, "", "")))
+	}
+}
+
+func writeHTMLLines(b *strings.Builder, lines []Line) {
+	for _, group := range listGroups(lines) {
+		if list := group[0].List; list != "" {
+			tag := "ul"
+			if list == "ordered" {
+				tag = "ol"
+			}
+			fmt.Fprintf(b, "<%s>\n", tag)
+			for _, line := range group {
+				fmt.Fprintf(b, "<li>%s</li>\n", htmlSpans(line.Spans))
+			}
+			fmt.Fprintf(b, "</%s>\n", tag)
+			continue
+		}
+
+		line := group[0]
+		if line.Header > 0 {
+			// Headings inside the content sit below the subsection heading
+			level := min(line.Header+2, 6)
+			fmt.Fprintf(b, "<h%d>%s</h%d>\n", level, htmlSpans(line.Spans), level)
+		} else {
+			fmt.Fprintf(b, "<p>%s</p>\n", htmlSpans(line.Spans))
+		}
+	}
+}
+
+func htmlSpans(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		if span.Image != "" {
+			fmt.Fprintf(&b, `<img src="%s" alt="">`, html.EscapeString(span.Image))
+			continue
+		}
+
+		text := html.EscapeString(span.Text)
+		if span.Bold {
+			text = "<strong>" + text + "</strong>"
+		}
+		if span.Italic {
+			text = "<em>" + text + "</em>"
+		}
+		if span.Underline {
+			text = "<u>" + text + "</u>"
+		}
+		if span.Link != "" {
+			text = `<a href="` + html.EscapeString(span.Link) + `">` + text + `</a>`
+		}
+		if span.Ref != "" {
+			text = `<a class="ref" href="#` + span.Ref + `">` + text + `</a>`
+		}
+		b.WriteString(text)
+	}
+	if b.Len() == 0 {
+		return "<br>"
+	}
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package reportGeneration
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
+	"strings"
+)
+
+// maxImageWidth is the widest an image is drawn, in points (6 inches)
+const maxImageWidth = 432.0
+
+// embeddedImage is an image decoded from a data URI
+type embeddedImage struct {
+	Data      []byte
+	MediaType string
+	Format    string // "png", "jpeg" or "gif"
+	Width     int    // Pixels
+	Height    int
+}
+
+// decodeImage reads an image out of a data URI. Images that link to other
+// sites are not fetched.
+func decodeImage(source string) (*embeddedImage, error) {
+	if !strings.HasPrefix(source, "data:") {
+		return nil, fmt.Errorf("image is not embedded")
+	}
+
+	header, payload, ok := strings.Cut(strings.TrimPrefix(source, "data:"), ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URI")
+	}
+
+	var data []byte
+	if strings.HasSuffix(header, ";base64") {
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image data: %w", err)
+		}
+		data = decoded
+	} else {
+		decoded, err := url.PathUnescape(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image data: %w", err)
+		}
+		data = []byte(decoded)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %w", err)
+	}
+
+	return &embeddedImage{
+		Data:      data,
+		MediaType: "image/" + format,
+		Format:    format,
+		Width:     config.Width,
+		Height:    config.Height,
+	}, nil
+}
+
+// size returns the drawn size of the image in points, at 96 pixels per inch
+// and scaled down to fit the page width.
+func (img *embeddedImage) size() (float64, float64) {
+	width := float64(img.Width) * 72 / 96
+	height := float64(img.Height) * 72 / 96
+	if width > maxImageWidth {
+		height = height * maxImageWidth / width
+		width = maxImageWidth
+	}
+	return width, height
+}
+
+// extension is the file extension for the image format
+func (img *embeddedImage) extension() string {
+	if img.Format == "jpeg" {
+		return "jpg"
+	}
+	return img.Format
+}
@@ -0,0 +1,142 @@
+package reportGeneration
+
+import (
+	"fmt"
+	"sema/models/reportTemplates"
+	"strings"
+)
+
+// markdownEscaper escapes characters that Markdown would treat as formatting
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+// markdownExporter writes CommonMark. Underlines have no Markdown syntax and
+// are written as <u> tags.
+type markdownExporter struct{}
+
+func (markdownExporter) Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error) {
+	doc, err := ParseReport(reportName, reportContent, style)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	writeMarkdownPageLines(&b, doc, doc.headerLines())
+	if logo := doc.titlePageFields().Logo; logo != "" {
+		fmt.Fprintf(&b, "![](%s)\n\n", logo)
+	}
+	if doc.Style.Organization != "" {
+		fmt.Fprintf(&b, "%s\n\n", markdownEscaper.Replace(doc.Style.Organization))
+	}
+	fmt.Fprintf(&b, "# %s\n\n", markdownEscaper.Replace(doc.Title))
+	if doc.Style.Version != "" {
+		fmt.Fprintf(&b, "Version %s\n\n", markdownEscaper.Replace(doc.Style.Version))
+	}
+	fmt.Fprintf(&b, "%s\n\n", doc.dateText())
+
+	// Headings get explicit anchors, as each renderer makes its own from the text
+	if len(doc.Sections) > 0 {
+		b.WriteString("## Contents\n\n")
+		for _, h := range doc.headings() {
+			fmt.Fprintf(&b, "%s- [%s](#%s)\n", strings.Repeat("  ", h.Level-1), markdownEscaper.Replace(h.text()), h.Anchor)
+		}
+		b.WriteString("\n")
+	}
+
+	for i, section := range doc.Sections {
+		h := sectionHeading(i, section.Title)
+		fmt.Fprintf(&b, "<a id=\"%s\"></a>\n\n## %s\n\n", h.Anchor, markdownEscaper.Replace(h.text()))
+		for j, subsection := range section.Subsections {
+			h := subsectionHeading(i, j, subsection.Title)
+			fmt.Fprintf(&b, "<a id=\"%s\"></a>\n\n### %s\n\n", h.Anchor, markdownEscaper.Replace(h.text()))
+			writeMarkdownLines(&b, subsection.Lines)
+		}
+	}
+
+	writeMarkdownPageLines(&b, doc, doc.footerLines())
+	return []byte(b.String()), nil
+}
+
+// writeMarkdownPageLines writes the header or footer once, with the
+// classification in bold. Page numbers are left out.
+func writeMarkdownPageLines(b *strings.Builder, doc *Document, lines []pageLine) {
+	for _, line := range lines {
+		if line.PageNumbers {
+			continue
+		}
+		text := markdownEscaper.Replace(doc.expandFields(line.Text, "", ""))
+		if line.Classification {
+			text = "**" + text + "**"
+		}
+		fmt.Fprintf(b, "%s\n\n", text)
+	}
+}
+
+func (markdownExporter) ContentType() string { return "text/markdown; charset=utf-8" }
+func (markdownExporter) Extension() string   { return "md" }
+
+func writeMarkdownLines(b *strings.Builder, lines []Line) {
+	for _, group := range listGroups(lines) {
+		if group[0].List != "" {
+			for k, line := range group {
+				marker := "-"
+				if line.List == "ordered" {
+					marker = fmt.Sprintf("%d.", k+1)
+				}
+				fmt.Fprintf(b, "%s %s\n", marker, markdownSpans(line.Spans))
+			}
+			b.WriteString("\n")
+			continue
+		}
+
+		line := group[0]
+		if line.IsEmpty() {
+			continue
+		}
+		if line.Header > 0 {
+			fmt.Fprintf(b, "%s %s\n\n", strings.Repeat("#", min(line.Header+3, 6)), markdownSpans(line.Spans))
+		} else {
+			fmt.Fprintf(b, "%s\n\n", markdownSpans(line.Spans))
+		}
+	}
+}
+
+func markdownSpans(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		if span.Image != "" {
+			fmt.Fprintf(&b, "![](%s)", span.Image)
+			continue
+		}
+
+		// Emphasis markers must hug the text, so keep surrounding spaces outside
+		trimmed := strings.TrimSpace(span.Text)
+		if trimmed == "" {
+			b.WriteString(span.Text)
+			continue
+		}
+		leading := span.Text[:strings.Index(span.Text, trimmed)]
+		trailing := span.Text[len(leading)+len(trimmed):]
+
+		text := markdownEscaper.Replace(trimmed)
+		if span.Underline {
+			text = "<u>" + text + "</u>"
+		}
+		if span.Italic {
+			text = "*" + text + "*"
+		}
+		if span.Bold {
+			text = "**" + text + "**"
+		}
+		if span.Link != "" {
+			text = "[" + text + "](<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(span.Link) + ">)"
+		}
+		if span.Ref != "" {
+			text = "[" + text + "](#" + span.Ref + ")"
+		}
+		b.WriteString(leading + text + trailing)
+	}
+	return b.String()
+}
@@ -0,0 +1,627 @@
+package reportGeneration
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"sema/models/reportTemplates"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A4 page layout in points
+const (
+	pageWidth   = 595.28
+	pageHeight  = 841.89
+	pageMargin  = 56.69 // 20mm
+	contentSize = 11.0
+	lineSpacing = 1.4
+	listIndent  = 18.0
+
+	pageLineSize = 9.0 // Headers and footers
+)
+
+// nativePDFExporter lays the report out and writes the PDF itself, without Chrome.
+// It only has the standard Type1 fonts in WinAnsiEncoding, so characters
+// outside Western European scripts are printed as '?'. The pdf-chrome format
+// prints them.
+type nativePDFExporter struct{}
+
+func (nativePDFExporter) Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error) {
+	doc, err := ParseReport(reportName, reportContent, style)
+	if err != nil {
+		return nil, err
+	}
+	return renderNativePDF(doc), nil
+}
+
+func (nativePDFExporter) ContentType() string { return "application/pdf" }
+func (nativePDFExporter) Extension() string   { return "pdf" }
+
+type pdfPage struct {
+	content bytes.Buffer
+	links   []string // Link annotation dictionaries
+	refs    []pdfRef // Links inside the document, written once every page has an object
+}
+
+// pdfRef is the area of a link to a heading
+type pdfRef struct {
+	rect   string
+	anchor string
+}
+
+// pdfDest is where a heading was drawn
+type pdfDest struct {
+	page int
+	y    float64
+}
+
+// pdfRenderer flows a document onto pages from the top down
+type pdfRenderer struct {
+	w      *pdfWriter
+	pages  []*pdfPage
+	page   *pdfPage
+	y      float64 // Top of the next line
+	images []int   // Image XObjects, drawn as /Im1, /Im2, ...
+	dests  map[string]pdfDest
+}
+
+// pdfFragment is a piece of a laid out line in one style
+type pdfFragment struct {
+	text      string
+	font      pdfFont
+	underline bool
+	link      string
+	ref       string
+	width     float64
+}
+
+func renderNativePDF(doc *Document) []byte {
+	r := &pdfRenderer{w: &pdfWriter{}, dests: make(map[string]pdfDest)}
+
+	// Title page
+	r.newPage()
+	if logo := doc.Style.Logo; logo != "" {
+		r.y = pageHeight * 0.85
+		r.logo(logo)
+	}
+	if doc.Style.Organization != "" {
+		r.y = pageHeight * 0.7
+		r.centered(doc.Style.Organization, fontRegular, 14)
+	}
+	r.y = pageHeight * 0.62
+	r.centered(doc.Title, fontBold, 24)
+	if doc.Style.Version != "" {
+		r.y -= 24
+		r.centered("Version "+doc.Style.Version, fontRegular, contentSize)
+	}
+	r.y = pageMargin + 2*contentSize
+	r.centered(doc.dateText(), fontRegular, contentSize)
+
+	// The contents pages are filled in once the pages of the headings are known
+	contents := doc.headings()
+	contentsStart := len(r.pages)
+	for k := 0; k < contentsPages(len(contents)); k++ {
+		r.newPage()
+	}
+
+	for i, section := range doc.Sections {
+		r.newPage()
+		r.heading(sectionHeading(i, section.Title), 18)
+		r.y -= 12
+
+		for j, subsection := range section.Subsections {
+			r.y -= 10
+			r.keepWithNext(14 * lineSpacing * 3)
+			r.heading(subsectionHeading(i, j, subsection.Title), 14)
+			r.y -= 4
+			r.lines(subsection.Lines)
+		}
+	}
+
+	r.contents(contents, contentsStart)
+	r.pageLines(doc)
+	return r.finish(doc.Title)
+}
+
+// heading draws a numbered heading and records where it is for links
+func (r *pdfRenderer) heading(h heading, size float64) {
+	r.dests[h.Anchor] = pdfDest{page: len(r.pages) - 1, y: r.y}
+	r.paragraph([]Span{{Text: h.text(), Bold: true}}, size, 0, "")
+}
+
+// Rows of the table of contents
+const (
+	contentsRowHeight   = contentSize * lineSpacing
+	contentsTitleHeight = 18*lineSpacing + 12
+)
+
+// contentsPages is how many pages a table of contents with n entries takes
+func contentsPages(n int) int {
+	if n == 0 {
+		return 0
+	}
+	var usable float64 = pageHeight - 2*pageMargin
+	first := int((usable - contentsTitleHeight) / contentsRowHeight)
+	rest := int(usable / contentsRowHeight)
+	if n <= first {
+		return 1
+	}
+	return 1 + (n-first+rest-1)/rest
+}
+
+// contents draws the table of contents on the pages reserved for it, with
+// dot leaders to each heading's page number
+func (r *pdfRenderer) contents(headings []heading, start int) {
+	if len(headings) == 0 {
+		return
+	}
+
+	page := start
+	r.page = r.pages[page]
+	r.y = pageHeight - pageMargin
+	r.paragraph([]Span{{Text: "Contents", Bold: true}}, 18, 0, "")
+	r.y -= 12
+
+	for _, h := range headings {
+		if r.y-contentsRowHeight < pageMargin {
+			page++
+			r.page = r.pages[page]
+			r.y = pageHeight - pageMargin
+		}
+
+		font := fontRegular
+		if h.Level == 1 {
+			font = fontBold
+		}
+		x := pageMargin + float64(h.Level-1)*listIndent
+		right := pageWidth - pageMargin
+		baseline := r.y - contentSize
+
+		number := fmt.Sprint(r.dests[h.Anchor].page + 1)
+		numberWidth := textWidth(number, fontRegular, contentSize)
+		available := right - numberWidth - contentSize - x
+
+		// Titles too long for the row are cut short
+		text := h.text()
+		if textWidth(text, font, contentSize) > available {
+			for text != "" && textWidth(text+"...", font, contentSize) > available {
+				_, size := utf8.DecodeLastRuneInString(text)
+				text = text[:len(text)-size]
+			}
+			text = strings.TrimRightFunc(text, unicode.IsSpace) + "..."
+		}
+		width := textWidth(text, font, contentSize)
+
+		leaderStart := x + width + contentSize/2
+		dotWidth := textWidth(" .", fontRegular, contentSize)
+		dots := strings.Repeat(" .", max(0, int((right-numberWidth-contentSize/2-leaderStart)/dotWidth)))
+
+		r.drawText(x, baseline, []pdfFragment{{text: text, font: font, width: width}}, contentSize)
+		r.drawText(right-numberWidth-contentSize/2-textWidth(dots, fontRegular, contentSize), baseline, []pdfFragment{{text: dots, width: textWidth(dots, fontRegular, contentSize)}}, contentSize)
+		r.drawText(right-numberWidth, baseline, []pdfFragment{{text: number, width: numberWidth}}, contentSize)
+		r.page.refs = append(r.page.refs, pdfRef{
+			rect:   fmt.Sprintf("%s %s %s %s", pdfNum(x), pdfNum(r.y-contentsRowHeight), pdfNum(right), pdfNum(r.y)),
+			anchor: h.Anchor,
+		})
+		r.y -= contentsRowHeight
+	}
+}
+
+func (r *pdfRenderer) newPage() {
+	r.page = &pdfPage{}
+	r.pages = append(r.pages, r.page)
+	r.y = pageHeight - pageMargin
+}
+
+// keepWithNext starts a new page unless there is room for height below
+func (r *pdfRenderer) keepWithNext(height float64) {
+	if r.y-height < pageMargin {
+		r.newPage()
+	}
+}
+
+func (r *pdfRenderer) lines(lines []Line) {
+	for _, group := range listGroups(lines) {
+		for k, line := range group {
+			switch {
+			case line.List == "bullet":
+				r.paragraph(line.Spans, contentSize, listIndent, "•")
+			case line.List == "ordered":
+				r.paragraph(line.Spans, contentSize, listIndent, fmt.Sprintf("%d.", k+1))
+			case line.Header > 0:
+				size := max(contentSize, 14-float64(line.Header))
+				r.y -= 6
+				r.keepWithNext(size * lineSpacing * 3)
+				r.paragraph(boldSpans(line.Spans), size, 0, "")
+			default:
+				r.paragraph(line.Spans, contentSize, 0, "")
+			}
+		}
+		if group[0].List != "" || !group[0].IsEmpty() {
+			r.y -= contentSize * 0.5
+		}
+	}
+}
+
+func boldSpans(spans []Span) []Span {
+	bold := make([]Span, len(spans))
+	for i, span := range spans {
+		span.Bold = true
+		bold[i] = span
+	}
+	return bold
+}
+
+// paragraph wraps spans to the page width and draws them. Images are drawn on
+// their own lines. A list marker, if any, is hung in the indent.
+func (r *pdfRenderer) paragraph(spans []Span, size, indent float64, marker string) {
+	width := pageWidth - 2*pageMargin - indent
+	lineHeight := size * lineSpacing
+
+	var text []Span
+	flush := func() {
+		lines := wrapSpans(text, size, width)
+		if len(lines) == 0 {
+			lines = [][]pdfFragment{nil} // An empty line in the editor still takes up space
+		}
+		for _, line := range lines {
+			r.keepWithNext(lineHeight)
+			if marker != "" {
+				r.drawText(pageMargin+indent-listIndent, r.y-size, []pdfFragment{{text: marker, width: textWidth(marker, fontRegular, size)}}, size)
+				marker = ""
+			}
+			r.drawText(pageMargin+indent, r.y-size, line, size)
+			r.y -= lineHeight
+		}
+		text = nil
+	}
+
+	for _, span := range spans {
+		if span.Image == "" {
+			text = append(text, span)
+			continue
+		}
+		if len(text) > 0 {
+			flush()
+		}
+		r.image(span.Image, indent)
+	}
+	if len(text) > 0 || marker != "" || len(spans) == 0 {
+		flush()
+	}
+}
+
+// wrapSpans breaks spans into lines no wider than width
+func wrapSpans(spans []Span, size, width float64) [][]pdfFragment {
+	var lines [][]pdfFragment
+	var line []pdfFragment
+	lineWidth := 0.0
+
+	add := func(fragment pdfFragment) {
+		if n := len(line); n > 0 && line[n-1].font == fragment.font && line[n-1].underline == fragment.underline && line[n-1].link == fragment.link && line[n-1].ref == fragment.ref {
+			line[n-1].text += fragment.text
+			line[n-1].width += fragment.width
+		} else {
+			line = append(line, fragment)
+		}
+		lineWidth += fragment.width
+	}
+	breakLine := func() {
+		// Spaces at the end of a wrapped line take no room
+		if n := len(line); n > 0 {
+			line[n-1].text = strings.TrimRightFunc(line[n-1].text, unicode.IsSpace)
+			line[n-1].width = textWidth(line[n-1].text, line[n-1].font, size)
+		}
+		lines = append(lines, line)
+		line = nil
+		lineWidth = 0
+	}
+
+	for _, span := range spans {
+		font := spanFont(span)
+		for _, word := range splitWords(span.Text) {
+			fragment := pdfFragment{text: word, font: font, underline: span.Underline, link: span.Link, ref: span.Ref}
+			fragment.width = textWidth(word, font, size)
+
+			if strings.TrimSpace(word) == "" {
+				if len(line) > 0 {
+					add(fragment) // Spaces at the start of a wrapped line are dropped
+				}
+				continue
+			}
+			if lineWidth+fragment.width > width && len(line) > 0 {
+				breakLine()
+			}
+
+			// Words wider than the page are broken between characters
+			for fragment.width > width {
+				cut := nextRune(fragment.text, 0)
+				for cut < len(fragment.text) && textWidth(fragment.text[:nextRune(fragment.text, cut)], font, size) <= width {
+					cut = nextRune(fragment.text, cut)
+				}
+				head := fragment
+				head.text = fragment.text[:cut]
+				head.width = textWidth(head.text, font, size)
+				add(head)
+				breakLine()
+				fragment.text = fragment.text[cut:]
+				fragment.width = textWidth(fragment.text, font, size)
+			}
+			add(fragment)
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitWords splits text into words and runs of spaces, keeping both
+func splitWords(text string) []string {
+	var words []string
+	start := 0
+	inSpace := false
+	for i, c := range text {
+		if i > start && unicode.IsSpace(c) != inSpace {
+			words = append(words, text[start:i])
+			start = i
+		}
+		inSpace = unicode.IsSpace(c)
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// nextRune returns the index of the rune after the one at i
+func nextRune(text string, i int) int {
+	_, size := utf8.DecodeRuneInString(text[i:])
+	return i + size
+}
+
+func spanFont(span Span) pdfFont {
+	switch {
+	case span.Bold && span.Italic:
+		return fontBoldItalic
+	case span.Bold:
+		return fontBold
+	case span.Italic:
+		return fontItalic
+	default:
+		return fontRegular
+	}
+}
+
+// drawText writes a line of fragments with its baseline at y
+func (r *pdfRenderer) drawText(x, y float64, fragments []pdfFragment, size float64) {
+	content := &r.page.content
+	for _, fragment := range fragments {
+		if fragment.link != "" || fragment.ref != "" {
+			content.WriteString("0 0 0.6 rg\n")
+		}
+		fmt.Fprintf(content, "BT /F%d %s Tf %s %s Td %s Tj ET\n", fragment.font+1, pdfNum(size), pdfNum(x), pdfNum(y), pdfString(fragment.text))
+		if fragment.underline || fragment.link != "" {
+			fmt.Fprintf(content, "%s %s %s %s re f\n", pdfNum(x), pdfNum(y-size*0.15), pdfNum(fragment.width), pdfNum(size*0.05))
+		}
+		if fragment.ref != "" {
+			content.WriteString("0 g\n")
+			r.page.refs = append(r.page.refs, pdfRef{
+				rect:   fmt.Sprintf("%s %s %s %s", pdfNum(x), pdfNum(y-size*0.25), pdfNum(x+fragment.width), pdfNum(y+size*0.85)),
+				anchor: fragment.ref,
+			})
+		}
+		if fragment.link != "" {
+			content.WriteString("0 g\n")
+			r.page.links = append(r.page.links, fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s %s %s %s] /Border [0 0 0] /A << /S /URI /URI %s >> >>",
+				pdfNum(x), pdfNum(y-size*0.25), pdfNum(x+fragment.width), pdfNum(y+size*0.85), pdfString(fragment.link)))
+		}
+		x += fragment.width
+	}
+}
+
+func (r *pdfRenderer) centered(text string, font pdfFont, size float64) {
+	width := textWidth(text, font, size)
+	r.drawText((pageWidth-width)/2, r.y, []pdfFragment{{text: text, font: font, width: width}}, size)
+}
+
+// pageLines draws the header and footer on every page now the number of
+// pages is known. They sit in the page margins.
+func (r *pdfRenderer) pageLines(doc *Document) {
+	header, footer := doc.headerLines(), doc.footerLines()
+	pages := strconv.Itoa(len(r.pages))
+
+	for i, page := range r.pages {
+		r.page = page
+		number := strconv.Itoa(i + 1)
+
+		r.y = pageHeight - 24
+		for _, line := range header {
+			r.pageLine(doc, line, number, pages)
+			r.y -= pageLineSize * lineSpacing
+		}
+		r.y = 20
+		for k := len(footer) - 1; k >= 0; k-- {
+			r.pageLine(doc, footer[k], number, pages)
+			r.y += pageLineSize * lineSpacing
+		}
+	}
+}
+
+func (r *pdfRenderer) pageLine(doc *Document, line pageLine, page, pages string) {
+	font := fontRegular
+	if line.Classification {
+		font = fontBold
+	}
+	r.centered(doc.expandFields(line.Text, page, pages), font, pageLineSize)
+}
+
+// logo draws the title page logo centered below r.y
+func (r *pdfRenderer) logo(source string) {
+	img, err := decodeImage(source)
+	if err != nil {
+		log.Printf("Skipping logo in PDF export: %v", err)
+		return
+	}
+	id, err := r.embedImage(img)
+	if err != nil {
+		log.Printf("Skipping logo in PDF export: %v", err)
+		return
+	}
+
+	width, height := img.size()
+	scale := min(1, 200/width, 100/height)
+	width, height = width*scale, height*scale
+
+	r.images = append(r.images, id)
+	fmt.Fprintf(&r.page.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", pdfNum(width), pdfNum(height), pdfNum((pageWidth-width)/2), pdfNum(r.y-height), len(r.images))
+}
+
+// image draws an embedded image scaled to fit the page. Images that can't be
+// read are left out.
+func (r *pdfRenderer) image(source string, indent float64) {
+	img, err := decodeImage(source)
+	if err != nil {
+		log.Printf("Skipping image in PDF export: %v", err)
+		return
+	}
+	id, err := r.embedImage(img)
+	if err != nil {
+		log.Printf("Skipping image in PDF export: %v", err)
+		return
+	}
+
+	width, height := img.size()
+	maxWidth := pageWidth - 2*pageMargin - indent
+	maxHeight := pageHeight - 2*pageMargin
+	scale := min(1, maxWidth/width, maxHeight/height)
+	width, height = width*scale, height*scale
+
+	r.keepWithNext(height)
+	r.images = append(r.images, id)
+	fmt.Fprintf(&r.page.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n", pdfNum(width), pdfNum(height), pdfNum(pageMargin+indent), pdfNum(r.y-height), len(r.images))
+	r.y -= height + contentSize*0.5
+}
+
+// maxImagePixels is the most pixels an image may have to be drawn in a PDF.
+// Images other than JPEGs take 4 bytes a pixel to decode.
+const maxImagePixels = 25_000_000
+
+// embedImage adds an image XObject. JPEGs are copied as they are, other
+// formats are decoded to RGB with their transparency as a soft mask.
+// Images with more than maxImagePixels are rejected before decoding.
+func (r *pdfRenderer) embedImage(img *embeddedImage) (int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(img.Data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return 0, fmt.Errorf("image of %dx%d pixels is larger than %d pixels", config.Width, config.Height, maxImagePixels)
+	}
+	size := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /BitsPerComponent 8", config.Width, config.Height)
+
+	if img.Format == "jpeg" {
+		colorSpace := "/DeviceRGB"
+		switch config.ColorModel {
+		case color.GrayModel:
+			colorSpace = "/DeviceGray"
+		case color.CMYKModel:
+			colorSpace = "/DeviceCMYK /Decode [1 0 1 0 1 0 1 0]"
+		}
+		return r.w.addStream(size+" /ColorSpace "+colorSpace+" /Filter /DCTDecode", img.Data, false), nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+	bounds := decoded.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			if c.A != 0xFF {
+				opaque = false
+			}
+		}
+	}
+
+	dict := size + " /ColorSpace /DeviceRGB"
+	if !opaque {
+		mask := r.w.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /BitsPerComponent 8 /ColorSpace /DeviceGray", bounds.Dx(), bounds.Dy()), alpha, true)
+		dict += fmt.Sprintf(" /SMask %d 0 R", mask)
+	}
+	return r.w.addStream(dict, rgb, true), nil
+}
+
+// finish writes the pages and shared resources and returns the file
+func (r *pdfRenderer) finish(title string) []byte {
+	var fonts strings.Builder
+	for i, name := range pdfFontNames {
+		id := r.w.add(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fmt.Fprintf(&fonts, " /F%d %d 0 R", i+1, id)
+	}
+	var images strings.Builder
+	for i, id := range r.images {
+		fmt.Fprintf(&images, " /Im%d %d 0 R", i+1, id)
+	}
+	resources := r.w.add(fmt.Sprintf("<< /Font <<%s >> /XObject <<%s >> >>", fonts.String(), images.String()))
+
+	// Page objects are numbered first so links can point at any page
+	pagesID := r.w.reserve()
+	pageIDs := make([]int, len(r.pages))
+	for i := range r.pages {
+		pageIDs[i] = r.w.reserve()
+	}
+
+	var kids strings.Builder
+	for i, page := range r.pages {
+		contents := r.w.addStream("", page.content.Bytes(), true)
+
+		var annotations []string
+		for _, link := range page.links {
+			annotations = append(annotations, fmt.Sprintf("%d 0 R", r.w.add(link)))
+		}
+		for _, ref := range page.refs {
+			dest, ok := r.dests[ref.anchor]
+			if !ok {
+				continue
+			}
+			link := fmt.Sprintf("<< /Type /Annot /Subtype /Link /Rect [%s] /Border [0 0 0] /Dest [%d 0 R /XYZ null %s null] >>", ref.rect, pageIDs[dest.page], pdfNum(dest.y))
+			annotations = append(annotations, fmt.Sprintf("%d 0 R", r.w.add(link)))
+		}
+		annots := ""
+		if len(annotations) > 0 {
+			annots = " /Annots [" + strings.Join(annotations, " ") + "]"
+		}
+
+		id := pageIDs[i]
+		r.w.set(id, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %d 0 R /Contents %d 0 R%s >>",
+			pagesID, pdfNum(pageWidth), pdfNum(pageHeight), resources, contents, annots))
+		fmt.Fprintf(&kids, "%d 0 R ", id)
+	}
+	r.w.set(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.TrimSpace(kids.String()), len(r.pages)))
+
+	catalog := r.w.add(fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+	info := r.w.add(fmt.Sprintf("<< /Title %s /Producer (SEMA) >>", pdfString(title)))
+	return r.w.bytes(catalog, info)
+}
+
+// pdfNum formats a coordinate with at most two decimals
+func pdfNum(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "" || s == "-" {
+		return "0"
+	}
+	return s
+}
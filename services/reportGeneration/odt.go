@@ -0,0 +1,289 @@
+package reportGeneration
+
+import (
+	"fmt"
+	"log"
+	"sema/models/reportTemplates"
+	"strings"
+)
+
+// odtExporter writes an OpenDocument text document
+type odtExporter struct{}
+
+func (odtExporter) ContentType() string { return "application/vnd.oasis.opendocument.text" }
+func (odtExporter) Extension() string   { return "odt" }
+
+// odtWriter collects the body and pictures while a document is written
+type odtWriter struct {
+	body     strings.Builder
+	pictures []zipFile
+	entries  []string // Manifest entries for the pictures
+	header   string   // style:header of the master page, empty when the style has none
+	footer   string
+}
+
+func (odtExporter) Export(reportName string, reportContent []map[string]interface{}, style reportTemplates.Style) ([]byte, error) {
+	doc, err := ParseReport(reportName, reportContent, style)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &odtWriter{}
+	if logo := doc.Style.Logo; logo != "" {
+		fmt.Fprintf(&w.body, `<text:p text:style-name="Subtitle">%s</text:p>`, w.image(logo))
+	}
+	if doc.Style.Organization != "" {
+		fmt.Fprintf(&w.body, `<text:p text:style-name="Subtitle">%s</text:p>`, escapeXML(doc.Style.Organization))
+	}
+	fmt.Fprintf(&w.body, `<text:p text:style-name="Title">%s</text:p>`, escapeXML(doc.Title))
+	if doc.Style.Version != "" {
+		fmt.Fprintf(&w.body, `<text:p text:style-name="Subtitle">Version %s</text:p>`, escapeXML(doc.Style.Version))
+	}
+	fmt.Fprintf(&w.body, `<text:p text:style-name="Subtitle">%s</text:p>`, escapeXML(doc.dateText()))
+	w.header = odtPageLines("header", doc, doc.headerLines())
+	w.footer = odtPageLines("footer", doc, doc.footerLines())
+
+	w.contents(doc.headings())
+
+	for i, section := range doc.Sections {
+		w.heading("Heading_20_1_20_Break", sectionHeading(i, section.Title))
+		for j, subsection := range section.Subsections {
+			w.heading("Heading_20_2", subsectionHeading(i, j, subsection.Title))
+			w.lines(subsection.Lines)
+		}
+	}
+
+	return w.archive()
+}
+
+// contents writes the table of contents as an index LibreOffice can update.
+// The entries link to the heading bookmarks, the page numbers are filled in
+// when the index is updated.
+func (w *odtWriter) contents(headings []heading) {
+	if len(headings) == 0 {
+		return
+	}
+
+	w.body.WriteString(`<text:table-of-content text:name="Contents"><text:table-of-content-source text:outline-level="2" text:use-index-marks="false">` +
+		`<text:index-title-template text:style-name="Contents_20_Heading">Contents</text:index-title-template>`)
+	for level := 1; level <= 2; level++ {
+		fmt.Fprintf(&w.body, `<text:table-of-content-entry-template text:outline-level="%d" text:style-name="Contents_20_%d">`+
+			`<text:index-entry-link-start/><text:index-entry-text/><text:index-entry-tab-stop style:type="right" style:leader-char="."/>`+
+			`<text:index-entry-page-number/><text:index-entry-link-end/></text:table-of-content-entry-template>`, level, level)
+	}
+	w.body.WriteString(`</text:table-of-content-source><text:index-body>` +
+		`<text:index-title text:name="Contents_Head"><text:p text:style-name="Contents_20_Heading">Contents</text:p></text:index-title>`)
+	for _, h := range headings {
+		fmt.Fprintf(&w.body, `<text:p text:style-name="Contents_20_%d"><text:a xlink:type="simple" xlink:href="#%s">%s</text:a></text:p>`, h.Level, h.Anchor, escapeXML(h.text()))
+	}
+	w.body.WriteString(`</text:index-body></text:table-of-content>`)
+}
+
+// heading writes a numbered heading with a bookmark cross-references link to
+func (w *odtWriter) heading(style string, h heading) {
+	fmt.Fprintf(&w.body, `<text:h text:style-name="%s" text:outline-level="%d"><text:bookmark text:name="%s"/>%s</text:h>`, style, h.Level, h.Anchor, escapeXML(h.text()))
+}
+
+func (w *odtWriter) lines(lines []Line) {
+	for _, group := range listGroups(lines) {
+		if list := group[0].List; list != "" {
+			style := "List_Bullet"
+			if list == "ordered" {
+				style = "List_Number"
+			}
+			fmt.Fprintf(&w.body, `<text:list text:style-name="%s">`, style)
+			for _, line := range group {
+				fmt.Fprintf(&w.body, `<text:list-item><text:p text:style-name="Text_20_body">%s</text:p></text:list-item>`, w.spans(line.Spans))
+			}
+			w.body.WriteString(`</text:list>`)
+			continue
+		}
+
+		line := group[0]
+		if line.Header > 0 {
+			level := min(line.Header+2, 6)
+			fmt.Fprintf(&w.body, `<text:h text:style-name="Heading_20_%d" text:outline-level="%d">%s</text:h>`, level, level, w.spans(line.Spans))
+		} else {
+			fmt.Fprintf(&w.body, `<text:p text:style-name="Text_20_body">%s</text:p>`, w.spans(line.Spans))
+		}
+	}
+}
+
+func (w *odtWriter) spans(spans []Span) string {
+	var b strings.Builder
+	for _, span := range spans {
+		if span.Image != "" {
+			b.WriteString(w.image(span.Image))
+			continue
+		}
+
+		text := escapeXML(span.Text)
+		if style := odtSpanStyle(span); style != "" {
+			text = `<text:span text:style-name="` + style + `">` + text + `</text:span>`
+		}
+		if span.Ref != "" {
+			text = `<text:a xlink:type="simple" xlink:href="#` + span.Ref + `">` + text + `</text:a>`
+		} else if span.Link != "" {
+			text = `<text:a xlink:type="simple" xlink:href="` + escapeXML(span.Link) + `">` + text + `</text:a>`
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// odtSpanStyle names the automatic text style for a span's formatting, such as "T_bi"
+func odtSpanStyle(span Span) string {
+	style := ""
+	if span.Bold {
+		style += "b"
+	}
+	if span.Italic {
+		style += "i"
+	}
+	if span.Underline {
+		style += "u"
+	}
+	if style == "" {
+		return ""
+	}
+	return "T_" + style
+}
+
+// image embeds a data URI image as a picture. Images that can't be read are left out.
+func (w *odtWriter) image(source string) string {
+	img, err := decodeImage(source)
+	if err != nil {
+		log.Printf("Skipping image in ODT export: %v", err)
+		return ""
+	}
+
+	name := fmt.Sprintf("Pictures/image%d.%s", len(w.pictures)+1, img.extension())
+	w.pictures = append(w.pictures, zipFile{name, img.Data})
+	w.entries = append(w.entries, fmt.Sprintf(`<manifest:file-entry manifest:full-path="%s" manifest:media-type="%s"/>`, name, img.MediaType))
+
+	width, height := img.size()
+	return fmt.Sprintf(`<draw:frame draw:name="Image %d" text:anchor-type="as-char" svg:width="%.2fpt" svg:height="%.2fpt">`+
+		`<draw:image xlink:href="%s" xlink:type="simple" xlink:show="embed" xlink:actuate="onLoad"/></draw:frame>`,
+		len(w.pictures), width, height, name)
+}
+
+// odtPageLines writes a master page header or footer. The page placeholders
+// become page number and page count fields.
+func odtPageLines(tag string, doc *Document, lines []pageLine) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	fields := strings.NewReplacer("{pages}", `<text:page-count>1</text:page-count>`, "{page}", `<text:page-number text:select-page="current">1</text:page-number>`)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<style:%s>`, tag)
+	for _, line := range lines {
+		style := "Page_20_Line"
+		if line.Classification {
+			style = "Classification"
+		}
+		fmt.Fprintf(&b, `<text:p text:style-name="%s">%s</text:p>`, style, fields.Replace(escapeXML(doc.expandFields(line.Text, "{page}", "{pages}"))))
+	}
+	fmt.Fprintf(&b, `</style:%s>`, tag)
+	return b.String()
+}
+
+func (w *odtWriter) archive() ([]byte, error) {
+	var manifest strings.Builder
+	manifest.WriteString(xmlHeader + `<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">`)
+	manifest.WriteString(`<manifest:file-entry manifest:full-path="/" manifest:version="1.2" manifest:media-type="application/vnd.oasis.opendocument.text"/>`)
+	manifest.WriteString(`<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>`)
+	manifest.WriteString(`<manifest:file-entry manifest:full-path="styles.xml" manifest:media-type="text/xml"/>`)
+	manifest.WriteString(strings.Join(w.entries, ""))
+	manifest.WriteString(`</manifest:manifest>`)
+
+	content := xmlHeader + `<office:document-content ` + odtNamespaces + ` office:version="1.2">` +
+		odtAutomaticStyles +
+		`<office:body><office:text>` + w.body.String() + `</office:text></office:body></office:document-content>`
+
+	files := []zipFile{
+		{"mimetype", []byte("application/vnd.oasis.opendocument.text")},
+		{"META-INF/manifest.xml", []byte(manifest.String())},
+		{"content.xml", []byte(content)},
+		{"styles.xml", []byte(odtStyles + odtMasterStyles + w.header + w.footer + `</style:master-page></office:master-styles></office:document-styles>`)},
+	}
+	return writeZip(append(files, w.pictures...), true)
+}
+
+const odtNamespaces = `xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" ` +
+	`xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" ` +
+	`xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" ` +
+	`xmlns:draw="urn:oasis:names:tc:opendocument:xmlns:drawing:1.0" ` +
+	`xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" ` +
+	`xmlns:xlink="http://www.w3.org/1999/xlink" ` +
+	`xmlns:svg="urn:oasis:names:tc:opendocument:xmlns:svg-compatible:1.0"`
+
+// odtAutomaticStyles has a text style for every mix of bold, italic and
+// underline, and the two list styles.
+var odtAutomaticStyles = func() string {
+	var b strings.Builder
+	b.WriteString(`<office:automatic-styles>`)
+	for _, style := range []string{"b", "i", "u", "bi", "bu", "iu", "biu"} {
+		fmt.Fprintf(&b, `<style:style style:name="T_%s" style:family="text"><style:text-properties`, style)
+		if strings.Contains(style, "b") {
+			b.WriteString(` fo:font-weight="bold"`)
+		}
+		if strings.Contains(style, "i") {
+			b.WriteString(` fo:font-style="italic"`)
+		}
+		if strings.Contains(style, "u") {
+			b.WriteString(` style:text-underline-style="solid" style:text-underline-width="auto" style:text-underline-color="font-color"`)
+		}
+		b.WriteString(`/></style:style>`)
+	}
+	b.WriteString(`<text:list-style style:name="List_Bullet"><text:list-level-style-bullet text:level="1" text:bullet-char="•">` +
+		`<style:list-level-properties text:space-before="0.25in" text:min-label-width="0.25in"/></text:list-level-style-bullet></text:list-style>`)
+	b.WriteString(`<text:list-style style:name="List_Number"><text:list-level-style-number text:level="1" style:num-suffix="." style:num-format="1">` +
+		`<style:list-level-properties text:space-before="0.25in" text:min-label-width="0.25in"/></text:list-level-style-number></text:list-style>`)
+	b.WriteString(`</office:automatic-styles>`)
+	return b.String()
+}()
+
+const odtStyles = xmlHeader + `<office:document-styles ` + odtNamespaces + ` office:version="1.2"><office:styles>` +
+	`<style:default-style style:family="paragraph"><style:paragraph-properties fo:text-align="justify"/>` +
+	`<style:text-properties fo:font-family="'Times New Roman'" fo:font-size="12pt"/></style:default-style>` +
+	`<style:style style:name="Standard" style:family="paragraph"/>` +
+	`<style:style style:name="Text_20_body" style:display-name="Text body" style:family="paragraph" style:parent-style-name="Standard">` +
+	`<style:paragraph-properties fo:margin-bottom="0.2cm" fo:line-height="150%"/></style:style>` +
+	`<style:style style:name="Title" style:family="paragraph" style:parent-style-name="Standard">` +
+	`<style:paragraph-properties fo:text-align="center" fo:margin-top="8cm"/><style:text-properties fo:font-size="24pt" fo:font-weight="bold" fo:text-transform="uppercase"/></style:style>` +
+	`<style:style style:name="Subtitle" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:text-align="center"/></style:style>` +
+	`<style:style style:name="Heading" style:family="paragraph" style:parent-style-name="Standard"><style:paragraph-properties fo:keep-with-next="always" fo:margin-top="0.4cm" fo:margin-bottom="0.2cm"/>` +
+	`<style:text-properties fo:font-weight="bold"/></style:style>` +
+	`<style:style style:name="Heading_20_1" style:display-name="Heading 1" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="1">` +
+	`<style:text-properties fo:font-size="18pt" fo:text-transform="uppercase"/></style:style>` +
+	`<style:style style:name="Heading_20_1_20_Break" style:display-name="Heading 1 Break" style:family="paragraph" style:parent-style-name="Heading_20_1" style:default-outline-level="1">` +
+	`<style:paragraph-properties fo:break-before="page"/></style:style>` +
+	`<style:style style:name="Heading_20_2" style:display-name="Heading 2" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="2">` +
+	`<style:text-properties fo:font-size="14pt"/></style:style>` +
+	`<style:style style:name="Heading_20_3" style:display-name="Heading 3" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="3"/>` +
+	`<style:style style:name="Heading_20_4" style:display-name="Heading 4" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="4">` +
+	`<style:text-properties fo:font-style="italic"/></style:style>` +
+	`<style:style style:name="Heading_20_5" style:display-name="Heading 5" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="5"/>` +
+	`<style:style style:name="Heading_20_6" style:display-name="Heading 6" style:family="paragraph" style:parent-style-name="Heading" style:default-outline-level="6"/>` +
+	`<style:style style:name="Contents_20_Heading" style:display-name="Contents Heading" style:family="paragraph" style:parent-style-name="Heading_20_1">` +
+	`<style:paragraph-properties fo:break-before="page"/></style:style>` +
+	`<style:style style:name="Contents_20_1" style:display-name="Contents 1" style:family="paragraph" style:parent-style-name="Standard">` +
+	`<style:paragraph-properties fo:text-align="start"><style:tab-stops><style:tab-stop style:position="17cm" style:type="right" style:leader-style="dotted" style:leader-text="."/></style:tab-stops></style:paragraph-properties>` +
+	`<style:text-properties fo:font-weight="bold"/></style:style>` +
+	`<style:style style:name="Contents_20_2" style:display-name="Contents 2" style:family="paragraph" style:parent-style-name="Contents_20_1">` +
+	`<style:paragraph-properties fo:margin-left="0.5cm"/><style:text-properties fo:font-weight="normal"/></style:style>` +
+	`<style:style style:name="Page_20_Line" style:display-name="Page Line" style:family="paragraph" style:parent-style-name="Standard">` +
+	`<style:paragraph-properties fo:text-align="center"/><style:text-properties fo:font-size="9pt" fo:color="#666666"/></style:style>` +
+	`<style:style style:name="Classification" style:family="paragraph" style:parent-style-name="Page_20_Line">` +
+	`<style:text-properties fo:font-weight="bold" fo:color="#000000" fo:text-transform="uppercase"/></style:style>` +
+	`</office:styles>`
+
+// odtMasterStyles lays out an A4 page. The header and footer are added to the
+// master page after it.
+const odtMasterStyles = `<office:automatic-styles><style:page-layout style:name="A4">` +
+	`<style:page-layout-properties fo:page-width="21cm" fo:page-height="29.7cm" fo:margin-top="1cm" fo:margin-bottom="1cm" fo:margin-left="2cm" fo:margin-right="2cm"/>` +
+	`<style:header-style><style:header-footer-properties fo:min-height="1cm" fo:margin-bottom="0.5cm"/></style:header-style>` +
+	`<style:footer-style><style:header-footer-properties fo:min-height="1cm" fo:margin-top="0.5cm"/></style:footer-style>` +
+	`</style:page-layout></office:automatic-styles>` +
+	`<office:master-styles><style:master-page style:name="Standard" style:page-layout-name="A4">`
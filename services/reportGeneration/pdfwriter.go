@@ -0,0 +1,98 @@
+package reportGeneration
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+)
+
+// pdfWriter assembles the objects of a PDF file and writes the cross
+// reference table. Objects are numbered from 1 in the order they are added.
+type pdfWriter struct {
+	objects [][]byte
+}
+
+// reserve allocates an object number to be filled in later with set
+func (w *pdfWriter) reserve() int {
+	w.objects = append(w.objects, nil)
+	return len(w.objects)
+}
+
+func (w *pdfWriter) set(id int, object string) {
+	w.objects[id-1] = []byte(object)
+}
+
+// add appends an object and returns its number
+func (w *pdfWriter) add(object string) int {
+	id := w.reserve()
+	w.set(id, object)
+	return id
+}
+
+// addStream appends a stream object. Extra dictionary entries such as an
+// image's size go in dict. Unless the data is already compressed it is
+// compressed with Flate.
+func (w *pdfWriter) addStream(dict string, data []byte, compress bool) int {
+	if compress {
+		var buf bytes.Buffer
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		data = buf.Bytes()
+		dict += " /Filter /FlateDecode"
+	}
+
+	var object bytes.Buffer
+	fmt.Fprintf(&object, "<< %s /Length %d >>\nstream\n", strings.TrimSpace(dict), len(data))
+	object.Write(data)
+	object.WriteString("\nendstream")
+
+	id := w.reserve()
+	w.objects[id-1] = object.Bytes()
+	return id
+}
+
+// bytes writes the file with the given catalog as its root and document
+// information dictionary
+func (w *pdfWriter) bytes(root, info int) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(w.objects))
+	for i, object := range w.objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(object)
+		out.WriteString("\nendobj\n")
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(w.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.objects)+1, root, info, xref)
+	return out.Bytes()
+}
+
+// pdfString encodes text as a PDF literal string in WinAnsiEncoding
+func pdfString(text string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range winAnsiBytes(text) {
+		switch c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
@@ -0,0 +1,213 @@
+package reportGeneration
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"sema/models/delta"
+)
+
+// Cross-references are links in the editor whose address starts with "ref:"
+// and is the ID of a section or subsection, "ref:3fK9...", which keeps
+// working when it is renamed. Older links name a section, or a section and
+// one of its subsections, by title: "ref:Development" or
+// "ref:Development/ADV_FSP Functional Specification". Titles are matched
+// ignoring case and may be URL escaped, so a slash in a title is written as
+// %2F. On export the link text becomes the numbered heading and the link
+// points inside the document.
+const referencePrefix = "ref:"
+
+// heading is a numbered section or subsection heading, and an entry of the
+// table of contents
+type heading struct {
+	Number string // "1" or "1.2"
+	Title  string
+	Anchor string // "section_1" or "section_1_2", a valid HTML id and Word bookmark name
+	Level  int    // 1 for sections, 2 for subsections
+}
+
+func (h heading) text() string {
+	return h.Number + " " + h.Title
+}
+
+func sectionHeading(i int, title string) heading {
+	return heading{Number: fmt.Sprint(i + 1), Title: title, Anchor: fmt.Sprintf("section_%d", i+1), Level: 1}
+}
+
+func subsectionHeading(i, j int, title string) heading {
+	return heading{Number: fmt.Sprintf("%d.%d", i+1, j+1), Title: title, Anchor: fmt.Sprintf("section_%d_%d", i+1, j+1), Level: 2}
+}
+
+// headings lists every section and subsection heading in document order
+func (doc *Document) headings() []heading {
+	var headings []heading
+	for i, section := range doc.Sections {
+		headings = append(headings, sectionHeading(i, section.Title))
+		for j, subsection := range section.Subsections {
+			headings = append(headings, subsectionHeading(i, j, subsection.Title))
+		}
+	}
+	return headings
+}
+
+// findReference returns the heading a "ref:" address points to
+func (doc *Document) findReference(address string) (heading, bool) {
+	target := strings.TrimPrefix(address, referencePrefix)
+	if found, ok := doc.findReferenceID(target); ok {
+		return found, true
+	}
+
+	sectionTitle, subsectionTitle, hasSubsection := strings.Cut(target, "/")
+	if unescaped, err := url.PathUnescape(sectionTitle); err == nil {
+		sectionTitle = unescaped
+	}
+	if unescaped, err := url.PathUnescape(subsectionTitle); err == nil {
+		subsectionTitle = unescaped
+	}
+
+	for i, section := range doc.Sections {
+		if !strings.EqualFold(strings.TrimSpace(section.Title), strings.TrimSpace(sectionTitle)) {
+			continue
+		}
+		if !hasSubsection {
+			return sectionHeading(i, section.Title), true
+		}
+		for j, subsection := range section.Subsections {
+			if strings.EqualFold(strings.TrimSpace(subsection.Title), strings.TrimSpace(subsectionTitle)) {
+				return subsectionHeading(i, j, subsection.Title), true
+			}
+		}
+	}
+	return heading{}, false
+}
+
+// findReferenceID returns the heading of the section or subsection with an ID
+func (doc *Document) findReferenceID(id string) (heading, bool) {
+	if id == "" {
+		return heading{}, false
+	}
+	for i, section := range doc.Sections {
+		if section.ID == id {
+			return sectionHeading(i, section.Title), true
+		}
+		for j, subsection := range section.Subsections {
+			if subsection.ID == id {
+				return subsectionHeading(i, j, subsection.Title), true
+			}
+		}
+	}
+	return heading{}, false
+}
+
+// resolveReferences turns cross-reference links into references to numbered
+// headings. References that match nothing keep their text without a link.
+func (doc *Document) resolveReferences() {
+	for i := range doc.Sections {
+		for j := range doc.Sections[i].Subsections {
+			for k := range doc.Sections[i].Subsections[j].Lines {
+				line := &doc.Sections[i].Subsections[j].Lines[k]
+				line.Spans = doc.resolveLineReferences(line.Spans)
+			}
+		}
+	}
+}
+
+func (doc *Document) resolveLineReferences(spans []Span) []Span {
+	resolved := spans[:0]
+	previous := ""
+	for _, span := range spans {
+		link := span.Link
+		if !strings.HasPrefix(link, referencePrefix) {
+			previous = ""
+			resolved = append(resolved, span)
+			continue
+		}
+
+		target, ok := doc.findReference(link)
+		if !ok {
+			log.Printf("Unresolved cross-reference in export: %s", link)
+			span.Link = ""
+			previous = ""
+			resolved = append(resolved, span)
+			continue
+		}
+
+		// Quill splits a link into several spans when only part of it is
+		// formatted, the heading is written once for all of them
+		if link == previous {
+			continue
+		}
+		previous = link
+		span.Text = target.text()
+		span.Link = ""
+		span.Ref = target.Anchor
+		resolved = append(resolved, span)
+	}
+	return resolved
+}
+
+// addOutline fills in the section and subsection titles of a document that
+// wasn't parsed, so the Chrome PDF can have contents and cross-references
+func (doc *Document) addOutline(reportContent []map[string]interface{}) {
+	if len(doc.Sections) > 0 {
+		return
+	}
+	for _, sectionData := range reportContent {
+		sectionTitle, _ := sectionData["sectionTitle"].(string)
+		sectionID, _ := sectionData["sectionID"].(string)
+		section := Section{ID: sectionID, Title: sectionTitle}
+		subsections, _ := sectionData["subsections"].([]map[string]interface{})
+		for _, subsectionData := range subsections {
+			id, _ := subsectionData["id"].(string)
+			title, _ := subsectionData["title"].(string)
+			section.Subsections = append(section.Subsections, Subsection{ID: id, Title: title})
+		}
+		doc.Sections = append(doc.Sections, section)
+	}
+}
+
+// resolveOpReferences does what resolveReferences does for the ops of an
+// editor, before go-render-quill turns them into the Chrome PDF's HTML. Links
+// newSpan would drop are dropped too.
+func (doc *Document) resolveOpReferences(ops []delta.DeltaOp) []delta.DeltaOp {
+	resolved := ops[:0]
+	previous := ""
+	for _, op := range ops {
+		if op.Attributes == nil || op.Attributes.Link == nil || !strings.HasPrefix(*op.Attributes.Link, referencePrefix) {
+			if op.Attributes != nil && op.Attributes.Link != nil && !delta.SafeLink(*op.Attributes.Link) {
+				attributes := *op.Attributes
+				attributes.Link = nil
+				op.Attributes = &attributes
+			}
+			previous = ""
+			resolved = append(resolved, op)
+			continue
+		}
+
+		link := *op.Attributes.Link
+		attributes := *op.Attributes
+		op.Attributes = &attributes
+		target, ok := doc.findReference(link)
+		if !ok {
+			log.Printf("Unresolved cross-reference in export: %s", link)
+			op.Attributes.Link = nil
+			previous = ""
+			resolved = append(resolved, op)
+			continue
+		}
+
+		if link == previous {
+			continue
+		}
+		previous = link
+		text, _ := json.Marshal(target.text())
+		anchor := "#" + target.Anchor
+		op.Insert = text
+		op.Attributes.Link = &anchor
+		resolved = append(resolved, op)
+	}
+	return resolved
+}
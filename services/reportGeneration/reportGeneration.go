@@ -5,6 +5,8 @@ import (
   "context"
   "encoding/json"
   "fmt"
+  "html"
+  "strings"
   "time"
   "os"
   "github.com/chromedp/cdproto/page"
@@ -17,9 +19,21 @@ import (
 func GeneratePDF(reportName string, reportContent []map[string]interface{}) error {
   fmt.Println("Generating PDF for:", reportName)
 
+  doc := &Document{Title: reportName, Date: time.Now()}
+  htmlContent, err := renderPDFHTML(doc, reportContent)
+  if err != nil {
+    return err
+  }
+
+  return saveHTMLToPDF(reportName+".pdf", htmlContent)
+}
+
+// renderPDFHTML builds the HTML page that Chrome prints. The document only
+// supplies the title, date and style, the content is rendered by go-render-quill.
+func renderPDFHTML(doc *Document, reportContent []map[string]interface{}) (string, error) {
   var htmlContent string
 
-  htmlContent += "<!DOCTYPE html> <html> <head> <style>" +
+  htmlContent += "<!DOCTYPE html> <html> <head> <meta charset=\"utf-8\"> <style>" +
     "@page { size: A4; margin: 20mm; } " +
     "body { font-family: \"Times New Roman\", serif; font-size: 12pt; line-height: 1.5; text-align: justify; color: #333; } " +
     "h1 { font-size: 18pt; font-weight: bold; margin-bottom: 10mm; text-transform: uppercase; padding-bottom: 3mm; } " +
@@ -27,20 +41,32 @@ func GeneratePDF(reportName string, reportContent []map[string]interface{}) erro
     "p { margin-bottom: 5mm; } " +
     ".page-break { page-break-before: always; } " +
     ".avoid-break { page-break-inside: avoid; } " +
+    ".title-page { height: 100vh; display: flex; flex-direction: column; justify-content: center; align-items: center; text-align: center; page-break-after: always; } " +
+    ".title-page .logo { max-width: 200px; max-height: 100px; } " +
+    ".toc { page-break-after: always; } .toc ul { list-style: none; } .toc a { color: inherit; text-decoration: none; } " +
+    doc.Style.Stylesheet +
     "</style></head><body>"
 
-  titlePage := "<div style=\"height: 100vh; display: flex; flex-direction: column; justify-content: center; align-items: center; text-align: center;\"> <h1>%s</h1></div> <div style=\"position: absolute; bottom: 20px; width: 100%%; text-align: center;\"><p>%s</p> </div>"
-  htmlContent += fmt.Sprintf(titlePage, reportName, time.Now().Format("January 2, 2006"))
+  titlePage, err := doc.renderTitlePage()
+  if err != nil {
+    return "", err
+  }
+  htmlContent += titlePage
+
+  doc.addOutline(reportContent)
+  var contents strings.Builder
+  writeHTMLContents(&contents, doc)
+  htmlContent += contents.String()
 
   for i, sectionData := range reportContent {
     sectionName := sectionData["sectionTitle"].(string)
     var htmlSection string
     if i == 0 {
-      htmlSection = "<h1>%d    %s</h1>"
+      htmlSection = "<h1 id=\"section_%d\">%d    %s</h1>"
     } else {
-      htmlSection = "<div class = \"page-break\"> <h1>%d %s</h1> </div>"
+      htmlSection = "<div class = \"page-break\"> <h1 id=\"section_%d\">%d %s</h1> </div>"
     }
-    htmlContent += fmt.Sprintf(htmlSection, i+1, sectionName)
+    htmlContent += fmt.Sprintf(htmlSection, i+1, i+1, sectionName)
 
     subSectionData, ok := sectionData["subsections"].([]map[string]interface{})
     if !ok {
@@ -49,33 +75,33 @@ func GeneratePDF(reportName string, reportContent []map[string]interface{}) erro
 
     for j, subsection := range subSectionData {
       subSectionTitle := subsection["title"].(string)
-      htmlSubsection := "<div class = \"avoid-break\"> <h2>%d.%d    %s</h2>"
-			htmlContent += fmt.Sprintf(htmlSubsection, i+1, j+1, subSectionTitle)
+      htmlSubsection := "<div class = \"avoid-break\"> <h2 id=\"section_%d_%d\">%d.%d    %s</h2>"
+			htmlContent += fmt.Sprintf(htmlSubsection, i+1, j+1, i+1, j+1, subSectionTitle)
 
 			subSectionContent, ok := subsection["content"].(string)
 			if !ok || subSectionContent == "" {
-				return fmt.Errorf("missing or invalid content for subsection: %v", subsection["title"])
+				return "", fmt.Errorf("missing or invalid content for subsection: %v", subsection["title"])
 			}
 
 			var parsedDelta delta.Delta
 			err := json.Unmarshal([]byte(subSectionContent), &parsedDelta)
 			if err != nil {
-				return fmt.Errorf("missing or invalid content for subsection: %v, in section: %s", subsection["title"],  sectionName)
+				return "", fmt.Errorf("missing or invalid content for subsection: %v, in section: %s", subsection["title"],  sectionName)
 			}
 
-			opsJSON, err := json.Marshal(parsedDelta.Delta.Delta.Ops)
+			opsJSON, err := json.Marshal(doc.resolveOpReferences(parsedDelta.Delta.Delta.Ops))
 			if err != nil {
-				return fmt.Errorf("error marshalling Ops: %v", err)
+				return "", fmt.Errorf("error marshalling Ops: %v", err)
 			}
 
 			html, err := quill.Render(opsJSON)
 			if err != nil {
-				return fmt.Errorf("error converting Delta to HTML: %v", err)
+				return "", fmt.Errorf("error converting Delta to HTML: %v", err)
 			}
 
 
 			if len(html) < 23 {
-				return fmt.Errorf("missing or invalid content for subsection: %v, in section: %s", subsection["title"],  sectionName)
+				return "", fmt.Errorf("missing or invalid content for subsection: %v, in section: %s", subsection["title"],  sectionName)
 			}
 			fmt.Println(len(html))
 
@@ -85,24 +111,56 @@ func GeneratePDF(reportName string, reportContent []map[string]interface{}) erro
 
 	htmlContent += "</body></html>"
 
-	return saveHTMLToPDF(reportName+".pdf", htmlContent)
+	return htmlContent, nil
 }
 
 func saveHTMLToPDF(pdfPath, htmlContent string) error {
+	buf, err := htmlToPDF(htmlContent, "", "")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(pdfPath, buf, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save PDF: %v", err)
+	}
+
+	fmt.Println("PDF successfully generated:", pdfPath)
+	return nil
+}
+
+// htmlToPDF prints an HTML page with headless Chrome. The header and footer
+// are Chrome header templates and are left out when both are empty.
+func htmlToPDF(htmlContent, header, footer string) ([]byte, error) {
 	ctx, cancel := chromedp.NewContext(context.Background())
 	defer cancel()
 
+	// Quote the page as a JavaScript string so quotes in the content or a
+	// custom stylesheet can't end it early
+	quoted, err := json.Marshal(htmlContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode page: %v", err)
+	}
+
 	var buf []byte
-	err := chromedp.Run(ctx,
+	err = chromedp.Run(ctx,
 	chromedp.Navigate("about:blank"),
 	chromedp.ActionFunc(func(ctx context.Context) error {
-		return chromedp.Evaluate(fmt.Sprintf(`document.documentElement.innerHTML = '%s';`, htmlContent), nil).Do(ctx)
+		return chromedp.Evaluate(fmt.Sprintf(`document.documentElement.innerHTML = %s;`, quoted), nil).Do(ctx)
 	}),
 	chromedp.WaitVisible("body", chromedp.ByQuery),
 	chromedp.Sleep(5*time.Second),
 	chromedp.ActionFunc(func(ctx context.Context) error {
 		var localBuf []byte
-		localBuf, _, err := page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+		params := page.PrintToPDF().WithPrintBackground(true)
+		if header != "" || footer != "" {
+			// Chrome draws nothing for an empty template, so a blank span stands in
+			params = params.WithDisplayHeaderFooter(true).
+				WithHeaderTemplate(header + "<span></span>").
+				WithFooterTemplate(footer + "<span></span>").
+				WithPreferCSSPageSize(true)
+		}
+		localBuf, _, err := params.Do(ctx)
 		if err != nil {
 			return err
 		}
@@ -111,14 +169,29 @@ func saveHTMLToPDF(pdfPath, htmlContent string) error {
 	}),
 )
 if err != nil {
-	return fmt.Errorf("failed to render PDF: %v", err)
+	return nil, fmt.Errorf("failed to render PDF: %v", err)
 }
 
-err = os.WriteFile(pdfPath, buf, 0644)
-if err != nil {
-	return fmt.Errorf("failed to save PDF: %v", err)
+return buf, nil
 }
 
-fmt.Println("PDF successfully generated:", pdfPath)
-return nil
+// chromePageLines turns header or footer lines into a Chrome header template,
+// where the page fields are spans Chrome fills in
+func chromePageLines(doc *Document, lines []pageLine) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	fields := strings.NewReplacer("{pages}", `<span class="totalPages"></span>`, "{page}", `<span class="pageNumber"></span>`)
+	var b strings.Builder
+	b.WriteString(`<div style="width: 100%; font-size: 9px; text-align: center; color: #666;">`)
+	for _, line := range lines {
+		style := ""
+		if line.Classification {
+			style = ` style="font-weight: bold; color: #000; text-transform: uppercase;"`
+		}
+		fmt.Fprintf(&b, `<div%s>%s</div>`, style, fields.Replace(html.EscapeString(doc.expandFields(line.Text, "{page}", "{pages}"))))
+	}
+	b.WriteString(`</div>`)
+	return b.String()
 }
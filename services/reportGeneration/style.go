@@ -0,0 +1,127 @@
+package reportGeneration
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+const defaultDateFormat = "January 2, 2006"
+
+// pageNumberText is added to the footer unless a style hides page numbers
+const pageNumberText = "Page {page} of {pages}"
+
+// defaultTitlePage is the title page of HTML and Chrome PDF exports when the
+// style doesn't have its own template
+const defaultTitlePage = `<div class="title-page">
+{{- if .Logo}}<img class="logo" src="{{.Logo}}" alt="">{{end -}}
+{{- if .Organization}}<p class="organization">{{.Organization}}</p>{{end -}}
+<h1>{{.Title}}</h1>
+{{- if .Version}}<p class="version">Version {{.Version}}</p>{{end -}}
+<p class="date">{{.Date}}</p></div>`
+
+// TitlePageFields are the fields available to title page templates
+type TitlePageFields struct {
+	Title          string
+	Organization   string
+	Classification string
+	Version        string
+	Date           string
+	Logo           template.URL
+}
+
+// dateText formats the report date with the style's layout
+func (doc *Document) dateText() string {
+	layout := doc.Style.DateFormat
+	if layout == "" {
+		layout = defaultDateFormat
+	}
+	return doc.Date.Format(layout)
+}
+
+// expandFields fills in the placeholders of a header or footer line. Each
+// format numbers pages its own way, so the page fields are passed in.
+func (doc *Document) expandFields(text, page, pages string) string {
+	return strings.NewReplacer(
+		"{title}", doc.Title,
+		"{organization}", doc.Style.Organization,
+		"{classification}", doc.Style.Classification,
+		"{version}", doc.Style.Version,
+		"{date}", doc.dateText(),
+		"{page}", page,
+		"{pages}", pages,
+	).Replace(text)
+}
+
+// pageLine is a line of a page header or footer, with its placeholders not
+// yet filled in
+type pageLine struct {
+	Text           string
+	Classification bool // The classification banner
+	PageNumbers    bool // Only makes sense in formats with pages
+}
+
+// headerLines are the lines at the top of every page, with the
+// classification banner first
+func (doc *Document) headerLines() []pageLine {
+	var lines []pageLine
+	if doc.Style.Classification != "" {
+		lines = append(lines, pageLine{Text: doc.Style.Classification, Classification: true})
+	}
+	if doc.Style.Header != "" {
+		lines = append(lines, pageLine{Text: doc.Style.Header})
+	}
+	return lines
+}
+
+// footerLines are the lines at the bottom of every page, with the
+// classification banner last
+func (doc *Document) footerLines() []pageLine {
+	var lines []pageLine
+	if doc.Style.Footer != "" {
+		lines = append(lines, pageLine{Text: doc.Style.Footer})
+	}
+	if !doc.Style.HidePageNumbers {
+		lines = append(lines, pageLine{Text: pageNumberText, PageNumbers: true})
+	}
+	if doc.Style.Classification != "" {
+		lines = append(lines, pageLine{Text: doc.Style.Classification, Classification: true})
+	}
+	return lines
+}
+
+// titlePageFields collects the title page fields. Only image data URIs and
+// web addresses are accepted for the logo.
+func (doc *Document) titlePageFields() TitlePageFields {
+	fields := TitlePageFields{
+		Title:          doc.Title,
+		Organization:   doc.Style.Organization,
+		Classification: doc.Style.Classification,
+		Version:        doc.Style.Version,
+		Date:           doc.dateText(),
+	}
+	logo := doc.Style.Logo
+	if strings.HasPrefix(logo, "data:image/") || strings.HasPrefix(logo, "https://") || strings.HasPrefix(logo, "http://") {
+		fields.Logo = template.URL(logo)
+	}
+	return fields
+}
+
+// renderTitlePage runs the style's title page template, or the default one
+func (doc *Document) renderTitlePage() (string, error) {
+	source := doc.Style.TitlePage
+	if source == "" {
+		source = defaultTitlePage
+	}
+
+	tmpl, err := template.New("titlePage").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("invalid title page template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, doc.titlePageFields()); err != nil {
+		return "", fmt.Errorf("failed to render title page: %w", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,96 @@
+package sectionAccess
+
+import (
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
+)
+
+// Level is what a report member may do in a section. Each level allows
+// everything the ones before it do.
+type Level string
+
+const (
+	None    Level = ""
+	Read    Level = "read"
+	Comment Level = "comment"
+	Edit    Level = "edit"
+)
+
+var ranks = map[Level]int{None: 0, Read: 1, Comment: 2, Edit: 3}
+
+// Valid reports whether a level can be granted
+func (l Level) Valid() bool {
+	return ranks[l] > 0
+}
+
+// Allows reports whether a level is at least the required one
+func (l Level) Allows(required Level) bool {
+	return ranks[l] >= ranks[required]
+}
+
+// The most a member's role lets them do in a section they are granted.
+// Admins and owners may edit every section.
+var roleLimits = map[reportRoles.Role]Level{
+	reportRoles.Viewer:    Read,
+	reportRoles.Commenter: Comment,
+	reportRoles.Editor:    Edit,
+}
+
+// Store is the part of the report repository access is read from
+type Store interface {
+	GetMemberRole(uID, reportID string) (reportRoles.Role, error)
+	GetSectionGrants(uID, reportID string) (map[string]Level, error)
+}
+
+// Access is what one member may do in the sections of a report: the grants
+// they have, limited by their role
+type Access struct {
+	Role   reportRoles.Role
+	Grants map[string]Level // sectionID -> level
+}
+
+// All is the access of someone who may edit every section
+var All = Access{Role: reportRoles.Owner}
+
+// Lookup fetches a member's access to a report
+func Lookup(store Store, uID, reportID string) (Access, error) {
+	role, err := store.GetMemberRole(uID, reportID)
+	if err != nil {
+		return Access{}, err
+	}
+	if role.IsAdmin() {
+		return Access{Role: role}, nil
+	}
+
+	grants, err := store.GetSectionGrants(uID, reportID)
+	if err != nil {
+		return Access{}, err
+	}
+	return Access{Role: role, Grants: grants}, nil
+}
+
+// Section returns the level the member has in a section
+func (a Access) Section(sectionID string) Level {
+	if a.Role.IsAdmin() {
+		return Edit
+	}
+	level, limit := a.Grants[sectionID], roleLimits[a.Role]
+	if !limit.Allows(level) {
+		return limit
+	}
+	return level
+}
+
+// Filter keeps the sections the member may read, each with its level set
+func (a Access) Filter(sections []reportStructure.Section) []reportStructure.Section {
+	readable := []reportStructure.Section{}
+	for _, section := range sections {
+		level := a.Section(section.ID)
+		if !level.Allows(Read) {
+			continue
+		}
+		section.Access = string(level)
+		readable = append(readable, section)
+	}
+	return readable
+}
@@ -0,0 +1,49 @@
+package sectionAccess_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/reportRoles"
+	"sema/models/reportStructure"
+	"sema/services/sectionAccess"
+)
+
+func TestLevels(t *testing.T) {
+	assert.True(t, sectionAccess.Edit.Allows(sectionAccess.Comment))
+	assert.True(t, sectionAccess.Comment.Allows(sectionAccess.Read))
+	assert.False(t, sectionAccess.Read.Allows(sectionAccess.Edit))
+	assert.False(t, sectionAccess.None.Allows(sectionAccess.Read))
+	assert.False(t, sectionAccess.Level("owner").Allows(sectionAccess.Read))
+
+	assert.True(t, sectionAccess.Read.Valid())
+	assert.False(t, sectionAccess.None.Valid())
+	assert.False(t, sectionAccess.Level("owner").Valid())
+}
+
+func TestFilter(t *testing.T) {
+	sections := []reportStructure.Section{{ID: "s1", Title: "Introduction"}, {ID: "s2", Title: "Design"}}
+
+	member := sectionAccess.Access{Role: reportRoles.Editor, Grants: map[string]sectionAccess.Level{"s2": sectionAccess.Comment}}
+	assert.Equal(t, []reportStructure.Section{{ID: "s2", Title: "Design", Access: "comment"}}, member.Filter(sections))
+	assert.Equal(t, sectionAccess.None, member.Section("s1"))
+
+	assert.Len(t, sectionAccess.All.Filter(sections), 2)
+	assert.Equal(t, sectionAccess.Edit, sectionAccess.All.Section("s1"))
+}
+
+func TestRoleLimitsGrants(t *testing.T) {
+	grants := map[string]sectionAccess.Level{"s1": sectionAccess.Edit, "s2": sectionAccess.Read}
+
+	viewer := sectionAccess.Access{Role: reportRoles.Viewer, Grants: grants}
+	assert.Equal(t, sectionAccess.Read, viewer.Section("s1"))
+	assert.Equal(t, sectionAccess.Read, viewer.Section("s2"))
+	assert.Equal(t, sectionAccess.None, viewer.Section("s3"))
+
+	commenter := sectionAccess.Access{Role: reportRoles.Commenter, Grants: grants}
+	assert.Equal(t, sectionAccess.Comment, commenter.Section("s1"))
+	assert.Equal(t, sectionAccess.Read, commenter.Section("s2"))
+
+	admin := sectionAccess.Access{Role: reportRoles.Admin}
+	assert.Equal(t, sectionAccess.Edit, admin.Section("s3"))
+}
@@ -0,0 +1,360 @@
+package templateMigration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sema/models/delta"
+	"sema/models/reportStructure"
+	"sema/models/reportTemplates"
+)
+
+// Actions a migration step can take
+const (
+	AddSection       = "addSection"
+	MoveSection      = "moveSection"
+	RemoveSection    = "removeSection"
+	AddSubsection    = "addSubsection"
+	MoveSubsection   = "moveSubsection"
+	RemoveSubsection = "removeSubsection"
+)
+
+// Step is one change to a report's structure. Positions are 0-based and
+// account for every step before it. Titles are for display, except that a
+// section added by an earlier step has no ID yet and is found by its title.
+type Step struct {
+	Action       string `json:"action"`
+	Section      string `json:"section"`
+	SectionID    string `json:"sectionID,omitempty"`
+	Subsection   string `json:"subsection,omitempty"`
+	SubsectionID string `json:"subsectionID,omitempty"`
+	ToSection    string `json:"toSection,omitempty"` // Only for subsections that move
+	ToSectionID  string `json:"toSectionID,omitempty"`
+	Position     int    `json:"position"`
+
+	// Set on removals that delete content someone has written
+	DiscardsContent bool `json:"discardsContent,omitempty"`
+}
+
+func (s Step) String() string {
+	switch s.Action {
+	case AddSection:
+		return fmt.Sprintf("add section %s at %d", s.Section, s.Position)
+	case MoveSection:
+		return fmt.Sprintf("move section %s to %d", s.Section, s.Position)
+	case RemoveSection:
+		return fmt.Sprintf("remove section %s", s.Section)
+	case AddSubsection:
+		return fmt.Sprintf("add subsection %s/%s at %d", s.Section, s.Subsection, s.Position)
+	case MoveSubsection:
+		return fmt.Sprintf("move subsection %s/%s to %s at %d", s.Section, s.Subsection, s.ToSection, s.Position)
+	case RemoveSubsection:
+		return fmt.Sprintf("remove subsection %s/%s", s.Section, s.Subsection)
+	}
+	return s.Action
+}
+
+// Migration moves a report from one version of its template to another
+type Migration struct {
+	TemplateID  string `json:"templateID"`
+	FromVersion int    `json:"fromVersion"`
+	ToVersion   int    `json:"toVersion"`
+	Steps       []Step `json:"steps"`
+}
+
+// DiscardsContent reports whether any step deletes written content
+func (m Migration) DiscardsContent() bool {
+	for _, step := range m.Steps {
+		if step.DiscardsContent {
+			return true
+		}
+	}
+	return false
+}
+
+// LogMessage describes the migration for the report's log
+func (m Migration) LogMessage() string {
+	changes := "no structural changes"
+	if len(m.Steps) > 0 {
+		described := make([]string, len(m.Steps))
+		for i, step := range m.Steps {
+			described[i] = step.String()
+		}
+		changes = strings.Join(described, "; ")
+	}
+	return fmt.Sprintf("migrated report from template %s version %d to version %d: %s", m.TemplateID, m.FromVersion, m.ToVersion, changes)
+}
+
+// Plan turns a report's sections into a template's. Sections and subsections
+// are matched by title, so the content of any kept is untouched. A subsection
+// the template moves to another section is moved along with its content,
+// as long as its title is only in one section of each. Titles the template
+// repeats are only taken the first time.
+func Plan(current []reportStructure.Section, target []reportTemplates.Section) []Step {
+	target = distinctTitles(target)
+	working := copySections(current)
+	var steps []Step
+
+	// Sections into template order, with any the template drops left at the end
+	for i, section := range target {
+		at := sectionIndex(working, section.Title)
+		if at == -1 {
+			steps = append(steps, Step{Action: AddSection, Section: section.Title, Position: i})
+			working = insertAt(working, reportStructure.Section{Title: section.Title}, i)
+		} else if at != i {
+			moved := working[at]
+			steps = append(steps, Step{Action: MoveSection, Section: moved.Title, SectionID: moved.ID, Position: i})
+			working = insertAt(append(working[:at], working[at+1:]...), moved, i)
+		}
+	}
+
+	// Subsections moving between sections keep their content
+	for i, section := range target {
+		for _, title := range section.Subsections {
+			if subsectionIndex(working[i].Subsections, title) != -1 {
+				continue
+			}
+			from := movedFrom(working, target, title)
+			if from == -1 {
+				continue
+			}
+			at := subsectionIndex(working[from].Subsections, title)
+			subsection := working[from].Subsections[at]
+			steps = append(steps, Step{
+				Action: MoveSubsection, Section: working[from].Title, SectionID: working[from].ID,
+				Subsection: subsection.Title, SubsectionID: subsection.ID,
+				ToSection: working[i].Title, ToSectionID: working[i].ID, Position: len(working[i].Subsections),
+			})
+			working[from].Subsections = append(working[from].Subsections[:at], working[from].Subsections[at+1:]...)
+			working[i].Subsections = append(working[i].Subsections, subsection)
+		}
+	}
+
+	// Subsections into template order, then drop the rest
+	for i, section := range target {
+		for j, title := range section.Subsections {
+			at := subsectionIndex(working[i].Subsections, title)
+			if at == -1 {
+				steps = append(steps, Step{Action: AddSubsection, Section: working[i].Title, SectionID: working[i].ID, Subsection: title, Position: j})
+				working[i].Subsections = insertAt(working[i].Subsections, reportStructure.Subsection{Title: title}, j)
+			} else if at != j {
+				subsection := working[i].Subsections[at]
+				steps = append(steps, Step{
+					Action: MoveSubsection, Section: working[i].Title, SectionID: working[i].ID,
+					Subsection: subsection.Title, SubsectionID: subsection.ID,
+					ToSection: working[i].Title, ToSectionID: working[i].ID, Position: j,
+				})
+				rest := append(working[i].Subsections[:at], working[i].Subsections[at+1:]...)
+				working[i].Subsections = insertAt(rest, subsection, j)
+			}
+		}
+		for _, subsection := range working[i].Subsections[len(section.Subsections):] {
+			steps = append(steps, Step{Action: RemoveSubsection, Section: working[i].Title, SectionID: working[i].ID, Subsection: subsection.Title, SubsectionID: subsection.ID})
+		}
+		working[i].Subsections = working[i].Subsections[:len(section.Subsections)]
+	}
+
+	for _, section := range working[len(target):] {
+		steps = append(steps, Step{Action: RemoveSection, Section: section.Title, SectionID: section.ID})
+	}
+	return steps
+}
+
+// Store is the part of the report repository a migration reads and changes
+type Store interface {
+	FetchReportSectionContents(reportID, sectionID string) (map[string]string, error)
+	AddSection(reportID, title string, position int) (string, error)
+	AddSubsection(reportID, sectionID, title string, position int) (string, error)
+	DeleteSection(reportID, sectionID string) error
+	DeleteSubsection(reportID, sectionID, subsectionID string) error
+	MoveSection(reportID, sectionID string, position int) error
+	MoveSubsection(reportID, sectionID, subsectionID, toSectionID string, position int) error
+}
+
+// MarkDiscardedContent flags the removals in a plan that would delete
+// subsections with content
+func MarkDiscardedContent(store Store, reportID string, steps []Step) error {
+	contents := make(map[string]map[string]string)
+	sectionContents := func(sectionID string) (map[string]string, error) {
+		if _, ok := contents[sectionID]; !ok {
+			fetched, err := store.FetchReportSectionContents(reportID, sectionID)
+			if err != nil {
+				return nil, err
+			}
+			contents[sectionID] = fetched
+		}
+		return contents[sectionID], nil
+	}
+
+	for i, step := range steps {
+		if step.Action != RemoveSection && step.Action != RemoveSubsection {
+			continue
+		}
+		fetched, err := sectionContents(step.SectionID)
+		if err != nil {
+			return fmt.Errorf("failed to check content of section %s: %w", step.Section, err)
+		}
+		for subsectionID, content := range fetched {
+			if step.Action == RemoveSubsection && subsectionID != step.SubsectionID {
+				continue
+			}
+			if hasContent(content) {
+				steps[i].DiscardsContent = true
+			}
+		}
+	}
+	return nil
+}
+
+// Apply runs the steps of a plan in order. Steps already applied stay applied
+// if one fails, and the error says which failed.
+func Apply(store Store, reportID string, steps []Step) error {
+	// IDs of the sections added so far, by title
+	added := make(map[string]string)
+	sectionID := func(id, title string) string {
+		if id == "" {
+			return added[title]
+		}
+		return id
+	}
+
+	for _, step := range steps {
+		var err error
+		switch step.Action {
+		case AddSection:
+			added[step.Section], err = store.AddSection(reportID, step.Section, step.Position)
+		case MoveSection:
+			err = store.MoveSection(reportID, step.SectionID, step.Position)
+		case RemoveSection:
+			err = store.DeleteSection(reportID, step.SectionID)
+		case AddSubsection:
+			_, err = store.AddSubsection(reportID, sectionID(step.SectionID, step.Section), step.Subsection, step.Position)
+		case MoveSubsection:
+			err = store.MoveSubsection(reportID, step.SectionID, step.SubsectionID, sectionID(step.ToSectionID, step.ToSection), step.Position)
+		case RemoveSubsection:
+			err = store.DeleteSubsection(reportID, step.SectionID, step.SubsectionID)
+		default:
+			err = fmt.Errorf("unknown action %s", step.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s: %w", step, err)
+		}
+	}
+	return nil
+}
+
+// hasContent reports whether stored content has anything but whitespace. An
+// editor that has been opened but not typed in is saved as a single newline.
+func hasContent(content string) bool {
+	var stored delta.Delta
+	if err := json.Unmarshal([]byte(content), &stored); err != nil {
+		return strings.TrimSpace(content) != ""
+	}
+	for _, op := range stored.Delta.Delta.Ops {
+		if len(op.Insert) == 0 {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(op.Insert, &text); err != nil || strings.TrimSpace(text) != "" {
+			return true // Text, or an embed such as an image
+		}
+	}
+	return false
+}
+
+// movedFrom finds the section a subsection can be moved from: the only one
+// it is in, and one the template doesn't keep it in
+func movedFrom(working []reportStructure.Section, target []reportTemplates.Section, title string) int {
+	from := -1
+	for i, section := range working {
+		if subsectionIndex(section.Subsections, title) == -1 {
+			continue
+		}
+		if from != -1 || (i < len(target) && containsTitle(target[i].Subsections, title)) {
+			return -1
+		}
+		from = i
+	}
+	return from
+}
+
+// sameTitle compares titles the way the repository keeps them unique
+func sameTitle(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// distinctTitles leaves out the sections, and the subsections of a section,
+// whose title comes up again. Templates made by hand were never validated and
+// may repeat one, and each title is only matched once.
+func distinctTitles(sections []reportTemplates.Section) []reportTemplates.Section {
+	var distinct []reportTemplates.Section
+	for _, section := range sections {
+		if sectionTitleIndex(distinct, section.Title) != -1 {
+			continue
+		}
+		var subsections []string
+		for _, title := range section.Subsections {
+			if !containsTitle(subsections, title) {
+				subsections = append(subsections, title)
+			}
+		}
+		section.Subsections = subsections
+		distinct = append(distinct, section)
+	}
+	return distinct
+}
+
+func sectionTitleIndex(sections []reportTemplates.Section, title string) int {
+	for i, section := range sections {
+		if sameTitle(section.Title, title) {
+			return i
+		}
+	}
+	return -1
+}
+
+func copySections(sections []reportStructure.Section) []reportStructure.Section {
+	copied := make([]reportStructure.Section, len(sections))
+	for i, section := range sections {
+		copied[i] = reportStructure.Section{ID: section.ID, Title: section.Title, Subsections: append([]reportStructure.Subsection{}, section.Subsections...)}
+	}
+	return copied
+}
+
+func sectionIndex(sections []reportStructure.Section, title string) int {
+	for i, section := range sections {
+		if sameTitle(section.Title, title) {
+			return i
+		}
+	}
+	return -1
+}
+
+func subsectionIndex(subsections []reportStructure.Subsection, title string) int {
+	for i, subsection := range subsections {
+		if sameTitle(subsection.Title, title) {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsTitle(titles []string, title string) bool {
+	for _, existing := range titles {
+		if sameTitle(existing, title) {
+			return true
+		}
+	}
+	return false
+}
+
+func insertAt[T any](items []T, item T, position int) []T {
+	if position < 0 || position > len(items) {
+		position = len(items)
+	}
+	items = append(items, item)
+	copy(items[position+1:], items[position:])
+	items[position] = item
+	return items
+}
@@ -0,0 +1,144 @@
+package templateMigration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sema/models/reportStructure"
+	"sema/models/reportTemplates"
+)
+
+// apply runs steps against a copy of sections the way the repository would,
+// giving added sections and subsections their title as ID
+func apply(sections []reportStructure.Section, steps []Step) []reportStructure.Section {
+	working := copySections(sections)
+	find := func(id, title string) int {
+		if id == "" {
+			id = title
+		}
+		for i, section := range working {
+			if section.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+	remove := func(subsections []reportStructure.Subsection, id string) []reportStructure.Subsection {
+		for i, subsection := range subsections {
+			if subsection.ID == id {
+				return append(subsections[:i], subsections[i+1:]...)
+			}
+		}
+		return subsections
+	}
+	for _, step := range steps {
+		switch step.Action {
+		case AddSection:
+			working = insertAt(working, reportStructure.Section{ID: step.Section, Title: step.Section}, step.Position)
+		case MoveSection:
+			at := find(step.SectionID, step.Section)
+			moved := working[at]
+			working = insertAt(append(working[:at], working[at+1:]...), moved, step.Position)
+		case RemoveSection:
+			at := find(step.SectionID, step.Section)
+			working = append(working[:at], working[at+1:]...)
+		case AddSubsection:
+			at := find(step.SectionID, step.Section)
+			working[at].Subsections = insertAt(working[at].Subsections, reportStructure.Subsection{ID: step.Subsection, Title: step.Subsection}, step.Position)
+		case MoveSubsection:
+			from := find(step.SectionID, step.Section)
+			to := find(step.ToSectionID, step.ToSection)
+			moved := reportStructure.Subsection{ID: step.SubsectionID, Title: step.Subsection}
+			working[from].Subsections = remove(working[from].Subsections, step.SubsectionID)
+			working[to].Subsections = insertAt(working[to].Subsections, moved, step.Position)
+		case RemoveSubsection:
+			at := find(step.SectionID, step.Section)
+			working[at].Subsections = remove(working[at].Subsections, step.SubsectionID)
+		}
+	}
+	return working
+}
+
+// structure gives template sections IDs made from their titles
+func structure(sections []reportTemplates.Section) []reportStructure.Section {
+	structured := make([]reportStructure.Section, len(sections))
+	for i, section := range sections {
+		structured[i] = reportStructure.Section{ID: section.Title, Title: section.Title, Subsections: []reportStructure.Subsection{}}
+		for _, title := range section.Subsections {
+			structured[i].Subsections = append(structured[i].Subsections, reportStructure.Subsection{ID: title, Title: title})
+		}
+	}
+	return structured
+}
+
+func TestPlan(t *testing.T) {
+	current := structure([]reportTemplates.Section{
+		{Title: "Introduction", Subsections: []string{"Overview", "Scope", "Notes"}},
+		{Title: "Design", Subsections: []string{"Components", "Interfaces"}},
+		{Title: "Appendix", Subsections: []string{"Glossary"}},
+	})
+	target := []reportTemplates.Section{
+		{Title: "Design", Subsections: []string{"Interfaces", "Components"}},
+		{Title: "Introduction", Subsections: []string{"Scope", "Overview", "Terminology"}},
+		{Title: "References", Subsections: []string{"Glossary"}},
+	}
+
+	steps := Plan(current, target)
+	assert.Equal(t, structure(target), apply(current, steps))
+
+	// Glossary moves with its section gone rather than being recreated
+	assert.Contains(t, steps, Step{Action: MoveSubsection, Section: "Appendix", SectionID: "Appendix", Subsection: "Glossary", SubsectionID: "Glossary", ToSection: "References", Position: 0})
+	assert.Contains(t, steps, Step{Action: RemoveSubsection, Section: "Introduction", SectionID: "Introduction", Subsection: "Notes", SubsectionID: "Notes"})
+	assert.Equal(t, Step{Action: RemoveSection, Section: "Appendix", SectionID: "Appendix"}, steps[len(steps)-1])
+
+	// Nothing to do when the report already matches
+	assert.Empty(t, Plan(structure(target), target))
+}
+
+func TestPlanMatchesTitlesLoosely(t *testing.T) {
+	current := []reportStructure.Section{{ID: "s1", Title: "introduction ", Subsections: []reportStructure.Subsection{{ID: "u1", Title: "Overview"}}}}
+	assert.Empty(t, Plan(current, []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"overview"}}}))
+}
+
+func TestPlanTakesRepeatedTitlesOnce(t *testing.T) {
+	current := structure([]reportTemplates.Section{
+		{Title: "Introduction", Subsections: []string{"Overview"}},
+	})
+	// Templates made by hand may list a title twice
+	target := []reportTemplates.Section{
+		{Title: "Introduction", Subsections: []string{"Overview", "Scope", "overview"}},
+		{Title: "Design", Subsections: []string{"Components"}},
+		{Title: "introduction", Subsections: []string{"Notes"}},
+	}
+
+	steps := Plan(current, target)
+	assert.Equal(t, structure([]reportTemplates.Section{
+		{Title: "Introduction", Subsections: []string{"Overview", "Scope"}},
+		{Title: "Design", Subsections: []string{"Components"}},
+	}), apply(current, steps))
+}
+
+func TestPlanFromEmpty(t *testing.T) {
+	target := []reportTemplates.Section{{Title: "Introduction", Subsections: []string{"Overview"}}}
+	steps := Plan(nil, target)
+	assert.Equal(t, []Step{
+		{Action: AddSection, Section: "Introduction", Position: 0},
+		{Action: AddSubsection, Section: "Introduction", Subsection: "Overview", Position: 0},
+	}, steps)
+}
+
+func TestHasContent(t *testing.T) {
+	assert.False(t, hasContent(""))
+	assert.False(t, hasContent(`{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"\n"}]}}}`))
+	assert.True(t, hasContent(`{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Text\n"}]}}}`))
+	assert.True(t, hasContent(`{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":{"image":"data:"}},{"insert":"\n"}]}}}`))
+}
+
+func TestLogMessage(t *testing.T) {
+	migration := Migration{TemplateID: "st", FromVersion: 1, ToVersion: 2, Steps: []Step{
+		{Action: AddSection, Section: "References", Position: 2},
+		{Action: RemoveSubsection, Section: "Introduction", Subsection: "Notes", DiscardsContent: true},
+	}}
+	assert.True(t, migration.DiscardsContent())
+	assert.Equal(t, "migrated report from template st version 1 to version 2: add section References at 2; remove subsection Introduction/Notes", migration.LogMessage())
+}
@@ -0,0 +1,175 @@
+package versionDiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"sema/models/delta"
+)
+
+// Result is the difference between two saved contents of a subsection
+type Result struct {
+	Delta delta.DeltaOps  `json:"delta"` // Turns the old content into the new one
+	Runs  []delta.DiffRun `json:"runs"`
+	HTML  string          `json:"html"` // Redline of the runs
+}
+
+// Compare diffs two subsection contents in the stored delta message format
+func Compare(fromContent, toContent string) (*Result, error) {
+	from, err := parseContent(fromContent)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseContent(toContent)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := from.Diff(to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff contents: %w", err)
+	}
+
+	runs := from.DiffRuns(diff)
+	return &Result{Delta: diff, Runs: runs, HTML: RenderRedline(runs)}, nil
+}
+
+// parseContent reads stored subsection content. Empty content is an empty
+// editor, which is a single newline.
+func parseContent(content string) (delta.DeltaOps, error) {
+	var stored delta.Delta
+	if content != "" {
+		if err := json.Unmarshal([]byte(content), &stored); err != nil {
+			return delta.DeltaOps{}, fmt.Errorf("invalid subsection content: %w", err)
+		}
+	}
+
+	ops := stored.Delta.Delta
+	if len(ops.Ops) == 0 {
+		ops.Ops = []delta.DeltaOp{{Insert: json.RawMessage(`"\n"`)}}
+	}
+	return ops, nil
+}
+
+// RenderRedline renders diff runs as HTML, with insertions in <ins>, deletions
+// in <del> and reformatted text in a span titled with the formats changed.
+// Inserted and deleted line breaks are shown with a pilcrow.
+func RenderRedline(runs []delta.DiffRun) string {
+	var b strings.Builder
+	open := false
+	openParagraph := func() {
+		if !open {
+			b.WriteString("<p>")
+			open = true
+		}
+	}
+	closeParagraph := func() {
+		openParagraph()
+		b.WriteString("</p>")
+		open = false
+	}
+
+	b.WriteString(`<div class="redline">`)
+	for _, run := range runs {
+		if run.Embed != nil {
+			openParagraph()
+			b.WriteString(wrapRun(run, renderEmbed(run.Embed)))
+			continue
+		}
+
+		lines := strings.Split(run.Text, "\n")
+		for i, line := range lines {
+			if line != "" {
+				openParagraph()
+				b.WriteString(wrapRun(run, formatText(html.EscapeString(line), run.Attributes)))
+			}
+			if i == len(lines)-1 {
+				break
+			}
+
+			// A line break between this line and the next
+			switch run.Type {
+			case "insert":
+				openParagraph()
+				b.WriteString(wrapRun(run, "&para;"))
+				closeParagraph()
+			case "delete":
+				openParagraph()
+				b.WriteString(wrapRun(run, "&para;"))
+			default:
+				closeParagraph()
+			}
+		}
+	}
+	if open {
+		b.WriteString("</p>")
+	}
+	b.WriteString("</div>")
+	return b.String()
+}
+
+func wrapRun(run delta.DiffRun, content string) string {
+	switch run.Type {
+	case "insert":
+		return `<ins class="diff-insert">` + content + `</ins>`
+	case "delete":
+		return `<del class="diff-delete">` + content + `</del>`
+	case "format":
+		return `<span class="diff-format" title="Formatting changed: ` + html.EscapeString(changedFormats(run.Changes)) + `">` + content + `</span>`
+	default:
+		return content
+	}
+}
+
+func formatText(text string, attributes *delta.Attributes) string {
+	if attributes == nil {
+		return text
+	}
+	if attributes.Bold != nil && *attributes.Bold {
+		text = "<strong>" + text + "</strong>"
+	}
+	if attributes.Italic != nil && *attributes.Italic {
+		text = "<em>" + text + "</em>"
+	}
+	if attributes.Underline != nil && *attributes.Underline {
+		text = "<u>" + text + "</u>"
+	}
+	if attributes.Link != nil && delta.SafeLink(*attributes.Link) {
+		text = `<a href="` + html.EscapeString(*attributes.Link) + `">` + text + `</a>`
+	}
+	return text
+}
+
+func renderEmbed(embed json.RawMessage) string {
+	var image delta.ImageEmbed
+	if err := json.Unmarshal(embed, &image); err == nil && image.Image != "" {
+		return `<img src="` + html.EscapeString(image.Image) + `" alt="">`
+	}
+	return "[embed]"
+}
+
+// changedFormats lists the format names in a format change, sorted
+func changedFormats(changes *delta.Attributes) string {
+	if changes == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return ""
+	}
+	var formats map[string]interface{}
+	if err := json.Unmarshal(data, &formats); err != nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
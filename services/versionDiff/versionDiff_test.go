@@ -0,0 +1,61 @@
+package versionDiff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func storedContent(ops string) string {
+	return `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":` + ops + `}}}`
+}
+
+func TestCompare(t *testing.T) {
+	from := storedContent(`[{"insert":"The TOE is secure.\n"}]`)
+	to := storedContent(`[{"insert":"The TOE","attributes":{"bold":true}},{"insert":" is <very> secure.\nNew line\n"}]`)
+
+	result, err := Compare(from, to)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Delta.Ops)
+	assert.Equal(t, "format", result.Runs[0].Type)
+	assert.Equal(t, "The TOE", result.Runs[0].Text)
+
+	assert.Contains(t, result.HTML, `<span class="diff-format" title="Formatting changed: bold"><strong>The TOE</strong></span>`)
+	assert.Contains(t, result.HTML, `<ins class="diff-insert">&lt;very&gt; </ins>`)
+	assert.Contains(t, result.HTML, `<ins class="diff-insert">&para;</ins></p><p><ins class="diff-insert">New line</ins>`)
+}
+
+func TestCompareEmptyContent(t *testing.T) {
+	result, err := Compare("", storedContent(`[{"insert":"Written\n"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `<div class="redline"><p><ins class="diff-insert">Written</ins></p></div>`, result.HTML)
+
+	_, err = Compare("not json", "")
+	assert.Error(t, err)
+}
+
+func TestRenderRedlineDeletedLineBreak(t *testing.T) {
+	result, err := Compare(storedContent(`[{"insert":"One\nTwo\n"}]`), storedContent(`[{"insert":"OneTwo\n"}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `<div class="redline"><p>One<del class="diff-delete">&para;</del>Two</p></div>`, result.HTML)
+}
+
+func TestRenderRedlineOnlyKeepsSafeLinks(t *testing.T) {
+	to := storedContent(`[{"insert":"web","attributes":{"link":"https://example.com/?a=1&b=2"}},` +
+		`{"insert":"mail","attributes":{"link":"mailto:lab@example.com"}},` +
+		`{"insert":"section","attributes":{"link":"ref:Development/ADV_FSP Functional Specification"}},` +
+		`{"insert":"script","attributes":{"link":"JavaScript:alert(1)"}},` +
+		`{"insert":"data","attributes":{"link":"data:text/html,<script>alert(1)</script>"}},` +
+		`{"insert":"tab","attributes":{"link":"java\tscript:alert(1)"}},` +
+		`{"insert":"\n"}]`)
+	result, err := Compare("", to)
+	assert.NoError(t, err)
+
+	assert.Contains(t, result.HTML, `<a href="https://example.com/?a=1&amp;b=2">web</a>`)
+	assert.Contains(t, result.HTML, `<a href="mailto:lab@example.com">mail</a>`)
+	assert.Contains(t, result.HTML, `<a href="ref:Development/ADV_FSP Functional Specification">section</a>`)
+	assert.Contains(t, result.HTML, `<ins class="diff-insert">script</ins>`)
+	assert.Equal(t, 3, strings.Count(result.HTML, "<a "))
+	assert.NotContains(t, strings.ToLower(result.HTML), "script:")
+}
@@ -0,0 +1,116 @@
+package websockets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// sendQueueSize is how many messages a connection may have waiting to be
+// written before it is taken to have stopped reading and is closed
+const sendQueueSize = 256
+
+var (
+	// ErrNotConnected is returned for messages to connections that were
+	// disconnected or never connected
+	ErrNotConnected = errors.New("connection is not connected")
+	// ErrSlowClient is returned when a connection's queue is full. The
+	// connection is closed.
+	ErrSlowClient = errors.New("connection is not keeping up")
+)
+
+// client writes the messages queued for one connection, so a client that
+// stopped reading only holds up its own writer and never the manager.
+// Messages are queued encoded, as they point into state the manager keeps
+// changing.
+type client struct {
+	conn     *websocket.Conn
+	send     chan []byte
+	finished chan struct{} // closed once the writer is done
+}
+
+func (c *client) write() {
+	defer close(c.finished)
+	failed := false
+	for message := range c.send {
+		if failed {
+			continue // Drained so queueing never blocks
+		}
+		if err := writeMessage(c.conn, message); err != nil {
+			log.Println("Error sending message:", err)
+			failed = true
+			c.conn.Close() // The reader fails and removes the connection
+		}
+	}
+}
+
+// Connect starts the writer of a connection. Connections are also connected
+// when they open a section, so this is only needed for messages sent before.
+func (manager *WebSocketManager) Connect(conn *websocket.Conn) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.connect(conn)
+}
+
+// connect is Connect for callers that hold manager.mu
+func (manager *WebSocketManager) connect(conn *websocket.Conn) {
+	if _, ok := manager.clients[conn]; ok {
+		return
+	}
+	c := &client{conn: conn, send: make(chan []byte, sendQueueSize), finished: make(chan struct{})}
+	manager.clients[conn] = c
+	go c.write()
+}
+
+// Disconnect stops the writer of a connection once everything queued for it
+// is written, and waits for that. Messages queued afterwards are dropped.
+func (manager *WebSocketManager) Disconnect(conn *websocket.Conn) {
+	manager.mu.Lock()
+	c, ok := manager.clients[conn]
+	if ok {
+		delete(manager.clients, conn)
+		close(c.send)
+	}
+	manager.mu.Unlock()
+
+	if ok {
+		<-c.finished
+	}
+}
+
+// queue hands a message to a connection's writer. A connection whose queue is
+// full is closed. The caller must hold manager.mu.
+func (manager *WebSocketManager) queue(conn *websocket.Conn, message interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return manager.queueEncoded(conn, data)
+}
+
+// queueEncoded is queue for a message that is already encoded, such as one
+// broadcast to a whole section. The caller must hold manager.mu.
+func (manager *WebSocketManager) queueEncoded(conn *websocket.Conn, data []byte) error {
+	c, ok := manager.clients[conn]
+	if !ok {
+		return ErrNotConnected
+	}
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		conn.Close()
+		return fmt.Errorf("%w: %d messages waiting for %s", ErrSlowClient, sendQueueSize, conn.RemoteAddr())
+	}
+}
+
+// writeMessage writes an encoded message with a deadline, so a client that
+// stopped reading is given up on. Only the connection's writer calls it.
+func writeMessage(conn *websocket.Conn, data []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
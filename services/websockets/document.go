@@ -0,0 +1,93 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"sema/models/delta"
+)
+
+// maxHistory is how many applied deltas a document keeps for transforming
+// deltas from clients that are behind.
+const maxHistory = 1000
+
+// Document is the server copy of one editor (subsection) in a section. Every
+// applied delta bumps the revision, and clients send the revision their delta
+// was made against so concurrent edits can be transformed.
+type Document struct {
+	Content  delta.DeltaOps
+	Revision int
+
+	history []delta.DeltaOps // history[i] took the document from revision base+i to base+i+1
+	base    int
+}
+
+// NewDocument creates a document at revision 0 with the given content
+func NewDocument(content delta.DeltaOps) *Document {
+	return &Document{Content: withNewline(content)}
+}
+
+// withNewline gives content the line break Quill ends every document with
+func withNewline(content delta.DeltaOps) delta.DeltaOps {
+	content = content.Compose(delta.DeltaOps{})
+	if !content.EndsWithNewline() {
+		content.Ops = append(content.Ops, delta.DeltaOp{Insert: json.RawMessage(`"\n"`)})
+		content = content.Compose(delta.DeltaOps{})
+	}
+	return content
+}
+
+// Apply transforms ops, made against revision, over every delta applied since,
+// then applies it. It returns the transformed delta that clients must apply.
+// Deltas that reach past the end of the document or remove its final line
+// break are rejected.
+func (d *Document) Apply(revision int, ops delta.DeltaOps) (delta.DeltaOps, error) {
+	concurrent, err := d.Since(revision)
+	if err != nil {
+		return delta.DeltaOps{}, err
+	}
+	for _, applied := range concurrent {
+		ops = applied.Transform(ops, true)
+	}
+
+	if reach, length := ops.BaseLength(), d.Content.Length(); reach > length {
+		return delta.DeltaOps{}, fmt.Errorf("delta reaches %d characters into a document of %d", reach, length)
+	}
+	content := d.Content.Compose(ops)
+	if !content.EndsWithNewline() {
+		return delta.DeltaOps{}, fmt.Errorf("delta removes the final line break")
+	}
+
+	d.Content = content
+	d.history = append(d.history, ops)
+	d.Revision++
+
+	if len(d.history) > maxHistory {
+		drop := len(d.history) - maxHistory
+		d.history = append([]delta.DeltaOps(nil), d.history[drop:]...)
+		d.base += drop
+	}
+
+	return ops, nil
+}
+
+// Since returns the deltas applied after revision, oldest first
+func (d *Document) Since(revision int) ([]delta.DeltaOps, error) {
+	if revision > d.Revision || revision < 0 {
+		return nil, fmt.Errorf("revision %d is not valid, document is at revision %d", revision, d.Revision)
+	}
+	if revision < d.base {
+		return nil, fmt.Errorf("revision %d is too old, history starts at revision %d", revision, d.base)
+	}
+	return d.history[revision-d.base:], nil
+}
+
+// Replace swaps the whole content for new content. It is applied as a delta at
+// the current revision so it is ordered and transformed like a client edit.
+func (d *Document) Replace(content delta.DeltaOps) (delta.DeltaOps, error) {
+	ops := delta.DeltaOps{Ops: append([]delta.DeltaOp{}, withNewline(content).Ops...)}
+	if length := d.Content.Length(); length > 0 {
+		ops.Ops = append(ops.Ops, delta.DeltaOp{Delete: length})
+	}
+	return d.Apply(d.Revision, ops)
+}
@@ -0,0 +1,83 @@
+package websockets
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Variables so tests can shorten them
+var (
+	// writeWait is how long a write to a client may take before the
+	// connection is given up on
+	writeWait = 10 * time.Second
+	// pongWait is how long a client has to answer a ping
+	pongWait = 60 * time.Second
+	// pingPeriod is how often clients are pinged, well inside pongWait
+	pingPeriod = pongWait * 9 / 10
+	// idleTimeout closes connections that answer pings but send nothing else,
+	// such as a tab left open overnight
+	idleTimeout = 30 * time.Minute
+)
+
+// Heartbeat pings a connection and keeps its read deadline, so reads from a
+// client that went away without closing fail instead of blocking forever.
+// Pongs push the deadline out by pongWait, but never past idleTimeout after
+// the client's last message. Only the reading goroutine may call Received.
+type Heartbeat struct {
+	conn        *websocket.Conn
+	lastMessage time.Time
+	stop        chan struct{}
+}
+
+// StartHeartbeat sets the connection's first read deadline and starts pinging
+// it until Stop
+func StartHeartbeat(conn *websocket.Conn) *Heartbeat {
+	h := &Heartbeat{conn: conn, lastMessage: time.Now(), stop: make(chan struct{})}
+	h.extend()
+	conn.SetPongHandler(func(string) error {
+		h.extend()
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(pingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// Control frames may be written alongside the manager's writes
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+					return // The read deadline ends the connection
+				}
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+	return h
+}
+
+// Received notes a message from the client
+func (h *Heartbeat) Received() {
+	h.lastMessage = time.Now()
+	h.extend()
+}
+
+// Idle reports whether the client sent nothing for idleTimeout
+func (h *Heartbeat) Idle() bool {
+	return time.Since(h.lastMessage) >= idleTimeout
+}
+
+// Stop stops pinging
+func (h *Heartbeat) Stop() {
+	close(h.stop)
+}
+
+func (h *Heartbeat) extend() {
+	deadline := time.Now().Add(pongWait)
+	if idle := h.lastMessage.Add(idleTimeout); idle.Before(deadline) {
+		deadline = idle
+	}
+	h.conn.SetReadDeadline(deadline)
+}
@@ -0,0 +1,186 @@
+package websockets
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"sema/models/delta"
+	"sema/models/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// randomID returns n random bytes in hex
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Announce records who a connection in a joined section is, sends it everyone
+// else in the section and tells them it joined. The presence is returned with
+// its session ID, which is new unless who has one from a resumed session.
+func (manager *WebSocketManager) Announce(id string, conn *websocket.Conn, who protocol.Presence) (protocol.Presence, error) {
+	sessionID := who.SessionID
+	if sessionID == "" {
+		var err error
+		if sessionID, err = randomID(8); err != nil {
+			return protocol.Presence{}, fmt.Errorf("failed to generate session id: %w", err)
+		}
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if !manager.connections[id][conn] {
+		return protocol.Presence{}, fmt.Errorf("%w: connection is not open in %s", ErrNotJoined, id)
+	}
+	who.SessionID = sessionID
+	who.EditorId = ""
+	who.Ranges = []protocol.Range{}
+	who.JoinedAt = time.Now()
+	if existing, ok := manager.presence[id][conn]; ok {
+		// Joining again keeps the session
+		who.SessionID = existing.SessionID
+		who.JoinedAt = existing.JoinedAt
+	}
+
+	others := manager.sortedPresence(id, conn)
+	if err := manager.queue(conn, protocol.PresenceMessage{Type: protocol.TypePresence, Editors: others}); err != nil {
+		return protocol.Presence{}, fmt.Errorf("failed to send presence: %w", err)
+	}
+
+	if manager.presence[id] == nil {
+		manager.presence[id] = make(map[*websocket.Conn]*protocol.Presence)
+	}
+	tracked := who
+	manager.presence[id][conn] = &tracked
+	manager.broadcast(id, protocol.PresenceChangeMessage{Type: protocol.TypeJoined, Presence: who}, conn)
+
+	return who, nil
+}
+
+// MoveCursor sets the focused subsection of a connection and its ranges in
+// it, picked at revision, and sends them to everyone else in the section.
+// Ranges are moved over any edit since and kept inside the content.
+func (manager *WebSocketManager) MoveCursor(id string, conn *websocket.Conn, editorID string, ranges []protocol.Range, revision int) (protocol.Presence, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	who, ok := manager.presence[id][conn]
+	if !ok {
+		return protocol.Presence{}, fmt.Errorf("%w: connection has not joined %s", ErrNotJoined, id)
+	}
+	state, ok := manager.sections[id]
+	if !ok {
+		return protocol.Presence{}, fmt.Errorf("%w: %s", ErrNotJoined, id)
+	}
+
+	rebased := []protocol.Range{}
+	if editorID != "" {
+		doc, ok := state.documents[editorID]
+		if !ok {
+			return protocol.Presence{}, fmt.Errorf("%w %s in section %s", ErrUnknownEditor, editorID, id)
+		}
+		for _, r := range ranges {
+			if r.Index < 0 || r.Length < 0 {
+				return protocol.Presence{}, fmt.Errorf("invalid range %d+%d", r.Index, r.Length)
+			}
+			moved, _, err := rebaseAnchor(doc, r, revision)
+			if err != nil {
+				return protocol.Presence{}, err
+			}
+			rebased = append(rebased, moved)
+		}
+	}
+
+	who.EditorId = editorID
+	who.Ranges = rebased
+	moved := copyPresence(who)
+	manager.broadcast(id, protocol.PresenceChangeMessage{Type: protocol.TypeCursor, Presence: moved}, conn)
+
+	return moved, nil
+}
+
+// moveCursors moves the ranges of everyone focused on an editor over a delta
+// applied to it. The caller must hold manager.mu.
+func (manager *WebSocketManager) moveCursors(id, editorID string, ops delta.DeltaOps) {
+	for _, who := range manager.presence[id] {
+		if who.EditorId != editorID {
+			continue
+		}
+		for i, r := range who.Ranges {
+			who.Ranges[i] = r.Transform(ops)
+		}
+	}
+}
+
+// removePresence forgets who a closing connection was and tells the rest of
+// the section it left. The caller must hold manager.mu.
+func (manager *WebSocketManager) removePresence(id string, conn *websocket.Conn) {
+	who, ok := manager.presence[id][conn]
+	if !ok {
+		return
+	}
+	delete(manager.presence[id], conn)
+	if len(manager.presence[id]) == 0 {
+		delete(manager.presence, id)
+	}
+	manager.broadcast(id, protocol.PresenceChangeMessage{Type: protocol.TypeLeft, Presence: *who}, conn)
+}
+
+// sortedPresence returns copies of everyone in a section but one connection,
+// longest there first. The caller must hold manager.mu.
+func (manager *WebSocketManager) sortedPresence(id string, except *websocket.Conn) []protocol.Presence {
+	editors := []protocol.Presence{}
+	for conn, who := range manager.presence[id] {
+		if conn != except {
+			editors = append(editors, copyPresence(who))
+		}
+	}
+	sortPresence(editors)
+	return editors
+}
+
+// ReportPresence lists everyone with a section of a report open, by section
+// and then longest there first
+func (manager *WebSocketManager) ReportPresence(reportID string) []protocol.Presence {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	editors := []protocol.Presence{}
+	for _, room := range manager.presence {
+		for _, who := range room {
+			if who.ReportID == reportID {
+				editors = append(editors, copyPresence(who))
+			}
+		}
+	}
+	sortPresence(editors)
+	return editors
+}
+
+// copyPresence copies a presence with its own ranges, so it can be used
+// after manager.mu is released
+func copyPresence(who *protocol.Presence) protocol.Presence {
+	copied := *who
+	copied.Ranges = append([]protocol.Range{}, who.Ranges...)
+	return copied
+}
+
+func sortPresence(editors []protocol.Presence) {
+	sort.Slice(editors, func(i, j int) bool {
+		if editors[i].SectionID != editors[j].SectionID {
+			return editors[i].SectionID < editors[j].SectionID
+		}
+		if !editors[i].JoinedAt.Equal(editors[j].JoinedAt) {
+			return editors[i].JoinedAt.Before(editors[j].JoinedAt)
+		}
+		return editors[i].SessionID < editors[j].SessionID
+	})
+}
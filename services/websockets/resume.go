@@ -0,0 +1,199 @@
+package websockets
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sema/models/delta"
+	"sema/models/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+// resumeGrace is how long the session of a dropped connection can be resumed
+var resumeGrace = 2 * time.Minute
+
+// ErrCannotResume is returned when a client has to join afresh instead, such
+// as when its session expired or the section changed too much since
+var ErrCannotResume = errors.New("session cannot be resumed")
+
+// appliedDelta is a delta a session sent, by the revision it made
+type appliedDelta struct {
+	editorID  string
+	revision  int
+	messageID string
+}
+
+// session is what outlives a connection: the token the client resumes with,
+// who it is and its own deltas, which are acked rather than replayed when it
+// resumes
+type session struct {
+	id        string        // the section
+	state     *sectionState // the loaded section, revisions only count while it stays loaded
+	token     string
+	sessionID string // the presence session, kept over resumes
+	uid       string
+	email     string
+	applied   []appliedDelta
+	expires   time.Time // set once the connection dropped
+}
+
+// record remembers a delta the session sent, as far back as documents keep
+// history
+func (s *session) record(editorID string, revision int, messageID string) {
+	s.applied = append(s.applied, appliedDelta{editorID, revision, messageID})
+	if len(s.applied) > maxHistory {
+		s.applied = append([]appliedDelta(nil), s.applied[len(s.applied)-maxHistory:]...)
+	}
+}
+
+// sent returns the id of the session's delta that made a revision, and false
+// when someone else made it
+func (s *session) sent(editorID string, revision int) (string, bool) {
+	for _, applied := range s.applied {
+		if applied.editorID == editorID && applied.revision == revision {
+			return applied.messageID, true
+		}
+	}
+	return "", false
+}
+
+// StartSession gives an announced connection a session it can resume if the
+// connection drops, and returns the token to resume with
+func (manager *WebSocketManager) StartSession(id string, conn *websocket.Conn, who protocol.Presence) (string, error) {
+	token, err := randomID(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate resume token: %w", err)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if !manager.connections[id][conn] {
+		return "", fmt.Errorf("%w: connection is not open in %s", ErrNotJoined, id)
+	}
+	if existing, ok := manager.sessions[conn]; ok {
+		return existing.token, nil // Joining again keeps the session
+	}
+	manager.sessions[conn] = &session{id: id, state: manager.sections[id], token: token, sessionID: who.SessionID, uid: who.UID, email: who.Email}
+	return token, nil
+}
+
+// EndSession forgets a connection's session, for clients that leave on
+// purpose
+func (manager *WebSocketManager) EndSession(conn *websocket.Conn) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	delete(manager.sessions, conn)
+}
+
+// ResumeSection picks up a dropped session on a new connection. The client
+// has the given revision of every subsection and is sent what happened since:
+// other clients' deltas, acks of its own, and the reviews and comments of the
+// section. It returns the presence session to announce the client with. When
+// the session expired, belongs to someone else or the section can't be caught
+// up from the revisions, nothing is sent and ErrCannotResume is returned.
+func (manager *WebSocketManager) ResumeSection(id string, conn *websocket.Conn, token string, revisions map[string]int, uid, email string) (string, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	// The old connection may not have been noticed to be gone yet, in which
+	// case it is taken over once the session can be resumed
+	s, ok := manager.suspended[token]
+	var old *websocket.Conn
+	for c, live := range manager.sessions {
+		if live.token == token && c != conn {
+			s, ok, old = live, true, c
+		}
+	}
+	if !ok || s.id != id || s.uid != uid || s.email != email || old == nil && time.Now().After(s.expires) {
+		return "", fmt.Errorf("%w: unknown or expired token", ErrCannotResume)
+	}
+	if !manager.connections[id][conn] {
+		return "", fmt.Errorf("%w: connection is not open in %s", ErrNotJoined, id)
+	}
+	state, ok := manager.sections[id]
+	if !ok || state != s.state || manager.restructuring[state.reportID] {
+		return "", fmt.Errorf("%w: section %s was unloaded", ErrCannotResume, id)
+	}
+	if len(revisions) != len(state.documents) {
+		return "", fmt.Errorf("%w: the section's subsections changed", ErrCannotResume)
+	}
+
+	// Check everything can be caught up before sending anything
+	var missed []interface{}
+	for editorID, doc := range state.documents {
+		revision, ok := revisions[editorID]
+		if !ok {
+			return "", fmt.Errorf("%w: no revision of %s", ErrCannotResume, editorID)
+		}
+		applied, err := doc.Since(revision)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrCannotResume, err)
+		}
+		for i, ops := range applied {
+			revision := revision + i + 1
+			if messageID, mine := s.sent(editorID, revision); mine {
+				missed = append(missed, protocol.Ack{Type: protocol.TypeAck, ReplyTo: messageID, EditorId: editorID, Revision: revision})
+				continue
+			}
+			missed = append(missed, delta.Delta{
+				Type:  protocol.TypeDelta,
+				Delta: delta.DeltaData{EditorId: editorID, Revision: revision, Delta: ops},
+			})
+		}
+	}
+
+	if old != nil {
+		manager.removeConnection(id, old)
+		old.Close()
+	}
+	for _, message := range missed {
+		if err := manager.queue(conn, message); err != nil {
+			return "", fmt.Errorf("failed to send missed changes: %w", err)
+		}
+	}
+	if err := manager.sendSectionState(conn, state); err != nil {
+		return "", err
+	}
+
+	delete(manager.suspended, token)
+	s.expires = time.Time{}
+	manager.sessions[conn] = s
+	return s.sessionID, nil
+}
+
+// suspendSession keeps the session of a dropped connection for resumeGrace.
+// The caller must hold manager.mu.
+func (manager *WebSocketManager) suspendSession(conn *websocket.Conn) {
+	s, ok := manager.sessions[conn]
+	if !ok {
+		return
+	}
+	delete(manager.sessions, conn)
+	s.expires = time.Now().Add(resumeGrace)
+	manager.suspended[s.token] = s
+}
+
+// resumable reports whether a section has sessions that may still be
+// resumed. The caller must hold manager.mu.
+func (manager *WebSocketManager) resumable(id string) bool {
+	for _, s := range manager.suspended {
+		if s.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dropExpiredSessions forgets sessions that can no longer be resumed. The
+// caller must hold manager.mu.
+func (manager *WebSocketManager) dropExpiredSessions() {
+	now := time.Now()
+	for token, s := range manager.suspended {
+		if now.After(s.expires) {
+			delete(manager.suspended, token)
+		}
+	}
+}
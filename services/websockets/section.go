@@ -0,0 +1,150 @@
+package websockets
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+
+	"sema/models/comment"
+	"sema/models/delta"
+	"sema/models/review"
+)
+
+// SectionStore is the part of the report repository the manager uses to load
+// a section's subsections, their reviews and comment threads, and write them
+// back. Sections and subsections are addressed by ID.
+type SectionStore interface {
+	FetchReportSectionContents(reportID, sectionID string) (map[string]string, error)
+	UpdateReportSectionContents(reportID, sectionID, subsectionID, newContent, author string) error
+	FetchCommentThreads(reportID, sectionID string) ([]comment.Thread, error)
+	UpdateCommentAnchors(reportID string, anchors map[string]comment.Anchor) error
+	FetchSectionReviews(reportID, sectionID string) (map[string]review.Review, error)
+}
+
+// sectionState is the authoritative copy of a section while anyone has it open
+type sectionState struct {
+	reportID  string
+	sectionID string
+	store     SectionStore
+	documents map[string]*Document // editorId (subsection ID) -> document
+	dirty     map[string]bool      // editors changed since the last write back
+	authors   map[string]string    // editorId -> last user to change it
+	threads   map[string]*comment.Thread
+	moved     map[string]bool          // threads whose anchor moved since the last write back
+	reviews   map[string]review.Review // editorId -> review, approved editors take no edits
+}
+
+// loadSection reads every subsection of a section from the store
+func loadSection(reportID, sectionID string, store SectionStore) (*sectionState, error) {
+	contents, err := store.FetchReportSectionContents(reportID, sectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load section %s: %w", sectionID, err)
+	}
+	threads, err := store.FetchCommentThreads(reportID, sectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load comments of section %s: %w", sectionID, err)
+	}
+	reviews, err := store.FetchSectionReviews(reportID, sectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load reviews of section %s: %w", sectionID, err)
+	}
+
+	state := &sectionState{
+		reportID:  reportID,
+		sectionID: sectionID,
+		store:     store,
+		documents: make(map[string]*Document),
+		dirty:     make(map[string]bool),
+		authors:   make(map[string]string),
+		threads:   make(map[string]*comment.Thread),
+		moved:     make(map[string]bool),
+		reviews:   reviews,
+	}
+	for i := range threads {
+		state.threads[threads[i].ID] = &threads[i]
+	}
+
+	for editorID, content := range contents {
+		ops, err := decodeContent(content)
+		if err != nil {
+			// Leave the editor out rather than overwrite content we can't read
+			log.Printf("Skipping subsection %s in section %s: %v", editorID, sectionID, err)
+			continue
+		}
+		state.documents[editorID] = NewDocument(ops)
+	}
+
+	return state, nil
+}
+
+// moveAnchors moves the anchors of an editor's threads over a delta applied
+// to it
+func (state *sectionState) moveAnchors(editorID string, ops delta.DeltaOps) {
+	for id, thread := range state.threads {
+		if thread.SubsectionID != editorID {
+			continue
+		}
+		if anchor := thread.Anchor.Transform(ops); anchor != thread.Anchor {
+			thread.Anchor = anchor
+			state.moved[id] = true
+		}
+	}
+}
+
+// movedAnchors returns the anchors that moved since the last write back. The
+// caller must hold manager.mu.
+func (state *sectionState) movedAnchors() map[string]comment.Anchor {
+	anchors := make(map[string]comment.Anchor)
+	for threadID := range state.moved {
+		if thread, ok := state.threads[threadID]; ok {
+			anchors[threadID] = thread.Anchor
+		}
+	}
+	return anchors
+}
+
+// sortedThreads returns copies of the section's threads, oldest first
+func (state *sectionState) sortedThreads() []comment.Thread {
+	threads := make([]comment.Thread, 0, len(state.threads))
+	for _, thread := range state.threads {
+		threads = append(threads, *thread)
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		if !threads[i].CreatedAt.Equal(threads[j].CreatedAt) {
+			return threads[i].CreatedAt.Before(threads[j].CreatedAt)
+		}
+		return threads[i].ID < threads[j].ID
+	})
+	return threads
+}
+
+// decodeContent parses stored subsection content. Empty subsections start as
+// a single newline, the same as an empty Quill editor.
+func decodeContent(content string) (delta.DeltaOps, error) {
+	var stored delta.Delta
+	if content != "" {
+		if err := json.Unmarshal([]byte(content), &stored); err != nil {
+			return delta.DeltaOps{}, fmt.Errorf("invalid stored content: %w", err)
+		}
+	}
+
+	ops := stored.Delta.Delta
+	if len(ops.Ops) == 0 {
+		ops.Ops = []delta.DeltaOp{{Insert: json.RawMessage(`"\n"`)}}
+	}
+	return ops, nil
+}
+
+// encodeContent stores content in the same shape as a delta message, which is
+// what the report generation reads.
+func encodeContent(editorID string, ops delta.DeltaOps) (string, error) {
+	data, err := json.Marshal(delta.Delta{
+		Type:  "delta",
+		Delta: delta.DeltaData{EditorId: editorID, Delta: ops},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode content for %s: %w", editorID, err)
+	}
+	return string(data), nil
+}
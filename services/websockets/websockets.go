@@ -1,11 +1,19 @@
 package websockets
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sema/models/comment"
 	"sema/models/delta"
+	"sema/models/protocol"
+	"sema/models/reportStructure"
+	"sema/models/review"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -17,15 +25,52 @@ var Upgrader = websocket.Upgrader{
 	EnableCompression: false,
 }
 
+var (
+	// ErrApproved is returned for edits to an approved subsection
+	ErrApproved = errors.New("subsection is approved")
+	// ErrNotJoined is returned for sections nobody has joined
+	ErrNotJoined = errors.New("section has not been joined")
+	// ErrRestructuring is returned while a report's sections are being changed
+	ErrRestructuring = errors.New("report is being restructured")
+	// ErrUnknownEditor is returned for subsections that are not in the section
+	ErrUnknownEditor = errors.New("unknown editor")
+	// ErrOutOfStep is returned for deltas that do not fit their revision
+	ErrOutOfStep = errors.New("delta does not fit its revision")
+)
+
+// flushInterval is how often changed subsections are written back to the repository
+const flushInterval = 5 * time.Second
+
 type WebSocketManager struct {
-	connections map[string]map[*websocket.Conn]bool // Section -> map of connections
-	mu          sync.Mutex
+	connections   map[string]map[*websocket.Conn]bool               // Section -> map of connections
+	clients       map[*websocket.Conn]*client                       // Connection -> its writer
+	presence      map[string]map[*websocket.Conn]*protocol.Presence // Section -> who each announced connection is
+	sessions      map[*websocket.Conn]*session                      // Connection -> its resumable session
+	suspended     map[string]*session                               // Resume token -> session of a dropped connection
+	sections      map[string]*sectionState                          // Section -> authoritative contents
+	restructuring map[string]bool                                   // Reports whose sections are being changed
+	mu            sync.Mutex
+
+	// Held while writing back, so two writes of a subsection never race and
+	// an older copy can't land after a newer one. Taken before mu.
+	flushMu sync.Mutex
 }
 
 func SpawnWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{
-		connections: make(map[string]map[*websocket.Conn]bool),
+	manager := &WebSocketManager{
+		connections:   make(map[string]map[*websocket.Conn]bool),
+		clients:       make(map[*websocket.Conn]*client),
+		presence:      make(map[string]map[*websocket.Conn]*protocol.Presence),
+		sessions:      make(map[*websocket.Conn]*session),
+		suspended:     make(map[string]*session),
+		sections:      make(map[string]*sectionState),
+		restructuring: make(map[string]bool),
 	}
+
+	// Start periodic write back of edited sections
+	manager.StartFlusher(flushInterval)
+
+	return manager
 }
 
 func (manager *WebSocketManager) OpenConnection(id string, conn *websocket.Conn) {
@@ -35,6 +80,7 @@ func (manager *WebSocketManager) OpenConnection(id string, conn *websocket.Conn)
 	if manager.connections[id] == nil {
 		manager.connections[id] = make(map[*websocket.Conn]bool)
 	}
+	manager.connect(conn)
 
 	manager.connections[id][conn] = true
 	log.Printf("WebSocket opened for section %s", id)
@@ -44,11 +90,23 @@ func (manager *WebSocketManager) CloseConnection(id string, conn *websocket.Conn
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
+	manager.removeConnection(id, conn)
+}
+
+// removeConnection drops a connection and tells the rest of the section it
+// left. Its session can be resumed for a while. When the last one leaves, the
+// section is written back straight away. The caller must hold manager.mu.
+func (manager *WebSocketManager) removeConnection(id string, conn *websocket.Conn) {
 	if manager.connections[id] != nil {
 		delete(manager.connections[id], conn)
+		manager.removePresence(id, conn)
+		manager.suspendSession(conn)
 
 		if len(manager.connections[id]) == 0 {
 			delete(manager.connections, id)
+			if _, ok := manager.sections[id]; ok {
+				go manager.Flush()
+			}
 		}
 	}
 }
@@ -57,21 +115,38 @@ func (manager *WebSocketManager) SendToIDExpectConn(id string, message delta.Del
 	manager.mu.Lock()
 	defer manager.mu.Unlock()
 
+	manager.broadcast(id, message, expectConn)
+}
+
+// broadcast sends a message to every connection in a section except one. The
+// caller must hold manager.mu.
+func (manager *WebSocketManager) broadcast(id string, message interface{}, expectConn *websocket.Conn) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Println("Error encoding message:", err)
+		return
+	}
 	for conn := range manager.connections[id] {
 		if expectConn == conn {
 			fmt.Println("Broadcastor: ", conn.RemoteAddr())
 			continue
 		}
 		fmt.Println("Sending to: ", conn.RemoteAddr())
-		if err := conn.WriteJSON(message); err != nil {
+		if err := manager.queueEncoded(conn, data); err != nil {
 			log.Println("Error sending message:", err)
 			conn.Close()
-			manager.CloseConnection(id, conn) // Close and remove the connection if it fails
-			
+			manager.removeConnection(id, conn) // Close and remove the connection if it fails
 		}
 	}
 }
 
+// Send queues a message for one connection. Connections take one writer at a
+// time, so everything sent outside the manager goes through here.
+func (manager *WebSocketManager) Send(conn *websocket.Conn, message interface{}) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.queue(conn, message)
+}
 
 func (manager *WebSocketManager) GetNumofConns(id string) int {
 	manager.mu.Lock()
@@ -79,24 +154,534 @@ func (manager *WebSocketManager) GetNumofConns(id string) int {
 	return len(manager.connections[id])
 }
 
+// JoinSection loads the section from the store if nobody has it open yet and
+// sends the joining connection a snapshot of every subsection.
+func (manager *WebSocketManager) JoinSection(id, reportID, sectionID string, store SectionStore, conn *websocket.Conn) error {
+	manager.mu.Lock()
+	_, loaded := manager.sections[id]
+	restructuring := manager.restructuring[reportID]
+	manager.mu.Unlock()
+
+	if restructuring {
+		return fmt.Errorf("%w: %s", ErrRestructuring, reportID)
+	}
+
+	// Read from the store without holding the lock
+	var state *sectionState
+	if !loaded {
+		var err error
+		state, err = loadSection(reportID, sectionID, store)
+		if err != nil {
+			return err
+		}
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	// Another client may have loaded the section in the meantime
+	if manager.restructuring[reportID] {
+		return fmt.Errorf("%w: %s", ErrRestructuring, reportID)
+	} else if existing, ok := manager.sections[id]; ok {
+		state = existing
+	} else if state == nil {
+		return fmt.Errorf("section %s was unloaded while joining", id)
+	} else {
+		manager.sections[id] = state
+	}
+
+	for editorID, doc := range state.documents {
+		snapshot := delta.Delta{
+			Type: protocol.TypeSnapshot,
+			Delta: delta.DeltaData{
+				EditorId: editorID,
+				Revision: doc.Revision,
+				Delta:    doc.Content,
+			},
+		}
+		if err := manager.queue(conn, snapshot); err != nil {
+			return fmt.Errorf("failed to send snapshot of %s: %w", editorID, err)
+		}
+	}
+
+	return manager.sendSectionState(conn, state)
+}
+
+// sendSectionState sends a joining connection the reviews and comment
+// threads of a section. The caller must hold manager.mu.
+func (manager *WebSocketManager) sendSectionState(conn *websocket.Conn, state *sectionState) error {
+	reviews := protocol.ReviewsMessage{Type: protocol.TypeReviews, Reviews: state.reviews}
+	if err := manager.queue(conn, reviews); err != nil {
+		return fmt.Errorf("failed to send reviews: %w", err)
+	}
+
+	comments := protocol.CommentsMessage{Type: protocol.TypeComments, Threads: state.sortedThreads()}
+	if err := manager.queue(conn, comments); err != nil {
+		return fmt.Errorf("failed to send comments: %w", err)
+	}
+
+	return nil
+}
+
+// Revision returns the current revision of an editor in a section
+func (manager *WebSocketManager) Revision(id, editorID string) int {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if state, ok := manager.sections[id]; ok {
+		if doc, ok := state.documents[editorID]; ok {
+			return doc.Revision
+		}
+	}
+	return 0
+}
+
+// ApplyDelta transforms a client's delta against any concurrent ones, applies
+// it, sends it to the other connections and acknowledges it to the sender.
+// Everything happens under the lock so each client sees revisions in order.
+// The author is recorded against the version saved on the next write back.
+func (manager *WebSocketManager) ApplyDelta(id string, message protocol.DeltaMessage, author string, sender *websocket.Conn) (delta.Delta, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	editorID := message.Delta.EditorId
+	state, ok := manager.sections[id]
+	if !ok || !manager.connections[id][sender] {
+		return delta.Delta{}, fmt.Errorf("%w: %s", ErrNotJoined, id)
+	}
+	if manager.restructuring[state.reportID] {
+		return delta.Delta{}, fmt.Errorf("%w: %s", ErrRestructuring, state.reportID)
+	}
+	doc, ok := state.documents[editorID]
+	if !ok {
+		return delta.Delta{}, fmt.Errorf("%w %s in section %s", ErrUnknownEditor, editorID, id)
+	}
+	if state.reviews[editorID].Status == review.Approved {
+		return delta.Delta{}, fmt.Errorf("%w: %s", ErrApproved, editorID)
+	}
+
+	ops, err := doc.Apply(message.Delta.Revision, message.Delta.Delta)
+	if err != nil {
+		return delta.Delta{}, fmt.Errorf("%w: %s: %v", ErrOutOfStep, editorID, err)
+	}
+	state.dirty[editorID] = true
+	state.authors[editorID] = author
+	state.moveAnchors(editorID, ops)
+	manager.moveCursors(id, editorID, ops)
+	if s, ok := manager.sessions[sender]; ok {
+		s.record(editorID, doc.Revision, message.ID)
+	}
+
+	applied := delta.Delta{
+		Type: protocol.TypeDelta,
+		Delta: delta.DeltaData{
+			EditorId: editorID,
+			Revision: doc.Revision,
+			Delta:    ops,
+		},
+	}
+	manager.broadcast(id, applied, sender)
+
+	ack := protocol.Ack{Type: protocol.TypeAck, ReplyTo: message.ID, EditorId: editorID, Revision: doc.Revision}
+	if err := manager.queue(sender, ack); err != nil {
+		log.Println("Error sending ack:", err)
+	}
+
+	return applied, nil
+}
+
+// SendSnapshot sends one connection the current content of an editor, to put
+// it back in step after an edit was rejected
+func (manager *WebSocketManager) SendSnapshot(id, editorID string, conn *websocket.Conn) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	state, ok := manager.sections[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotJoined, id)
+	}
+	doc, ok := state.documents[editorID]
+	if !ok {
+		return fmt.Errorf("%w %s in section %s", ErrUnknownEditor, editorID, id)
+	}
+	return manager.queue(conn, delta.Delta{
+		Type: protocol.TypeSnapshot,
+		Delta: delta.DeltaData{
+			EditorId: editorID,
+			Revision: doc.Revision,
+			Delta:    doc.Content,
+		},
+	})
+}
+
+// UpdateReview tells everyone in the section a subsection's review changed.
+// An open section takes no more edits to the subsection once it is approved.
+func (manager *WebSocketManager) UpdateReview(id, editorID string, changed review.Review) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if state, ok := manager.sections[id]; ok {
+		state.reviews[editorID] = changed
+	}
+	manager.broadcast(id, protocol.ReviewMessage{Type: protocol.TypeReview, EditorId: editorID, Review: changed}, nil)
+}
+
+// Content returns the content of an editor in an open section in the stored
+// format, which may be newer than what the repository has. It returns false
+// when the section is not open, in which case the caller should read from the
+// repository.
+func (manager *WebSocketManager) Content(id, editorID string) (string, bool, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	state, ok := manager.sections[id]
+	if !ok {
+		return "", false, nil
+	}
+	doc, ok := state.documents[editorID]
+	if !ok {
+		return "", false, fmt.Errorf("%w %s in section %s", ErrUnknownEditor, editorID, id)
+	}
+	content, err := encodeContent(editorID, doc.Content)
+	if err != nil {
+		return "", false, err
+	}
+	return content, true, nil
+}
+
+// ReplaceContent swaps the content of an editor in an open section for stored
+// content and sends the change to everyone in it. It returns false when the
+// section is not open, in which case the caller should write to the repository.
+func (manager *WebSocketManager) ReplaceContent(id, editorID, content, author string) (bool, error) {
+	ops, err := decodeContent(content)
+	if err != nil {
+		return false, err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	state, ok := manager.sections[id]
+	if !ok {
+		return false, nil
+	}
+	if manager.restructuring[state.reportID] {
+		return false, fmt.Errorf("%w: %s", ErrRestructuring, state.reportID)
+	}
+	doc, ok := state.documents[editorID]
+	if !ok {
+		return false, fmt.Errorf("%w %s in section %s", ErrUnknownEditor, editorID, id)
+	}
+	if state.reviews[editorID].Status == review.Approved {
+		return false, fmt.Errorf("%w: %s", ErrApproved, editorID)
+	}
+
+	ops, err = doc.Replace(ops)
+	if err != nil {
+		return false, fmt.Errorf("failed to replace content of %s: %w", editorID, err)
+	}
+	state.dirty[editorID] = true
+	state.authors[editorID] = author
+	state.moveAnchors(editorID, ops)
+	manager.moveCursors(id, editorID, ops)
+
+	replaced := delta.Delta{
+		Type: protocol.TypeDelta,
+		Delta: delta.DeltaData{
+			EditorId: editorID,
+			Revision: doc.Revision,
+			Delta:    ops,
+		},
+	}
+	manager.broadcast(id, replaced, nil)
+
+	return true, nil
+}
+
+// RestructureReport runs a change to a report's sections and subsections.
+// Open sections of the report are written back and unloaded first, so
+// nothing is saved under a path the change moves or deletes. Until it is done
+// edits to the report are rejected and its sections can't be joined. Everyone
+// connected to the report is then sent the new structure to reload with.
+func (manager *WebSocketManager) RestructureReport(reportID string, change func() ([]reportStructure.Section, error)) error {
+	type pendingWrite struct {
+		state    *sectionState
+		editorID string
+		content  string
+		author   string
+	}
+
+	manager.flushMu.Lock()
+	defer manager.flushMu.Unlock()
+
+	manager.mu.Lock()
+	if manager.restructuring[reportID] {
+		manager.mu.Unlock()
+		return fmt.Errorf("report %s is already being restructured", reportID)
+	}
+	manager.restructuring[reportID] = true
+
+	var writes []pendingWrite
+	var anchorWrites []pendingAnchors
+	for _, state := range manager.sections {
+		if state.reportID != reportID {
+			continue
+		}
+		for editorID := range state.dirty {
+			content, err := encodeContent(editorID, state.documents[editorID].Content)
+			if err != nil {
+				log.Println("Error encoding content:", err)
+				continue
+			}
+			writes = append(writes, pendingWrite{state, editorID, content, state.authors[editorID]})
+		}
+		if anchors := state.movedAnchors(); len(anchors) > 0 {
+			anchorWrites = append(anchorWrites, pendingAnchors{state, anchors})
+		}
+	}
+	manager.mu.Unlock()
+
+	defer func() {
+		manager.mu.Lock()
+		delete(manager.restructuring, reportID)
+		manager.mu.Unlock()
+	}()
+
+	for _, write := range writes {
+		state := write.state
+		if err := state.store.UpdateReportSectionContents(state.reportID, state.sectionID, write.editorID, write.content, write.author); err != nil {
+			// The sections stay loaded and dirty for the next flush
+			return fmt.Errorf("failed to write back %s in section %s: %w", write.editorID, state.sectionID, err)
+		}
+	}
+	for _, write := range anchorWrites {
+		state := write.state
+		if err := state.store.UpdateCommentAnchors(state.reportID, write.anchors); err != nil {
+			return fmt.Errorf("failed to write back comment anchors in section %s: %w", state.sectionID, err)
+		}
+	}
+
+	manager.mu.Lock()
+	for id, state := range manager.sections {
+		if state.reportID == reportID {
+			delete(manager.sections, id)
+		}
+	}
+	manager.mu.Unlock()
+
+	sections, err := change()
+	if err != nil {
+		return err
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	message := protocol.StructureMessage{Type: protocol.TypeStructure, Sections: sections}
+	prefix := reportID + "/"
+	for id := range manager.connections {
+		if strings.HasPrefix(id, prefix) {
+			manager.broadcast(id, message, nil)
+		}
+	}
+	return nil
+}
+
+// pendingAnchors are moved comment anchors of a section waiting to be written
+type pendingAnchors struct {
+	state   *sectionState
+	anchors map[string]comment.Anchor
+}
+
+// Flush writes every changed subsection and moved comment anchor back to its
+// store, and unloads sections that nobody has open once they are saved. Only
+// one flush runs at a time.
+func (manager *WebSocketManager) Flush() {
+	type pendingWrite struct {
+		id       string
+		state    *sectionState
+		editorID string
+		content  string
+		author   string
+		revision int
+	}
+
+	manager.flushMu.Lock()
+	defer manager.flushMu.Unlock()
+
+	manager.mu.Lock()
+	manager.dropExpiredSessions()
+	var writes []pendingWrite
+	var anchorWrites []pendingAnchors
+	for id, state := range manager.sections {
+		for editorID := range state.dirty {
+			doc := state.documents[editorID]
+			content, err := encodeContent(editorID, doc.Content)
+			if err != nil {
+				log.Println("Error encoding content:", err)
+				continue
+			}
+			writes = append(writes, pendingWrite{id, state, editorID, content, state.authors[editorID], doc.Revision})
+		}
+		if anchors := state.movedAnchors(); len(anchors) > 0 {
+			anchorWrites = append(anchorWrites, pendingAnchors{state, anchors})
+		}
+
+		// Sections stay loaded while a dropped session may come back for what it missed
+		if len(state.dirty) == 0 && len(state.moved) == 0 && len(manager.connections[id]) == 0 && !manager.resumable(id) {
+			delete(manager.sections, id)
+		}
+	}
+	manager.mu.Unlock()
+
+	for _, write := range anchorWrites {
+		state := write.state
+		if err := state.store.UpdateCommentAnchors(state.reportID, write.anchors); err != nil {
+			log.Printf("Failed to write back comment anchors in section %s: %v", state.sectionID, err)
+			continue // Stay moved and are retried on the next flush
+		}
+
+		manager.mu.Lock()
+		for threadID, anchor := range write.anchors {
+			if thread, ok := state.threads[threadID]; !ok || thread.Anchor == anchor {
+				delete(state.moved, threadID)
+			}
+		}
+		manager.mu.Unlock()
+	}
+
+	for _, write := range writes {
+		state := write.state
+		err := state.store.UpdateReportSectionContents(state.reportID, state.sectionID, write.editorID, write.content, write.author)
+		if err != nil {
+			log.Printf("Failed to write back %s in section %s: %v", write.editorID, state.sectionID, err)
+
+			// Stays dirty and is retried on the next flush, clients show it as unsaved
+			failed := protocol.NewError(protocol.CodeSaveFailed, "", "changes to %s are not saved yet", write.editorID)
+			failed.EditorId = write.editorID
+			manager.mu.Lock()
+			manager.broadcast(write.id, failed, nil)
+			manager.mu.Unlock()
+			continue
+		}
+
+		manager.mu.Lock()
+		if state.documents[write.editorID].Revision == write.revision {
+			delete(state.dirty, write.editorID)
+		}
+		manager.broadcast(write.id, protocol.Saved{Type: protocol.TypeSaved, EditorId: write.editorID, Revision: write.revision}, nil)
+		manager.mu.Unlock()
+	}
+}
+
+// RebaseAnchor moves an anchor picked at revision of an editor to the
+// editor's current revision, returning both. Sections nobody has open have
+// not changed since they were stored, so their anchors are returned as they
+// are.
+func (manager *WebSocketManager) RebaseAnchor(id, editorID string, anchor comment.Anchor, revision int) (comment.Anchor, int, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	state, ok := manager.sections[id]
+	if !ok {
+		return anchor, revision, nil
+	}
+	doc, ok := state.documents[editorID]
+	if !ok {
+		return anchor, revision, nil
+	}
+	anchor, _, err := rebaseAnchor(doc, anchor, revision)
+	return anchor, doc.Revision, err
+}
 
+// AddComment starts tracking a stored thread in an open section and sends it
+// to everyone in the section. The anchor is at revision, and is moved over
+// any edit since. The thread is returned with its current anchor.
+func (manager *WebSocketManager) AddComment(id string, thread comment.Thread, revision int) (comment.Thread, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
 
-// Add error checking 
-func (manager *WebSocketManager) RequestSectionContents(id string, expectConn *websocket.Conn) {
-  manager.mu.Lock()
-  defer manager.mu.Unlock()
+	if state, ok := manager.sections[id]; ok {
+		if doc, ok := state.documents[thread.SubsectionID]; ok {
+			anchor, moved, err := rebaseAnchor(doc, thread.Anchor, revision)
+			if err != nil {
+				return comment.Thread{}, err
+			}
+			thread.Anchor = anchor
+			if moved {
+				state.moved[thread.ID] = true
+			}
+		}
+		tracked := thread
+		state.threads[thread.ID] = &tracked
+	}
 
-  for conn := range manager.connections[id] {
-    if conn == expectConn {
-      continue
-    }
-    request := map[string]string{"action": "request_contents"}
-    if err := conn.WriteJSON(request); err != nil {
-      log.Println("Error requesting section contents:", err)
-      conn.Close()
-      manager.CloseConnection(id, conn)
-    }
-    return
-  }
+	manager.broadcast(id, protocol.CommentMessage{Type: protocol.TypeComment, Thread: thread}, nil)
+	return thread, nil
 }
 
+// rebaseAnchor moves an anchor over the deltas applied to a document since
+// revision and keeps it inside the content
+func rebaseAnchor(doc *Document, anchor comment.Anchor, revision int) (comment.Anchor, bool, error) {
+	applied, err := doc.Since(revision)
+	if err != nil {
+		return anchor, false, err
+	}
+	rebased := anchor
+	for _, ops := range applied {
+		rebased = rebased.Transform(ops)
+	}
+	rebased = clampAnchor(rebased, doc.Content.Length())
+	return rebased, rebased != anchor, nil
+}
+
+// UpdateComment sends a changed thread to everyone in the section. An open
+// section keeps the anchor it has been moving, which the thread is returned
+// with.
+func (manager *WebSocketManager) UpdateComment(id string, thread comment.Thread) comment.Thread {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	if state, ok := manager.sections[id]; ok {
+		if tracked, ok := state.threads[thread.ID]; ok {
+			thread.Anchor = tracked.Anchor
+		}
+		tracked := thread
+		state.threads[thread.ID] = &tracked
+	}
+
+	manager.broadcast(id, protocol.CommentMessage{Type: protocol.TypeComment, Thread: thread}, nil)
+	return thread
+}
+
+// CommentThreads returns the threads of an open section with their current
+// anchors, and false when the section is not open
+func (manager *WebSocketManager) CommentThreads(id string) ([]comment.Thread, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	state, ok := manager.sections[id]
+	if !ok {
+		return nil, false
+	}
+	return state.sortedThreads(), true
+}
+
+// clampAnchor keeps an anchor inside content of the given length
+func clampAnchor(anchor comment.Anchor, length int) comment.Anchor {
+	if anchor.Index > length {
+		anchor.Index = length
+	}
+	if anchor.Index+anchor.Length > length {
+		anchor.Length = length - anchor.Index
+	}
+	return anchor
+}
+
+func (manager *WebSocketManager) StartFlusher(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			manager.Flush()
+		}
+	}()
+}
@@ -1,14 +1,22 @@
 package websockets
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"sema/models/comment"
 	"sema/models/delta"
+	"sema/models/protocol"
+	"sema/models/reportStructure"
+	"sema/models/review"
 )
 
 func startTestServer(t *testing.T) (*httptest.Server, *websocket.Dialer, string) {
@@ -86,12 +94,188 @@ func TestSendToIDExpectConn(t *testing.T) {
 	assert.Equal(t, 2, manager.GetNumofConns(sectionID))
 }
 
-func TestRequestSectionContents(t *testing.T) {
+func TestGetNumofConns(t *testing.T) {
+	server, dialer, url := startTestServer(t)
+	defer server.Close()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "count-section"
+
+	assert.Equal(t, 0, manager.GetNumofConns(sectionID))
+
+	conn, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
+
+	manager.OpenConnection(sectionID, conn)
+	assert.Equal(t, 1, manager.GetNumofConns(sectionID))
+}
+
+func TestDocumentApplyTransformsConcurrentDeltas(t *testing.T) {
+	var content delta.DeltaOps
+	json.Unmarshal([]byte(`{"ops":[{"insert":"abc\n"}]}`), &content)
+	doc := NewDocument(content)
+
+	var first, second delta.DeltaOps
+	json.Unmarshal([]byte(`{"ops":[{"retain":1},{"insert":"X"}]}`), &first)
+	json.Unmarshal([]byte(`{"ops":[{"retain":3},{"insert":"Y"}]}`), &second)
+
+	// Both clients edited revision 0
+	_, err := doc.Apply(0, first)
+	assert.NoError(t, err)
+	applied, err := doc.Apply(0, second)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, doc.Revision)
+	assert.Equal(t, 4, applied.Ops[0].Retain)
+
+	data, _ := json.Marshal(doc.Content)
+	assert.JSONEq(t, `{"ops":[{"insert":"aXbcY\n"}]}`, string(data))
+
+	_, err = doc.Apply(3, second)
+	assert.Error(t, err)
+}
+
+func TestDocumentApplyRejectsDeltasOutOfRange(t *testing.T) {
+	var content delta.DeltaOps
+	json.Unmarshal([]byte(`{"ops":[{"insert":"abc\n"}]}`), &content)
+	doc := NewDocument(content)
+
+	for _, raw := range []string{
+		`{"ops":[{"delete":10}]}`,
+		`{"ops":[{"retain":50},{"insert":"X"}]}`,
+		`{"ops":[{"retain":3},{"delete":1}]}`,              // the final line break
+		`{"ops":[{"retain":4},{"insert":"X"}]}`,            // after the final line break
+		`{"ops":[{"retain":2},{"delete":1},{"retain":2}]}`, // fits before, not after the delete
+	} {
+		var ops delta.DeltaOps
+		json.Unmarshal([]byte(raw), &ops)
+		_, err := doc.Apply(0, ops)
+		assert.Error(t, err, raw)
+	}
+	assert.Equal(t, 0, doc.Revision)
+	data, _ := json.Marshal(doc.Content)
+	assert.JSONEq(t, `{"ops":[{"insert":"abc\n"}]}`, string(data))
+
+	// A delta that was in range when made is checked after it is transformed
+	var first, second delta.DeltaOps
+	json.Unmarshal([]byte(`{"ops":[{"delete":3}]}`), &first)
+	json.Unmarshal([]byte(`{"ops":[{"retain":3},{"insert":"!"}]}`), &second)
+	_, err := doc.Apply(0, first)
+	assert.NoError(t, err)
+	_, err = doc.Apply(0, second)
+	assert.NoError(t, err)
+	data, _ = json.Marshal(doc.Content)
+	assert.JSONEq(t, `{"ops":[{"insert":"!\n"}]}`, string(data))
+
+	// Stored content without the final line break gets one
+	json.Unmarshal([]byte(`{"ops":[{"insert":"abc"}]}`), &content)
+	data, _ = json.Marshal(NewDocument(content).Content)
+	assert.JSONEq(t, `{"ops":[{"insert":"abc\n"}]}`, string(data))
+}
+
+// memoryStore is a SectionStore holding contents in a map
+type memoryStore struct {
+	mu       sync.Mutex
+	contents map[string]string
+	authors  map[string]string
+	threads  []comment.Thread
+	reviews  map[string]review.Review
+	failing  bool          // writes fail while set
+	delay    time.Duration // how long each write takes
+	writing  int           // writes under way
+	overlaps int           // writes that started while another was under way
+	writes   int
+}
+
+func (s *memoryStore) FetchReportSectionContents(reportID, sectionID string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents := make(map[string]string)
+	for editorID, content := range s.contents {
+		contents[editorID] = content
+	}
+	return contents, nil
+}
+
+func (s *memoryStore) UpdateReportSectionContents(reportID, sectionID, subsectionID, newContent, author string) error {
+	s.mu.Lock()
+	if s.writing > 0 {
+		s.overlaps++
+	}
+	s.writing++
+	s.writes++
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writing--
+	if s.failing {
+		return errors.New("store is down")
+	}
+	s.contents[subsectionID] = newContent
+	if s.authors != nil {
+		s.authors[subsectionID] = author
+	}
+	return nil
+}
+
+func (s *memoryStore) FetchCommentThreads(reportID, sectionID string) ([]comment.Thread, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]comment.Thread(nil), s.threads...), nil
+}
+
+func (s *memoryStore) UpdateCommentAnchors(reportID string, anchors map[string]comment.Anchor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.threads {
+		if anchor, ok := anchors[s.threads[i].ID]; ok {
+			s.threads[i].Anchor = anchor
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) FetchSectionReviews(reportID, sectionID string) (map[string]review.Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reviews := make(map[string]review.Review)
+	for editorID, r := range s.reviews {
+		reviews[editorID] = r
+	}
+	return reviews, nil
+}
+
+func TestJoinSectionSendsSnapshot(t *testing.T) {
+	server, dialer, url := startTestServer(t)
+	defer server.Close()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "join-section"
+	store := &memoryStore{contents: map[string]string{
+		"Overview": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Hello\n"}]}}}`,
+		"Scope":    "",
+	}}
+
+	conn, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
+	manager.OpenConnection(sectionID, conn)
+
+	err = manager.JoinSection(sectionID, "report1", "Introduction", store, conn)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, manager.Revision(sectionID, "Overview"))
+	assert.Equal(t, 0, manager.Revision(sectionID, "Scope"))
+}
+
+func TestApplyDelta(t *testing.T) {
 	server, dialer, url := startTestServer(t)
 	defer server.Close()
 
 	manager := SpawnWebSocketManager()
-	sectionID := "request-section"
+	sectionID := "apply-section"
+	store := &memoryStore{contents: map[string]string{"Overview": ""}, authors: map[string]string{}}
 
 	conn1, _, err := dialer.Dial(url, nil)
 	assert.NoError(t, err)
@@ -100,23 +284,582 @@ func TestRequestSectionContents(t *testing.T) {
 
 	manager.OpenConnection(sectionID, conn1)
 	manager.OpenConnection(sectionID, conn2)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn1))
 
-	manager.RequestSectionContents(sectionID, conn1)
-	assert.Equal(t, 2, manager.GetNumofConns(sectionID))
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+
+	applied, err := manager.ApplyDelta(sectionID, msg, "alice@example.com", conn1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied.Delta.Revision)
+	assert.Equal(t, 1, manager.Revision(sectionID, "Overview"))
+
+	// Editors that are not in the section are rejected
+	msg.Delta.EditorId = "Missing"
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn1)
+	assert.Error(t, err)
+
+	// Changes are written back and the section unloads once everyone leaves
+	manager.CloseConnection(sectionID, conn1)
+	manager.CloseConnection(sectionID, conn2)
+	manager.Flush()
+	assert.JSONEq(t, `{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi\n"}]}}}`, store.contents["Overview"])
+	assert.Equal(t, "alice@example.com", store.authors["Overview"])
+
+	manager.Flush()
+	assert.Equal(t, 0, manager.Revision(sectionID, "Overview"))
 }
 
-func TestGetNumofConns(t *testing.T) {
+func TestReplaceContent(t *testing.T) {
 	server, dialer, url := startTestServer(t)
 	defer server.Close()
 
 	manager := SpawnWebSocketManager()
-	sectionID := "count-section"
+	sectionID := "replace-section"
+	store := &memoryStore{contents: map[string]string{"Overview": ""}, authors: map[string]string{}}
 
-	assert.Equal(t, 0, manager.GetNumofConns(sectionID))
+	replacement := `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Restored\n"}]}}}`
+
+	// Sections nobody has open are left to the caller
+	replaced, err := manager.ReplaceContent(sectionID, "Overview", replacement, "bob@example.com")
+	assert.NoError(t, err)
+	assert.False(t, replaced)
 
 	conn, _, err := dialer.Dial(url, nil)
 	assert.NoError(t, err)
+	manager.OpenConnection(sectionID, conn)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn))
+
+	replaced, err = manager.ReplaceContent(sectionID, "Overview", replacement, "bob@example.com")
+	assert.NoError(t, err)
+	assert.True(t, replaced)
+	assert.Equal(t, 1, manager.Revision(sectionID, "Overview"))
 
+	manager.Flush()
+	assert.JSONEq(t, `{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Restored\n"}]}}}`, store.contents["Overview"])
+	assert.Equal(t, "bob@example.com", store.authors["Overview"])
+}
+
+func TestDocumentReplace(t *testing.T) {
+	var content, replacement delta.DeltaOps
+	json.Unmarshal([]byte(`{"ops":[{"insert":"Old text\n"}]}`), &content)
+	json.Unmarshal([]byte(`{"ops":[{"insert":"New\n"}]}`), &replacement)
+
+	doc := NewDocument(content)
+	ops, err := doc.Replace(replacement)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, doc.Revision)
+	assert.Equal(t, len("Old text\n"), ops.Ops[len(ops.Ops)-1].Delete)
+
+	data, _ := json.Marshal(doc.Content)
+	assert.JSONEq(t, `{"ops":[{"insert":"New\n"}]}`, string(data))
+}
+
+func TestRestructureReport(t *testing.T) {
+	server, dialer, url := startTestServer(t)
+	defer server.Close()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/Introduction"
+	store := &memoryStore{contents: map[string]string{"Overview": ""}, authors: map[string]string{}}
+
+	conn, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
 	manager.OpenConnection(sectionID, conn)
-	assert.Equal(t, 1, manager.GetNumofConns(sectionID))
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn))
+
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.NoError(t, err)
+
+	structure := []reportStructure.Section{{ID: "intro", Title: "Introduction", Subsections: []reportStructure.Subsection{{ID: "summary", Title: "Summary"}}}}
+	err = manager.RestructureReport("report1", func() ([]reportStructure.Section, error) {
+		// Pending edits are saved before the change runs
+		assert.JSONEq(t, `{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi\n"}]}}}`, store.contents["Overview"])
+
+		_, err := manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+		assert.Error(t, err)
+		assert.Error(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn))
+		return structure, nil
+	})
+	assert.NoError(t, err)
+
+	// The section is unloaded and can be joined again afterwards
+	assert.Equal(t, 0, manager.Revision(sectionID, "Overview"))
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn))
+
+	// A failed change is returned
+	err = manager.RestructureReport("report1", func() ([]reportStructure.Section, error) {
+		return nil, assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+}
+
+func TestCommentAnchorsFollowEdits(t *testing.T) {
+	server, dialer, url := startTestServer(t)
+	defer server.Close()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/comments"
+	store := &memoryStore{
+		contents: map[string]string{"Overview": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Hello world\n"}]}}}`},
+		authors:  map[string]string{},
+		threads:  []comment.Thread{{ID: "t1", SubsectionID: "Overview", Anchor: comment.Anchor{Index: 6, Length: 5}}},
+	}
+
+	// Threads of sections nobody has open are left to the caller
+	_, open := manager.CommentThreads(sectionID)
+	assert.False(t, open)
+
+	conn, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
+	manager.OpenConnection(sectionID, conn)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn))
+
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Oh "}]}}}`), &msg)
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.NoError(t, err)
+
+	threads, open := manager.CommentThreads(sectionID)
+	assert.True(t, open)
+	assert.Equal(t, comment.Anchor{Index: 9, Length: 5}, threads[0].Anchor)
+
+	// A comment picked on "Hello" before the edit is moved to where it is now
+	anchor, revision, err := manager.RebaseAnchor(sectionID, "Overview", comment.Anchor{Index: 0, Length: 5}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, comment.Anchor{Index: 3, Length: 5}, anchor)
+	assert.Equal(t, 1, revision)
+	_, _, err = manager.RebaseAnchor(sectionID, "Overview", anchor, 5)
+	assert.Error(t, err)
+
+	added, err := manager.AddComment(sectionID, comment.Thread{ID: "t2", SubsectionID: "Overview", Anchor: comment.Anchor{Index: 0, Length: 5}}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, comment.Anchor{Index: 3, Length: 5}, added.Anchor)
+
+	// Ranges past the end are kept inside the content
+	added, err = manager.AddComment(sectionID, comment.Thread{ID: "t3", SubsectionID: "Overview", Anchor: comment.Anchor{Index: 10, Length: 50}}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, comment.Anchor{Index: 10, Length: 5}, added.Anchor)
+
+	// Updates keep the anchor the section has been moving
+	updated := manager.UpdateComment(sectionID, comment.Thread{ID: "t1", SubsectionID: "Overview", Resolved: true})
+	assert.Equal(t, comment.Anchor{Index: 9, Length: 5}, updated.Anchor)
+
+	// Moved anchors are written back
+	manager.CloseConnection(sectionID, conn)
+	manager.Flush()
+	assert.Equal(t, comment.Anchor{Index: 9, Length: 5}, store.threads[0].Anchor)
+	manager.Flush()
+	_, open = manager.CommentThreads(sectionID)
+	assert.False(t, open)
+}
+
+func TestApprovedEditorsRejectEdits(t *testing.T) {
+	server, dialer, url := startTestServer(t)
+	defer server.Close()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/approved"
+	store := &memoryStore{
+		contents: map[string]string{"Overview": "", "Scope": ""},
+		authors:  map[string]string{},
+		reviews:  map[string]review.Review{"Scope": {Status: review.Approved}},
+	}
+
+	conn, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
+	manager.OpenConnection(sectionID, conn)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "Introduction", store, conn))
+
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Scope","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.ErrorIs(t, err, ErrApproved)
+	_, err = manager.ReplaceContent(sectionID, "Scope", "", "alice@example.com")
+	assert.ErrorIs(t, err, ErrApproved)
+	assert.NoError(t, manager.SendSnapshot(sectionID, "Scope", conn))
+
+	// Other subsections can still be edited
+	msg.Delta.EditorId = "Overview"
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.NoError(t, err)
+
+	// Reopening takes edits again, approving stops them
+	manager.UpdateReview(sectionID, "Scope", review.Review{Status: review.Draft})
+	msg.Delta.EditorId = "Scope"
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.NoError(t, err)
+	manager.UpdateReview(sectionID, "Overview", review.Review{Status: review.Approved})
+	msg.Delta.EditorId = "Overview"
+	msg.Delta.Revision = 1
+	_, err = manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.ErrorIs(t, err, ErrApproved)
+}
+
+func TestPresence(t *testing.T) {
+	server, dialer, url := startTestServer(t)
+	defer server.Close()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/presence"
+	store := &memoryStore{contents: map[string]string{
+		"Overview": `{"type":"delta","delta":{"editorId":"Overview","delta":{"ops":[{"insert":"Hello\n"}]}}}`,
+	}, authors: map[string]string{}}
+
+	conn1, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
+	conn2, _, err := dialer.Dial(url, nil)
+	assert.NoError(t, err)
+	manager.OpenConnection(sectionID, conn1)
+	manager.OpenConnection(sectionID, conn2)
+
+	// Only joined connections can announce themselves
+	alice := protocol.Presence{UID: "aliceUID", Email: "alice@example.com", ReportID: "report1", SectionID: "presence"}
+	_, err = manager.Announce("report1/other", conn1, alice)
+	assert.Error(t, err)
+
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "presence", store, conn1))
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "presence", store, conn2))
+	announced, err := manager.Announce(sectionID, conn1, alice)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, announced.SessionID)
+	_, err = manager.Announce(sectionID, conn2, protocol.Presence{UID: "bobUID", Email: "bob@example.com", ReportID: "report1", SectionID: "presence"})
+	assert.NoError(t, err)
+
+	// Joining again keeps the session
+	again, err := manager.Announce(sectionID, conn1, alice)
+	assert.NoError(t, err)
+	assert.Equal(t, announced.SessionID, again.SessionID)
+
+	moved, err := manager.MoveCursor(sectionID, conn1, "Overview", []protocol.Range{{Index: 1, Length: 3}}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []protocol.Range{{Index: 1, Length: 3}}, moved.Ranges)
+	_, err = manager.MoveCursor(sectionID, conn1, "Missing", []protocol.Range{{Index: 0}}, 0)
+	assert.Error(t, err)
+
+	// Cursors follow edits, and ranges picked before them are rebased
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+	_, err = manager.ApplyDelta(sectionID, msg, "bob@example.com", conn2)
+	assert.NoError(t, err)
+
+	editors := manager.ReportPresence("report1")
+	assert.Len(t, editors, 2)
+	assert.Equal(t, "alice@example.com", editors[0].Email)
+	assert.Equal(t, "Overview", editors[0].EditorId)
+	assert.Equal(t, []protocol.Range{{Index: 3, Length: 3}}, editors[0].Ranges)
+
+	rebased, err := manager.MoveCursor(sectionID, conn2, "Overview", []protocol.Range{{Index: 0}, {Index: 10, Length: 5}}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []protocol.Range{{Index: 2}, {Index: 8}}, rebased.Ranges)
+
+	// Leaving drops the presence
+	manager.CloseConnection(sectionID, conn1)
+	editors = manager.ReportPresence("report1")
+	assert.Len(t, editors, 1)
+	assert.Equal(t, "bob@example.com", editors[0].Email)
+	assert.Empty(t, manager.ReportPresence("report2"))
+
+	manager.CloseConnection(sectionID, conn2)
+	assert.Empty(t, manager.ReportPresence("report1"))
+}
+
+// connPair returns both ends of a WebSocket: the server's, which the manager
+// writes to, and the client's to read what it was sent
+func connPair(t *testing.T) (*websocket.Conn, *websocket.Conn, func()) {
+	serverConns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverConns <- conn
+	}))
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[4:], nil)
+	assert.NoError(t, err)
+	return <-serverConns, client, func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestFlushReportsSaves(t *testing.T) {
+	conn, client, cleanup := connPair(t)
+	defer cleanup()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/saves"
+	store := &memoryStore{contents: map[string]string{"Overview": ""}, authors: map[string]string{}}
+	manager.OpenConnection(sectionID, conn)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "saves", store, conn))
+	for _, expected := range []string{protocol.TypeSnapshot, protocol.TypeReviews, protocol.TypeComments} {
+		var message map[string]interface{}
+		assert.NoError(t, client.ReadJSON(&message))
+		assert.Equal(t, expected, message["type"])
+	}
+
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","id":"d1","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+	_, err := manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.NoError(t, err)
+	var ack protocol.Ack
+	assert.NoError(t, client.ReadJSON(&ack))
+	assert.Equal(t, protocol.Ack{Type: protocol.TypeAck, ReplyTo: "d1", EditorId: "Overview", Revision: 1}, ack)
+
+	// A failed write back is reported and tried again on the next flush
+	store.mu.Lock()
+	store.failing = true
+	store.mu.Unlock()
+	manager.Flush()
+	var failed protocol.Error
+	assert.NoError(t, client.ReadJSON(&failed))
+	assert.Equal(t, protocol.CodeSaveFailed, failed.Code)
+	assert.Equal(t, "Overview", failed.EditorId)
+
+	store.mu.Lock()
+	store.failing = false
+	store.mu.Unlock()
+	manager.Flush()
+	var saved protocol.Saved
+	assert.NoError(t, client.ReadJSON(&saved))
+	assert.Equal(t, protocol.Saved{Type: protocol.TypeSaved, EditorId: "Overview", Revision: 1}, saved)
+	assert.JSONEq(t, `{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi\n"}]}}}`, store.contents["Overview"])
+}
+
+// shortenTimeouts makes heartbeats and sessions expire within a test
+func shortenTimeouts(t *testing.T) {
+	saved := []time.Duration{pongWait, pingPeriod, idleTimeout, resumeGrace}
+	pongWait, pingPeriod, idleTimeout, resumeGrace = 200*time.Millisecond, 50*time.Millisecond, 600*time.Millisecond, 300*time.Millisecond
+	t.Cleanup(func() {
+		pongWait, pingPeriod, idleTimeout, resumeGrace = saved[0], saved[1], saved[2], saved[3]
+	})
+}
+
+func TestHeartbeat(t *testing.T) {
+	shortenTimeouts(t)
+
+	// A client that stops answering pings is given up on
+	conn, _, cleanup := connPair(t)
+	defer cleanup()
+	heartbeat := StartHeartbeat(conn)
+	defer heartbeat.Stop()
+	start := time.Now()
+	_, _, err := conn.ReadMessage()
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), idleTimeout)
+	assert.False(t, heartbeat.Idle())
+
+	// A client that answers pings but sends nothing times out as idle
+	conn, client, cleanup := connPair(t)
+	defer cleanup()
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return // Reading answers pings
+			}
+		}
+	}()
+	heartbeat = StartHeartbeat(conn)
+	defer heartbeat.Stop()
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"type":"close"}`)))
+	_, _, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	heartbeat.Received()
+
+	start = time.Now()
+	_, _, err = conn.ReadMessage()
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), idleTimeout-100*time.Millisecond)
+	assert.True(t, heartbeat.Idle())
+}
+
+func TestResumeSection(t *testing.T) {
+	shortenTimeouts(t)
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/resume"
+	store := &memoryStore{contents: map[string]string{"Overview": "", "Scope": ""}, authors: map[string]string{}}
+	alice := protocol.Presence{UID: "aliceUID", Email: "alice@example.com", ReportID: "report1", SectionID: "resume"}
+	bob := protocol.Presence{UID: "bobUID", Email: "bob@example.com", ReportID: "report1", SectionID: "resume"}
+
+	conn, _, cleanup := connPair(t)
+	defer cleanup()
+	other, _, cleanupOther := connPair(t)
+	defer cleanupOther()
+	for _, c := range []*websocket.Conn{conn, other} {
+		manager.OpenConnection(sectionID, c)
+		assert.NoError(t, manager.JoinSection(sectionID, "report1", "resume", store, c))
+	}
+	announced, err := manager.Announce(sectionID, conn, alice)
+	assert.NoError(t, err)
+	token, err := manager.StartSession(sectionID, conn, announced)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	again, err := manager.StartSession(sectionID, conn, announced)
+	assert.NoError(t, err)
+	assert.Equal(t, token, again)
+	_, err = manager.Announce(sectionID, other, bob)
+	assert.NoError(t, err)
+
+	// Alice's delta, then Bob's, then the connection drops
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","id":"d1","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+	_, err = manager.ApplyDelta(sectionID, msg, alice.Email, conn)
+	assert.NoError(t, err)
+	var reply protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Overview","revision":1,"delta":{"ops":[{"retain":2},{"insert":"!"}]}}}`), &reply)
+	_, err = manager.ApplyDelta(sectionID, reply, bob.Email, other)
+	assert.NoError(t, err)
+	manager.CloseConnection(sectionID, conn)
+
+	// The section stays loaded while the session can be resumed
+	manager.CloseConnection(sectionID, other)
+	manager.Flush()
+	assert.Equal(t, 2, manager.Revision(sectionID, "Overview"))
+
+	resumed, client, cleanupResumed := connPair(t)
+	defer cleanupResumed()
+	manager.OpenConnection(sectionID, resumed)
+
+	// Only the same user can resume, from revisions of every subsection
+	_, err = manager.ResumeSection(sectionID, resumed, token, map[string]int{"Overview": 0, "Scope": 0}, bob.UID, bob.Email)
+	assert.ErrorIs(t, err, ErrCannotResume)
+	_, err = manager.ResumeSection(sectionID, resumed, "unknown", map[string]int{"Overview": 0, "Scope": 0}, alice.UID, alice.Email)
+	assert.ErrorIs(t, err, ErrCannotResume)
+	_, err = manager.ResumeSection(sectionID, resumed, token, map[string]int{"Overview": 0}, alice.UID, alice.Email)
+	assert.ErrorIs(t, err, ErrCannotResume)
+	_, err = manager.ResumeSection(sectionID, resumed, token, map[string]int{"Overview": 5, "Scope": 0}, alice.UID, alice.Email)
+	assert.ErrorIs(t, err, ErrCannotResume)
+
+	// Alice's own delta comes back as an ack and Bob's as a delta
+	sessionID, err := manager.ResumeSection(sectionID, resumed, token, map[string]int{"Overview": 0, "Scope": 0}, alice.UID, alice.Email)
+	assert.NoError(t, err)
+	assert.Equal(t, announced.SessionID, sessionID)
+
+	var ack protocol.Ack
+	assert.NoError(t, client.ReadJSON(&ack))
+	assert.Equal(t, protocol.Ack{Type: protocol.TypeAck, ReplyTo: "d1", EditorId: "Overview", Revision: 1}, ack)
+	var missed delta.Delta
+	assert.NoError(t, client.ReadJSON(&missed))
+	assert.Equal(t, protocol.TypeDelta, missed.Type)
+	assert.Equal(t, 2, missed.Delta.Revision)
+	data, _ := json.Marshal(missed.Delta.Delta)
+	assert.JSONEq(t, `{"ops":[{"retain":2},{"insert":"!"}]}`, string(data))
+	for _, expected := range []string{protocol.TypeReviews, protocol.TypeComments} {
+		var message map[string]interface{}
+		assert.NoError(t, client.ReadJSON(&message))
+		assert.Equal(t, expected, message["type"])
+	}
+
+	// A token resumes once, and the session ends when the client leaves
+	_, err = manager.ResumeSection(sectionID, resumed, token, map[string]int{"Overview": 2, "Scope": 0}, alice.UID, alice.Email)
+	assert.ErrorIs(t, err, ErrCannotResume)
+	manager.EndSession(resumed)
+	manager.CloseConnection(sectionID, resumed)
+	manager.Flush()
+	assert.Equal(t, 0, manager.Revision(sectionID, "Overview"))
+}
+
+func TestResumedSessionsExpire(t *testing.T) {
+	shortenTimeouts(t)
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/expire"
+	store := &memoryStore{contents: map[string]string{"Overview": ""}, authors: map[string]string{}}
+	alice := protocol.Presence{UID: "aliceUID", Email: "alice@example.com", ReportID: "report1", SectionID: "expire"}
+
+	conn, _, cleanup := connPair(t)
+	defer cleanup()
+	manager.OpenConnection(sectionID, conn)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "expire", store, conn))
+	announced, err := manager.Announce(sectionID, conn, alice)
+	assert.NoError(t, err)
+	token, err := manager.StartSession(sectionID, conn, announced)
+	assert.NoError(t, err)
+	manager.CloseConnection(sectionID, conn)
+
+	time.Sleep(resumeGrace + 50*time.Millisecond)
+	manager.Flush()
+	assert.Equal(t, 0, manager.GetNumofConns(sectionID))
+
+	resumed, _, cleanupResumed := connPair(t)
+	defer cleanupResumed()
+	manager.OpenConnection(sectionID, resumed)
+	_, err = manager.ResumeSection(sectionID, resumed, token, map[string]int{"Overview": 0}, alice.UID, alice.Email)
+	assert.ErrorIs(t, err, ErrCannotResume)
+}
+
+func TestFlushesRunOneAtATime(t *testing.T) {
+	conn, _, cleanup := connPair(t)
+	defer cleanup()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/flushes"
+	store := &memoryStore{contents: map[string]string{"Overview": ""}, authors: map[string]string{}, delay: 50 * time.Millisecond}
+	manager.OpenConnection(sectionID, conn)
+	assert.NoError(t, manager.JoinSection(sectionID, "report1", "flushes", store, conn))
+
+	var msg protocol.DeltaMessage
+	json.Unmarshal([]byte(`{"type":"delta","delta":{"editorId":"Overview","revision":0,"delta":{"ops":[{"insert":"Hi"}]}}}`), &msg)
+	_, err := manager.ApplyDelta(sectionID, msg, "alice@example.com", conn)
+	assert.NoError(t, err)
+
+	// Leaving flushes in the background while the flusher runs too
+	manager.CloseConnection(sectionID, conn)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manager.Flush()
+		}()
+	}
+	wg.Wait()
+	manager.Flush()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	assert.Zero(t, store.overlaps)
+	assert.Equal(t, 1, store.writes)
+}
+
+func TestSlowClientsAreDisconnected(t *testing.T) {
+	slow, _, cleanupSlow := connPair(t) // Never read
+	defer cleanupSlow()
+	fast, client, cleanupFast := connPair(t)
+	defer cleanupFast()
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	manager := SpawnWebSocketManager()
+	sectionID := "report1/slow"
+	manager.OpenConnection(sectionID, slow)
+	manager.OpenConnection(sectionID, fast)
+
+	// Broadcasting never waits for a client that stopped reading
+	payload := strings.Repeat("x", 64*1024)
+	start := time.Now()
+	for i := 0; i < 2*sendQueueSize; i++ {
+		manager.mu.Lock()
+		manager.broadcast(sectionID, map[string]string{"type": "test", "payload": payload}, nil)
+		manager.mu.Unlock()
+	}
+	assert.Less(t, time.Since(start), writeWait)
+
+	manager.mu.Lock()
+	assert.False(t, manager.connections[sectionID][slow])
+	manager.mu.Unlock()
+
+	// Disconnecting stops the writer and later messages are dropped
+	manager.Disconnect(slow)
+	assert.ErrorIs(t, manager.Send(slow, protocol.Saved{Type: protocol.TypeSaved}), ErrNotConnected)
 }
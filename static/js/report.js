@@ -5,66 +5,551 @@ let editors = {};
 let currentSocket = null;
 let currentSection = null;
 
+/* The socket protocol version this client speaks, see models/protocol */
+const PROTOCOL_VERSION = 1;
+
+/* Messages waiting for an ack by id, resent when the server says trying
+   again may work */
+let nextMessageId = 1;
+let unanswered = {};
+
+/* The token to resume the section with if the socket drops, and how many
+   reconnects were tried since the last welcome. While resuming, local
+   changes are held until the server has caught us up. */
+let resumeToken = null;
+let reconnectAttempts = 0;
+let resuming = false;
+
+/* Quill's delta type, used to transform concurrent edits */
+const Delta = Quill.import('delta');
+
+/* Allow cross-reference links such as "ref:<section or subsection id>", which
+   exports turn into links to the numbered heading */
+Quill.import('formats/link').PROTOCOL_WHITELIST.push('ref');
+
+/* Per editor: last server revision, the delta waiting for an ack, and the
+   local changes made while waiting */
+let revisions = {};
+let pendingDeltas = {};
+let bufferedDeltas = {};
+
+/* Per editor: its review, approved editors are read-only */
+let reviews = {};
+
+/* Per editor: comment threads by id. Anchors are kept against the editor's
+   contents, local changes included */
+let commentThreads = {};
+
+/* Everyone else in the section by session id, with their cursors kept
+   against our contents like comment anchors */
+let presence = {};
+
+/* Our own cursor, sent once the server knows the revision it is at */
+let cursorEditor = '';
+let cursorWaiting = false;
+
 
 /* Applys change to the relevant editor */
 function applyDeltaToEditor(delta) {
   const editorId = delta.editorId; // The editor id
   if (editors[editorId]) {
-    editors[editorId].updateContents(delta.delta); // update the delta of the editor
-    console.log(`Apply new delta:`, delta.delta,` at `, editorId);
+    let remote = new Delta(delta.delta);
+
+    // The server ordered the remote delta before our unacknowledged changes
+    if (pendingDeltas[editorId]) {
+      const pending = pendingDeltas[editorId];
+      pendingDeltas[editorId] = remote.transform(pending, true);
+      remote = pending.transform(remote, false);
+    }
+    if (bufferedDeltas[editorId]) {
+      const buffered = bufferedDeltas[editorId];
+      bufferedDeltas[editorId] = remote.transform(buffered, true);
+      remote = buffered.transform(remote, false);
+    }
+
+    revisions[editorId] = delta.revision;
+    moveAnchors(editorId, remote);
+    moveCursors(editorId, remote);
+    editors[editorId].updateContents(remote); // update the delta of the editor
+    console.log(`Apply new delta:`, remote,` at `, editorId);
   }
 }
 
-/* Send changes user made in editor to the server */
-function sendDeltaToServer(delta) {
-  if (currentSocket && currentSocket.readyState === WebSocket.OPEN) {
-    const message = {
-      type: 'delta',
-      delta: delta
-    };
-    msg = JSON.stringify(message);
-    currentSocket.send(msg);
-    console.log(`Sending new delta to web server:`, msg);
+/* Replace an editor's contents with the server's copy */
+function applySnapshotToEditor(snapshot) {
+  const editorId = snapshot.editorId;
+  if (editors[editorId]) {
+    revisions[editorId] = snapshot.revision;
+    pendingDeltas[editorId] = null;
+    bufferedDeltas[editorId] = null;
+    editors[editorId].setContents(snapshot.delta, 'api');
+    console.log(`Loaded snapshot at revision ${snapshot.revision} for `, editorId);
   }
 }
 
+/* Send a local change, or hold it until the previous one is acknowledged */
+function queueDelta(editorId, change) {
+  if (pendingDeltas[editorId] || resuming) {
+    const buffered = bufferedDeltas[editorId];
+    bufferedDeltas[editorId] = buffered ? buffered.compose(change) : change;
+    return;
+  }
 
-function broadcastSectionContents(reportId, section) {
-  let contents = {}; // Initialize contents as an empty object
+  pendingDeltas[editorId] = change;
+  sendDeltaToServer({
+    editorId: editorId,
+    revision: revisions[editorId] || 0,
+    delta: change
+  });
+}
+
+/* The server applied our delta, send whatever was typed in the meantime */
+function handleAck(ack) {
+  const editorId = ack.editorId;
+  revisions[editorId] = ack.revision;
+  pendingDeltas[editorId] = null;
+
+  const buffered = bufferedDeltas[editorId];
+  bufferedDeltas[editorId] = null;
+  if (buffered) {
+    queueDelta(editorId, buffered);
+  } else if (cursorWaiting && cursorEditor === editorId) {
+    sendCursor(editorId);
+  }
+}
+
+function resetEditorState() {
+  editors = {};
+  revisions = {};
+  pendingDeltas = {};
+  bufferedDeltas = {};
+  commentThreads = {};
+  reviews = {};
+  presence = {};
+  unanswered = {};
+  cursorEditor = '';
+  cursorWaiting = false;
+  resumeToken = null;
+  reconnectAttempts = 0;
+  resuming = false;
+  renderPresence();
+}
 
+/* The socket dropped while we were in the section. Open it again, backing
+   off up to 30s, and resume from the revisions we have. Everyone else is
+   sent again with the join. */
+function reconnect(section) {
+  const dropped = currentSocket;
+  resuming = resumeToken !== null;
+  unanswered = {};
+  presence = {};
+  Object.keys(editors).forEach(renderCursors);
+  renderPresence();
+
+  const delay = Math.min(30000, 1000 * 2 ** reconnectAttempts++);
+  console.log(`Reconnecting to ${section} in ${delay}ms`);
+  setTimeout(() => {
+    if (currentSocket === dropped && currentSection === section) {
+      openSocket(section);
+    }
+  }, delay);
+}
+
+/* The server caught us up. A delta still waiting for an ack never reached
+   it, so it is sent again from the revision we are at now, and changes
+   held while resuming go out after it. */
+function finishResume() {
+  resuming = false;
   Object.keys(editors).forEach(editorId => {
-    const delta = editors[editorId].getContents(); // Get the delta from the editor
-    contents[editorId] = {  // Add each editor's delta to the contents object type: 'delta', 
-      type: 'delta',
-      delta: {editorId: editorId, delta: delta}
-    };
-    console.log(editorId);
+    const pending = pendingDeltas[editorId];
+    if (pending) {
+      sendDeltaToServer({
+        editorId: editorId,
+        revision: revisions[editorId] || 0,
+        delta: pending
+      });
+    } else if (bufferedDeltas[editorId]) {
+      const buffered = bufferedDeltas[editorId];
+      bufferedDeltas[editorId] = null;
+      queueDelta(editorId, buffered);
+    }
   });
+  if (cursorEditor) {
+    sendCursor(cursorEditor);
+  }
+}
 
+/* +++++++++++++++++ Presence and cursors +++++++++++++++++ */
 
-  // Check if the socket is open and then send the contents
-  if (currentSocket && currentSocket.readyState === WebSocket.OPEN) {
-    currentSocket.send(JSON.stringify({ type: 'sync', reportid: reportId, section: section, contents: contents}));
-    console.log(`Sending sync contents to websever:`, contents)
+/* Tell the section where our cursor is. Ranges have to be against a
+   revision the server knows, so wait for our changes to be acknowledged. */
+function sendCursor(editorId) {
+  cursorEditor = editorId;
+  if (editorId && (pendingDeltas[editorId] || bufferedDeltas[editorId])) {
+    cursorWaiting = true;
+    return;
   }
+  cursorWaiting = false;
+
+  // A newer cursor replaces this one, so it is not resent
+  const selection = editorId && editors[editorId] ? editors[editorId].getSelection() : null;
+  sendMessage({
+    type: 'cursor',
+    editorId: selection ? editorId : '',
+    ranges: selection ? [selection] : [],
+    revision: selection ? revisions[editorId] || 0 : 0
+  }, false);
 }
 
-function updateRepoSectionContents(reportId, section) {
-  let contents = {}; // Initialize contents as an empty object
+/* Someone joined, moved their cursor or left. Their ranges are at the
+   server's revision, which is missing our unacknowledged changes. */
+function setPresence(who) {
+  const editorId = who.editorId;
+  if (editorId) {
+    [pendingDeltas[editorId], bufferedDeltas[editorId]].forEach(local => {
+      if (local) {
+        who.ranges = who.ranges.map(range => moveAnchor(range, local));
+      }
+    });
+  }
+  const previous = presence[who.sessionId];
+  presence[who.sessionId] = who;
 
-  Object.keys(editors).forEach(editorId => {
-    const delta = editors[editorId].getContents(); // Get the delta from the editor
-    contents[editorId] = {  // Add each editor's delta to the contents object type: 'delta', 
-      type: 'delta',
-      delta: {editorId: editorId, delta: delta}
-    };
-    console.log(editorId);
+  if (previous && previous.editorId && previous.editorId !== editorId) {
+    renderCursors(previous.editorId);
+  }
+  if (editorId) {
+    renderCursors(editorId);
+  }
+  renderPresence();
+}
+
+function removePresence(who) {
+  delete presence[who.sessionId];
+  if (who.editorId) {
+    renderCursors(who.editorId);
+  }
+  renderPresence();
+}
+
+/* Cursors move over changes like comment anchors do */
+function moveCursors(editorId, change) {
+  Object.values(presence).forEach(who => {
+    if (who.editorId === editorId) {
+      who.ranges = who.ranges.map(range => moveAnchor(range, change));
+    }
   });
+}
 
-  // Check if the socket is open and then send the contents
-  if (currentSocket && currentSocket.readyState === WebSocket.OPEN) {
-    currentSocket.send(JSON.stringify({ type: 'updateRepo', reportid: reportId, section: section, contents: contents}));
-    console.log(`Sending repo contents to websever:`, contents)
+/* A colour per person, the same on every client */
+function presenceColour(email) {
+  let hash = 0;
+  for (const c of email) {
+    hash = (hash * 31 + c.charCodeAt(0)) % 360;
+  }
+  return `hsl(${hash}, 70%, 45%)`;
+}
+
+/* List who else has the section open and where */
+function renderPresence() {
+  const list = document.getElementById('presence');
+  if (!list) {
+    return;
+  }
+  list.innerHTML = '';
+
+  const sectionObject = subsections.find(subsection => subsection.id === currentSection);
+  Object.values(presence).forEach(who => {
+    const item = document.createElement('span');
+    item.classList.add('presence-editor');
+    item.style.borderColor = presenceColour(who.email);
+    item.textContent = who.email;
+    const focused = sectionObject && (sectionObject.subsections || []).find(subsection => subsection.id === who.editorId);
+    if (focused) {
+      item.title = `In ${focused.title}`;
+    }
+    list.appendChild(item);
+  });
+}
+
+/* Draw everyone's cursors and selections over an editor */
+function renderCursors(editorId) {
+  const overlay = document.getElementById(`cursors-${editorId}`);
+  if (!overlay || !editors[editorId]) {
+    return;
+  }
+  overlay.innerHTML = '';
+
+  Object.values(presence).forEach(who => {
+    if (who.editorId !== editorId) {
+      return;
+    }
+    const colour = presenceColour(who.email);
+    who.ranges.forEach(range => {
+      const bounds = editors[editorId].getBounds(range.index, range.length);
+      if (!bounds) {
+        return;
+      }
+      const mark = document.createElement('div');
+      mark.classList.add(range.length > 0 ? 'remote-selection' : 'remote-cursor');
+      mark.style.left = `${bounds.left}px`;
+      mark.style.top = `${bounds.top}px`;
+      mark.style.height = `${bounds.height}px`;
+      mark.style.width = range.length > 0 ? `${bounds.width}px` : '';
+      mark.style.borderColor = colour;
+      mark.style.backgroundColor = range.length > 0 ? colour : '';
+      mark.title = who.email;
+      overlay.appendChild(mark);
+    });
+  });
+}
+
+/* +++++++++++++++++ Review workflow +++++++++++++++++ */
+
+const statusLabels = {
+  draft: 'Draft',
+  internal_review: 'Internal review',
+  evaluator_review: 'Evaluator review',
+  approved: 'Approved'
+};
+
+/* The moves offered from each status. The server decides who may make them. */
+const statusMoves = {
+  draft: [['internal_review', 'Submit for review']],
+  internal_review: [['evaluator_review', 'Send to evaluator'], ['draft', 'Send back']],
+  evaluator_review: [['approved', 'Approve'], ['draft', 'Send back']],
+  approved: [['draft', 'Reopen']]
+};
+
+function setReview(editorId, review) {
+  if (!editors[editorId]) {
+    return;
+  }
+  reviews[editorId] = review;
+  editors[editorId].enable(sectionAccess() === 'edit' && review.status !== 'approved');
+  renderReview(editorId);
+}
+
+/* Show an editor's status with buttons to move it on */
+function renderReview(editorId) {
+  const statusDiv = document.getElementById(`review-${editorId}`);
+  const review = reviews[editorId];
+  if (!statusDiv || !review) {
+    return;
+  }
+  statusDiv.innerHTML = '';
+
+  const label = document.createElement('span');
+  label.classList.add('review-status', review.status);
+  label.textContent = statusLabels[review.status] || review.status;
+  if (review.reviewers && review.reviewers.length > 0) {
+    label.title = `Reviewers: ${review.reviewers.join(', ')}`;
+  }
+  statusDiv.appendChild(label);
+
+  (statusMoves[review.status] || []).forEach(([status, text]) => {
+    const button = document.createElement('button');
+    button.textContent = text;
+    button.onclick = () => changeStatus(editorId, status);
+    statusDiv.appendChild(button);
+  });
+}
+
+/* The new review comes back over the socket */
+function changeStatus(editorId, status) {
+  const note = prompt(`${statusLabels[status]}: add a note (optional)`);
+  if (note === null) {
+    return;
+  }
+  fetch(`/report/${encodeURIComponent(getReportId())}/api/sections/${encodeURIComponent(currentSection)}/subsections/${encodeURIComponent(editorId)}/status`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ status: status, note: note })
+  })
+    .then(response => response.json())
+    .then(data => {
+      if (data.error) {
+        alert("Error changing status: " + data.error);
+      }
+    })
+    .catch(error => console.error('Error changing status:', error));
+}
+
+/* +++++++++++++++++ Comment threads +++++++++++++++++ */
+
+/* Move the anchors of an editor's threads over a change to its contents */
+function moveAnchors(editorId, change) {
+  Object.values(commentThreads[editorId] || {}).forEach(thread => {
+    thread.anchor = moveAnchor(thread.anchor, change);
+  });
+}
+
+/* Text typed at either end of a range is left out of it */
+function moveAnchor(anchor, change) {
+  const start = change.transformPosition(anchor.index, false);
+  const end = Math.max(start, change.transformPosition(anchor.index + anchor.length, true));
+  return { index: start, length: end - start };
+}
+
+/* Threads from the server are anchored to its contents, which are missing
+   our unacknowledged changes */
+function addCommentThread(thread) {
+  const editorId = thread.subsectionID;
+  if (!editors[editorId]) {
+    return;
+  }
+  [pendingDeltas[editorId], bufferedDeltas[editorId]].forEach(local => {
+    if (local) {
+      thread.anchor = moveAnchor(thread.anchor, local);
+    }
+  });
+  commentThreads[editorId] = commentThreads[editorId] || {};
+  commentThreads[editorId][thread.id] = thread;
+  renderCommentThreads(editorId);
+}
+
+function commentsURL(path) {
+  return `/report/${encodeURIComponent(getReportId())}/api/sections/${encodeURIComponent(currentSection)}/${path}`;
+}
+
+/* Post to a comments route. The change comes back over the socket. */
+function postComment(path, body) {
+  return fetch(commentsURL(path), {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body || {})
+  })
+    .then(response => response.json())
+    .then(data => {
+      if (data.error) {
+        alert("Error: " + data.error);
+      }
+    })
+    .catch(error => console.error('Error posting comment:', error));
+}
+
+/* Comment on the selected text of an editor */
+function startCommentThread(editorId) {
+  const selection = editors[editorId].getSelection();
+  if (!selection || selection.length === 0) {
+    alert("Select the text to comment on first");
+    return;
+  }
+  // The range has to be against a revision the server knows
+  if (pendingDeltas[editorId] || bufferedDeltas[editorId]) {
+    alert("Your changes are still being saved, try again in a moment");
+    return;
+  }
+  const body = prompt("Comment (mention people with @email):");
+  if (!body) {
+    return;
+  }
+  postComment(`subsections/${encodeURIComponent(editorId)}/comments`, {
+    body: body,
+    index: selection.index,
+    length: selection.length,
+    revision: revisions[editorId] || 0
+  });
+}
+
+/* List an editor's threads under it, open ones first */
+function renderCommentThreads(editorId) {
+  const list = document.getElementById(`comments-${editorId}`);
+  if (!list) {
+    return;
+  }
+  list.innerHTML = '';
+
+  const threads = Object.values(commentThreads[editorId] || {});
+  threads.sort((a, b) => (a.resolved - b.resolved) || a.createdAt.localeCompare(b.createdAt));
+  threads.forEach(thread => {
+    const item = document.createElement('div');
+    item.classList.add('comment-thread');
+    if (thread.resolved) {
+      item.classList.add('resolved');
+    }
+
+    const quote = document.createElement('div');
+    quote.classList.add('comment-quote');
+    quote.textContent = editors[editorId].getText(thread.anchor.index, thread.anchor.length) || '(text removed)';
+    quote.onclick = () => editors[editorId].setSelection(thread.anchor.index, thread.anchor.length);
+    item.appendChild(quote);
+
+    [thread, ...(thread.replies || [])].forEach(entry => {
+      const line = document.createElement('div');
+      line.classList.add('comment-body');
+      line.textContent = `${entry.author}: ${entry.body}`;
+      item.appendChild(line);
+    });
+
+    const canComment = sectionAccess() !== 'read';
+    if (canComment) {
+      const replyButton = document.createElement('button');
+      replyButton.textContent = 'Reply';
+      replyButton.onclick = () => {
+        const body = prompt("Reply (mention people with @email):");
+        if (body) {
+          postComment(`comments/${encodeURIComponent(thread.id)}/replies`, { body: body });
+        }
+      };
+      item.appendChild(replyButton);
+
+      const resolveButton = document.createElement('button');
+      resolveButton.textContent = thread.resolved ? 'Reopen' : 'Resolve';
+      resolveButton.onclick = () => postComment(`comments/${encodeURIComponent(thread.id)}/${thread.resolved ? 'reopen' : 'resolve'}`);
+      item.appendChild(resolveButton);
+    }
+
+    list.appendChild(item);
+  });
+}
+
+/* The signed in user's access to the open section */
+function sectionAccess() {
+  const sectionObject = subsections.find(subsection => subsection.id === currentSection);
+  return sectionObject ? sectionObject.access : 'read';
+}
+
+/* Send a message with an id the server answers with. Unless told not to,
+   it is kept until the answer comes. */
+function sendMessage(message, keep = true) {
+  if (!currentSocket || currentSocket.readyState !== WebSocket.OPEN) {
+    return;
+  }
+  message.id = message.id || `m${nextMessageId++}`;
+  if (keep) {
+    unanswered[message.id] = message;
+  }
+  currentSocket.send(JSON.stringify(message));
+}
+
+/* Send changes user made in editor to the server */
+function sendDeltaToServer(delta) {
+  sendMessage({ type: 'delta', delta: delta });
+  console.log(`Sending new delta to web server:`, delta);
+}
+
+/* The server rejected a message or could not save */
+function handleError(error) {
+  const message = unanswered[error.replyTo];
+  delete unanswered[error.replyTo];
+
+  if (error.code === 'save_failed') {
+    setSaved(error.editorId, false);
+  } else if (error.code === 'unsupported_version') {
+    alert("This page is out of date, reload it to keep editing");
+  } else if (message && error.retryable) {
+    setTimeout(() => sendMessage(message), 1000);
+  }
+  // Rejected deltas are followed by a snapshot to carry on from
+  console.warn(`Server error ${error.code}: ${error.message}`);
+}
+
+/* Show whether an editor's changes are in the repository */
+function setSaved(editorId, saved) {
+  const label = document.getElementById(`saved-${editorId}`);
+  if (label) {
+    label.textContent = saved ? '' : 'Not saved yet';
   }
 }
 
@@ -97,7 +582,14 @@ function openSocket(section) {
   /* Trigger async "join" message to server */
   currentSocket.onopen = function() {
     console.log(`Opened socket for ${section}`);
-    currentSocket.send(JSON.stringify({type: 'join', reportid: reportId, section: section}));
+    if (resumeToken) {
+      // Ask for what we missed, from the revision of every editor
+      const resumeFrom = {};
+      Object.keys(editors).forEach(editorId => resumeFrom[editorId] = revisions[editorId] || 0);
+      sendMessage({ type: 'join', version: PROTOCOL_VERSION, resume: resumeToken, revisions: resumeFrom }, false);
+    } else {
+      sendMessage({ type: 'join', version: PROTOCOL_VERSION }, false);
+    }
 
   };
 
@@ -105,12 +597,55 @@ function openSocket(section) {
   currentSocket.onmessage = function(event) {
     const data = JSON.parse(event.data);
     console.log(`Received data at ${section}: `, data) 
-    if (data.type == 'delta') {
+    if (data.type == 'snapshot') {
+      applySnapshotToEditor(data.delta);
+
+    } else if (data.type == 'delta') {
       applyDeltaToEditor(data.delta);
 
-    } else if (data.action == 'request_contents') {
-      broadcastSectionContents(reportId, section);   
-      console.log(`Sent section contents for ${section}`);
+    } else if (data.type == 'ack') {
+      delete unanswered[data.replyTo];
+      if (data.editorId) {
+        handleAck(data);
+      }
+
+    } else if (data.type == 'welcome') {
+      console.log(`Joined ${section} with protocol version ${data.version}, resumed: ${data.resumed}`);
+      resumeToken = data.resumeToken;
+      reconnectAttempts = 0;
+      // Without a resume the snapshots replaced whatever was held
+      finishResume();
+
+    } else if (data.type == 'error') {
+      handleError(data);
+
+    } else if (data.type == 'saved') {
+      setSaved(data.editorId, true);
+
+    } else if (data.type == 'reviews') {
+      Object.entries(data.reviews || {}).forEach(([editorId, review]) => setReview(editorId, review));
+
+    } else if (data.type == 'review') {
+      setReview(data.editorId, data.review);
+
+    } else if (data.type == 'comments') {
+      (data.threads || []).forEach(addCommentThread);
+
+    } else if (data.type == 'comment') {
+      addCommentThread(data.thread);
+
+    } else if (data.type == 'presence') {
+      (data.editors || []).forEach(setPresence);
+
+    } else if (data.type == 'joined' || data.type == 'cursor') {
+      setPresence(data.presence);
+
+    } else if (data.type == 'left') {
+      removePresence(data.presence);
+
+    } else if (data.type == 'structure') {
+      // Sections were added, removed, moved or renamed, so the page is stale
+      location.reload();
     }
 
   };
@@ -124,6 +659,24 @@ function openSocket(section) {
   /* Handle WebSocket closure */
   currentSocket.onclose = function(event) {
     console.log(`Closed socket for previous section: `, event.reason, event.code);
+    // Leaving the section clears currentSocket first, anything else dropped
+    if (this !== currentSocket || currentSection !== section) {
+      return;
+    }
+    if (event.code === 1001 && event.reason === 'idle') {
+      // Closed for sending nothing in a while, come back when the user does
+      const wake = () => {
+        document.removeEventListener('mousedown', wake, true);
+        document.removeEventListener('keydown', wake, true);
+        if (this === currentSocket && currentSection === section) {
+          reconnect(section);
+        }
+      };
+      document.addEventListener('mousedown', wake, true);
+      document.addEventListener('keydown', wake, true);
+      return;
+    }
+    reconnect(section);
 
   };
 
@@ -131,20 +684,19 @@ function openSocket(section) {
 
 function cleanPreviousSection() {
   /* Handle WebSocket closure */
-  reportId = getReportId();
   console.log("closing ", currentSection, "with editors:", editors);
-  updateRepoSectionContents(reportId, currentSection);
-  editors = {};
+  resetEditorState();
   if (currentSocket) {
     currentSocket.send(JSON.stringify({type: 'close', section: currentSection}));
     currentSocket.close();
+    currentSocket = null;
   }
   console.log("previous secton cleaned");
 }
 
 
 
-function loadSubsections(section) {
+function loadSubsections(section) { // The section's id
 
   if (currentSection == 'settings') {
     const settingsDiv = document.getElementById('settings');
@@ -174,7 +726,6 @@ function loadSubsections(section) {
 
   submenu.innerHTML = ''; // Clear previous submenu links
   editorsDiv.innerHTML = ''; // Clear previous editors
-  mainHeader.textContent = section;
 
   // Ensure subsections data is valid
   if (!subsections || !Array.isArray(subsections)) {
@@ -183,8 +734,8 @@ function loadSubsections(section) {
     return;
   }
 
-  // Find the subsection object by matching the title
-  const sectionObject = subsections.find(subsection => subsection.Title === section);
+  // Find the section object by its id, titles are only shown
+  const sectionObject = subsections.find(subsection => subsection.id === section);
 
   if (!sectionObject) {
     console.error(`Section "${section}" not found in subsections`);
@@ -192,7 +743,8 @@ function loadSubsections(section) {
     return;
   }
 
-  const sectionSubsections = sectionObject.Subsections || [];
+  mainHeader.textContent = sectionObject.title;
+  const sectionSubsections = sectionObject.subsections || [];
 
   // Check if subsections for this section exist
   if (sectionSubsections.length === 0) {
@@ -207,25 +759,51 @@ function loadSubsections(section) {
 
     // Add submenu link
     const link = document.createElement('a');
-    link.href = `#${subsection}`;
-    link.textContent = subsection;
+    link.href = `#subsection-${subsection.id}`;
+    link.textContent = subsection.title;
     submenu.appendChild(link);
 
     // Add Quill editor for subsection
     const editorContainer = document.createElement('div');
     editorContainer.classList.add('editor-container');
+    editorContainer.id = `subsection-${subsection.id}`;
 
     const editorHeader = document.createElement('div');
     editorHeader.classList.add('editor-header');
-    editorHeader.textContent = subsection;
+    editorHeader.textContent = subsection.title;
+
+    // Set when the server could not write the subsection back yet
+    const savedLabel = document.createElement('span');
+    savedLabel.id = `saved-${subsection.id}`;
+    savedLabel.classList.add('save-state');
+    editorHeader.appendChild(savedLabel);
 
     const editorDiv = document.createElement('div');
-    editorDiv.id = `editor-${section.replace(/\s+/g, '_')}-${index}`;
+    editorDiv.id = `editor-${subsection.id}`;
     editorDiv.style.height = '200px';
     editorDiv.style.border = '1px solid #ccc';
 
+    // Threads on this subsection, and a button to start one on the selection
+    const commentsDiv = document.createElement('div');
+    commentsDiv.id = `comments-${subsection.id}`;
+    commentsDiv.classList.add('comments');
+
+    if (sectionObject.access === 'comment' || sectionObject.access === 'edit') {
+      const commentButton = document.createElement('button');
+      commentButton.textContent = 'Comment';
+      commentButton.onclick = () => startCommentThread(subsection.id);
+      editorHeader.appendChild(commentButton);
+    }
+
+    // Review status and the moves from it, filled in when the section loads
+    const reviewDiv = document.createElement('div');
+    reviewDiv.id = `review-${subsection.id}`;
+    reviewDiv.classList.add('review');
+
     editorContainer.appendChild(editorHeader);
+    editorContainer.appendChild(reviewDiv);
     editorContainer.appendChild(editorDiv);
+    editorContainer.appendChild(commentsDiv);
     editorsDiv.appendChild(editorContainer);
 
     // Check if Quill is loaded and available
@@ -243,21 +821,38 @@ function loadSubsections(section) {
     try {
       // Initialize Quill editor
       // editors[editorDiv.id] = new Quill(`#${editorDiv.id}`, {
-      editors[subsection] = new Quill(`#${editorDiv.id}`, {
+      editors[subsection.id] = new Quill(`#${editorDiv.id}`, {
         theme: 'snow',
-        placeholder: `Edit content for ${subsection}...`,
+        placeholder: `Edit content for ${subsection.title}...`,
+        readOnly: sectionObject.access !== 'edit', // Read or comment access only
         modules: {
           toolbar: toolbarOptions
         }
       });
 
+      // Others' cursors are drawn over the editor
+      const cursorsDiv = document.createElement('div');
+      cursorsDiv.id = `cursors-${subsection.id}`;
+      cursorsDiv.classList.add('remote-cursors');
+      editorDiv.appendChild(cursorsDiv);
+
       // Attach event listener for text changes
-      editors[subsection].on('text-change', function (delta, _, source) {
+      editors[subsection.id].on('text-change', function (delta, _, source) {
         if (source === 'user') {
-          sendDeltaToServer({
-            editorId: subsection,
-            delta: delta
-          });
+          moveAnchors(subsection.id, delta);
+          moveCursors(subsection.id, delta);
+          queueDelta(subsection.id, delta);
+        }
+        renderCommentThreads(subsection.id);
+        renderCursors(subsection.id);
+      });
+
+      // Share where our cursor is, or that this editor lost focus
+      editors[subsection.id].on('selection-change', function (range) {
+        if (range) {
+          sendCursor(subsection.id);
+        } else if (cursorEditor === subsection.id) {
+          sendCursor('');
         }
       });
     } catch (error) {
@@ -335,6 +930,29 @@ function loadSettings() {
     });
 }
 
+// pollExportJob checks an export job every second and resolves with its
+// download link once it is done
+async function pollExportJob(jobID, button) {
+  while (true) {
+    const response = await fetch(`/report/${reportID}/api/exports/${jobID}`);
+    const data = await response.json();
+    if (!response.ok) {
+      throw new Error(data.error || "Failed to check export");
+    }
+
+    const job = data.job;
+    if (job.status === 'done') {
+      return data.downloadURL;
+    }
+    if (job.status === 'failed') {
+      throw new Error(job.error || "Export failed");
+    }
+
+    button.textContent = job.status === 'queued' ? 'Queued...' : 'Generating...';
+    await new Promise(resolve => setTimeout(resolve, 1000));
+  }
+}
+
 function renderSettingsUI() {
 
   const settingsDiv = document.getElementById('settings');
@@ -343,32 +961,46 @@ function renderSettingsUI() {
   reportID = getReportId();
   generateReportButton.textContent = 'Report Generate';
   generateReportButton.classList.add('centered-button');
+  const formatSelect = document.createElement('select');
+  formatSelect.classList.add('centered-button');
+  [['pdf', 'PDF'], ['docx', 'Word (DOCX)'], ['odt', 'OpenDocument (ODT)'], ['md', 'Markdown'], ['html', 'HTML']].forEach(([value, label]) => {
+    const option = document.createElement('option');
+    option.value = value;
+    option.textContent = label;
+    formatSelect.appendChild(option);
+  });
+
   generateReportButton.onclick = function () {
-    // REFACTOR: does not need duplicates of reportID 
-    fetch(`/report/${reportID}/api/generateReport?reportID=${reportID}`, {
-      method: "GET",
+    const format = formatSelect.value;
+    generateReportButton.disabled = true;
+
+    // Start an export job, poll it until it finishes, then download the file
+    fetch(`/report/${reportID}/api/exports`, {
+      method: "POST",
       headers: {
         "Content-Type": "application/json"
-      }
+      },
+      body: JSON.stringify({ format: format })
     })
       .then(async (response) => {
-        if (response.ok) {
-          return response.blob(); // Server returns PDF
-        } else {
-          const errorData = await response.json(); // Try to parse error message
-          const errorMsg = errorData.error || "Failed to generate report";
-          throw new Error(errorMsg);
+        const data = await response.json();
+        if (!response.ok) {
+          throw new Error(data.error || "Failed to generate report");
         }
+        return pollExportJob(data.job.jobID, generateReportButton);
       })
-      .then(blob => {
+      .then(downloadURL => {
         const link = document.createElement('a');
-        link.href = URL.createObjectURL(blob);
-        link.download = `${reportID}.pdf`;
+        link.href = downloadURL;
         link.click();
       })
       .catch(error => {
         alert("Error generating report: " + error.message);
         console.error("Error:", error);
+      })
+      .finally(() => {
+        generateReportButton.disabled = false;
+        generateReportButton.textContent = 'Report Generate';
       });
   };
 
@@ -408,6 +1040,24 @@ function renderSettingsUI() {
     document.getElementById('removeUserModal').style.display = 'block';
   };
 
+  const membersButton = document.createElement('button');
+  membersButton.textContent = 'Members';
+  membersButton.classList.add('centered-button');
+  membersButton.onclick = function () {
+    fetch(`/report/${getReportId()}/api/members`)
+      .then(response => response.json())
+      .then(data => {
+        if (data.error) {
+          alert("Error listing members: " + data.error);
+          return;
+        }
+        const lines = data.members.map(member =>
+          `${member.email || member.uid}: ${member.role}, joined ${new Date(member.joinedAt).toLocaleDateString()}`);
+        alert(lines.join('\n'));
+      })
+      .catch(error => console.error('Error listing members:', error));
+  };
+
   const addRenameReportButton = document.createElement('button');
   addRenameReportButton.textContent = 'Rename Report'; 
   addRenameReportButton.classList.add('centered-button');
@@ -425,11 +1075,13 @@ function renderSettingsUI() {
   };
 
 
+  settingsDiv.appendChild(formatSelect);
   settingsDiv.appendChild(generateReportButton); 
   settingsDiv.appendChild(deleteReportButton); 
   settingsDiv.appendChild(addUserButton);
   settingsDiv.appendChild(addAdminButton);
   settingsDiv.appendChild(addRemoveUserButton);
+  settingsDiv.appendChild(membersButton);
   settingsDiv.appendChild(addRenameReportButton);
   settingsDiv.appendChild(openLogsButton);
 }
@@ -440,6 +1092,7 @@ function renderSettingsUI() {
 
 document.getElementById('submitEmailButtonUser').onclick = function () {
   const email = document.getElementById('userEmail').value;
+  const role = document.getElementById('userRole').value;
 
   reportID = getReportId()
 
@@ -454,8 +1107,7 @@ document.getElementById('submitEmailButtonUser').onclick = function () {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json', },
-    // refactor this does not need report ID in json since it's in link now
-    body: JSON.stringify({ email: email, reportID: reportID, privilege: false}),
+    body: JSON.stringify({ email: email, role: role }),
 
   })
     .then(response => response.json())
@@ -465,6 +1117,9 @@ document.getElementById('submitEmailButtonUser').onclick = function () {
         console.error("Error adding user:", data.error);
         return;
       } 
+      if (data.invitation) {
+        showInvitationLink(data.invitation);
+      }
       console.log('User added:', data);
       document.getElementById('addUserModal').style.display = 'none'; // Close modal
     })
@@ -476,6 +1131,12 @@ document.getElementById('submitEmailButtonUser').onclick = function () {
 
 
 
+// People without an account are invited, the link is sent to them by hand
+function showInvitationLink(invitation) {
+  const link = `${window.location.origin}/register?invite=${invitation.token}`;
+  prompt(`${invitation.email} has no account yet. Send them this invitation link, it expires ${new Date(invitation.expiresAt).toLocaleDateString()}:`, link);
+}
+
 // Close modal when "Cancel" button is clicked
 document.getElementById('closeUserModalButton').onclick = function () {
   document.getElementById('addUserModal').style.display = 'none';
@@ -503,8 +1164,7 @@ document.getElementById('submitEmailButtonAdmin').onclick = function () {
     headers: {
       'Content-Type': 'application/json',
     },
-    // refactor this does not need report ID in json since it's in link now
-    body: JSON.stringify({ email: email, reportID: reportID, privilege: true}),
+    body: JSON.stringify({ email: email, role: 'admin' }),
 
   })
     .then(response => response.json())
@@ -515,6 +1175,9 @@ document.getElementById('submitEmailButtonAdmin').onclick = function () {
         return;
       } 
 
+      if (data.invitation) {
+        showInvitationLink(data.invitation);
+      }
       console.log('Admin added:', data);
       document.getElementById('addAdminModal').style.display = 'none'; // Close modal
     })
@@ -679,15 +1342,13 @@ function loadSectionLinks() {
   }
 
   subsections.forEach(section => {
-    const sectionTitle = section.Title;
-
     // Create a new link element
     const link = document.createElement('a');
     link.href = 'javascript:void(0)';
-    link.textContent = sectionTitle;
+    link.textContent = section.title;
 
-    // Add onclick event to call loadSubsections with the section title
-    link.setAttribute('onclick', `loadSubsections('${sectionTitle}')`);
+    // Sections are loaded by id, so any title works
+    link.addEventListener('click', () => loadSubsections(section.id));
 
     // Append the link to the sections container
     sectionsLinksDiv.appendChild(link);
@@ -704,12 +1365,11 @@ window.onload = function() {
 function cleanup() {
   // Clean up or save necessary data
   console.log("Page is unloading, perform cleanup.");
-  reportId = getReportId();
-  updateRepoSectionContents(reportId, currentSection);
-  editors = {};
+  resetEditorState();
   if (currentSocket) {
     currentSocket.send(JSON.stringify({type: 'close', section: currentSection}));
     currentSocket.close();
+    currentSocket = null;
   }
 
 };
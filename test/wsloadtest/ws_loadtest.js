@@ -25,8 +25,7 @@ function startClient(i) {
     // Send join message
     ws.send(JSON.stringify({
       type: 'join',
-      reportid: reportID,
-      section: sectionID
+      version: 1
     }));
 
     // Send edits every second